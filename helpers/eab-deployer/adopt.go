@@ -0,0 +1,78 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/deployer"
+)
+
+const adoptUsage = `usage:
+  eab-deployer adopt [-dry_run] [-force]`
+
+// runAdoptCommand adopts a deployment made without the helper and prints the adopted stages and the gaps.
+func runAdoptCommand(ctx context.Context, d *deployer.Deployer, args []string) error {
+	fs := flag.NewFlagSet("adopt", flag.ContinueOnError)
+	dryRun := fs.Bool("dry_run", false, "Only report the stages that can be adopted, the steps file is not changed.")
+	force := fs.Bool("force", false, "Replace the steps of a steps file that is not empty.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s\n%s", strings.Join(fs.Args(), " "), adoptUsage)
+	}
+
+	report, err := d.Adopt(ctx, deployer.AdoptOptions{DryRun: *dryRun, Force: *force})
+	if err != nil {
+		return err
+	}
+	fmt.Println("# Repositories:")
+	for _, name := range slices.Sorted(maps.Keys(report.Repos)) {
+		r := report.Repos[name]
+		if !r.Exists {
+			fmt.Printf("%s not found\n", name)
+			continue
+		}
+		for _, b := range slices.Sorted(maps.Keys(r.Branches)) {
+			fmt.Printf("%s %s %s\n", name, b, r.Branches[b])
+		}
+	}
+	fmt.Println("# Stages:")
+	for _, s := range report.Stages {
+		if s.Adopted {
+			fmt.Printf("%s ADOPTED\n", s.Stage)
+			continue
+		}
+		fmt.Printf("%s NOT ADOPTED\n", s.Stage)
+		for _, g := range s.Gaps {
+			fmt.Printf("  - %s\n", g)
+		}
+	}
+	switch {
+	case *dryRun:
+		fmt.Println("# Dry run, the steps file was not changed.")
+	case report.Gaps():
+		fmt.Println("# The stages not adopted will be deployed by the next run of the helper, fix the gaps first if they are already deployed.")
+	default:
+		fmt.Println("# All the stages were adopted.")
+	}
+	return nil
+}
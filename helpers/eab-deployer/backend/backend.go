@@ -0,0 +1,241 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package backend generates the GCS backend configuration of the stages from the backend.tf files of the
+// blueprint, without changing the blueprint code.
+package backend
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
+)
+
+// File is the name of the backend configuration files.
+const File = "backend.tf"
+
+// Settings are the backend settings of a deployment.
+type Settings struct {
+	// Prefix, if set, is prepended to the state prefix of each stage, to host several deployments in the same bucket.
+	Prefix string
+	// KMSEncryptionKey, if set, is the Cloud KMS key used to encrypt the state files.
+	KMSEncryptionKey string
+	// ImpersonateServiceAccount, if set, is the service account impersonated by the helper to access the state.
+	// It is only used by the terraform commands executed by the helper, not by the Cloud Build pipelines.
+	ImpersonateServiceAccount string
+}
+
+// InitConfig are the -backend-config values of the terraform init commands executed by the helper.
+func (s Settings) InitConfig() map[string]interface{} {
+	if s.ImpersonateServiceAccount == "" {
+		return nil
+	}
+	return map[string]interface{}{"impersonate_service_account": s.ImpersonateServiceAccount}
+}
+
+// gcsBackend finds the gcs backend block of a configuration.
+func gcsBackend(f *hclwrite.File) *hclwrite.Block {
+	for _, tf := range f.Body().Blocks() {
+		if tf.Type() != "terraform" {
+			continue
+		}
+		if b := tf.Body().FirstMatchingBlock("backend", []string{"gcs"}); b != nil {
+			return b
+		}
+	}
+	return nil
+}
+
+// stringAttribute reads the literal value of a string attribute.
+func stringAttribute(b *hclwrite.Block, name string) (string, error) {
+	attr := b.Body().GetAttribute(name)
+	if attr == nil {
+		return "", nil
+	}
+	expr, d := hclsyntax.ParseExpression(attr.Expr().BuildTokens(nil).Bytes(), name, hcl.InitialPos)
+	if d.HasErrors() {
+		return "", d
+	}
+	v, d := expr.Value(nil)
+	if d.HasErrors() {
+		return "", d
+	}
+	if v.Type() != cty.String || v.IsNull() {
+		return "", fmt.Errorf("attribute %s is not a string", name)
+	}
+	return v.AsString(), nil
+}
+
+// Generate creates the content of a backend configuration file from a blueprint backend file, with the bucket,
+// the settings prefix prepended to the stage prefix and the KMS key. Comments and other blocks are preserved.
+func Generate(file, bucket string, s Settings) ([]byte, error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	f, d := hclwrite.ParseConfig(src, file, hcl.InitialPos)
+	if d.HasErrors() {
+		return nil, d
+	}
+	b := gcsBackend(f)
+	if b == nil {
+		return nil, fmt.Errorf("%s has no gcs backend", file)
+	}
+	prefix, err := stringAttribute(b, "prefix")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	body := b.Body()
+	body.SetAttributeValue("bucket", cty.StringVal(bucket))
+	if prefix != "" && s.Prefix != "" {
+		body.SetAttributeValue("prefix", cty.StringVal(path.Join(s.Prefix, prefix)))
+	}
+	if s.KMSEncryptionKey != "" {
+		body.SetAttributeValue("kms_encryption_key", cty.StringVal(s.KMSEncryptionKey))
+	}
+	return hclwrite.Format(f.Bytes()), nil
+}
+
+// prefixRemoteStates prepends the prefix to the literal prefixes of the gcs terraform_remote_state data sources.
+// It reports if a data source was changed.
+func prefixRemoteStates(f *hclwrite.File, prefix string) bool {
+	changed := false
+	for _, b := range f.Body().Blocks() {
+		if b.Type() != "data" || len(b.Labels()) == 0 || b.Labels()[0] != "terraform_remote_state" {
+			continue
+		}
+		if v, err := stringAttribute(b, "backend"); err != nil || v != "gcs" {
+			continue
+		}
+		config := b.Body().GetAttribute("config")
+		if config == nil {
+			continue
+		}
+		tokens := config.Expr().BuildTokens(nil)
+		for i := 0; i+3 < len(tokens); i++ {
+			if tokens[i].Type == hclsyntax.TokenIdent && string(tokens[i].Bytes) == "prefix" &&
+				tokens[i+1].Type == hclsyntax.TokenEqual &&
+				tokens[i+2].Type == hclsyntax.TokenOQuote &&
+				tokens[i+3].Type == hclsyntax.TokenQuotedLit {
+				tokens[i+3].Bytes = []byte(path.Join(prefix, string(tokens[i+3].Bytes)) + suffixSlash(tokens[i+3].Bytes))
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// suffixSlash keeps the trailing slash removed by path.Join.
+func suffixSlash(b []byte) string {
+	if len(b) > 0 && b[len(b)-1] == '/' {
+		return "/"
+	}
+	return ""
+}
+
+// GenerateRemoteStates prefixes the gcs terraform_remote_state data sources of a file, to read the state of
+// the other stages with the same settings prefix. It returns nil when the file does not need changes.
+func GenerateRemoteStates(file string, s Settings) ([]byte, error) {
+	if s.Prefix == "" {
+		return nil, nil
+	}
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	f, d := hclwrite.ParseConfig(src, file, hcl.InitialPos)
+	if d.HasErrors() {
+		return nil, d
+	}
+	if !prefixRemoteStates(f, s.Prefix) {
+		return nil, nil
+	}
+	return f.Bytes(), nil
+}
+
+// GenerateDir creates the backend configuration files for all the backend files under a stage directory,
+// and the terraform files with remote states that need the settings prefix.
+// The files are returned by path relative to the directory.
+func GenerateDir(dir, bucket string, s Settings) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == utils.TerraformTempDir {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || filepath.Ext(p) != ".tf" {
+			return nil
+		}
+		var content []byte
+		if d.Name() == File {
+			content, err = Generate(p, bucket, s)
+		} else {
+			content, err = GenerateRemoteStates(p, s)
+		}
+		if err != nil || content == nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = content
+		return nil
+	})
+	return files, err
+}
+
+// StateObject finds the gcs backend in the terraform files of a directory and returns its bucket and the
+// object of its default workspace state.
+func StateObject(dir string) (string, string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return "", "", err
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return "", "", err
+		}
+		f, d := hclwrite.ParseConfig(src, file, hcl.InitialPos)
+		if d.HasErrors() {
+			return "", "", d
+		}
+		b := gcsBackend(f)
+		if b == nil {
+			continue
+		}
+		bucket, err := stringAttribute(b, "bucket")
+		if err != nil {
+			return "", "", fmt.Errorf("%s: %w", file, err)
+		}
+		prefix, err := stringAttribute(b, "prefix")
+		if err != nil {
+			return "", "", fmt.Errorf("%s: %w", file, err)
+		}
+		return bucket, path.Join(prefix, "default.tfstate"), nil
+	}
+	return "", "", fmt.Errorf("%s has no gcs backend", dir)
+}
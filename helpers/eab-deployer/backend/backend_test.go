@@ -0,0 +1,222 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const blueprintBackend = `/**
+ * Copyright 2024 Google LLC
+ */
+
+terraform {
+  backend "gcs" {
+    bucket = "UPDATE_ME"
+    prefix = "terraform/multi_tenant/development"
+  }
+}
+`
+
+func writeFile(t *testing.T, file, content string) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+	assert.NoError(t, os.WriteFile(file, []byte(content), 0644))
+}
+
+func TestGenerate(t *testing.T) {
+	file := filepath.Join(t.TempDir(), File)
+	writeFile(t, file, blueprintBackend)
+
+	tests := []struct {
+		name     string
+		settings Settings
+		want     string
+	}{
+		{
+			name: "bucket only",
+			want: `/**
+ * Copyright 2024 Google LLC
+ */
+
+terraform {
+  backend "gcs" {
+    bucket = "bkt-prj-seed-tf-state"
+    prefix = "terraform/multi_tenant/development"
+  }
+}
+`,
+		},
+		{
+			name:     "prefix and kms",
+			settings: Settings{Prefix: "bu-retail", KMSEncryptionKey: "projects/prj-kms/locations/us/keyRings/kr/cryptoKeys/state", ImpersonateServiceAccount: "sa@prj.iam.gserviceaccount.com"},
+			want: `/**
+ * Copyright 2024 Google LLC
+ */
+
+terraform {
+  backend "gcs" {
+    bucket             = "bkt-prj-seed-tf-state"
+    prefix             = "bu-retail/terraform/multi_tenant/development"
+    kms_encryption_key = "projects/prj-kms/locations/us/keyRings/kr/cryptoKeys/state"
+  }
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Generate(file, "bkt-prj-seed-tf-state", tt.settings)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+
+	content, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, blueprintBackend, string(content), "blueprint file should not change")
+}
+
+func TestGenerateErrors(t *testing.T) {
+	dir := t.TempDir()
+	local := filepath.Join(dir, "local.tf")
+	writeFile(t, local, `terraform {
+  backend "local" {}
+}
+`)
+	_, err := Generate(local, "bkt", Settings{})
+	assert.ErrorContains(t, err, "has no gcs backend")
+
+	variable := filepath.Join(dir, "variable.tf")
+	writeFile(t, variable, `terraform {
+  backend "gcs" {
+    prefix = var.prefix
+  }
+}
+`)
+	_, err = Generate(variable, "bkt", Settings{})
+	assert.Error(t, err)
+
+	_, err = Generate(filepath.Join(dir, "missing.tf"), "bkt", Settings{})
+	assert.Error(t, err)
+}
+
+func TestGenerateDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "envs", "development", File), blueprintBackend)
+	writeFile(t, filepath.Join(dir, "envs", "production", File), blueprintBackend)
+	writeFile(t, filepath.Join(dir, "envs", "production", ".terraform", File), blueprintBackend)
+	writeFile(t, filepath.Join(dir, "modules", "env", "main.tf"), "")
+	writeFile(t, filepath.Join(dir, "envs", "production", "remote.tf"), `data "terraform_remote_state" "bootstrap" {
+  backend = "gcs"
+  config = {
+    prefix = "terraform/bootstrap"
+  }
+}
+`)
+
+	files, err := GenerateDir(dir, "bkt", Settings{})
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	assert.Contains(t, string(files["envs/development/backend.tf"]), `bucket = "bkt"`)
+	assert.Contains(t, files, "envs/production/backend.tf")
+
+	files, err = GenerateDir(dir, "bkt", Settings{Prefix: "bu-retail"})
+	assert.NoError(t, err)
+	assert.Len(t, files, 3)
+	assert.Contains(t, string(files["envs/production/remote.tf"]), `prefix = "bu-retail/terraform/bootstrap"`)
+}
+
+func TestInitConfig(t *testing.T) {
+	assert.Nil(t, Settings{Prefix: "bu-retail"}.InitConfig())
+	assert.Equal(t, map[string]interface{}{"impersonate_service_account": "sa@prj.iam.gserviceaccount.com"}, Settings{ImpersonateServiceAccount: "sa@prj.iam.gserviceaccount.com"}.InitConfig())
+}
+
+func TestGenerateRemoteStates(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "remote.tf")
+	writeFile(t, file, `data "terraform_remote_state" "multitenant" {
+  for_each = toset(var.environment_names)
+
+  backend = "gcs"
+
+  config = {
+    bucket = var.remote_state_bucket
+    prefix = "terraform/multi_tenant/${each.value}"
+  }
+}
+
+data "terraform_remote_state" "local" {
+  backend = "local"
+
+  config = {
+    prefix = "terraform/local"
+  }
+}
+`)
+	got, err := GenerateRemoteStates(file, Settings{})
+	assert.NoError(t, err)
+	assert.Nil(t, got, "no changes without prefix")
+
+	got, err = GenerateRemoteStates(file, Settings{Prefix: "bu-retail"})
+	assert.NoError(t, err)
+	assert.Equal(t, `data "terraform_remote_state" "multitenant" {
+  for_each = toset(var.environment_names)
+
+  backend = "gcs"
+
+  config = {
+    bucket = var.remote_state_bucket
+    prefix = "bu-retail/terraform/multi_tenant/${each.value}"
+  }
+}
+
+data "terraform_remote_state" "local" {
+  backend = "local"
+
+  config = {
+    prefix = "terraform/local"
+  }
+}
+`, string(got))
+
+	other := filepath.Join(t.TempDir(), "main.tf")
+	writeFile(t, other, `module "env" {}`)
+	got, err = GenerateRemoteStates(other, Settings{Prefix: "bu-retail"})
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func TestStateObject(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "main.tf"), `resource "null_resource" "x" {}`)
+	_, _, err := StateObject(dir)
+	assert.EqualError(t, err, dir+" has no gcs backend")
+
+	writeFile(t, filepath.Join(dir, "versions.tf"), `
+terraform {
+  backend "gcs" {
+    bucket = "bkt-inhouse"
+    prefix = "terraform/inhouse/production"
+  }
+}
+`)
+	bucket, object, err := StateObject(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "bkt-inhouse", bucket)
+	assert.Equal(t, "terraform/inhouse/production/default.tfstate", object)
+}
@@ -0,0 +1,107 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cost
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+)
+
+func testTFVars() stages.GlobalTFVars {
+	return stages.GlobalTFVars{
+		BillingAccount: "AAAAAA-AAAAAA-AAAAAA",
+		Envs: map[string]stages.Env{
+			"development": {BillingAccount: "BBBBBB-BBBBBB-BBBBBB", SubnetsSelfLinks: []string{"sb-d-us-central1"}},
+			"production":  {BillingAccount: "AAAAAA-AAAAAA-AAAAAA", SubnetsSelfLinks: []string{"sb-p-us-central1", "sb-p-us-east4"}},
+		},
+		Applications: map[string]map[string]stages.ApplicationService{
+			"cymbal-bank": {
+				"accounts": {CreateAdminProject: true, CreateInfraProject: true},
+				"frontend": {CreateAdminProject: true},
+			},
+		},
+	}
+}
+
+func TestLoadRateCard(t *testing.T) {
+	rc, err := LoadRateCard("")
+	assert.NoError(t, err)
+	assert.Equal(t, "USD", rc.Currency)
+	for _, r := range Resources {
+		assert.Contains(t, rc.Monthly, r)
+	}
+
+	file := filepath.Join(t.TempDir(), "ratecard.json")
+	assert.NoError(t, os.WriteFile(file, []byte(`{"currency": "EUR", "monthly": {"project": {"price": 0}}}`), 0644))
+	_, err = LoadRateCard(file)
+	assert.ErrorContains(t, err, "has no price for: [cloudsql_instance gke_cluster gke_node_pool storage_bucket]")
+}
+
+func TestCountPlanFile(t *testing.T) {
+	counts, err := CountPlanFile(filepath.Join("testdata", "plan.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{GKECluster: 2, GKENodePool: 1, StorageBucket: 1}, counts)
+
+	_, err = CountPlan([]byte("not json"))
+	assert.Error(t, err)
+}
+
+func TestEstimate(t *testing.T) {
+	rc := RateCard{Currency: "USD", Monthly: map[string]Rate{
+		Project: {}, GKECluster: {Price: 73}, GKENodePool: {Price: 100}, CloudSQLInstance: {Price: 50}, StorageBucket: {},
+	}}
+	plans := map[string][]map[string]int{
+		"production": {{GKECluster: 1, GKENodePool: 2}, {CloudSQLInstance: 1}},
+	}
+	e := New(testTFVars(), plans, rc)
+	assert.Equal(t, []Line{
+		{Env: "development", Resource: Project, Count: 2, Source: SourceTFVars},
+		{Env: "development", Resource: GKECluster, Count: 1, Source: SourceTFVars, Monthly: 73},
+		{Env: "production", Resource: Project, Count: 2, Source: SourceTFVars},
+		{Env: "production", Resource: GKECluster, Count: 1, Source: SourcePlan, Monthly: 73},
+		{Env: "production", Resource: GKENodePool, Count: 2, Source: SourcePlan, Monthly: 200},
+		{Env: "production", Resource: CloudSQLInstance, Count: 1, Source: SourcePlan, Monthly: 50},
+		{Env: "shared", Resource: Project, Count: 2, Source: SourceTFVars},
+	}, e.Lines)
+	assert.Equal(t, 323.0, e.Total("production"))
+	assert.Equal(t, 396.0, e.Total(""))
+
+	assert.Equal(t, []string{"AAAAAA-AAAAAA-AAAAAA", "BBBBBB-BBBBBB-BBBBBB"}, e.BillingAccounts())
+	e.CheckQuota(map[string]int{"AAAAAA-AAAAAA-AAAAAA": 3, "BBBBBB-BBBBBB-BBBBBB": 1}, 5)
+	assert.Equal(t, []Quota{
+		{BillingAccount: "AAAAAA-AAAAAA-AAAAAA", Linked: 3, New: 4, Limit: 5},
+		{BillingAccount: "BBBBBB-BBBBBB-BBBBBB", Linked: 1, New: 2, Limit: 5},
+	}, e.Quotas)
+	assert.True(t, e.QuotaExceeded())
+
+	var out bytes.Buffer
+	assert.NoError(t, e.Write(&out))
+	lines := strings.Split(out.String(), "\n")
+	assert.Regexp(t, `^ENV\s+RESOURCE\s+COUNT\s+SOURCE\s+MONTHLY \(USD\)$`, lines[0])
+	assert.Regexp(t, `production\s+total\s+323.00`, out.String())
+	assert.Regexp(t, `all\s+total\s+396.00`, out.String())
+	assert.Regexp(t, `AAAAAA-AAAAAA-AAAAAA\s+3\s+4\s+5\s+EXCEEDED by 2`, out.String())
+	assert.Regexp(t, `BBBBBB-BBBBBB-BBBBBB\s+1\s+2\s+5\s+OK`, out.String())
+
+	e.CheckQuota(nil, 0)
+	assert.False(t, e.QuotaExceeded(), "an unknown quota is never exceeded")
+}
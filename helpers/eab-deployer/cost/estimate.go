@@ -0,0 +1,204 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cost
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+)
+
+const (
+	// SharedEnv has the resources that are not in an environment, like the admin projects of the applications.
+	SharedEnv = "shared"
+	// SourceTFVars is the source of the counts derived from the tfvars file.
+	SourceTFVars = "tfvars"
+	// SourcePlan is the source of the counts read from the plans.
+	SourcePlan = "plan"
+)
+
+// Line is the estimate of a resource in an environment.
+type Line struct {
+	Env      string
+	Resource string
+	Count    int
+	Source   string
+	Monthly  float64
+}
+
+// Quota compares the projects of a billing account with its project quota.
+type Quota struct {
+	BillingAccount string
+	// Linked is the number of projects already linked to the billing account.
+	Linked int
+	// New is the number of projects created by the deployment.
+	New int
+	// Limit is the project quota of the billing account, zero when it is unknown.
+	Limit int
+}
+
+// Exceeded reports if the projects of the deployment do not fit in the quota.
+func (q Quota) Exceeded() bool {
+	return q.Limit > 0 && q.Linked+q.New > q.Limit
+}
+
+// Estimate is the estimate of the resources of a deployment.
+type Estimate struct {
+	Currency string
+	Lines    []Line
+	Quotas   []Quota
+	// billing is the billing account of each environment.
+	billing map[string]string
+}
+
+// FromTFVars counts the resources of each environment defined by the tfvars file: the cluster project and the
+// clusters of the multitenant stage, one for each subnet, and the projects of the applications.
+func FromTFVars(tfvars stages.GlobalTFVars) map[string]map[string]int {
+	counts := map[string]map[string]int{}
+	for env, e := range tfvars.Envs {
+		counts[env] = map[string]int{Project: 1, GKECluster: len(e.SubnetsSelfLinks)}
+	}
+	for _, services := range tfvars.Applications {
+		for _, s := range services {
+			if s.CreateAdminProject {
+				if counts[SharedEnv] == nil {
+					counts[SharedEnv] = map[string]int{}
+				}
+				counts[SharedEnv][Project]++
+			}
+			if s.CreateInfraProject {
+				for env := range tfvars.Envs {
+					counts[env][Project]++
+				}
+			}
+		}
+	}
+	return counts
+}
+
+// New estimates the resources of each environment. The counts of the plans of an environment replace the
+// counts derived from the tfvars file for the resources found in the plans.
+func New(tfvars stages.GlobalTFVars, plans map[string][]map[string]int, rc RateCard) Estimate {
+	e := Estimate{Currency: rc.Currency, billing: map[string]string{SharedEnv: tfvars.BillingAccount}}
+	for env, v := range tfvars.Envs {
+		e.billing[env] = v.BillingAccount
+	}
+	counts := FromTFVars(tfvars)
+	sources := map[string]map[string]string{}
+	for env, c := range counts {
+		sources[env] = map[string]string{}
+		for r := range c {
+			sources[env][r] = SourceTFVars
+		}
+	}
+	for env, ps := range plans {
+		planned := map[string]int{}
+		for _, p := range ps {
+			for r, n := range p {
+				planned[r] += n
+			}
+		}
+		if counts[env] == nil {
+			counts[env] = map[string]int{}
+			sources[env] = map[string]string{}
+		}
+		for r, n := range planned {
+			counts[env][r] = n
+			sources[env][r] = SourcePlan
+		}
+	}
+	for _, env := range slices.Sorted(maps.Keys(counts)) {
+		for _, r := range Resources {
+			if n := counts[env][r]; n > 0 {
+				e.Lines = append(e.Lines, Line{Env: env, Resource: r, Count: n, Source: sources[env][r], Monthly: float64(n) * rc.Monthly[r].Price})
+			}
+		}
+	}
+	return e
+}
+
+// BillingAccounts are the billing accounts of the projects created by the deployment.
+func (e Estimate) BillingAccounts() []string {
+	accounts := []string{}
+	for _, l := range e.Lines {
+		if b := e.billing[l.Env]; l.Resource == Project && b != "" && !slices.Contains(accounts, b) {
+			accounts = append(accounts, b)
+		}
+	}
+	slices.Sort(accounts)
+	return accounts
+}
+
+// CheckQuota compares the new projects of each billing account with the projects already linked to it
+// and the project quota, a limit of zero means that the quota is unknown.
+func (e *Estimate) CheckQuota(linked map[string]int, limit int) {
+	e.Quotas = nil
+	for _, b := range e.BillingAccounts() {
+		q := Quota{BillingAccount: b, Linked: linked[b], Limit: limit}
+		for _, l := range e.Lines {
+			if l.Resource == Project && e.billing[l.Env] == b {
+				q.New += l.Count
+			}
+		}
+		e.Quotas = append(e.Quotas, q)
+	}
+}
+
+// Total is the monthly cost of an environment, or of all the environments when env is empty.
+func (e Estimate) Total(env string) float64 {
+	total := 0.0
+	for _, l := range e.Lines {
+		if env == "" || l.Env == env {
+			total += l.Monthly
+		}
+	}
+	return total
+}
+
+// QuotaExceeded reports if the projects of any billing account do not fit in the quota.
+func (e Estimate) QuotaExceeded() bool {
+	return slices.ContainsFunc(e.Quotas, Quota.Exceeded)
+}
+
+// Write prints the estimate table with the totals of each environment and the project quotas.
+func (e Estimate) Write(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ENV\tRESOURCE\tCOUNT\tSOURCE\tMONTHLY (%s)\n", e.Currency)
+	for i, l := range e.Lines {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%.2f\n", l.Env, l.Resource, l.Count, l.Source, l.Monthly)
+		if i == len(e.Lines)-1 || e.Lines[i+1].Env != l.Env {
+			fmt.Fprintf(w, "%s\ttotal\t\t\t%.2f\n", l.Env, e.Total(l.Env))
+		}
+	}
+	fmt.Fprintf(w, "all\ttotal\t\t\t%.2f\n", e.Total(""))
+	if len(e.Quotas) > 0 {
+		fmt.Fprintf(w, "\nBILLING ACCOUNT\tLINKED\tNEW\tLIMIT\tSTATUS\n")
+		for _, q := range e.Quotas {
+			limit, status := "unknown", "OK"
+			if q.Limit > 0 {
+				limit = fmt.Sprint(q.Limit)
+			}
+			if q.Exceeded() {
+				status = fmt.Sprintf("EXCEEDED by %d", q.Linked+q.New-q.Limit)
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", q.BillingAccount, q.Linked, q.New, limit, status)
+		}
+	}
+	return w.Flush()
+}
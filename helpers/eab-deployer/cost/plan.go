@@ -0,0 +1,69 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cost
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// resourceTypes maps the terraform resource types to the resources of the estimate.
+var resourceTypes = map[string]string{
+	"google_project":               Project,
+	"google_container_cluster":     GKECluster,
+	"google_container_node_pool":   GKENodePool,
+	"google_sql_database_instance": CloudSQLInstance,
+	"google_storage_bucket":        StorageBucket,
+}
+
+type plan struct {
+	ResourceChanges []struct {
+		Type   string `json:"type"`
+		Change struct {
+			Actions []string `json:"actions"`
+		} `json:"change"`
+	} `json:"resource_changes"`
+}
+
+// CountPlan counts the resources created by a plan in the JSON format of terraform show -json.
+func CountPlan(content []byte) (map[string]int, error) {
+	var p plan
+	if err := json.Unmarshal(content, &p); err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, rc := range p.ResourceChanges {
+		r, ok := resourceTypes[rc.Type]
+		if ok && slices.Contains(rc.Change.Actions, "create") {
+			counts[r]++
+		}
+	}
+	return counts, nil
+}
+
+// CountPlanFile counts the resources created by a plan file in JSON format.
+func CountPlanFile(file string) (map[string]int, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := CountPlan(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan %s: %w", file, err)
+	}
+	return counts, nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cost estimates the resources created by a deployment, their monthly cost and the
+// project quota of the billing accounts before the stages are applied.
+package cost
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Resources estimated by the helper, the keys of the rate card.
+const (
+	Project          = "project"
+	GKECluster       = "gke_cluster"
+	GKENodePool      = "gke_node_pool"
+	CloudSQLInstance = "cloudsql_instance"
+	StorageBucket    = "storage_bucket"
+)
+
+// Resources is the order of the resources in the estimate.
+var Resources = []string{Project, GKECluster, GKENodePool, CloudSQLInstance, StorageBucket}
+
+//go:embed ratecard.json
+var defaultRateCard []byte
+
+// Rate is the monthly price of a resource.
+type Rate struct {
+	Price float64 `json:"price"`
+	// Note describes what the price includes.
+	Note string `json:"note,omitempty"`
+}
+
+// RateCard has the monthly prices used by the estimate. The default rate card has approximate
+// list prices, it can be copied and updated with the prices of the billing account.
+type RateCard struct {
+	Currency string          `json:"currency"`
+	Updated  string          `json:"updated"`
+	Monthly  map[string]Rate `json:"monthly"`
+}
+
+// DefaultRateCard returns the rate card embedded in the helper.
+func DefaultRateCard() []byte {
+	return defaultRateCard
+}
+
+// LoadRateCard reads a rate card file, the embedded rate card is used when file is empty.
+func LoadRateCard(file string) (RateCard, error) {
+	content := defaultRateCard
+	if file != "" {
+		var err error
+		content, err = os.ReadFile(file)
+		if err != nil {
+			return RateCard{}, err
+		}
+	}
+	var rc RateCard
+	if err := json.Unmarshal(content, &rc); err != nil {
+		return RateCard{}, fmt.Errorf("failed to read rate card %s: %w", file, err)
+	}
+	missing := []string{}
+	for _, r := range Resources {
+		if _, ok := rc.Monthly[r]; !ok {
+			missing = append(missing, r)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return RateCard{}, fmt.Errorf("rate card %s has no price for: %v", file, missing)
+	}
+	return rc, nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package credentials builds the identity of each terraform command, gcloud command and API client of the helper.
+// The identity is passed to each invocation, the environment of the process is never changed.
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+// Environment variables of the google provider and the gcs backend.
+const (
+	ImpersonateEnv     = "GOOGLE_IMPERSONATE_SERVICE_ACCOUNT"
+	CredentialsFileEnv = "GOOGLE_APPLICATION_CREDENTIALS"
+	AccessTokenEnv     = "GOOGLE_OAUTH_ACCESS_TOKEN"
+)
+
+// Environment variables of the gcloud properties.
+const (
+	GcloudImpersonateEnv     = "CLOUDSDK_AUTH_IMPERSONATE_SERVICE_ACCOUNT"
+	GcloudCredentialsFileEnv = "CLOUDSDK_AUTH_CREDENTIAL_FILE_OVERRIDE"
+	GcloudAccessTokenFileEnv = "CLOUDSDK_AUTH_ACCESS_TOKEN_FILE"
+)
+
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// Identity are the credentials of an invocation. The zero value is the application default credentials.
+type Identity struct {
+	// CredentialsFile, if set, is a service account key or a workload identity federation configuration
+	// used instead of the application default credentials.
+	CredentialsFile string
+	// AccessTokenFile, if set, is a file with a short-lived access token used instead of the application
+	// default credentials. The file is read on each invocation, it can be refreshed by another process.
+	AccessTokenFile string
+	// ServiceAccount, if set, is impersonated with the base credentials.
+	ServiceAccount string
+}
+
+// Validate checks that only one kind of base credentials is used and that their files exist.
+func (i Identity) Validate() error {
+	if i.CredentialsFile != "" && i.AccessTokenFile != "" {
+		return fmt.Errorf("only one of the credentials file and the access token file can be used")
+	}
+	for _, f := range []string{i.CredentialsFile, i.AccessTokenFile} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			return fmt.Errorf("credentials not found: %w", err)
+		}
+	}
+	return nil
+}
+
+// Impersonate is the identity impersonating a service account with the same base credentials. An empty service
+// account keeps the identity.
+func (i Identity) Impersonate(serviceAccount string) Identity {
+	if serviceAccount != "" {
+		i.ServiceAccount = serviceAccount
+	}
+	return i
+}
+
+// base describes the base credentials.
+func (i Identity) base() string {
+	switch {
+	case i.CredentialsFile != "":
+		return fmt.Sprintf("credentials file %s", i.CredentialsFile)
+	case i.AccessTokenFile != "":
+		return fmt.Sprintf("access token file %s", i.AccessTokenFile)
+	}
+	return "application default credentials"
+}
+
+// String describes the effective identity, it never contains the secrets of the credentials.
+func (i Identity) String() string {
+	if i.ServiceAccount != "" {
+		return fmt.Sprintf("service account %s impersonated with the %s", i.ServiceAccount, i.base())
+	}
+	return i.base()
+}
+
+// accessToken reads the short-lived access token.
+func (i Identity) accessToken() (string, error) {
+	content, err := os.ReadFile(i.AccessTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the access token: %w", err)
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", fmt.Errorf("access token file %s is empty", i.AccessTokenFile)
+	}
+	return token, nil
+}
+
+// TerraformEnv are the environment variables of the terraform commands.
+func (i Identity) TerraformEnv() (map[string]string, error) {
+	env := map[string]string{}
+	if i.ServiceAccount != "" {
+		env[ImpersonateEnv] = i.ServiceAccount
+	}
+	if i.CredentialsFile != "" {
+		env[CredentialsFileEnv] = i.CredentialsFile
+	}
+	if i.AccessTokenFile != "" {
+		token, err := i.accessToken()
+		if err != nil {
+			return nil, err
+		}
+		env[AccessTokenEnv] = token
+	}
+	return env, nil
+}
+
+// SetTerraformEnv adds the environment variables of the identity to the options of a terraform command.
+func (i Identity) SetTerraformEnv(options *terraform.Options) error {
+	env, err := i.TerraformEnv()
+	if err != nil {
+		return err
+	}
+	if len(env) == 0 {
+		return nil
+	}
+	merged := maps.Clone(options.EnvVars)
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	maps.Copy(merged, env)
+	options.EnvVars = merged
+	return nil
+}
+
+// GcloudEnv are the environment variables of the gcloud commands.
+func (i Identity) GcloudEnv() map[string]string {
+	env := map[string]string{}
+	if i.ServiceAccount != "" {
+		env[GcloudImpersonateEnv] = i.ServiceAccount
+	}
+	if i.CredentialsFile != "" {
+		env[GcloudCredentialsFileEnv] = i.CredentialsFile
+	}
+	if i.AccessTokenFile != "" {
+		env[GcloudAccessTokenFileEnv] = i.AccessTokenFile
+	}
+	return env
+}
+
+// ClientOptions are the options of the Google API clients.
+func (i Identity) ClientOptions(ctx context.Context) ([]option.ClientOption, error) {
+	opts := []option.ClientOption{}
+	switch {
+	case i.CredentialsFile != "":
+		opts = append(opts, option.WithCredentialsFile(i.CredentialsFile))
+	case i.AccessTokenFile != "":
+		token, err := i.accessToken()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})))
+	}
+	if i.ServiceAccount == "" {
+		return append(opts, option.WithScopes(cloudPlatformScope)), nil
+	}
+	ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: i.ServiceAccount,
+		Scopes:          []string{cloudPlatformScope},
+	}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to impersonate %s: %w", i.ServiceAccount, err)
+	}
+	return []option.ClientOption{option.WithTokenSource(ts)}, nil
+}
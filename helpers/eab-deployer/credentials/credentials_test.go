@@ -0,0 +1,109 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentity(t *testing.T) {
+	dir := t.TempDir()
+	wif := filepath.Join(dir, "wif.json")
+	token := filepath.Join(dir, "token")
+	assert.NoError(t, os.WriteFile(wif, []byte(`{"type":"external_account"}`), 0600))
+	assert.NoError(t, os.WriteFile(token, []byte("ya29.secret\n"), 0600))
+	sa := "tf-cb-multitenant@eab-bootstrap.iam.gserviceaccount.com"
+
+	tests := []struct {
+		name      string
+		id        Identity
+		str       string
+		terraform map[string]string
+		gcloud    map[string]string
+	}{
+		{
+			name:      "adc",
+			str:       "application default credentials",
+			terraform: map[string]string{},
+			gcloud:    map[string]string{},
+		},
+		{
+			name:      "impersonation",
+			id:        Identity{}.Impersonate(sa),
+			str:       "service account " + sa + " impersonated with the application default credentials",
+			terraform: map[string]string{ImpersonateEnv: sa},
+			gcloud:    map[string]string{GcloudImpersonateEnv: sa},
+		},
+		{
+			name:      "workload identity federation",
+			id:        Identity{CredentialsFile: wif}.Impersonate(sa),
+			str:       "service account " + sa + " impersonated with the credentials file " + wif,
+			terraform: map[string]string{ImpersonateEnv: sa, CredentialsFileEnv: wif},
+			gcloud:    map[string]string{GcloudImpersonateEnv: sa, GcloudCredentialsFileEnv: wif},
+		},
+		{
+			name:      "access token",
+			id:        Identity{AccessTokenFile: token}.Impersonate(""),
+			str:       "access token file " + token,
+			terraform: map[string]string{AccessTokenEnv: "ya29.secret"},
+			gcloud:    map[string]string{GcloudAccessTokenFileEnv: token},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, tt.id.Validate())
+			assert.Equal(t, tt.str, tt.id.String())
+			assert.NotContains(t, tt.id.String(), "ya29")
+			env, err := tt.id.TerraformEnv()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.terraform, env)
+			assert.Equal(t, tt.gcloud, tt.id.GcloudEnv())
+		})
+	}
+}
+
+func TestSetTerraformEnv(t *testing.T) {
+	sa := "tf-cb-fleetscope@eab-bootstrap.iam.gserviceaccount.com"
+	shared := map[string]string{"TF_LOG": "INFO"}
+	options := &terraform.Options{EnvVars: shared}
+
+	assert.NoError(t, Identity{ServiceAccount: sa}.SetTerraformEnv(options))
+	assert.Equal(t, map[string]string{"TF_LOG": "INFO", ImpersonateEnv: sa}, options.EnvVars)
+	assert.Equal(t, map[string]string{"TF_LOG": "INFO"}, shared, "the environment of other options should not change")
+
+	options = &terraform.Options{}
+	assert.NoError(t, Identity{}.SetTerraformEnv(options))
+	assert.Nil(t, options.EnvVars)
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	token := filepath.Join(dir, "token")
+	assert.NoError(t, os.WriteFile(token, []byte(" \n"), 0600))
+
+	assert.ErrorContains(t, Identity{CredentialsFile: token, AccessTokenFile: token}.Validate(), "only one of")
+	assert.ErrorContains(t, Identity{CredentialsFile: filepath.Join(dir, "missing.json")}.Validate(), "credentials not found")
+
+	_, err := Identity{AccessTokenFile: token}.TerraformEnv()
+	assert.ErrorContains(t, err, "is empty")
+	_, err = Identity{AccessTokenFile: token}.ClientOptions(context.Background())
+	assert.ErrorContains(t, err, "is empty")
+}
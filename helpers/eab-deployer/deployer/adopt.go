@@ -0,0 +1,212 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/backend"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
+)
+
+// AdoptOptions are the options of an Adopt.
+type AdoptOptions struct {
+	// DryRun only reports the adoption, the steps file is not changed.
+	DryRun bool
+	// Force replaces the steps of a steps file that is not empty.
+	Force bool
+}
+
+// AdoptedStage is the adoption of a stage, a stage with gaps is not adopted.
+type AdoptedStage struct {
+	Stage   string   `json:"stage"`
+	Step    string   `json:"step"`
+	Adopted bool     `json:"adopted"`
+	Steps   []string `json:"steps"`
+	Gaps    []string `json:"gaps"`
+}
+
+// AdoptReport is the result of an adoption.
+type AdoptReport struct {
+	Stages []AdoptedStage `json:"stages"`
+	// Repos are the checkouts of the stage repositories, with the commits of their branches.
+	Repos map[string]stages.RepoInspection `json:"repos"`
+}
+
+// Gaps reports if a stage was not adopted.
+func (r AdoptReport) Gaps() bool {
+	return slices.ContainsFunc(r.Stages, func(s AdoptedStage) bool { return !s.Adopted })
+}
+
+// Adopt creates the steps file of a deployment made without the helper. It inspects the bootstrap outputs and
+// state bucket, the stage repositories in the checkout directory and the outputs of the stages, and marks as
+// completed the stages that are deployed. A stage is only adopted when the stages it depends on are adopted,
+// the next Apply deploys the stages that were not adopted.
+func (d *Deployer) Adopt(ctx context.Context, opts AdoptOptions) (AdoptReport, error) {
+	if err := ctx.Err(); err != nil {
+		return AdoptReport{}, err
+	}
+	if n := len(d.steps.Steps); n > 0 && !opts.Force && !opts.DryRun {
+		return AdoptReport{}, fmt.Errorf("the steps file %s already has %d steps, use force to replace them", d.steps.File, n)
+	}
+	if _, err := d.PrepareTerraform(ctx); err != nil {
+		return AdoptReport{}, err
+	}
+	insp := d.inspect(opts.DryRun)
+	report := adoptStages(d.stages, d.tfvars, d.conf, insp)
+	report.Repos = insp.Repos
+	if opts.DryRun {
+		return report, nil
+	}
+
+	for name := range d.steps.Steps {
+		delete(d.steps.Steps, name)
+	}
+	if err := d.steps.SaveSteps(); err != nil {
+		return report, err
+	}
+	for _, s := range report.Stages {
+		if !s.Adopted {
+			continue
+		}
+		for _, step := range append(s.Steps, s.Step) {
+			if err := d.steps.CompleteStep(step); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, nil
+}
+
+// adoptStages finds the stages of the list that can be adopted with the inspection, in execution order.
+func adoptStages(list []stage, tfvars stages.GlobalTFVars, c stages.CommonConf, insp stages.Inspection) AdoptReport {
+	report := AdoptReport{Stages: []AdoptedStage{}}
+	adopted := map[string]bool{}
+	for _, st := range list {
+		var steps, gaps []string
+		if st.custom != nil {
+			steps, gaps = stages.AdoptCustomStage(tfvars, insp, *st.custom)
+		} else {
+			steps, gaps = stages.AdoptStage(tfvars, c, insp, st.name)
+		}
+		for _, dep := range st.dependsOn {
+			if !adopted[dep] {
+				gaps = append(gaps, fmt.Sprintf("stage %s is not adopted", dep))
+			}
+		}
+		adopted[st.name] = len(gaps) == 0
+		report.Stages = append(report.Stages, AdoptedStage{Stage: st.name, Step: st.step, Adopted: adopted[st.name], Steps: steps, Gaps: gaps})
+	}
+	return report
+}
+
+// inspect collects the outputs, the state objects and the repositories of the deployment.
+// The failures are saved as errors of the stage, the inspection continues with the next stages.
+// A dry run does not prepare the bootstrap working copy, the outputs are read from the blueprint code when
+// the working copy does not exist.
+func (d *Deployer) inspect(dryRun bool) stages.Inspection {
+	insp := stages.Inspection{Repos: map[string]stages.RepoInspection{}, CustomStates: map[string]map[string]bool{}, Errors: map[string]string{}}
+	probe := func(stage string, f func(t testing.TB) error) bool {
+		err := run("adopt", d.log, d.trace, f)
+		if err != nil {
+			insp.Errors[stage] = err.Error()
+		}
+		return err == nil
+	}
+
+	probe(stages.BootstrapStep, func(t testing.TB) error {
+		dir, err := d.inspectBootstrapDir(t, dryRun)
+		if err != nil {
+			return err
+		}
+		if insp.StateMigrated, err = utils.FileExists(filepath.Join(dir, backend.File)); err != nil {
+			return err
+		}
+		o := stages.GetBootstrapStepOutputs(t, dir, d.conf.TerraformBinary, d.conf.Identity)
+		insp.Bootstrap = &o
+		insp.StateObjects = d.gcp.ListObjects(t, o.StateBucket)
+		return nil
+	})
+
+	branches := stages.AdoptBranches(d.tfvars)
+	inspectRepo := func(stage, repo string) {
+		if _, ok := insp.Repos[repo]; ok || repo == "" {
+			return
+		}
+		probe(stage, func(t testing.TB) error {
+			r, err := stages.InspectRepo(t, filepath.Join(d.conf.CheckoutPath, repo), branches)
+			insp.Repos[repo] = r
+			return err
+		})
+	}
+	for _, st := range d.stages {
+		if st.custom != nil {
+			cs := *st.custom
+			probe(cs.Name, func(t testing.TB) error {
+				deployed, err := stages.InspectCustomStage(d.tfvars, cs, func(bucket string) []string { return d.gcp.ListObjects(t, bucket) })
+				insp.CustomStates[cs.Name] = deployed
+				return err
+			})
+			continue
+		}
+		for _, repo := range stages.StageStepPrefixes(d.tfvars, st.name) {
+			inspectRepo(st.name, repo)
+		}
+	}
+
+	factoryRepo := d.tfvars.InfraCloudbuildV2RepositoryConfig.Repositories["applicationfactory"].RepositoryName
+	if insp.Repos[factoryRepo].Exists {
+		probe(stages.AppFactoryStep, func(t testing.TB) error {
+			o := d.appFactoryOutputs(t)
+			if len(o.AppGroup) == 0 {
+				return fmt.Errorf("outputs of %s have no applications", stages.AppFactoryStep)
+			}
+			insp.AppFactory = &o
+			return nil
+		})
+	}
+	infraRepo := d.tfvars.InfraCloudbuildV2RepositoryConfig.Repositories["hello-world"].RepositoryName
+	if insp.Repos[infraRepo].Exists {
+		probe(stages.AppInfraStep, func(t testing.TB) error {
+			o := d.appInfraOutputs(t)
+			insp.AppInfra = &o
+			return nil
+		})
+	}
+	if insp.AppInfra != nil {
+		inspectRepo(stages.AppSourceStep, insp.AppInfra.ServiceRepositoryName)
+	}
+	return insp
+}
+
+// inspectBootstrapDir is the directory of the bootstrap code with the outputs. The working copy is only prepared
+// when it is not a dry run.
+func (d *Deployer) inspectBootstrapDir(t testing.TB, dryRun bool) (string, error) {
+	if !dryRun {
+		return stages.PrepareBootstrapDir(t, d.conf)
+	}
+	dir := d.conf.BootstrapDir()
+	exists, err := utils.FileExists(dir)
+	if err != nil || exists {
+		return dir, err
+	}
+	return filepath.Join(d.conf.EABPath, stages.BootstrapStep), nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"context"
+	"testing"
+
+	testinginterface "github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+)
+
+func TestAdoptStages(t *testing.T) {
+	tfvars := stages.GlobalTFVars{
+		Envs: map[string]stages.Env{"development": {}},
+		InfraCloudbuildV2RepositoryConfig: stages.CloudbuildV2RepositoryConfig{
+			Repositories: map[string]stages.Repository{
+				"multitenant":        {RepositoryName: "eab-multitenant"},
+				"fleetscope":         {RepositoryName: "eab-fleetscope"},
+				"applicationfactory": {RepositoryName: "eab-applicationfactory"},
+			},
+		},
+	}
+	branches := map[string]string{"plan": "1", "development": "2", "production": "3"}
+	insp := stages.Inspection{
+		Bootstrap:     &stages.BootstrapOutputs{StateBucket: "bkt-prj-seed-tf-state"},
+		StateMigrated: true,
+		StateObjects:  []string{"terraform/multi_tenant/development/default.tfstate", "terraform/fleet_scope/development/default.tfstate"},
+		Repos: map[string]stages.RepoInspection{
+			"eab-multitenant": {Exists: true, Branches: branches},
+			"eab-fleetscope":  {Exists: true, Branches: branches},
+		},
+	}
+
+	report := adoptStages(stagesList, tfvars, stages.CommonConf{}, insp)
+	adopted := map[string]bool{}
+	for _, s := range report.Stages {
+		adopted[s.Stage] = s.Adopted
+	}
+	assert.Equal(t, map[string]bool{
+		"1-bootstrap":   true,
+		"2-multitenant": true,
+		"3-fleetscope":  true,
+		"4-appfactory":  false,
+		"5-appinfra":    false,
+		"6-appsource":   false,
+	}, adopted)
+	assert.True(t, report.Gaps())
+	assert.Contains(t, report.Stages[4].Gaps, "stage 4-appfactory is not adopted", "stages should not be adopted without their dependencies")
+	assert.Equal(t, "gcp-multitenant", report.Stages[1].Step)
+
+	insp.Repos["eab-multitenant"] = stages.RepoInspection{}
+	report = adoptStages(stagesList, tfvars, stages.CommonConf{}, insp)
+	assert.False(t, report.Stages[2].Adopted)
+	assert.Equal(t, []string{"stage 2-multitenant is not adopted"}, report.Stages[2].Gaps)
+}
+
+func TestAdoptExistingSteps(t *testing.T) {
+	events := []Event{}
+	d := newTestDeployer(t, &events)
+	assert.NoError(t, d.Steps().CompleteStep("gcp-bootstrap"))
+
+	_, err := d.Adopt(context.Background(), AdoptOptions{})
+	assert.ErrorContains(t, err, "already has 1 steps, use force to replace them")
+}
+
+func TestInspectCustomStage(t *testing.T) {
+	events := []Event{}
+	d := newTestDeployer(t, &events)
+	repo := t.TempDir()
+	writeFiles(t, repo, map[string]string{
+		"envs/production/backend.tf": `
+terraform {
+  backend "gcs" {
+    bucket = "bkt-inhouse"
+    prefix = "terraform/inhouse/production"
+  }
+}
+`,
+	})
+	var err error
+	d.stages, err = withCustomStages(stagesList, []stages.CustomStage{{Name: "3a-inhouse", After: "3-fleetscope", Repository: repo, Envs: []string{"production"}}})
+	assert.NoError(t, err)
+	d.gcp.Runf = func(t testinginterface.TB, cmd string, args ...interface{}) gjson.Result {
+		return gjson.Parse(`[{"name": "terraform/inhouse/production/default.tfstate"}]`)
+	}
+
+	insp := d.inspect(true)
+	assert.Equal(t, map[string]bool{"production": true}, insp.CustomStates["3a-inhouse"])
+	assert.NoDirExists(t, d.conf.BootstrapDir(), "a dry run should not prepare the bootstrap working copy")
+
+	report := adoptStages(d.stages, d.tfvars, d.conf, insp)
+	assert.Equal(t, "3a-inhouse", report.Stages[3].Stage)
+	assert.Equal(t, []string{"3a-inhouse.production"}, report.Stages[3].Steps)
+	assert.Empty(t, report.Stages[3].Gaps)
+	assert.True(t, report.Stages[3].Adopted)
+
+	insp.CustomStates["3a-inhouse"]["production"] = false
+	report = adoptStages(d.stages, d.tfvars, d.conf, insp)
+	assert.Equal(t, []string{"state of environment production not found"}, report.Stages[3].Gaps)
+	assert.False(t, report.Stages[3].Adopted)
+}
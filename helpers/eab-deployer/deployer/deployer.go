@@ -0,0 +1,625 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package deployer deploys and destroys the Enterprise Application Blueprint stages.
+// It is the library used by the eab-deployer command and can be embedded in other tools.
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/mitchellh/go-testing-interface"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/credentials"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/hooks"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/msg"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/notify"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/steps"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/telemetry"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
+)
+
+type EventType string
+
+const (
+	StageStarted   EventType = "STAGE_STARTED"
+	StageCompleted EventType = "STAGE_COMPLETED"
+	StageSkipped   EventType = "STAGE_SKIPPED"
+	StageFailed    EventType = "STAGE_FAILED"
+	StepChanged    EventType = "STEP_CHANGED"
+	Log            EventType = "LOG"
+)
+
+const (
+	ActionApply   = "APPLY"
+	ActionSkip    = "SKIP"
+	ActionDestroy = "DESTROY"
+)
+
+// Event is a progress notification of an execution.
+type Event struct {
+	Type EventType `json:"type"`
+	// Operation is apply or destroy for stage events.
+	Operation string    `json:"operation,omitempty"`
+	Stage     string    `json:"stage,omitempty"`
+	Step      string    `json:"step,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// Config is the configuration of a Deployer.
+type Config struct {
+	// TFVarsFile is the path of the global tfvars file.
+	TFVarsFile string
+	// StepsFile is the path of the file used to save the progress, defaults to .steps.json.
+	StepsFile string
+	// CheckoutPath, if set, replaces the code_checkout_path of the tfvars file.
+	CheckoutPath  string
+	DisablePrompt bool
+	// Logger is used by the stages, defaults to the standard output logger.
+	Logger *logger.Logger
+	// Out receives the messages of validations and remediations, defaults to the standard output.
+	Out io.Writer
+	// OnEvent, if set, is called for each progress event.
+	OnEvent func(Event)
+	// StateBackupDir is the directory of the state backups, defaults to the state-backups directory next to the steps file.
+	StateBackupDir string
+	// TerraformCacheDir is the directory of the downloaded terraform binaries, defaults to DefaultTerraformCacheDir.
+	TerraformCacheDir string
+	// HooksFile, if set, is the path of the file with the step hooks and the custom stages.
+	HooksFile string
+	// NotificationsFile, if set, is the path of the file with the sinks of the step notifications.
+	NotificationsFile string
+	// Identity is the base identity of the terraform and gcloud commands, defaults to the application default
+	// credentials. The stages impersonate their service accounts with it.
+	Identity credentials.Identity
+}
+
+// Deployer runs the stages of a deployment. A Deployer must not be used by concurrent executions.
+type Deployer struct {
+	tfvars stages.GlobalTFVars
+	conf   stages.CommonConf
+	steps  steps.Steps
+	out    io.Writer
+	// stateBackupDir is the directory of the state backups.
+	stateBackupDir    string
+	terraformCacheDir string
+	// onEvent is never nil.
+	onEvent func(Event)
+	gcp     gcp.GCP
+	// stages are the stages of the deployment with the custom stages, in execution order.
+	stages []stage
+	hooks  hooks.Config
+	// notifier, if set, sends the step notifications in the background.
+	notifier *notify.Notifier
+	// trace is the Trace of the spans of the executions of the Deployer.
+	trace *telemetry.Trace
+}
+
+// stage is a top level step of the deployment.
+type stage struct {
+	name string
+	step string
+	// dependsOn are the stages with outputs or remote states used by the stage.
+	dependsOn []string
+	deploy    func(t testing.TB, d *Deployer) error
+	destroy   func(t testing.TB, d *Deployer) error
+	// custom is the definition of a custom stage.
+	custom *stages.CustomStage
+}
+
+// stagesList are the stages of the deployment in execution order.
+// The app source stage is not destroyed, its resources are removed by the destruction of the app infra stage.
+var stagesList = []stage{
+	{
+		name: "1-bootstrap",
+		step: "gcp-bootstrap",
+		deploy: func(t testing.TB, d *Deployer) error {
+			return stages.DeployBootstrapStage(t, d.steps, d.tfvars, d.conf)
+		},
+		destroy: func(t testing.TB, d *Deployer) error {
+			return stages.DestroyBootstrapStage(t, d.steps, d.tfvars, d.conf)
+		},
+	},
+	{
+		name:      "2-multitenant",
+		step:      "gcp-multitenant",
+		dependsOn: []string{"1-bootstrap"},
+		deploy: func(t testing.TB, d *Deployer) error {
+			return stages.DeployMultitenantStage(t, d.steps, d.tfvars, d.bootstrapOutputs(t), d.conf)
+		},
+		destroy: func(t testing.TB, d *Deployer) error {
+			return stages.DestroyMultitenantStage(t, d.steps, d.tfvars, d.bootstrapOutputs(t), d.conf)
+		},
+	},
+	{
+		name:      "3-fleetscope",
+		step:      "gcp-fleetscope",
+		dependsOn: []string{"1-bootstrap", "2-multitenant"},
+		deploy: func(t testing.TB, d *Deployer) error {
+			return stages.DeployFleetscopeStage(t, d.steps, d.tfvars, d.bootstrapOutputs(t), d.conf)
+		},
+		destroy: func(t testing.TB, d *Deployer) error {
+			return stages.DestroyFleetscopeStage(t, d.steps, d.tfvars, d.bootstrapOutputs(t), d.conf)
+		},
+	},
+	{
+		name:      "4-appfactory",
+		step:      "gcp-appfactory",
+		dependsOn: []string{"1-bootstrap", "2-multitenant"},
+		deploy: func(t testing.TB, d *Deployer) error {
+			bo := d.bootstrapOutputs(t)
+			sa := bo.CBServiceAccountsEmails["applicationfactory"]
+			if d.conf.DisablePrompt {
+				msg.ConfirmQuota(sa, true)
+			} else {
+				d.steps.WaitApproval("gcp-appfactory", fmt.Sprintf("billing quota increase for %s", sa), func() {
+					msg.ConfirmQuota(sa, false)
+				})
+			}
+			return stages.DeployAppFactoryStage(t, d.steps, d.tfvars, bo, d.conf)
+		},
+		destroy: func(t testing.TB, d *Deployer) error {
+			return stages.DestroyAppFactoryStage(t, d.steps, d.tfvars, d.bootstrapOutputs(t), d.conf)
+		},
+	},
+	{
+		name:      "5-appinfra",
+		step:      "appinfra-hello-world",
+		dependsOn: []string{"1-bootstrap", "2-multitenant", "3-fleetscope", "4-appfactory"},
+		deploy: func(t testing.TB, d *Deployer) error {
+			return stages.DeployAppInfraStage(t, d.steps, d.tfvars, d.bootstrapOutputs(t), d.appFactoryOutputs(t), d.conf)
+		},
+		destroy: func(t testing.TB, d *Deployer) error {
+			return stages.DestroyAppInfraStage(t, d.steps, d.tfvars, d.appFactoryOutputs(t), d.conf)
+		},
+	},
+	{
+		name:      "6-appsource",
+		step:      "gcp-appsource-hello-world",
+		dependsOn: []string{"5-appinfra"},
+		deploy: func(t testing.TB, d *Deployer) error {
+			return stages.DeployAppSourceStage(t, d.steps, d.tfvars, d.appInfraOutputs(t), d.conf)
+		},
+	},
+}
+
+// New creates a Deployer loading the tfvars and steps files of the configuration.
+func New(c Config) (*Deployer, error) {
+	if c.StepsFile == "" {
+		c.StepsFile = ".steps.json"
+	}
+	if c.Logger == nil {
+		c.Logger = utils.GetLogger(false)
+	}
+	if c.Out == nil {
+		c.Out = os.Stdout
+	}
+	if c.OnEvent == nil {
+		c.OnEvent = func(Event) {}
+	}
+	if c.StateBackupDir == "" {
+		c.StateBackupDir = filepath.Join(filepath.Dir(c.StepsFile), "state-backups")
+	}
+	if c.TerraformCacheDir == "" {
+		c.TerraformCacheDir = DefaultTerraformCacheDir()
+	}
+
+	if err := c.Identity.Validate(); err != nil {
+		return nil, err
+	}
+	tfvars, err := stages.ReadGlobalTFVars(c.TFVarsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GlobalTFVars file: %w", err)
+	}
+	if c.CheckoutPath != "" {
+		tfvars.CodeCheckoutPath = c.CheckoutPath
+	}
+	if err := stages.ValidateDirectories(tfvars); err != nil {
+		return nil, fmt.Errorf("failed validating directories: %w", err)
+	}
+	s, err := steps.LoadSteps(c.StepsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state file %s: %w", c.StepsFile, err)
+	}
+	s.OnChange = func(step steps.Step) {
+		c.OnEvent(Event{Type: StepChanged, Step: step.Name, Status: step.Status, Message: step.Error, Time: time.Now().UTC()})
+	}
+	var hc hooks.Config
+	if c.HooksFile != "" {
+		if hc, err = hooks.Load(c.HooksFile); err != nil {
+			return nil, err
+		}
+	}
+	list, err := withCustomStages(stagesList, hc.Stages)
+	if err != nil {
+		return nil, fmt.Errorf("invalid custom stages in %s: %w", c.HooksFile, err)
+	}
+	var n *notify.Notifier
+	if c.NotificationsFile != "" {
+		if n, err = newNotifier(c.NotificationsFile, c.OnEvent); err != nil {
+			return nil, err
+		}
+		onChange := s.OnChange
+		s.OnChange = func(step steps.Step) {
+			onChange(step)
+			n.Notify(step)
+		}
+	}
+
+	d := &Deployer{
+		tfvars: tfvars,
+		conf: stages.CommonConf{
+			EABPath:          tfvars.EABCodePath,
+			CheckoutPath:     tfvars.CodeCheckoutPath,
+			PolicyPath:       tfvars.PolicyLibrary(),
+			DisablePrompt:    c.DisablePrompt,
+			Logger:           c.Logger,
+			TemplateOverlays: tfvars.TemplateOverlays,
+			TemplateValues:   tfvars.TemplateValues,
+			Backend:          tfvars.BackendSettings(),
+			Identity:         c.Identity,
+		},
+		steps:             s,
+		out:               c.Out,
+		stateBackupDir:    c.StateBackupDir,
+		terraformCacheDir: c.TerraformCacheDir,
+		onEvent:           c.OnEvent,
+		gcp:               gcp.NewGCPWithIdentity(c.Identity),
+		stages:            list,
+		hooks:             hc,
+		notifier:          n,
+		trace:             telemetry.NewTrace(),
+	}
+	d.steps.Trace = d.trace
+	if len(hc.Hooks) > 0 {
+		d.steps.Hook = d.runHooks
+	}
+	return d, nil
+}
+
+// newNotifier creates the notifier of a notifications file, the errors of the sinks are logged as warnings.
+func newNotifier(file string, onEvent func(Event)) (*notify.Notifier, error) {
+	nc, err := notify.Load(file)
+	if err != nil {
+		return nil, err
+	}
+	n, err := notify.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notifications file %s: %w", file, err)
+	}
+	n.OnError = func(err error) {
+		onEvent(Event{Type: Log, Message: fmt.Sprintf("# WARNING: %s", err), Time: time.Now().UTC()})
+	}
+	return n, nil
+}
+
+// Close sends the pending step notifications, the Deployer must not be used after Close.
+func (d *Deployer) Close() {
+	if d.notifier != nil {
+		d.notifier.Close()
+	}
+}
+
+// TFVars is the configuration of the deployment.
+func (d *Deployer) TFVars() stages.GlobalTFVars {
+	return d.tfvars
+}
+
+// Steps is the execution state of the deployment.
+func (d *Deployer) Steps() steps.Steps {
+	return d.steps
+}
+
+func (d *Deployer) emit(e Event) {
+	e.Time = time.Now().UTC()
+	d.onEvent(e)
+}
+
+func (d *Deployer) log(msg string) {
+	d.emit(Event{Type: Log, Message: msg})
+}
+
+func (d *Deployer) bootstrapOutputs(t testing.TB) stages.BootstrapOutputs {
+	dir, err := stages.PrepareBootstrapDir(t, d.conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stages.GetBootstrapStepOutputs(t, dir, d.conf.TerraformBinary, d.conf.Identity)
+}
+
+func (d *Deployer) appFactoryOutputs(t testing.TB) stages.AppFactoryOutputs {
+	repo := d.tfvars.InfraCloudbuildV2RepositoryConfig.Repositories["applicationfactory"].RepositoryName
+	return stages.GetAppFactoryStepOutputs(t, filepath.Join(d.conf.CheckoutPath, repo), d.conf.TerraformBinary, d.conf.Identity)
+}
+
+func (d *Deployer) appInfraOutputs(t testing.TB) stages.AppInfraOutputs {
+	repo := d.tfvars.InfraCloudbuildV2RepositoryConfig.Repositories["hello-world"].RepositoryName
+	return stages.GetAppInfraStepOutputs(t, filepath.Join(d.conf.CheckoutPath, repo), d.conf.TerraformBinary, d.conf.Identity)
+}
+
+// PlannedStage is a stage of an execution plan.
+type PlannedStage struct {
+	Stage  string `json:"stage"`
+	Step   string `json:"step"`
+	Status string `json:"status"`
+	Action string `json:"action"`
+	// Reason is why a completed stage will be applied again.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Plan lists the stages of a deployment and if they will be applied or skipped.
+// The completed stages that are stale will be applied again.
+func (d *Deployer) Plan(ctx context.Context) ([]PlannedStage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	stale, err := d.Stale()
+	if err != nil {
+		return nil, err
+	}
+	plan := []PlannedStage{}
+	for _, st := range d.stages {
+		p := PlannedStage{Stage: st.name, Step: st.step, Status: "PENDING", Action: ActionApply}
+		if step, ok := d.steps.Steps[st.step]; ok {
+			p.Status = step.Status
+		}
+		if d.steps.IsStepComplete(st.step) {
+			p.Action = ActionSkip
+		}
+		for _, s := range stale {
+			if s.Stage == st.name {
+				p.Action = ActionApply
+				p.Reason = s.Reason
+			}
+		}
+		plan = append(plan, p)
+	}
+	return plan, nil
+}
+
+// ApplyOptions are the options of an Apply.
+type ApplyOptions struct {
+	// UpTo, if set, is the name of the last stage to be applied, for example 3-fleetscope.
+	UpTo string
+	// ConfirmStale, if set, is called with the completed stages that will be applied again because their inputs
+	// changed. When it returns false the stale stages are not applied again.
+	ConfirmStale func([]StaleStage) bool
+	// PushOnly pushes the code of the steps applied by pipelines and records their commits without waiting for
+	// their builds. The apply stops before the first stage that depends on a pushed stage, Reconcile checks the
+	// builds of the pushed commits.
+	PushOnly bool
+}
+
+// DestroyOptions are the options of a Destroy.
+type DestroyOptions struct {
+	// KeepStepsFile keeps the steps file after all the stages are destroyed.
+	KeepStepsFile bool
+}
+
+// stageIndex is the index of a stage by name, -1 if it does not exist.
+func stageIndex(list []stage, name string) int {
+	for i, st := range list {
+		if st.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// runStage runs a stage operation in the step of the stage, the step is marked as failed when the operation fails.
+func (d *Deployer) runStage(operation string, st stage, f func(t testing.TB, d *Deployer) error, runStep func(string, func() error) error, done func(string) bool) error {
+	if done(st.step) {
+		d.emit(Event{Type: StageSkipped, Operation: operation, Stage: st.name, Step: st.step})
+		return nil
+	}
+	d.emit(Event{Type: StageStarted, Operation: operation, Stage: st.name, Step: st.step})
+	attrs := []attribute.KeyValue{telemetry.StageKey.String(st.name), telemetry.OperationKey.String(operation)}
+	err := d.trace.Run("stage "+st.name, attrs, func(*telemetry.Span) error {
+		return runStep(st.step, func() error {
+			return run(st.name, d.log, d.trace, func(t testing.TB) error {
+				return f(t, d)
+			})
+		})
+	})
+	if err != nil {
+		d.emit(Event{Type: StageFailed, Operation: operation, Stage: st.name, Step: st.step, Message: err.Error()})
+		return fmt.Errorf("%s stage failed: %w", st.name, err)
+	}
+	d.emit(Event{Type: StageCompleted, Operation: operation, Stage: st.name, Step: st.step})
+	return nil
+}
+
+// Apply deploys the stages that are not complete, and the stale stages: the completed stages with inputs that
+// changed since they were applied and the stages that depend on them.
+// The context is checked before each stage, a stage that is running is not interrupted.
+func (d *Deployer) Apply(ctx context.Context, opts ApplyOptions) (err error) {
+	sp := d.trace.StartContext(ctx, "apply")
+	defer sp.Finish(&err)
+	last := len(d.stages) - 1
+	if opts.UpTo != "" {
+		last = stageIndex(d.stages, opts.UpTo)
+		if last < 0 {
+			return fmt.Errorf("unknown stage '%s'", opts.UpTo)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := d.markStale(opts.ConfirmStale); err != nil {
+		return err
+	}
+	if _, err := d.PrepareTerraform(ctx); err != nil {
+		return err
+	}
+	d.conf.PushOnly = opts.PushOnly
+	defer func() { d.conf.PushOnly = false }()
+	for _, st := range d.stages[:last+1] {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if dep := d.pushedDependency(st); dep != "" {
+			d.log(fmt.Sprintf("# stopping before stage %s, stage %s is pushed: reconcile its builds and apply again", st.name, dep))
+			return nil
+		}
+		ran := !d.steps.IsStepComplete(st.step)
+		runStep := d.steps.RunStep
+		if opts.PushOnly {
+			prefixes := stages.StageStepPrefixes(d.tfvars, st.name)
+			runStep = func(step string, f func() error) error {
+				return d.steps.RunStageStep(step, prefixes, f)
+			}
+		}
+		if err := d.runStage("apply", st, st.deploy, runStep, d.steps.IsStepComplete); err != nil {
+			return err
+		}
+		// the stages applied before the inputs were saved get the current inputs, the pushed stages get them
+		// when their builds are reconciled
+		if d.steps.IsStepComplete(st.step) && (ran || d.steps.GetInputs(st.step) == nil) {
+			d.saveInputs(st)
+		}
+	}
+	return nil
+}
+
+// pushedDependency is the first stage used by st whose step is pushed, empty if there is none.
+func (d *Deployer) pushedDependency(st stage) string {
+	for _, name := range st.dependsOn {
+		if i := stageIndex(d.stages, name); i >= 0 && d.steps.IsStepPushed(d.stages[i].step) {
+			return name
+		}
+	}
+	return ""
+}
+
+// Destroy destroys the stages in reverse order. Only terraform resources are destroyed, local directories are not deleted.
+// The context is checked before each stage, a stage that is running is not interrupted.
+func (d *Deployer) Destroy(ctx context.Context, opts DestroyOptions) (err error) {
+	sp := d.trace.StartContext(ctx, "destroy")
+	defer sp.Finish(&err)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := d.PrepareTerraform(ctx); err != nil {
+		return err
+	}
+	for i := len(d.stages) - 1; i >= 0; i-- {
+		st := d.stages[i]
+		if st.destroy == nil {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := d.runStage("destroy", st, st.destroy, d.steps.RunDestroyStep, d.steps.IsStepDestroyed); err != nil {
+			return err
+		}
+	}
+	if opts.KeepStepsFile {
+		return nil
+	}
+	if err := steps.DeleteStepsFile(d.steps.File); err != nil {
+		return fmt.Errorf("failed to delete state file %s: %w", d.steps.File, err)
+	}
+	return nil
+}
+
+// serviceAccounts are the service accounts of the stages, they only exist after 1-bootstrap is deployed.
+func (d *Deployer) serviceAccounts(t testing.TB) map[string]string {
+	if !d.steps.IsStepComplete("gcp-bootstrap") {
+		return map[string]string{}
+	}
+	return d.bootstrapOutputs(t).CBServiceAccountsEmails
+}
+
+// Validate runs all the validations of the tfvars file inputs. The findings are written to the output.
+func (d *Deployer) Validate(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return run("validate", d.log, d.trace, func(t testing.TB) error {
+		g := d.tfvars
+		serviceAccounts := d.serviceAccounts(t)
+		if err := stages.ValidateComponents(t, d.gcp); err != nil {
+			fmt.Fprintf(d.out, "# %s\n", err.Error())
+		}
+		if binary, err := d.PrepareTerraform(ctx); err != nil {
+			fmt.Fprintf(d.out, "# %s\n", err.Error())
+		} else {
+			fmt.Fprintf(d.out, "# Using terraform %s\n", binary)
+		}
+		stages.ValidateBasicFields(t, g)
+		stages.ValidateDestroyFlags(t, g)
+		stages.ValidatePermissions(t, g, d.gcp, serviceAccounts)
+		stages.ValidateRequiredAPIs(t, g, d.gcp)
+		stages.ValidateRepositories(t, g, d.gcp)
+		stages.ValidateNetworkRequirementes(t, g, d.gcp)
+		stages.ValidateRegions(t, g, d.gcp, d.out)
+		stages.ValidatePrivateWorkerPoolRequirementes(t, g, d.gcp)
+		stages.ValidateVPCSCRequirements(t, g, d.gcp, serviceAccounts)
+		return nil
+	})
+}
+
+// Remediate builds and prints the remediation plan of the validation findings, applies it when confirm
+// returns true and validates again. A nil confirm applies the plan without confirmation.
+func (d *Deployer) Remediate(ctx context.Context, confirm func(stages.RemediationPlan) bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var plan stages.RemediationPlan
+	err := run("remediate", d.log, d.trace, func(t testing.TB) error {
+		plan = stages.BuildRemediationPlan(t, d.tfvars, d.gcp)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	plan.Print(d.out)
+	if len(plan) == 0 {
+		return nil
+	}
+	if confirm != nil && !confirm(plan) {
+		fmt.Fprintln(d.out, "# Remediation plan not applied.")
+		return nil
+	}
+	err = run("remediate", d.log, d.trace, func(t testing.TB) error {
+		return plan.Apply(t, d.out)
+	})
+	if err != nil {
+		fmt.Fprintf(d.out, "# Remediation plan failed. Error: %s\n", err.Error())
+	}
+	return errors.Join(err, d.Validate(ctx))
+}
+
+// ListSteps lists the executed steps.
+func (d *Deployer) ListSteps() []string {
+	return d.steps.ListSteps()
+}
+
+// ResetStep marks a step as pending.
+func (d *Deployer) ResetStep(name string) error {
+	return d.steps.ResetStep(name)
+}
@@ -0,0 +1,238 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	testinginterface "github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/steps"
+)
+
+func TestRun(t *testing.T) {
+	logs := []string{}
+	log := func(msg string) { logs = append(logs, msg) }
+
+	err := run("ok", log, nil, func(t testinginterface.TB) error {
+		t.Logf("running %s", t.Name())
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"running ok"}, logs)
+
+	reached := false
+	err = run("fatal", log, nil, func(t testinginterface.TB) error {
+		t.Fatalf("terraform apply failed: %s", "exit status 1")
+		reached = true
+		return nil
+	})
+	assert.EqualError(t, err, "terraform apply failed: exit status 1")
+	assert.False(t, reached, "execution should stop on Fatal")
+
+	err = run("errors", log, nil, func(t testinginterface.TB) error {
+		t.Error("first")
+		t.Errorf("second %d", 2)
+		return nil
+	})
+	assert.EqualError(t, err, "first; second 2")
+
+	err = run("returned", log, nil, func(t testinginterface.TB) error {
+		return errors.New("returned error")
+	})
+	assert.EqualError(t, err, "returned error")
+
+	assert.Panics(t, func() {
+		_ = run("panic", log, nil, func(t testinginterface.TB) error { panic("unexpected") })
+	}, "other panics should not be recovered")
+}
+
+func newTestDeployer(t *testing.T, events *[]Event) *Deployer {
+	file := filepath.Join(t.TempDir(), "global.tfvars")
+	err := stages.WriteGlobalTFVars(file, stages.GlobalTFVars{EABCodePath: t.TempDir(), CodeCheckoutPath: t.TempDir()})
+	assert.NoError(t, err)
+	checkout := t.TempDir()
+	d, err := New(Config{
+		TFVarsFile:   file,
+		StepsFile:    filepath.Join(t.TempDir(), ".steps.json"),
+		CheckoutPath: checkout,
+		OnEvent:      func(e Event) { *events = append(*events, e) },
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, checkout, d.TFVars().CodeCheckoutPath)
+	return d
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{TFVarsFile: filepath.Join(t.TempDir(), "missing.tfvars")})
+	assert.ErrorContains(t, err, "failed to read GlobalTFVars file")
+
+	file := filepath.Join(t.TempDir(), "global.tfvars")
+	assert.NoError(t, stages.WriteGlobalTFVars(file, stages.GlobalTFVars{EABCodePath: t.TempDir(), CodeCheckoutPath: t.TempDir()}))
+	_, err = New(Config{TFVarsFile: file, CheckoutPath: filepath.Join(t.TempDir(), "missing")})
+	assert.ErrorContains(t, err, "failed validating directories")
+}
+
+func TestPlan(t *testing.T) {
+	events := []Event{}
+	d := newTestDeployer(t, &events)
+	s := d.Steps()
+	assert.NoError(t, s.CompleteStep("gcp-bootstrap"))
+	assert.NoError(t, s.FailStep("gcp-multitenant", "build failed"))
+
+	plan, err := d.Plan(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, plan, 6)
+	assert.Equal(t, PlannedStage{Stage: "1-bootstrap", Step: "gcp-bootstrap", Status: "COMPLETED", Action: ActionSkip}, plan[0])
+	assert.Equal(t, PlannedStage{Stage: "2-multitenant", Step: "gcp-multitenant", Status: "FAILED", Action: ActionApply}, plan[1])
+	assert.Equal(t, PlannedStage{Stage: "6-appsource", Step: "gcp-appsource-hello-world", Status: "PENDING", Action: ActionApply}, plan[5])
+
+	assert.Len(t, events, 2)
+	assert.Equal(t, StepChanged, events[0].Type)
+	assert.Equal(t, "gcp-bootstrap", events[0].Step)
+	assert.Equal(t, "COMPLETED", events[0].Status)
+	assert.Equal(t, "gcp-multitenant", events[1].Step)
+	assert.Equal(t, "build failed", events[1].Message)
+}
+
+func TestApplyCancelled(t *testing.T) {
+	events := []Event{}
+	d := newTestDeployer(t, &events)
+
+	err := d.Apply(context.Background(), ApplyOptions{UpTo: "7-unknown"})
+	assert.EqualError(t, err, "unknown stage '7-unknown'")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, d.Apply(ctx, ApplyOptions{}), context.Canceled)
+	assert.ErrorIs(t, d.Destroy(ctx, DestroyOptions{}), context.Canceled)
+	assert.Empty(t, events, "no stage should run")
+	assert.Empty(t, d.ListSteps())
+}
+
+func TestRunStage(t *testing.T) {
+	events := []Event{}
+	d := newTestDeployer(t, &events)
+	st := stage{name: "1-bootstrap", step: "gcp-bootstrap"}
+
+	err := d.runStage("apply", st, func(t testinginterface.TB, d *Deployer) error {
+		t.Fatal("bootstrap failed")
+		return nil
+	}, d.steps.RunStep, d.steps.IsStepComplete)
+	assert.EqualError(t, err, "1-bootstrap stage failed: bootstrap failed")
+	assert.Equal(t, "bootstrap failed", d.steps.GetStepError("gcp-bootstrap"), "step should be marked as failed")
+
+	types := []EventType{}
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []EventType{StageStarted, StepChanged, StepChanged, StageFailed}, types)
+
+	events = events[:0]
+	err = d.runStage("apply", st, func(t testinginterface.TB, d *Deployer) error { return nil }, d.steps.RunStep, d.steps.IsStepComplete)
+	assert.NoError(t, err)
+	assert.True(t, d.steps.IsStepComplete("gcp-bootstrap"))
+	assert.Equal(t, StageCompleted, events[len(events)-1].Type)
+
+	events = events[:0]
+	err = d.runStage("apply", st, func(t testinginterface.TB, d *Deployer) error { return errors.New("should not run") }, d.steps.RunStep, d.steps.IsStepComplete)
+	assert.NoError(t, err)
+	assert.Equal(t, []Event{{Type: StageSkipped, Operation: "apply", Stage: "1-bootstrap", Step: "gcp-bootstrap", Time: events[0].Time}}, events)
+
+	s, err := steps.LoadSteps(d.steps.File)
+	assert.NoError(t, err)
+	assert.True(t, s.IsStepComplete("gcp-bootstrap"), "progress should be saved")
+}
+
+func TestNotifications(t *testing.T) {
+	texts := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		texts = append(texts, body["text"])
+		if len(texts) > 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("EAB_TEST_CHAT_WEBHOOK", srv.URL)
+	file := filepath.Join(t.TempDir(), "notifications.hcl")
+	assert.NoError(t, os.WriteFile(file, []byte(`
+sink "chat" {
+  type    = "slack"
+  url_env = "EAB_TEST_CHAT_WEBHOOK"
+}
+`), 0644))
+	tfvars := filepath.Join(t.TempDir(), "global.tfvars")
+	assert.NoError(t, stages.WriteGlobalTFVars(tfvars, stages.GlobalTFVars{EABCodePath: t.TempDir(), CodeCheckoutPath: t.TempDir()}))
+	events := []Event{}
+	d, err := New(Config{
+		TFVarsFile:        tfvars,
+		StepsFile:         filepath.Join(t.TempDir(), ".steps.json"),
+		NotificationsFile: file,
+		OnEvent:           func(e Event) { events = append(events, e) },
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, d.steps.CompleteStep("gcp-bootstrap"))
+	assert.NoError(t, d.steps.CompleteStep("gcp-bootstrap.migrate-state"))
+	assert.NoError(t, d.steps.FailStep("gcp-multitenant", "build failed"))
+	d.Close()
+	assert.Equal(t, []string{"Step gcp-bootstrap completed", "Step gcp-multitenant failed\nbuild failed"}, texts)
+	assert.Equal(t, StepChanged, events[0].Type, "the events should still be emitted")
+	assert.Equal(t, Event{Type: Log, Message: "# WARNING: sink chat: failed to send the notification of step gcp-multitenant: request failed with status 500 Internal Server Error", Time: events[3].Time}, events[3])
+
+	_, err = New(Config{TFVarsFile: tfvars, NotificationsFile: filepath.Join(t.TempDir(), "missing.hcl")})
+	assert.ErrorContains(t, err, "failed to load notifications file")
+}
+
+func TestRemediateFailure(t *testing.T) {
+	events := []Event{}
+	d := newTestDeployer(t, &events)
+	var out bytes.Buffer
+	d.out = &out
+	d.gcp.Runf = func(t testinginterface.TB, cmd string, args ...interface{}) gjson.Result {
+		if strings.HasPrefix(cmd, "components list") {
+			return gjson.Parse(`[]`)
+		}
+		return gjson.Parse(`[{}]`)
+	}
+	d.gcp.RunCmdE = func(t testinginterface.TB, cmd string, args ...interface{}) (string, error) {
+		return "", errors.New("permission denied")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := d.Remediate(ctx, func(stages.RemediationPlan) bool {
+		// stop before the validation of the remediated findings
+		cancel()
+		return true
+	})
+	assert.ErrorContains(t, err, "permission denied", "the failed remediation should be returned")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Contains(t, out.String(), "# Remediation plan failed.")
+}
@@ -0,0 +1,80 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/cost"
+)
+
+// EstimateOptions are the options of an estimate.
+type EstimateOptions struct {
+	// RateCardFile is the file with the prices, the rate card embedded in the helper is used when it is empty.
+	RateCardFile string
+	// Plans are the plan files in JSON format of each environment, their counts replace the counts of the tfvars file.
+	Plans map[string][]string
+	// ProjectQuota is the project quota of the billing accounts, zero when it is unknown.
+	ProjectQuota int
+	// Offline skips the lookup of the projects linked to the billing accounts.
+	Offline bool
+}
+
+// Estimate estimates the resources created by the deployment, their monthly cost and the project quota of the
+// billing accounts. It only reads the tfvars file, the plans and the billing accounts, nothing is deployed.
+func (d *Deployer) Estimate(ctx context.Context, opts EstimateOptions) (cost.Estimate, error) {
+	if err := ctx.Err(); err != nil {
+		return cost.Estimate{}, err
+	}
+	rc, err := cost.LoadRateCard(opts.RateCardFile)
+	if err != nil {
+		return cost.Estimate{}, err
+	}
+	plans := map[string][]map[string]int{}
+	for env, files := range opts.Plans {
+		if _, ok := d.tfvars.Envs[env]; !ok && env != cost.SharedEnv {
+			return cost.Estimate{}, fmt.Errorf("plan of unknown environment '%s'", env)
+		}
+		for _, f := range files {
+			counts, err := cost.CountPlanFile(f)
+			if err != nil {
+				return cost.Estimate{}, err
+			}
+			plans[env] = append(plans[env], counts)
+		}
+	}
+	e := cost.New(d.tfvars, plans, rc)
+
+	linked := map[string]int{}
+	if !opts.Offline {
+		for _, b := range e.BillingAccounts() {
+			if err := ctx.Err(); err != nil {
+				return cost.Estimate{}, err
+			}
+			err := run("estimate", d.log, d.trace, func(t testing.TB) error {
+				linked[b] = len(d.gcp.ListBillingAccountProjects(t, b))
+				return nil
+			})
+			if err != nil {
+				d.log(fmt.Sprintf("# WARNING: failed to list the projects of billing account %s, they are not included in the quota: %s", b, err))
+			}
+		}
+	}
+	e.CheckQuota(linked, opts.ProjectQuota)
+	return e, nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"context"
+	"testing"
+
+	testinginterface "github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/cost"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+)
+
+func TestEstimate(t *testing.T) {
+	events := []Event{}
+	d := newTestDeployer(t, &events)
+	d.tfvars.BillingAccount = "AAAAAA-AAAAAA-AAAAAA"
+	d.tfvars.Envs = map[string]stages.Env{"development": {BillingAccount: "AAAAAA-AAAAAA-AAAAAA", SubnetsSelfLinks: []string{"sb-d-us-central1"}}}
+	d.gcp.Runf = func(t testinginterface.TB, cmd string, args ...interface{}) gjson.Result {
+		return gjson.Parse(`[{"projectId": "prj-seed"}, {"projectId": "prj-d-svpc"}]`)
+	}
+
+	_, err := d.Estimate(context.Background(), EstimateOptions{Plans: map[string][]string{"staging": {"plan.json"}}})
+	assert.EqualError(t, err, "plan of unknown environment 'staging'")
+
+	e, err := d.Estimate(context.Background(), EstimateOptions{ProjectQuota: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []cost.Quota{{BillingAccount: "AAAAAA-AAAAAA-AAAAAA", Linked: 2, New: 1, Limit: 3}}, e.Quotas)
+	assert.False(t, e.QuotaExceeded())
+
+	e, err = d.Estimate(context.Background(), EstimateOptions{ProjectQuota: 3, Offline: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, e.Quotas[0].Linked)
+}
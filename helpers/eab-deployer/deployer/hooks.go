@@ -0,0 +1,186 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/hooks"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+)
+
+// StageSteps are the steps of the stages of the deployment, with the custom stages of the hooks file when it is
+// set, in execution order.
+func StageSteps(hooksFile string) ([]string, error) {
+	var hc hooks.Config
+	if hooksFile != "" {
+		var err error
+		if hc, err = hooks.Load(hooksFile); err != nil {
+			return nil, err
+		}
+	}
+	list, err := withCustomStages(stagesList, hc.Stages)
+	if err != nil {
+		return nil, fmt.Errorf("invalid custom stages in %s: %w", hooksFile, err)
+	}
+	names := []string{}
+	for _, st := range list {
+		names = append(names, st.step)
+	}
+	return names, nil
+}
+
+// withCustomStages adds the custom stages to a list of stages, each one after its after stage or at the end.
+// A custom stage can only depend on the stages executed before it.
+func withCustomStages(list []stage, custom []stages.CustomStage) ([]stage, error) {
+	list = slices.Clone(list)
+	for _, cs := range custom {
+		if stageIndex(list, cs.Name) >= 0 {
+			return nil, fmt.Errorf("stage %s already exists", cs.Name)
+		}
+		pos := len(list)
+		if cs.After != "" {
+			pos = stageIndex(list, cs.After) + 1
+			if pos == 0 {
+				return nil, fmt.Errorf("unknown stage '%s' in the after of stage %s", cs.After, cs.Name)
+			}
+		}
+		for _, dep := range cs.DependsOn {
+			if stageIndex(list[:pos], dep) < 0 {
+				return nil, fmt.Errorf("stage %s depends on %s, that is not executed before it", cs.Name, dep)
+			}
+		}
+		list = slices.Insert(list, pos, stage{
+			name:      cs.Name,
+			step:      cs.Name,
+			dependsOn: cs.DependsOn,
+			custom:    &cs,
+			deploy: func(t testing.TB, d *Deployer) error {
+				return stages.DeployCustomStage(t, d.steps, d.tfvars, cs, d.conf)
+			},
+			destroy: func(t testing.TB, d *Deployer) error {
+				return stages.DestroyCustomStage(t, d.steps, d.tfvars, cs, d.conf)
+			},
+		})
+	}
+	return list, nil
+}
+
+// stageOf finds the stage of a top level or nested step, and if the step is the top level step of the stage.
+func (d *Deployer) stageOf(step string) (stage, bool) {
+	for _, st := range d.stages {
+		if st.step == step {
+			return st, true
+		}
+	}
+	for _, st := range d.stages {
+		prefixes := append([]string{st.step}, stages.StageStepPrefixes(d.tfvars, st.name)...)
+		for _, p := range prefixes {
+			if strings.HasPrefix(step, p+".") {
+				return st, false
+			}
+		}
+	}
+	return stage{}, false
+}
+
+// runHooks runs the hooks of a step, it is the hook of the steps when the hooks file has hooks.
+func (d *Deployer) runHooks(step, operation, when string) error {
+	st, top := d.stageOf(step)
+	names := []string{step}
+	if top {
+		names = append(names, st.name)
+	}
+	matched := d.hooks.Match(operation, when, names...)
+	if len(matched) == 0 {
+		return nil
+	}
+	in := hooks.Input{Step: step, Stage: st.name, Operation: operation, When: when}
+	err := run("hooks", d.log, d.trace, func(t testing.TB) error {
+		in.Outputs = d.hookOutputs(t)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, h := range matched {
+		d.log(fmt.Sprintf("# running %s hook %s of step %s", when, h.Name, step))
+		if err := d.hooks.Run(context.Background(), h, in, d.out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outputDirs are the terraform directories with the outputs of the completed stages, by stage name. Only the
+// stages applied by the helper have local outputs, the custom stages have the outputs of each environment.
+func (d *Deployer) outputDirs() map[string]string {
+	infraRepos := d.tfvars.InfraCloudbuildV2RepositoryConfig.Repositories
+	dirs := map[string]string{}
+	for _, st := range d.stages {
+		if st.custom != nil {
+			for _, env := range st.custom.StageEnvs(d.tfvars) {
+				if d.steps.IsStepComplete(st.step + "." + env) {
+					dirs[st.name+"."+env] = st.custom.EnvDir(env)
+				}
+			}
+			continue
+		}
+		if !d.steps.IsStepComplete(st.step) {
+			continue
+		}
+		switch st.name {
+		case stages.BootstrapStep:
+			dirs[st.name] = d.conf.BootstrapDir()
+		case stages.AppFactoryStep:
+			dirs[st.name] = filepath.Join(d.conf.CheckoutPath, infraRepos["applicationfactory"].RepositoryName, "envs", "shared")
+		case stages.AppInfraStep:
+			dirs[st.name] = filepath.Join(d.conf.CheckoutPath, infraRepos["hello-world"].RepositoryName, "apps", "default-example", "hello-world", "envs", "shared")
+		}
+	}
+	return dirs
+}
+
+// hookOutputs reads the outputs of the completed stages. The outputs that can not be read are skipped with a warning.
+func (d *Deployer) hookOutputs(t testing.TB) map[string]map[string]any {
+	outputs := map[string]map[string]any{}
+	for name, dir := range d.outputDirs() {
+		options := &terraform.Options{
+			TerraformBinary: d.conf.TerraformBinary,
+			TerraformDir:    dir,
+			Logger:          logger.Discard,
+			NoColor:         true,
+		}
+		err := d.conf.Identity.SetTerraformEnv(options)
+		var o map[string]any
+		if err == nil {
+			o, err = terraform.OutputAllE(t, options)
+		}
+		if err != nil {
+			d.log(fmt.Sprintf("# WARNING: failed to read the outputs of %s: %s", name, err))
+			continue
+		}
+		outputs[name] = o
+	}
+	return outputs
+}
@@ -0,0 +1,129 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/hooks"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+)
+
+func stageNames(list []stage) []string {
+	names := []string{}
+	for _, st := range list {
+		names = append(names, st.name)
+	}
+	return names
+}
+
+func TestWithCustomStages(t *testing.T) {
+	list, err := withCustomStages(stagesList, []stages.CustomStage{
+		{Name: "3a-inhouse", After: "3-fleetscope", DependsOn: []string{"1-bootstrap"}},
+		{Name: "7-cmdb"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1-bootstrap", "2-multitenant", "3-fleetscope", "3a-inhouse", "4-appfactory", "5-appinfra", "6-appsource", "7-cmdb"}, stageNames(list))
+	assert.Equal(t, "3a-inhouse", list[3].step)
+	assert.Equal(t, []string{"1-bootstrap"}, list[3].dependsOn)
+	assert.Len(t, stagesList, 6, "the built-in stages should not change")
+
+	tests := []struct {
+		name   string
+		custom stages.CustomStage
+		errMsg string
+	}{
+		{name: "existing", custom: stages.CustomStage{Name: "4-appfactory"}, errMsg: "stage 4-appfactory already exists"},
+		{name: "after", custom: stages.CustomStage{Name: "3a-inhouse", After: "3-unknown"}, errMsg: "unknown stage '3-unknown' in the after of stage 3a-inhouse"},
+		{name: "depends on", custom: stages.CustomStage{Name: "3a-inhouse", After: "3-fleetscope", DependsOn: []string{"4-appfactory"}}, errMsg: "stage 3a-inhouse depends on 4-appfactory, that is not executed before it"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := withCustomStages(stagesList, []stages.CustomStage{tt.custom})
+			assert.EqualError(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestHooks(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"inhouse/envs/production/main.tf": `output "id" { value = "inhouse" }`,
+		"hooks.hcl": `
+hook "scan" {
+  step    = "5-appinfra"
+  when    = "before"
+  command = ["sh", "-c", "cat > scan.json"]
+}
+
+hook "cmdb" {
+  step    = "eab-applicationfactory.*"
+  when    = "after"
+  command = ["sh", "-c", "exit 1"]
+}
+
+stage "3a-inhouse" {
+  after      = "3-fleetscope"
+  repository = "inhouse"
+  envs       = ["production"]
+}
+`,
+	})
+	file := filepath.Join(t.TempDir(), "global.tfvars")
+	assert.NoError(t, stages.WriteGlobalTFVars(file, stages.GlobalTFVars{
+		EABCodePath:      t.TempDir(),
+		CodeCheckoutPath: t.TempDir(),
+		InfraCloudbuildV2RepositoryConfig: stages.CloudbuildV2RepositoryConfig{
+			Repositories: map[string]stages.Repository{"applicationfactory": {RepositoryName: "eab-applicationfactory"}},
+		},
+	}))
+	d, err := New(Config{TFVarsFile: file, StepsFile: filepath.Join(t.TempDir(), ".steps.json"), HooksFile: filepath.Join(dir, "hooks.hcl")})
+	assert.NoError(t, err)
+
+	plan, err := d.Plan(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, plan, 7)
+	assert.Equal(t, PlannedStage{Stage: "3a-inhouse", Step: "3a-inhouse", Status: "PENDING", Action: ActionApply}, plan[3])
+
+	ran := false
+	assert.NoError(t, d.steps.RunStep("appinfra-hello-world", func() error { ran = true; return nil }))
+	assert.True(t, ran)
+	content, err := os.ReadFile(filepath.Join(dir, "scan.json"))
+	assert.NoError(t, err)
+	var in hooks.Input
+	assert.NoError(t, json.Unmarshal(content, &in))
+	assert.Equal(t, hooks.Input{Step: "appinfra-hello-world", Stage: "5-appinfra", Operation: "apply", When: "before", Outputs: map[string]map[string]any{}}, in)
+
+	err = d.steps.RunStep("eab-applicationfactory.shared", func() error { return nil })
+	assert.EqualError(t, err, "hook cmdb failed: exit status 1")
+	assert.False(t, d.steps.IsStepComplete("eab-applicationfactory.shared"), "the step should fail with the hook")
+
+	steps, err := StageSteps(filepath.Join(dir, "hooks.hcl"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gcp-bootstrap", "gcp-multitenant", "gcp-fleetscope", "3a-inhouse", "gcp-appfactory", "appinfra-hello-world", "gcp-appsource-hello-world"}, steps)
+
+	st, top := d.stageOf("3a-inhouse.production")
+	assert.Equal(t, "3a-inhouse", st.name)
+	assert.False(t, top)
+
+	_, err = New(Config{TFVarsFile: file, HooksFile: filepath.Join(dir, "missing.hcl")})
+	assert.ErrorContains(t, err, "failed to load hooks file")
+}
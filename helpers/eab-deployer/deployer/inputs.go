@@ -0,0 +1,124 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+)
+
+// StaleStage is a completed stage that will be applied again.
+type StaleStage struct {
+	Stage  string `json:"stage"`
+	Step   string `json:"step"`
+	Reason string `json:"reason"`
+}
+
+// Stale finds the completed stages with inputs that changed since they were applied, and the completed stages
+// that depend on them. The stages already marked as stale keep their reason. The stages applied before the
+// inputs were saved are only stale when they depend on a stale stage.
+func (d *Deployer) Stale() ([]StaleStage, error) {
+	stale := []StaleStage{}
+	staleStages := map[string]bool{}
+	for _, st := range d.stages {
+		reason := ""
+		switch {
+		case d.steps.IsStepStale(st.step):
+			reason = d.steps.Steps[st.step].Reason
+		case !d.steps.IsStepComplete(st.step):
+			continue
+		case d.steps.GetInputs(st.step) != nil:
+			inputs, err := d.stageInputs(st)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the inputs of stage %s: %w", st.name, err)
+			}
+			if changed := changedInputs(d.steps.GetInputs(st.step), inputs); len(changed) > 0 {
+				reason = fmt.Sprintf("inputs changed: %s", strings.Join(changed, ", "))
+			}
+		}
+		if reason == "" {
+			for _, dep := range st.dependsOn {
+				if staleStages[dep] {
+					reason = fmt.Sprintf("depends on stale stage %s", dep)
+					break
+				}
+			}
+		}
+		if reason != "" {
+			staleStages[st.name] = true
+			stale = append(stale, StaleStage{Stage: st.name, Step: st.step, Reason: reason})
+		}
+	}
+	return stale, nil
+}
+
+// changedInputs are the names of the inputs with a different checksum.
+func changedInputs(saved, current map[string]string) []string {
+	changed := []string{}
+	for _, k := range slices.Sorted(maps.Keys(current)) {
+		if saved[k] != current[k] {
+			changed = append(changed, k)
+		}
+	}
+	return changed
+}
+
+// markStale marks the stale stages and their nested steps as stale, after the confirmation.
+func (d *Deployer) markStale(confirm func([]StaleStage) bool) error {
+	stale, err := d.Stale()
+	if err != nil {
+		return err
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	for _, s := range stale {
+		d.log(fmt.Sprintf("# %s will be applied again: %s", s.Stage, s.Reason))
+	}
+	if confirm != nil && !confirm(stale) {
+		d.log("# The stages with changed inputs will not be applied again")
+		return nil
+	}
+	for _, s := range stale {
+		if err := d.steps.MarkStale(s.Step, s.Reason, stages.StageStepPrefixes(d.tfvars, s.Stage)...); err != nil {
+			return fmt.Errorf("failed to mark stage %s as stale: %w", s.Stage, err)
+		}
+	}
+	return nil
+}
+
+// stageInputs are the checksums of the inputs of a stage.
+func (d *Deployer) stageInputs(st stage) (map[string]string, error) {
+	if st.custom != nil {
+		return stages.CustomStageInputs(d.tfvars, d.conf, *st.custom)
+	}
+	return stages.StageInputs(d.tfvars, d.conf, st.name)
+}
+
+// saveInputs saves the inputs of a completed stage. A failure is only logged, the stage is not stale on the
+// next apply unless a stage it depends on is stale.
+func (d *Deployer) saveInputs(st stage) {
+	inputs, err := d.stageInputs(st)
+	if err == nil {
+		err = d.steps.SetInputs(st.step, inputs)
+	}
+	if err != nil {
+		d.log(fmt.Sprintf("# WARNING: failed to save the inputs of stage %s: %s", st.name, err))
+	}
+}
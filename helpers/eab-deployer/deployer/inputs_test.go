@@ -0,0 +1,94 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		assert.NoError(t, os.WriteFile(file, []byte(content), 0644))
+	}
+}
+
+func TestStale(t *testing.T) {
+	events := []Event{}
+	d := newTestDeployer(t, &events)
+	writeFiles(t, d.conf.EABPath, map[string]string{
+		"3-fleetscope/envs/development/main.tf":          `module "fleetscope" {}`,
+		"3-fleetscope/envs/development/terraform.tfvars": `namespace_ids = {}`,
+		"build/cloudbuild-tf-apply.yaml":                 "steps: []",
+		"build/cloudbuild-tf-plan.yaml":                  "steps: []",
+		"build/tf-wrapper.sh":                            "#!/bin/bash",
+	})
+	d.tfvars.InfraCloudbuildV2RepositoryConfig.Repositories = map[string]stages.Repository{"fleetscope": {RepositoryName: "eab-fleetscope"}}
+	s := d.Steps()
+	for _, step := range []string{"gcp-bootstrap", "gcp-fleetscope", "eab-fleetscope.copy-code", "appinfra-hello-world", "gcp-appsource-hello-world"} {
+		assert.NoError(t, s.CompleteStep(step))
+	}
+	inputs, err := stages.StageInputs(d.tfvars, d.conf, "3-fleetscope")
+	assert.NoError(t, err)
+	assert.NoError(t, s.SetInputs("gcp-fleetscope", inputs))
+
+	stale, err := d.Stale()
+	assert.NoError(t, err)
+	assert.Empty(t, stale, "stages without saved inputs and unchanged stages are not stale")
+
+	writeFiles(t, d.conf.EABPath, map[string]string{"3-fleetscope/envs/development/terraform.tfvars": `namespace_ids = {frontend = "frontend"}`})
+	stale, err = d.Stale()
+	assert.NoError(t, err)
+	assert.Empty(t, stale, "the tfvars files written by the helper are not code")
+
+	d.tfvars.NamespaceIDs = map[string]string{"frontend": "frontend"}
+	writeFiles(t, d.conf.EABPath, map[string]string{"3-fleetscope/envs/development/main.tf": `module "fleetscope" { source = "../../modules" }`})
+	stale, err = d.Stale()
+	assert.NoError(t, err)
+	assert.Equal(t, []StaleStage{
+		{Stage: "3-fleetscope", Step: "gcp-fleetscope", Reason: "inputs changed: code, tfvars"},
+		{Stage: "5-appinfra", Step: "appinfra-hello-world", Reason: "depends on stale stage 3-fleetscope"},
+		{Stage: "6-appsource", Step: "gcp-appsource-hello-world", Reason: "depends on stale stage 5-appinfra"},
+	}, stale)
+
+	plan, err := d.Plan(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, PlannedStage{Stage: "3-fleetscope", Step: "gcp-fleetscope", Status: "COMPLETED", Action: ActionApply, Reason: "inputs changed: code, tfvars"}, plan[2])
+	assert.Equal(t, ActionSkip, plan[0].Action)
+
+	confirmed := []StaleStage{}
+	assert.NoError(t, d.markStale(func(s []StaleStage) bool { confirmed = s; return false }))
+	assert.Equal(t, stale, confirmed)
+	assert.True(t, s.IsStepComplete("gcp-fleetscope"), "stages should not be marked as stale without confirmation")
+
+	assert.NoError(t, d.markStale(nil))
+	assert.True(t, s.IsStepStale("gcp-fleetscope"))
+	assert.True(t, s.IsStepStale("eab-fleetscope.copy-code"), "nested steps should run again")
+	assert.True(t, s.IsStepStale("gcp-appsource-hello-world"))
+	assert.True(t, s.IsStepComplete("gcp-bootstrap"))
+
+	stale, err = d.Stale()
+	assert.NoError(t, err)
+	assert.Len(t, stale, 3, "stale stages keep their reason")
+	assert.Equal(t, "inputs changed: code, tfvars", stale[0].Reason)
+}
@@ -0,0 +1,152 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/msg"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/steps"
+)
+
+// Statuses of a reconciled step.
+const (
+	ReconcileCompleted = "COMPLETED"
+	ReconcileFailed    = "FAILED"
+	ReconcilePending   = "PENDING"
+	ReconcileRetried   = "RETRIED"
+)
+
+// ReconcileResult is the result of the reconciliation of a pushed step.
+type ReconcileResult struct {
+	Step   string `json:"step"`
+	Commit string `json:"commit,omitempty"`
+	Status string `json:"status"`
+	// Build is the last build of the commit, empty if there is none yet.
+	Build   string `json:"build,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Reconcile checks the builds of the commits of the pushed steps, and the rollouts of their releases, without
+// waiting for them. The steps with a successful build are completed, the steps with a failed build are failed
+// and the builds that failed with a transient error are retried. The pushed stages are completed, or failed,
+// when none of their nested steps is pushed.
+func (d *Deployer) Reconcile(ctx context.Context) ([]ReconcileResult, error) {
+	results := []ReconcileResult{}
+	for _, step := range d.steps.PushedSteps() {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		r := ReconcileResult{Step: step.Name, Commit: step.Push.Commit}
+		err := run("reconcile "+step.Name, d.log, d.trace, func(t testing.TB) error {
+			return d.reconcileStep(t, step.Name, *step.Push, &r)
+		})
+		if err != nil {
+			r.Status = ReconcilePending
+			r.Message = err.Error()
+		}
+		results = append(results, r)
+	}
+	for _, st := range d.stages {
+		if !d.steps.IsStepPushed(st.step) {
+			continue
+		}
+		prefixes := append([]string{st.step}, stages.StageStepPrefixes(d.tfvars, st.name)...)
+		if d.steps.HasPushedSteps(prefixes...) {
+			continue
+		}
+		r := ReconcileResult{Step: st.step, Status: ReconcileCompleted}
+		if failed := failedStep(d.steps, prefixes); failed != "" {
+			r.Status = ReconcileFailed
+			r.Message = fmt.Sprintf("step %s failed", failed)
+			if err := d.steps.FailStep(st.step, r.Message); err != nil {
+				return results, err
+			}
+		} else {
+			if err := d.steps.CompleteStep(st.step); err != nil {
+				return results, err
+			}
+			d.saveInputs(st)
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// reconcileStep checks the last build of the commit of a pushed step on the pushed branch.
+func (d *Deployer) reconcileStep(t testing.TB, name string, p steps.Push, r *ReconcileResult) error {
+	status, build := d.gcp.GetLastBranchBuildStatus(t, p.Project, p.Region, p.Commit, p.Branch)
+	r.Build = build
+	r.Status = ReconcilePending
+	switch status {
+	case "":
+		r.Message = "no build of the commit yet"
+		return nil
+	case gcp.BuildStatusQueued, gcp.BuildStatusWorking:
+		r.Message = fmt.Sprintf("build is %s", status)
+		return nil
+	case gcp.BuildStatusSuccess:
+		if p.Service != "" {
+			commit, err := p.ShortCommit()
+			if err != nil {
+				return err
+			}
+			switch d.gcp.ReconcileRelease(t, p.Project, p.Region, p.Service, commit) {
+			case gcp.ReleaseStatusWorking:
+				r.Message = "release is rolling out"
+				return nil
+			case gcp.ReleaseStatusFailure:
+				r.Status = ReconcileFailed
+				r.Message = fmt.Sprintf("Deploy %s env %s build Failed.\nSee:\nhttps://console.cloud.google.com/deploy/delivery-pipelines?project=%s\nfor details.", p.Repo, p.Service, p.Project)
+				return d.steps.FailStep(name, r.Message)
+			}
+		}
+		r.Status = ReconcileCompleted
+		return d.steps.CompleteStep(name)
+	}
+	if d.gcp.IsRetryableError(t, p.Project, p.Region, build) {
+		retry, err := d.gcp.TriggerNewBuild(t, context.Background(), fmt.Sprintf("projects/%s/locations/%s/builds/%s", p.Project, p.Region, build))
+		if err != nil {
+			return fmt.Errorf("failed to trigger new build: %w", err)
+		}
+		r.Status = ReconcileRetried
+		r.Build = retry
+		r.Message = fmt.Sprintf("build %s failed with a retryable error", build)
+		return nil
+	}
+	r.Status = ReconcileFailed
+	r.Message = fmt.Sprintf("build of %s %s failed\nSee:\n%s\nfor details", p.Repo, p.Branch, msg.BuildErrorURL(p.Project, p.Region, build))
+	return d.steps.FailStep(name, r.Message)
+}
+
+// failedStep is the first failed step nested in a prefix, empty if there is none.
+func failedStep(s steps.Steps, prefixes []string) string {
+	for _, name := range slices.Sorted(maps.Keys(s.Steps)) {
+		for _, p := range prefixes {
+			if strings.HasPrefix(name, p+".") && s.GetStepError(name) != "" {
+				return name
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,125 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	testinginterface "github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/steps"
+)
+
+func TestReconcile(t *testing.T) {
+	events := []Event{}
+	d := newTestDeployer(t, &events)
+	d.tfvars.InfraCloudbuildV2RepositoryConfig.Repositories = map[string]stages.Repository{"multitenant": {RepositoryName: "eab-multitenant"}}
+	builds := map[string]string{"a1b2c3d": "SUCCESS", "e4f5a6b": "WORKING"}
+	branches := map[string]string{"a1b2c3d": "plan", "e4f5a6b": "development", "c7d8e9f": "nonproduction"}
+	d.gcp.Runf = func(t testinginterface.TB, cmd string, args ...interface{}) gjson.Result {
+		filter := args[len(args)-1].(string)
+		commit := strings.TrimPrefix(filter, "substitutions.COMMIT_SHA:")
+		status, ok := builds[commit]
+		if !ok {
+			return gjson.Parse(`[]`)
+		}
+		return gjson.Parse(fmt.Sprintf(`[{"id":"build-%s","status":%q,"substitutions":{"BRANCH_NAME":%q}}]`, commit, status, branches[commit]))
+	}
+	d.gcp.RunCmd = func(t testinginterface.TB, cmd string, args ...interface{}) string {
+		return "Error: terraform apply failed"
+	}
+
+	s := d.Steps()
+	push := func(branch, commit string) *steps.Push {
+		return &steps.Push{Project: "prj-b-cicd", Region: "us-central1", Repo: "eab-multitenant", Branch: branch, Commit: commit}
+	}
+	assert.NoError(t, s.PushStep("gcp-multitenant", nil))
+	assert.NoError(t, s.PushStep("eab-multitenant.plan", push("plan", "a1b2c3d")))
+	assert.NoError(t, s.PushStep("eab-multitenant.development", push("development", "e4f5a6b")))
+	assert.NoError(t, s.PushStep("eab-multitenant.nonproduction", push("nonproduction", "c7d8e9f")))
+
+	results, err := d.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []ReconcileResult{
+		{Step: "eab-multitenant.development", Commit: "e4f5a6b", Status: ReconcilePending, Build: "build-e4f5a6b", Message: "build is WORKING"},
+		{Step: "eab-multitenant.nonproduction", Commit: "c7d8e9f", Status: ReconcilePending, Message: "no build of the commit yet"},
+		{Step: "eab-multitenant.plan", Commit: "a1b2c3d", Status: ReconcileCompleted, Build: "build-a1b2c3d"},
+	}, results)
+	assert.True(t, s.IsStepComplete("eab-multitenant.plan"))
+	assert.True(t, s.IsStepPushed("gcp-multitenant"), "the stage should wait for its pushed steps")
+
+	builds["e4f5a6b"] = "FAILURE"
+	builds["c7d8e9f"] = "SUCCESS"
+	results, err = d.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.Equal(t, ReconcileFailed, results[0].Status)
+	assert.Contains(t, results[0].Message, "build of eab-multitenant development failed")
+	assert.Equal(t, ReconcileCompleted, results[1].Status)
+	assert.Equal(t, ReconcileResult{Step: "gcp-multitenant", Status: ReconcileFailed, Message: "step eab-multitenant.development failed"}, results[2])
+	assert.False(t, s.IsStepComplete("gcp-multitenant"))
+
+	results, err = d.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, results, "nothing should be left to reconcile")
+}
+
+func TestReconcileBranchBuilds(t *testing.T) {
+	events := []Event{}
+	d := newTestDeployer(t, &events)
+	d.tfvars.InfraCloudbuildV2RepositoryConfig.Repositories = map[string]stages.Repository{"multitenant": {RepositoryName: "eab-multitenant"}}
+	// the environment branches are created from the plan commit, the newest build of the commit is the plan build
+	d.gcp.Runf = func(t testinginterface.TB, cmd string, args ...interface{}) gjson.Result {
+		assert.Equal(t, "substitutions.COMMIT_SHA:a1b2c3d", args[len(args)-1])
+		return gjson.Parse(`[
+			{"id":"build-plan","status":"WORKING","substitutions":{"BRANCH_NAME":"plan"}},
+			{"id":"build-development","status":"SUCCESS","substitutions":{"BRANCH_NAME":"development"}}
+		]`)
+	}
+
+	s := d.Steps()
+	assert.NoError(t, s.PushStep("eab-multitenant.development", &steps.Push{Project: "prj-b-cicd", Region: "us-central1", Repo: "eab-multitenant", Branch: "development", Commit: "a1b2c3d"}))
+	assert.NoError(t, s.PushStep("eab-multitenant.nonproduction", &steps.Push{Project: "prj-b-cicd", Region: "us-central1", Repo: "eab-multitenant", Branch: "nonproduction", Commit: "a1b2c3d"}))
+
+	results, err := d.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []ReconcileResult{
+		{Step: "eab-multitenant.development", Commit: "a1b2c3d", Status: ReconcileCompleted, Build: "build-development"},
+		{Step: "eab-multitenant.nonproduction", Commit: "a1b2c3d", Status: ReconcilePending, Message: "no build of the commit yet"},
+	}, results, "each step should only use the builds of its branch")
+}
+
+func TestReconcileInvalidCommit(t *testing.T) {
+	events := []Event{}
+	d := newTestDeployer(t, &events)
+	d.gcp.Runf = func(t testinginterface.TB, cmd string, args ...interface{}) gjson.Result {
+		return gjson.Parse(`[{"id":"build-1","status":"SUCCESS","substitutions":{"BRANCH_NAME":"main"}}]`)
+	}
+
+	s := d.Steps()
+	assert.NoError(t, s.PushStep("eab-hello-world", &steps.Push{Project: "prj-b-cicd", Region: "us-central1", Repo: "eab-hello-world", Branch: "main", Commit: "a1b2", Service: "hello-world"}))
+
+	results, err := d.Reconcile(context.Background())
+	assert.NoError(t, err, "a corrupted commit should not crash the reconciliation")
+	assert.Equal(t, []ReconcileResult{
+		{Step: "eab-hello-world", Commit: "a1b2", Status: ReconcilePending, Build: "build-1", Message: "invalid commit 'a1b2' of eab-hello-world branch main"},
+	}, results)
+}
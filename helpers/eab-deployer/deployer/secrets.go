@@ -0,0 +1,48 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+)
+
+// Secrets creates and rotates the secrets of the repository configs, see stages.ApplySecrets. When the app
+// factory stage is deployed, the connections of the application repositories in the admin projects are also
+// updated on rotation.
+func (d *Deployer) Secrets(ctx context.Context, opts stages.SecretsOptions) ([]stages.SecretResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var results []stages.SecretResult
+	err := run("secrets", d.log, d.trace, func(t testing.TB) error {
+		if d.steps.IsStepComplete("gcp-appfactory") {
+			o := d.appFactoryOutputs(t)
+			for _, name := range slices.Sorted(maps.Keys(o.AppGroup)) {
+				opts.AppConnectionProjects = append(opts.AppConnectionProjects, o.AppGroup[name].AppAdminProjectID)
+			}
+		}
+		var err error
+		results, err = stages.ApplySecrets(t, d.tfvars, gcp.NewSecrets(d.conf.Identity), stages.NewRepositoryChecker(d.gcp), opts)
+		return err
+	})
+	return results, err
+}
@@ -0,0 +1,115 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+)
+
+const (
+	StateList   = "list"
+	StateShow   = "show"
+	StateImport = "import"
+	StateRemove = "rm"
+	StatePull   = "pull"
+	StateBackup = "backup"
+)
+
+// StateRequest is a terraform state operation in a stage environment.
+type StateRequest struct {
+	Operation string
+	// Stage is the name of the stage, for example 3-fleetscope.
+	Stage string
+	// Env is the environment of the stage, empty for 1-bootstrap.
+	Env string
+	// Service is the application service of 5-appinfra, optional when there is only one service.
+	Service string
+	// Args are the addresses of list and rm, the address of show, or the address and the ID of import.
+	Args []string
+}
+
+// validate checks the arguments of the operation.
+func (r StateRequest) validate() error {
+	switch r.Operation {
+	case StateList:
+		return nil
+	case StateShow:
+		if len(r.Args) != 1 {
+			return fmt.Errorf("show requires one address")
+		}
+	case StateImport:
+		if len(r.Args) != 2 {
+			return fmt.Errorf("import requires an address and an ID")
+		}
+	case StateRemove:
+		if len(r.Args) == 0 {
+			return fmt.Errorf("rm requires at least one address")
+		}
+	case StatePull, StateBackup:
+		if len(r.Args) != 0 {
+			return fmt.Errorf("%s has no arguments", r.Operation)
+		}
+	default:
+		return fmt.Errorf("unknown state operation '%s'", r.Operation)
+	}
+	return nil
+}
+
+// State runs a terraform state operation in the terraform directory of a stage environment, with the backend
+// and the service account used by the stage. The state is saved in the backup directory before import and rm.
+// It returns the output of terraform, or the path of the file for a backup.
+func (d *Deployer) State(ctx context.Context, r StateRequest) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if err := r.validate(); err != nil {
+		return "", err
+	}
+	if _, err := d.PrepareTerraform(ctx); err != nil {
+		return "", err
+	}
+	var out string
+	err := run("state", d.log, d.trace, func(t testing.TB) error {
+		target, err := stages.ResolveStateTarget(t, d.tfvars, d.conf, r.Stage, r.Env, r.Service)
+		if err != nil {
+			return err
+		}
+		s, err := stages.NewStateCommand(t, target, d.stateBackupDir, d.conf)
+		if err != nil {
+			return err
+		}
+		switch r.Operation {
+		case StateList:
+			out, err = s.List(t, r.Args...)
+		case StateShow:
+			out, err = s.Show(t, r.Args[0])
+		case StateImport:
+			out, err = s.Import(t, r.Args[0], r.Args[1])
+		case StateRemove:
+			out, err = s.Remove(t, r.Args...)
+		case StatePull:
+			out, err = s.Pull(t)
+		case StateBackup:
+			out, err = s.Backup(t)
+		}
+		return err
+	})
+	return out, err
+}
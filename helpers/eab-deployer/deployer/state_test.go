@@ -0,0 +1,45 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateRequestValidate(t *testing.T) {
+	tests := []struct {
+		r      StateRequest
+		errMsg string
+	}{
+		{r: StateRequest{Operation: StateList}},
+		{r: StateRequest{Operation: StateList, Args: []string{"module.env"}}},
+		{r: StateRequest{Operation: StateShow}, errMsg: "show requires one address"},
+		{r: StateRequest{Operation: StateImport, Args: []string{"google_project.p"}}, errMsg: "import requires an address and an ID"},
+		{r: StateRequest{Operation: StateImport, Args: []string{"google_project.p", "prj"}}},
+		{r: StateRequest{Operation: StateRemove}, errMsg: "rm requires at least one address"},
+		{r: StateRequest{Operation: StateBackup, Args: []string{"x"}}, errMsg: "backup has no arguments"},
+		{r: StateRequest{Operation: "mv"}, errMsg: "unknown state operation 'mv'"},
+	}
+	for _, tt := range tests {
+		err := tt.r.validate()
+		if tt.errMsg == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, tt.errMsg)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/telemetry"
+)
+
+// failure is the panic value used to stop the execution of a stage on Fatal and FailNow.
+type failure struct {
+	msg string
+}
+
+// runT is the testing.TB used by the stages. Fatal failures stop the stage with a panic
+// that is recovered by run and returned as an error, instead of exiting the process.
+type runT struct {
+	testing.RuntimeT
+	name   string
+	log    func(msg string)
+	trace  *telemetry.Trace
+	mu     sync.Mutex
+	errors []string
+}
+
+func newRunT(name string, log func(msg string), trace *telemetry.Trace) *runT {
+	return &runT{name: name, log: log, trace: trace}
+}
+
+func (t *runT) record(msg string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.errors = append(t.errors, strings.TrimSpace(msg))
+}
+
+func (t *runT) Name() string {
+	return t.name
+}
+
+// Trace is the Trace of the execution, the parent of the spans started by the stages.
+func (t *runT) Trace() *telemetry.Trace {
+	return t.trace
+}
+
+func (t *runT) Error(args ...interface{}) {
+	t.record(fmt.Sprint(args...))
+	t.Fail()
+}
+
+func (t *runT) Errorf(format string, args ...interface{}) {
+	t.record(fmt.Sprintf(format, args...))
+	t.Fail()
+}
+
+func (t *runT) FailNow() {
+	t.Fail()
+	panic(failure{msg: "FailNow called"})
+}
+
+func (t *runT) Fatal(args ...interface{}) {
+	t.Fail()
+	panic(failure{msg: strings.TrimSpace(fmt.Sprint(args...))})
+}
+
+func (t *runT) Fatalf(format string, args ...interface{}) {
+	t.Fail()
+	panic(failure{msg: strings.TrimSpace(fmt.Sprintf(format, args...))})
+}
+
+func (t *runT) Log(args ...interface{}) {
+	t.log(strings.TrimSpace(fmt.Sprintln(args...)))
+}
+
+func (t *runT) Logf(format string, args ...interface{}) {
+	t.log(strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
+
+// run runs f with a new runT and converts its failures in an error. The spans started by f are part of trace.
+func run(name string, log func(msg string), trace *telemetry.Trace, f func(t testing.TB) error) (err error) {
+	t := newRunT(name, log, trace)
+	defer func() {
+		if r := recover(); r != nil {
+			fail, ok := r.(failure)
+			if !ok {
+				panic(r)
+			}
+			err = errors.New(fail.msg)
+		}
+	}()
+	err = f(t)
+	if err == nil && t.Failed() {
+		err = errors.New(strings.Join(t.errors, "; "))
+	}
+	return err
+}
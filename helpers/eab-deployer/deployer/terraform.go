@@ -0,0 +1,78 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/tfversion"
+)
+
+// DefaultTerraformCacheDir is the default directory of the terraform binaries downloaded by the helper.
+func DefaultTerraformCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(".eab-deployer", "terraform")
+	}
+	return filepath.Join(dir, "eab-deployer", "terraform")
+}
+
+// PrepareTerraform selects the terraform binary used by the stages and checks its version before any stage runs.
+// It is the terraform_binary of the tfvars file when it is set, otherwise the version pinned by the blueprint
+// is downloaded and verified into the cache. The binary must have the version of the Cloud Build pipelines.
+func (d *Deployer) PrepareTerraform(ctx context.Context) (string, error) {
+	if d.conf.TerraformBinary != "" {
+		return d.conf.TerraformBinary, nil
+	}
+	pin, err := tfversion.Pinned(d.conf.EABPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the terraform version of the blueprint: %w", err)
+	}
+	binary := ""
+	if d.tfvars.TerraformBinary != nil && *d.tfvars.TerraformBinary != "" {
+		binary = *d.tfvars.TerraformBinary
+	} else {
+		d.log(fmt.Sprintf("# Installing terraform %s in %s", pin.Version, d.terraformCacheDir))
+		binary, err = tfversion.Installer{CacheDir: d.terraformCacheDir}.Install(ctx, pin.Version)
+		if err != nil {
+			return "", fmt.Errorf("failed to install terraform %s: %w", pin.Version, err)
+		}
+	}
+	if err := tfversion.Check(ctx, binary, pin); err != nil {
+		return "", err
+	}
+	d.conf.TerraformBinary = binary
+	d.checkImageTag(pin)
+	return binary, nil
+}
+
+// checkImageTag warns when the terraform image of the pipelines was built from a previous version of the blueprint.
+func (d *Deployer) checkImageTag(pin tfversion.Pin) {
+	if !d.steps.IsStepComplete("gcp-bootstrap") || pin.ImageTag == "" {
+		return
+	}
+	_ = run("terraform", d.log, d.trace, func(t testing.TB) error {
+		tag := d.bootstrapOutputs(t).TFTagVersionTerraform
+		if tag != pin.ImageTag {
+			d.log(fmt.Sprintf("# WARNING: the pipelines use the terraform image tag %s and the blueprint has tag %s, apply 1-bootstrap again to build the image of terraform %s", tag, pin.ImageTag, pin.Version))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,51 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deployer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/tfversion"
+)
+
+func TestPrepareTerraform(t *testing.T) {
+	events := []Event{}
+	d := newTestDeployer(t, &events)
+	_, err := d.PrepareTerraform(context.Background())
+	assert.ErrorContains(t, err, "failed to read the terraform version of the blueprint")
+
+	eab := d.conf.EABPath
+	assert.NoError(t, os.MkdirAll(filepath.Join(eab, "1-bootstrap"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(eab, tfversion.Dockerfile), []byte("ARG TERRAFORM_VERSION=1.6.6\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(eab, tfversion.ImageFile), []byte(`docker_tag_version_terraform = "v1"`), 0644))
+
+	binary := filepath.Join(t.TempDir(), "terraform")
+	assert.NoError(t, os.WriteFile(binary, []byte("#!/bin/sh\necho '{\"terraform_version\":\"1.5.7\"}'\n"), 0755))
+	d.tfvars.TerraformBinary = &binary
+	_, err = d.PrepareTerraform(context.Background())
+	assert.ErrorContains(t, err, "is version 1.5.7, the pipelines use version 1.6.6")
+	assert.Empty(t, d.conf.TerraformBinary)
+
+	assert.NoError(t, os.WriteFile(binary, []byte("#!/bin/sh\necho '{\"terraform_version\":\"1.6.6\"}'\n"), 0755))
+	got, err := d.PrepareTerraform(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, binary, got)
+	assert.Equal(t, binary, d.conf.TerraformBinary, "the stages should use the checked binary")
+}
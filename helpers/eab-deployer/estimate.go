@@ -0,0 +1,83 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/cost"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/deployer"
+)
+
+const estimateUsage = `usage:
+  eab-deployer estimate [-rate_card FILE] [-plan ENV=FILE]... [-project_quota N] [-offline]
+  eab-deployer estimate -write_rate_card FILE`
+
+// planFlags are the repeated -plan flags, the plan files in JSON format of each environment.
+type planFlags map[string][]string
+
+func (p planFlags) String() string {
+	return fmt.Sprint(map[string][]string(p))
+}
+
+func (p planFlags) Set(v string) error {
+	env, file, ok := strings.Cut(v, "=")
+	if !ok || env == "" || file == "" {
+		return fmt.Errorf("expected ENV=FILE, got '%s'", v)
+	}
+	p[env] = append(p[env], file)
+	return nil
+}
+
+// runEstimateCommand prints the estimate of the deployment. It fails when the projects of the deployment
+// do not fit in the project quota of a billing account.
+func runEstimateCommand(ctx context.Context, d *deployer.Deployer, args []string) error {
+	fs := flag.NewFlagSet("estimate", flag.ContinueOnError)
+	rateCard := fs.String("rate_card", "", "Rate card `file` with the monthly prices, the rate card of the helper is used by default.")
+	writeRateCard := fs.String("write_rate_card", "", "Write the rate card of the helper to a `file` to update its prices.")
+	quota := fs.Int("project_quota", 0, "Project `quota` of the billing accounts, the quota is not checked when it is zero.")
+	offline := fs.Bool("offline", false, "Do not list the projects linked to the billing accounts.")
+	plans := planFlags{}
+	fs.Var(plans, "plan", "Plan of an environment in JSON format as `ENV=FILE`, created with terraform show -json. Can be repeated.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s\n%s", strings.Join(fs.Args(), " "), estimateUsage)
+	}
+	if *writeRateCard != "" {
+		if err := os.WriteFile(*writeRateCard, cost.DefaultRateCard(), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("# Rate card saved in %s\n", *writeRateCard)
+		return nil
+	}
+
+	e, err := d.Estimate(ctx, deployer.EstimateOptions{RateCardFile: *rateCard, Plans: plans, ProjectQuota: *quota, Offline: *offline})
+	if err != nil {
+		return err
+	}
+	if err := e.Write(os.Stdout); err != nil {
+		return err
+	}
+	if e.QuotaExceeded() {
+		return fmt.Errorf("the projects of the deployment exceed the project quota of a billing account")
+	}
+	return nil
+}
@@ -27,11 +27,16 @@ import (
 
 	"github.com/GoogleCloudPlatform/cloud-foundation-toolkit/infra/blueprint-test/pkg/gcloud"
 	"github.com/GoogleCloudPlatform/cloud-foundation-toolkit/infra/blueprint-test/pkg/utils"
+	"github.com/gruntwork-io/terratest/modules/shell"
+	"github.com/mattn/go-shellwords"
 	"github.com/mitchellh/go-testing-interface"
 	"github.com/tidwall/gjson"
 	"google.golang.org/api/cloudbuild/v1"
 	"google.golang.org/api/option"
 
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/credentials"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/msg"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/telemetry"
 	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/test/integration/testutils"
 )
 
@@ -76,6 +81,7 @@ func init() {
 type GCP struct {
 	Runf            func(t testing.TB, cmd string, args ...interface{}) gjson.Result
 	RunCmd          func(t testing.TB, cmd string, args ...interface{}) string
+	RunCmdE         func(t testing.TB, cmd string, args ...interface{}) (string, error)
 	TriggerNewBuild func(t testing.TB, ctx context.Context, buildName string) (string, error)
 	sleepTime       time.Duration
 }
@@ -85,10 +91,70 @@ func runCmd(t testing.TB, cmd string, args ...interface{}) string {
 	return gcloud.RunCmd(t, utils.StringFromTextAndArgs(append([]interface{}{cmd}, args...)...))
 }
 
+// runCmdE is a wrapper around gcloud.RunCmdE because the original function has an input with a private type
+func runCmdE(t testing.TB, cmd string, args ...interface{}) (string, error) {
+	return gcloud.RunCmdE(t, utils.StringFromTextAndArgs(append([]interface{}{cmd}, args...)...))
+}
+
+// runCmdEWithEnv is like runCmdE with the environment variables of an identity.
+func runCmdEWithEnv(env map[string]string) func(t testing.TB, cmd string, args ...interface{}) (string, error) {
+	return func(t testing.TB, cmd string, args ...interface{}) (string, error) {
+		a, err := shellwords.Parse(utils.StringFromTextAndArgs(append([]interface{}{cmd}, args...)...))
+		if err != nil {
+			return "", err
+		}
+		return shell.RunCommandAndGetStdOutE(t, shell.Command{
+			Command: "gcloud",
+			Args:    append(a, "--format", "json"),
+			Env:     env,
+			Logger:  utils.GetLoggerFromT(),
+		})
+	}
+}
+
+// runCmdWithEnv is like runCmd with the environment variables of an identity.
+func runCmdWithEnv(env map[string]string) func(t testing.TB, cmd string, args ...interface{}) string {
+	run := runCmdEWithEnv(env)
+	return func(t testing.TB, cmd string, args ...interface{}) string {
+		out, err := run(t, cmd, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+}
+
+// runfWithEnv is like gcloud.Runf with the environment variables of an identity.
+func runfWithEnv(env map[string]string) func(t testing.TB, cmd string, args ...interface{}) gjson.Result {
+	run := runCmdWithEnv(env)
+	return func(t testing.TB, cmd string, args ...interface{}) gjson.Result {
+		out := run(t, cmd, args...)
+		if !gjson.Valid(out) {
+			t.Fatalf("Error parsing output, invalid json: %s", out)
+		}
+		return gjson.Parse(out)
+	}
+}
+
 // triggerNewBuild triggers a new build based on the build provided
 func triggerNewBuild(t testing.TB, ctx context.Context, buildName string) (string, error) {
+	return triggerNewBuildWithIdentity(credentials.Identity{})(t, ctx, buildName)
+}
+
+// triggerNewBuildWithIdentity triggers new builds with the credentials of an identity.
+func triggerNewBuildWithIdentity(id credentials.Identity) func(t testing.TB, ctx context.Context, buildName string) (string, error) {
+	return func(t testing.TB, ctx context.Context, buildName string) (string, error) {
+		opts, err := id.ClientOptions(ctx)
+		if err != nil {
+			return "", err
+		}
+		return retryBuild(ctx, buildName, opts...)
+	}
+}
 
-	buildService, err := cloudbuild.NewService(ctx, option.WithScopes(cloudbuild.CloudPlatformScope))
+// retryBuild retries a build with the Cloud Build API.
+func retryBuild(ctx context.Context, buildName string, opts ...option.ClientOption) (string, error) {
+	buildService, err := cloudbuild.NewService(ctx, opts...)
 	if err != nil {
 		return "", fmt.Errorf("failed to create Cloud Build service: %w", err)
 	}
@@ -111,11 +177,28 @@ func NewGCP() GCP {
 	return GCP{
 		Runf:            gcloud.Runf,
 		RunCmd:          runCmd,
+		RunCmdE:         runCmdE,
 		TriggerNewBuild: triggerNewBuild,
 		sleepTime:       20,
 	}
 }
 
+// NewGCPWithIdentity creates a wrapper for Google Cloud Platform CLI with the credentials of an identity, the
+// gcloud commands and the API calls use the identity instead of the credentials of the process.
+func NewGCPWithIdentity(id credentials.Identity) GCP {
+	env := id.GcloudEnv()
+	if len(env) == 0 {
+		return NewGCP()
+	}
+	return GCP{
+		Runf:            runfWithEnv(env),
+		RunCmd:          runCmdWithEnv(env),
+		RunCmdE:         runCmdEWithEnv(env),
+		TriggerNewBuild: triggerNewBuildWithIdentity(id),
+		sleepTime:       20,
+	}
+}
+
 // GetBuilds gets all Cloud Build builds form a project and region that satisfy the given filter.
 func (g GCP) GetBuilds(t testing.TB, projectID, region, filter string) map[string]string {
 	var result = map[string]string{}
@@ -138,6 +221,18 @@ func (g GCP) GetLastBuildStatus(t testing.TB, projectID, region, filter string)
 	return build.Get("status").String(), build.Get("id").String()
 }
 
+// GetLastBranchBuildStatus gets the status and the ID of the last build of a commit pushed to a branch. A commit pushed
+// to several branches has a build for each of them, the builds of the other branches are skipped.
+func (g GCP) GetLastBranchBuildStatus(t testing.TB, projectID, region, commitSha, branch string) (string, string) {
+	builds := g.Runf(t, "builds list --project %s --region %s --sort-by ~createTime --filter %s", projectID, region, fmt.Sprintf("substitutions.COMMIT_SHA:%s", commitSha)).Array()
+	for _, build := range builds {
+		if branch == "" || build.Get("substitutions.BRANCH_NAME").String() == branch {
+			return build.Get("status").String(), build.Get("id").String()
+		}
+	}
+	return "", ""
+}
+
 // GetBuildStatus gets the status of the given build
 func (g GCP) GetBuildStatus(t testing.TB, projectID, region, buildID string) string {
 	return g.Runf(t, "builds describe %s  --project %s --region %s", buildID, projectID, region).Get("status").String()
@@ -160,12 +255,24 @@ func (g GCP) GetRunningBuildID(t testing.TB, projectID, region, filter string, r
 }
 
 // GetFinalBuildState gets the terminal status of the given build. It will wait if build is not finished.
-func (g GCP) GetFinalBuildState(t testing.TB, projectID, region, buildID string, maxBuildRetry int) (string, error) {
-	var status string
+// The changes of status are events of the span of the wait, the time spent in the queue is a wait of its own.
+func (g GCP) GetFinalBuildState(t testing.TB, projectID, region, buildID string, maxBuildRetry int) (status string, err error) {
+	sp := telemetry.TraceOf(t).StartWait(telemetry.Build, "wait build", telemetry.ProjectKey.String(projectID), telemetry.RegionKey.String(region), telemetry.BuildKey.String(buildID))
+	defer sp.Finish(&err)
+	var queue *telemetry.Span
+	defer func() {
+		if queue != nil {
+			queue.End(err)
+		}
+	}()
 	count := 0
 	fmt.Printf("waiting for build %s execution.\n", buildID)
 	status = g.GetBuildStatus(t, projectID, region, buildID)
 	fmt.Printf("build status is %s\n", status)
+	sp.AddEvent("status " + status)
+	if status == BuildStatusQueued {
+		queue = telemetry.TraceOf(t).StartWait(telemetry.BuildQueue, "build queue", telemetry.BuildKey.String(buildID))
+	}
 	for status != BuildStatusSuccess && status != BuildStatusFailure && status != BuildStatusCancelled {
 		fmt.Printf("build status is %s\n", status)
 		if count >= maxBuildRetry {
@@ -173,7 +280,15 @@ func (g GCP) GetFinalBuildState(t testing.TB, projectID, region, buildID string,
 		}
 		count = count + 1
 		time.Sleep(g.sleepTime * time.Second)
+		previous := status
 		status = g.GetBuildStatus(t, projectID, region, buildID)
+		if status != previous {
+			sp.AddEvent("status " + status)
+		}
+		if queue != nil && status != BuildStatusQueued {
+			queue.End(nil)
+			queue = nil
+		}
 	}
 	fmt.Printf("final build status is %s\n", status)
 	return status, nil
@@ -189,8 +304,9 @@ func (g GCP) GetRolloutsStatus(t testing.TB, projectID, region, service, release
 }
 
 // GetFinalRolloutState gets the terminal status of the given rollout. It will wait if build is not finished.
-func (g GCP) GetFinalRolloutState(t testing.TB, projectID, region, serviceName, releaseFullName, targetID string, maxRetry int) (string, error) {
-	var status string
+func (g GCP) GetFinalRolloutState(t testing.TB, projectID, region, serviceName, releaseFullName, targetID string, maxRetry int) (status string, err error) {
+	sp := telemetry.TraceOf(t).StartWait(telemetry.Rollout, "wait rollout", telemetry.ProjectKey.String(projectID), telemetry.RegionKey.String(region), telemetry.ReleaseKey.String(releaseFullName), telemetry.TargetKey.String(targetID))
+	defer sp.Finish(&err)
 	count := 0
 	fmt.Printf("waiting for rollout %s execution.\n", releaseFullName)
 	status = g.GetRolloutsStatus(t, projectID, region, serviceName, releaseFullName, targetID)
@@ -209,10 +325,16 @@ func (g GCP) GetFinalRolloutState(t testing.TB, projectID, region, serviceName,
 }
 
 // WaitBuildSuccess waits for the current build in a repo to finish.
-func (g GCP) WaitBuildSuccess(t testing.TB, project, region, repo, commitSha, failureMsg string, maxBuildRetry, maxErrorRetries int, timeBetweenErrorRetries time.Duration) error {
+func (g GCP) WaitBuildSuccess(t testing.TB, project, region, repo, commitSha, failureMsg string, maxBuildRetry, maxErrorRetries int, timeBetweenErrorRetries time.Duration) (err error) {
 	var filter, status, build string
-	var timeoutErr, err error
+	var timeoutErr error
 	ctx := context.Background()
+	retries := 0
+	sp := telemetry.TraceOf(t).Start("build", telemetry.ProjectKey.String(project), telemetry.RegionKey.String(region), telemetry.RepoKey.String(repo), telemetry.CommitKey.String(commitSha))
+	defer sp.Finish(&err)
+	defer func() {
+		sp.SetAttributes(telemetry.BuildKey.String(build), telemetry.RetryKey.Int(retries))
+	}()
 
 	if commitSha == "" {
 		filter = fmt.Sprintf("source.repoSource.repoName:%s", repo)
@@ -236,7 +358,7 @@ func (g GCP) WaitBuildSuccess(t testing.TB, project, region, repo, commitSha, fa
 
 		if status != BuildStatusSuccess {
 			if !g.IsRetryableError(t, project, region, build) {
-				return fmt.Errorf("%s\nSee:\nhttps://console.cloud.google.com/cloud-build/builds;region=%s/%s?project=%s\nfor details", failureMsg, region, build, project)
+				return fmt.Errorf("%s\nSee:\n%s\nfor details", failureMsg, msg.BuildErrorURL(project, region, build))
 			}
 			fmt.Println("build failed with retryable error. a new build will be triggered.")
 		} else {
@@ -244,6 +366,8 @@ func (g GCP) WaitBuildSuccess(t testing.TB, project, region, repo, commitSha, fa
 		}
 
 		// Trigger a new build
+		retries++
+		telemetry.RecordRetry(telemetry.Build, telemetry.RepoKey.String(repo))
 		build, err = g.TriggerNewBuild(t, ctx, fmt.Sprintf("projects/%s/locations/%s/builds/%s", project, region, build))
 		if err != nil {
 			return fmt.Errorf("failed to trigger new build (attempt %d/%d): %w", i+1, maxErrorRetries, err)
@@ -277,11 +401,13 @@ func (g GCP) GetBuildLogs(t testing.TB, projectID, region, buildID string) strin
 }
 
 // WaitReleaseSuccess waits for the current release in a repo to finish.
-func (g GCP) WaitReleaseSuccess(t testing.TB, project, region, serviceName, commitSha, failureMsg string, maxRetry int) error {
+func (g GCP) WaitReleaseSuccess(t testing.TB, project, region, serviceName, commitSha, failureMsg string, maxRetry int) (err error) {
 
 	releaseFullName := fmt.Sprintf("projects/%s/locations/%s/deliveryPipelines/%s/releases/%s-%s", project, region, serviceName, serviceName, commitSha)
+	sp := telemetry.TraceOf(t).Start("release", telemetry.ProjectKey.String(project), telemetry.RegionKey.String(region), telemetry.ReleaseKey.String(releaseFullName), telemetry.CommitKey.String(commitSha))
+	defer sp.Finish(&err)
 
-	releaseTargets := slices.Collect(maps.Keys(g.GetRelease(t, releaseFullName).Get("targetArtifacts").Map()))
+	releaseTargets := g.releaseTargets(t, project, region, serviceName, releaseFullName)
 	if len(releaseTargets) > 0 {
 		for i, targetID := range releaseTargets {
 			status, err := g.GetFinalRolloutState(t, project, region, serviceName, releaseFullName, targetID, maxRetry)
@@ -299,6 +425,66 @@ func (g GCP) WaitReleaseSuccess(t testing.TB, project, region, serviceName, comm
 	return nil
 }
 
+// ReconcileRelease checks the rollouts of the release of a commit without waiting. The targets are rolled out in
+// the order of the delivery pipeline, the next target is promoted when the rollout of the previous one succeeded. It returns ReleaseStatusSuccess
+// when all the rollouts succeeded, ReleaseStatusFailure when one failed, and ReleaseStatusWorking otherwise.
+func (g GCP) ReconcileRelease(t testing.TB, project, region, serviceName, commitSha string) string {
+	releaseFullName := fmt.Sprintf("projects/%s/locations/%s/deliveryPipelines/%s/releases/%s-%s", project, region, serviceName, serviceName, commitSha)
+	releaseTargets := g.releaseTargets(t, project, region, serviceName, releaseFullName)
+	for i, targetID := range releaseTargets {
+		status := g.GetRolloutsStatus(t, project, region, serviceName, releaseFullName, targetID)
+		switch status {
+		case ReleaseStatusSuccess:
+			continue
+		case ReleaseStatusFailure, ReleaseStatusCancelled:
+			return ReleaseStatusFailure
+		case "":
+			if i > 0 {
+				g.PromoteRelease(t, releaseFullName, serviceName, region, targetID)
+			}
+		}
+		return ReleaseStatusWorking
+	}
+	return ReleaseStatusSuccess
+}
+
+// GetPipelineTargets gets the targets of the stages of a Cloud Deploy delivery pipeline, in promotion order.
+func (g GCP) GetPipelineTargets(t testing.TB, project, region, pipeline string) []string {
+	for _, p := range g.Runf(t, "deploy delivery-pipelines list --project=%s --region=%s", project, region).Array() {
+		if strings.HasSuffix(p.Get("name").String(), "/deliveryPipelines/"+pipeline) {
+			return testutils.GetResultFieldStrSlice(p.Get("serialPipeline.stages").Array(), "targetId")
+		}
+	}
+	return []string{}
+}
+
+// orderTargets orders the targets of a release by the stages of its delivery pipeline, the targets that are not
+// in the pipeline are sorted after them.
+func orderTargets(pipeline, targets []string) []string {
+	ordered := []string{}
+	for _, p := range pipeline {
+		if slices.Contains(targets, p) && !slices.Contains(ordered, p) {
+			ordered = append(ordered, p)
+		}
+	}
+	for _, target := range slices.Sorted(slices.Values(targets)) {
+		if !slices.Contains(ordered, target) {
+			ordered = append(ordered, target)
+		}
+	}
+	return ordered
+}
+
+// releaseTargets are the targets of a release in promotion order. With clusters in several regions, each
+// environment has a target for each region and the release is promoted region by region.
+func (g GCP) releaseTargets(t testing.TB, project, region, serviceName, releaseFullName string) []string {
+	targets := slices.Collect(maps.Keys(g.GetRelease(t, releaseFullName).Get("targetArtifacts").Map()))
+	if len(targets) == 0 {
+		return targets
+	}
+	return orderTargets(g.GetPipelineTargets(t, project, region, serviceName), targets)
+}
+
 // GetRelease waits for the current release.
 func (g GCP) GetRelease(t testing.TB, releaseFullName string) gjson.Result {
 	return g.Runf(t, "deploy releases describe %s", releaseFullName).Array()[0]
@@ -309,6 +495,21 @@ func (g GCP) PromoteRelease(t testing.TB, releaseFullName, serviceName, region,
 	return g.Runf(t, "deploy releases promote --release=%s --delivery-pipeline=%s --region=%s --to-target=%s", releaseFullName, serviceName, region, nextTargetId)
 }
 
+// HasSourceRepo checks if a Cloud Source Repository exists in a project.
+func (g GCP) HasSourceRepo(t testing.TB, project, name string) bool {
+	for _, r := range g.Runf(t, "source repos list --project %s", project).Array() {
+		if strings.HasSuffix(r.Get("name").String(), "/repos/"+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateSourceRepo creates a Cloud Source Repository in a project.
+func (g GCP) CreateSourceRepo(t testing.TB, project, name string) {
+	g.Runf(t, "source repos create %s --project %s", name, project)
+}
+
 // HasSccNotification checks if a Security Command Center notification exists
 func (g GCP) HasSccNotification(t testing.TB, orgID, sccName string) bool {
 	filter := fmt.Sprintf("name=organizations/%s/notificationConfigs/%s", orgID, sccName)
@@ -375,3 +576,404 @@ func (g GCP) GetAuthToken(t testing.TB) string {
 
 	return result.Get("token").String()
 }
+
+// GetOrganizationDomain gets the domain (display name) of the given organization
+func (g GCP) GetOrganizationDomain(t testing.TB, orgID string) string {
+	return g.Runf(t, "organizations describe %s", orgID).Get("displayName").String()
+}
+
+// ListFolders lists the folders directly under the given organization, the result maps folder name to display name.
+func (g GCP) ListFolders(t testing.TB, orgID string) map[string]string {
+	var result = map[string]string{}
+	for _, f := range g.Runf(t, "resource-manager folders list --organization %s", orgID).Array() {
+		result[f.Get("name").String()] = f.Get("displayName").String()
+	}
+	return result
+}
+
+// ListSharedVPCHostProjects lists the Shared VPC host projects of the given organization.
+func (g GCP) ListSharedVPCHostProjects(t testing.TB, orgID string) []string {
+	return testutils.GetResultFieldStrSlice(g.Runf(t, "compute shared-vpc organizations list-host-projects %s", orgID).Array(), "name")
+}
+
+// ListNetworks lists the self links of the VPC networks in the given project.
+func (g GCP) ListNetworks(t testing.TB, project string) []string {
+	return testutils.GetResultFieldStrSlice(g.Runf(t, "compute networks list --project %s", project).Array(), "selfLink")
+}
+
+// ListSubnetworks lists the self links of the subnetworks of a VPC network in the given project.
+func (g GCP) ListSubnetworks(t testing.TB, project, network string) []string {
+	return testutils.GetResultFieldStrSlice(g.Runf(t, "compute networks subnets list --project %s --network %s", project, network).Array(), "selfLink")
+}
+
+// ListBillingAccountProjects lists the IDs of the projects linked to the given billing account.
+func (g GCP) ListBillingAccountProjects(t testing.TB, billingAccount string) []string {
+	return testutils.GetResultFieldStrSlice(g.Runf(t, "billing projects list --billing-account %s", billingAccount).Array(), "projectId")
+}
+
+// ListObjects lists the names of all the objects of the given bucket.
+func (g GCP) ListObjects(t testing.TB, bucket string) []string {
+	return testutils.GetResultFieldStrSlice(g.Runf(t, "storage objects list gs://%s/**", bucket).Array(), "name")
+}
+
+// ListWorkerPools lists the Cloud Build private worker pools in the given project and region.
+func (g GCP) ListWorkerPools(t testing.TB, project, region string) []string {
+	return testutils.GetResultFieldStrSlice(g.Runf(t, "builds worker-pools list --project %s --region %s", project, region).Array(), "name")
+}
+
+// SecondaryRange is a secondary IP range of a subnetwork.
+type SecondaryRange struct {
+	Name        string
+	IPCIDRRange string
+}
+
+// Subnetwork is the IP plan of a subnetwork.
+type Subnetwork struct {
+	Name                  string
+	Region                string
+	Network               string
+	IPCIDRRange           string
+	PrivateIPGoogleAccess bool
+	SecondaryRanges       []SecondaryRange
+}
+
+// Router is a Cloud Router and the names of its Cloud NAT gateways.
+type Router struct {
+	Name    string
+	Region  string
+	Network string
+	NATs    []string
+}
+
+// FirewallAllowed is a protocol and the ports allowed by a firewall rule.
+type FirewallAllowed struct {
+	Protocol string
+	Ports    []string
+}
+
+// FirewallRule is a VPC firewall rule.
+type FirewallRule struct {
+	Name         string
+	Network      string
+	Direction    string
+	Disabled     bool
+	SourceRanges []string
+	Allowed      []FirewallAllowed
+}
+
+// WorkerPoolNetwork is the network configuration of a Cloud Build private worker pool.
+type WorkerPoolNetwork struct {
+	PeeredNetwork        string
+	PeeredNetworkIPRange string
+	EgressOption         string
+	// AllocatedRanges are the ranges reserved for VPC peering in the peered network.
+	AllocatedRanges []string
+}
+
+// GetSubnetwork gets the IP plan of a subnetwork.
+func (g GCP) GetSubnetwork(t testing.TB, project, region, name string) Subnetwork {
+	res := g.Runf(t, "compute networks subnets describe %s --region=%s --project=%s", name, region, project)
+	s := Subnetwork{
+		Name:                  res.Get("name").String(),
+		Region:                testutils.GetLastSplitElement(res.Get("region").String(), "/"),
+		Network:               res.Get("network").String(),
+		IPCIDRRange:           res.Get("ipCidrRange").String(),
+		PrivateIPGoogleAccess: res.Get("privateIpGoogleAccess").Bool(),
+		SecondaryRanges:       []SecondaryRange{},
+	}
+	for _, r := range res.Get("secondaryIpRanges").Array() {
+		s.SecondaryRanges = append(s.SecondaryRanges, SecondaryRange{
+			Name:        r.Get("rangeName").String(),
+			IPCIDRRange: r.Get("ipCidrRange").String(),
+		})
+	}
+	return s
+}
+
+// ListRouters lists the Cloud Routers of a project in the given region.
+func (g GCP) ListRouters(t testing.TB, project, region string) []Router {
+	routers := []Router{}
+	for _, r := range g.Runf(t, "compute routers list --project=%s --regions=%s", project, region).Array() {
+		routers = append(routers, Router{
+			Name:    r.Get("name").String(),
+			Region:  testutils.GetLastSplitElement(r.Get("region").String(), "/"),
+			Network: r.Get("network").String(),
+			NATs:    testutils.GetResultFieldStrSlice(r.Get("nats").Array(), "name"),
+		})
+	}
+	return routers
+}
+
+// ListFirewallRules lists the firewall rules of a project that apply to the given network self link.
+func (g GCP) ListFirewallRules(t testing.TB, project, network string) []FirewallRule {
+	rules := []FirewallRule{}
+	for _, r := range g.Runf(t, "compute firewall-rules list --project=%s", project).Array() {
+		if r.Get("network").String() != network {
+			continue
+		}
+		rule := FirewallRule{
+			Name:         r.Get("name").String(),
+			Network:      r.Get("network").String(),
+			Direction:    r.Get("direction").String(),
+			Disabled:     r.Get("disabled").Bool(),
+			SourceRanges: utils.GetResultStrSlice(r.Get("sourceRanges").Array()),
+			Allowed:      []FirewallAllowed{},
+		}
+		for _, a := range r.Get("allowed").Array() {
+			rule.Allowed = append(rule.Allowed, FirewallAllowed{
+				Protocol: a.Get("IPProtocol").String(),
+				Ports:    utils.GetResultStrSlice(a.Get("ports").Array()),
+			})
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// GetWorkerPoolNetwork gets the network configuration of a private worker pool and the ranges allocated for it in the peered network.
+func (g GCP) GetWorkerPoolNetwork(t testing.TB, project, region, name string) WorkerPoolNetwork {
+	res := g.Runf(t, "builds worker-pools describe %s --region=%s --project=%s", name, region, project)
+	w := WorkerPoolNetwork{
+		PeeredNetwork:        res.Get("privatePoolV1Config.networkConfig.peeredNetwork").String(),
+		PeeredNetworkIPRange: res.Get("privatePoolV1Config.networkConfig.peeredNetworkIpRange").String(),
+		EgressOption:         res.Get("privatePoolV1Config.networkConfig.egressOption").String(),
+		AllocatedRanges:      []string{},
+	}
+	if w.PeeredNetwork == "" {
+		return w
+	}
+	networkInfo := regexp.MustCompile(`projects/([^/]+)/global/networks/([^/]+)`).FindStringSubmatch(w.PeeredNetwork)
+	if len(networkInfo) == 0 {
+		return w
+	}
+	for _, a := range g.Runf(t, "compute addresses list --global --project=%s", networkInfo[1]).Array() {
+		if a.Get("purpose").String() == "VPC_PEERING" && testutils.GetLastSplitElement(a.Get("network").String(), "/") == networkInfo[2] {
+			w.AllocatedRanges = append(w.AllocatedRanges, fmt.Sprintf("%s/%d", a.Get("address").String(), a.Get("prefixLength").Int()))
+		}
+	}
+	return w
+}
+
+// GetImpersonatedAuthToken gets an access token of the given service account using impersonation.
+func (g GCP) GetImpersonatedAuthToken(t testing.TB, serviceAccount string) string {
+	return g.Runf(t, "auth print-access-token --impersonate-service-account=%s", serviceAccount).Get("token").String()
+}
+
+// GetActiveAccount gets the account of the active gcloud credentials.
+func (g GCP) GetActiveAccount(t testing.TB) string {
+	accounts := g.Runf(t, "auth list --filter=status:ACTIVE").Array()
+	if len(accounts) == 0 {
+		return ""
+	}
+	return accounts[0].Get("account").String()
+}
+
+// Connection is a Cloud Build 2nd gen repository host connection.
+type Connection struct {
+	Name    string
+	HostURI string
+	Stage   string
+	Message string
+}
+
+// ListConnections lists the Cloud Build connections of a project in the given region.
+func (g GCP) ListConnections(t testing.TB, project, region string) []Connection {
+	connections := []Connection{}
+	for _, c := range g.Runf(t, "builds connections list --project=%s --region=%s", project, region).Array() {
+		hostURI := "https://github.com"
+		switch {
+		case c.Get("githubEnterpriseConfig").Exists():
+			hostURI = c.Get("githubEnterpriseConfig.hostUri").String()
+		case c.Get("gitlabConfig").Exists():
+			hostURI = c.Get("gitlabConfig.hostUri").String()
+		}
+		connections = append(connections, Connection{
+			Name:    testutils.GetLastSplitElement(c.Get("name").String(), "/"),
+			HostURI: hostURI,
+			Stage:   c.Get("installationState.stage").String(),
+			Message: c.Get("installationState.message").String(),
+		})
+	}
+	return connections
+}
+
+// ListServiceDirectoryServices lists the full names of the services of a Service Directory namespace.
+func (g GCP) ListServiceDirectoryServices(t testing.TB, project, location, namespace string) []string {
+	return testutils.GetResultFieldStrSlice(g.Runf(t, "service-directory services list --project=%s --location=%s --namespace=%s", project, location, namespace).Array(), "name")
+}
+
+// RegionQuota is a Compute Engine quota of a region.
+type RegionQuota struct {
+	Metric string
+	Limit  float64
+	Usage  float64
+}
+
+// Region is the status and the quotas of a Compute Engine region in a project.
+type Region struct {
+	Name   string
+	Status string
+	Quotas []RegionQuota
+}
+
+// ListRegions lists the status and the quotas of the regions of a project.
+func (g GCP) ListRegions(t testing.TB, project string) []Region {
+	regions := []Region{}
+	for _, res := range g.Runf(t, "compute regions list --project=%s", project).Array() {
+		r := Region{Name: res.Get("name").String(), Status: res.Get("status").String(), Quotas: []RegionQuota{}}
+		for _, q := range res.Get("quotas").Array() {
+			r.Quotas = append(r.Quotas, RegionQuota{Metric: q.Get("metric").String(), Limit: q.Get("limit").Float(), Usage: q.Get("usage").Float()})
+		}
+		regions = append(regions, r)
+	}
+	return regions
+}
+
+// ListFolderProjects lists the IDs of the active projects of a folder with a name.
+func (g GCP) ListFolderProjects(t testing.TB, folderID, name string) []string {
+	projects := []string{}
+	for _, p := range g.Runf(t, "projects list --filter parent.id=%s", strings.TrimPrefix(folderID, "folders/")).Array() {
+		if p.Get("name").String() == name && p.Get("lifecycleState").String() == "ACTIVE" {
+			projects = append(projects, p.Get("projectId").String())
+		}
+	}
+	return projects
+}
+
+// GetProjectNumber gets the number of a project.
+func (g GCP) GetProjectNumber(t testing.TB, project string) string {
+	return g.Runf(t, "projects describe %s", project).Get("projectNumber").String()
+}
+
+// PerimeterPolicy is an ingress or egress policy of a service perimeter.
+type PerimeterPolicy struct {
+	Title        string
+	IdentityType string
+	Identities   []string
+	// Sources are the access levels and resources the requests come from.
+	Sources []string
+	// Resources are the resources the requests go to.
+	Resources []string
+	// Services are the services of the allowed operations.
+	Services []string
+}
+
+// PerimeterConfig is the enforced or the dry-run configuration of a service perimeter.
+type PerimeterConfig struct {
+	Resources          []string
+	RestrictedServices []string
+	AccessLevels       []string
+	IngressPolicies    []PerimeterPolicy
+	EgressPolicies     []PerimeterPolicy
+}
+
+// ServicePerimeter is a VPC Service Controls perimeter.
+type ServicePerimeter struct {
+	Name   string
+	Status PerimeterConfig
+	Spec   PerimeterConfig
+}
+
+// GetServicePerimeter gets a service perimeter by its full name, accessPolicies/POLICY/servicePerimeters/PERIMETER.
+func (g GCP) GetServicePerimeter(t testing.TB, name string) ServicePerimeter {
+	p := g.Runf(t, "access-context-manager perimeters describe %s", name)
+	return ServicePerimeter{
+		Name:   p.Get("name").String(),
+		Status: perimeterConfig(p.Get("status")),
+		Spec:   perimeterConfig(p.Get("spec")),
+	}
+}
+
+func perimeterConfig(c gjson.Result) PerimeterConfig {
+	return PerimeterConfig{
+		Resources:          utils.GetResultStrSlice(c.Get("resources").Array()),
+		RestrictedServices: utils.GetResultStrSlice(c.Get("restrictedServices").Array()),
+		AccessLevels:       utils.GetResultStrSlice(c.Get("accessLevels").Array()),
+		IngressPolicies:    perimeterPolicies(c.Get("ingressPolicies").Array(), "ingressFrom", "ingressTo"),
+		EgressPolicies:     perimeterPolicies(c.Get("egressPolicies").Array(), "egressFrom", "egressTo"),
+	}
+}
+
+func perimeterPolicies(policies []gjson.Result, from, to string) []PerimeterPolicy {
+	result := []PerimeterPolicy{}
+	for _, p := range policies {
+		policy := PerimeterPolicy{
+			Title:        p.Get("title").String(),
+			IdentityType: p.Get(from + ".identityType").String(),
+			Identities:   utils.GetResultStrSlice(p.Get(from + ".identities").Array()),
+			Sources:      []string{},
+			Resources:    utils.GetResultStrSlice(p.Get(to + ".resources").Array()),
+			Services:     testutils.GetResultFieldStrSlice(p.Get(to+".operations").Array(), "serviceName"),
+		}
+		for _, s := range p.Get(from + ".sources").Array() {
+			if s.Get("accessLevel").Exists() {
+				policy.Sources = append(policy.Sources, s.Get("accessLevel").String())
+			} else {
+				policy.Sources = append(policy.Sources, s.Get("resource").String())
+			}
+		}
+		result = append(result, policy)
+	}
+	return result
+}
+
+// VPCSCViolation is a request denied by VPC Service Controls.
+type VPCSCViolation struct {
+	Service   string
+	Method    string
+	Reason    string
+	Principal string
+	DryRun    bool
+}
+
+// ListVPCSCViolations lists the VPC Service Controls violations logged in a project in the given period, for example "7d".
+func (g GCP) ListVPCSCViolations(t testing.TB, project, freshness string, limit int) []VPCSCViolation {
+	filter := `"protoPayload.metadata.@type=\"type.googleapis.com/google.cloud.audit.VpcServiceControlAuditMetadata\""`
+	violations := []VPCSCViolation{}
+	for _, e := range g.Runf(t, "logging read %s --project=%s --freshness=%s --limit=%d", filter, project, freshness, limit).Array() {
+		violations = append(violations, VPCSCViolation{
+			Service:   e.Get("protoPayload.serviceName").String(),
+			Method:    e.Get("protoPayload.methodName").String(),
+			Reason:    e.Get("protoPayload.metadata.violationReason").String(),
+			Principal: e.Get("protoPayload.authenticationInfo.principalEmail").String(),
+			DryRun:    e.Get("protoPayload.metadata.dryRun").Bool(),
+		})
+	}
+	return violations
+}
+
+// GetAccessLevelMembers gets the members of the conditions of a basic access level.
+func (g GCP) GetAccessLevelMembers(t testing.TB, name string) []string {
+	members := []string{}
+	for _, c := range g.Runf(t, "access-context-manager levels describe %s", name).Get("basic.conditions").Array() {
+		members = append(members, utils.GetResultStrSlice(c.Get("members").Array())...)
+	}
+	return members
+}
+
+// InstallComponents installs gcloud components.
+func (g GCP) InstallComponents(t testing.TB, components []string) error {
+	_, err := g.RunCmdE(t, "components install %s --quiet", strings.Join(components, " "))
+	return err
+}
+
+// EnablePrivateGoogleAccess enables Private Google Access in a subnetwork.
+func (g GCP) EnablePrivateGoogleAccess(t testing.TB, project, region, name string) error {
+	_, err := g.RunCmdE(t, "compute networks subnets update %s --region=%s --project=%s --enable-private-ip-google-access", name, region, project)
+	return err
+}
+
+// GetOrgACMPolicyID gets the Access Context Manager policy ID of the organization, it is empty when the organization has no policy.
+func (g GCP) GetOrgACMPolicyID(t testing.TB, orgID string) string {
+	policies := g.Runf(t, "access-context-manager policies list --organization %s --filter parent:organizations/%s --quiet", orgID, orgID).Array()
+	if len(policies) == 0 {
+		return ""
+	}
+	return testutils.GetLastSplitElement(policies[0].Get("name").String(), "/")
+}
+
+// CreateOrgACMPolicy creates the Access Context Manager policy of the organization.
+func (g GCP) CreateOrgACMPolicy(t testing.TB, orgID string) error {
+	_, err := g.RunCmdE(t, "access-context-manager policies create --organization %s --title 'Organization access level policy' --quiet", orgID)
+	return err
+}
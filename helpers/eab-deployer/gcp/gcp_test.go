@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	gotest "testing"
@@ -234,3 +235,196 @@ func TestWaitBuildSuccessRetry(t *gotest.T) {
 	assert.Equal(t, runCmdCallCount, 1, "runCmd getLogs must be called once")
 	assert.Equal(t, triggerNewBuildCallCount, 1, "TriggerNewBuild must be called once")
 }
+
+func TestGetSubnetwork(t *gotest.T) {
+	subnetwork, err := os.ReadFile(filepath.Join(".", "testdata", "subnetwork.json"))
+	assert.NoError(t, err)
+	gcp := GCP{
+		Runf: func(t testing.TB, cmd string, args ...interface{}) gjson.Result {
+			return gjson.Parse(string(subnetwork))
+		},
+	}
+	s := gcp.GetSubnetwork(t, "prj-p-svpc", "us-central1", "sb-p-us-central1")
+	assert.Equal(t, Subnetwork{
+		Name:                  "sb-p-us-central1",
+		Region:                "us-central1",
+		Network:               "https://www.googleapis.com/compute/v1/projects/prj-p-svpc/global/networks/vpc-p-svpc",
+		IPCIDRRange:           "10.1.20.0/24",
+		PrivateIPGoogleAccess: true,
+		SecondaryRanges: []SecondaryRange{
+			{Name: "sb-p-us-central1-secondary-01", IPCIDRRange: "192.168.0.0/18"},
+			{Name: "sb-p-us-central1-secondary-02", IPCIDRRange: "192.168.64.0/18"},
+		},
+	}, s)
+}
+
+func TestListFirewallRules(t *gotest.T) {
+	rules, err := os.ReadFile(filepath.Join(".", "testdata", "firewall_rules.json"))
+	assert.NoError(t, err)
+	gcp := GCP{
+		Runf: func(t testing.TB, cmd string, args ...interface{}) gjson.Result {
+			return gjson.Parse(string(rules))
+		},
+	}
+	r := gcp.ListFirewallRules(t, "prj-p-svpc", "https://www.googleapis.com/compute/v1/projects/prj-p-svpc/global/networks/vpc-p-svpc")
+	assert.Len(t, r, 1, "should only list the rules of the network")
+	assert.Equal(t, []string{"10.11.10.0/28", "10.11.20.0/28"}, r[0].SourceRanges)
+	assert.Equal(t, []FirewallAllowed{{Protocol: "tcp", Ports: []string{"443", "10250"}}}, r[0].Allowed)
+}
+
+func TestListConnections(t *gotest.T) {
+	connections, err := os.ReadFile(filepath.Join(".", "testdata", "connections.json"))
+	assert.NoError(t, err)
+	gcp := GCP{
+		Runf: func(t testing.TB, cmd string, args ...interface{}) gjson.Result {
+			return gjson.Parse(string(connections))
+		},
+	}
+	assert.Equal(t, []Connection{
+		{Name: "github-infra", HostURI: "https://github.com", Stage: "COMPLETE"},
+		{Name: "gitlab-infra", HostURI: "https://gitlab.example.com", Stage: "PENDING_USER_OAUTH", Message: "Could not reach the host."},
+	}, gcp.ListConnections(t, "prj-seed", "us-central1"))
+}
+
+func TestGetServicePerimeter(t *gotest.T) {
+	perimeter, err := os.ReadFile(filepath.Join(".", "testdata", "perimeter.json"))
+	assert.NoError(t, err)
+	gcp := GCP{
+		Runf: func(t testing.TB, cmd string, args ...interface{}) gjson.Result {
+			assert.Equal(t, "accessPolicies/123456789/servicePerimeters/eab_perimeter", args[0], "the perimeter name should not be a pointer")
+			return gjson.Parse(string(perimeter))
+		},
+	}
+	p := gcp.GetServicePerimeter(t, "accessPolicies/123456789/servicePerimeters/eab_perimeter")
+	assert.Equal(t, "accessPolicies/123456789/servicePerimeters/eab_perimeter", p.Name)
+	assert.Equal(t, []string{"storage.googleapis.com"}, p.Status.RestrictedServices)
+	assert.Empty(t, p.Status.AccessLevels)
+	assert.Equal(t, []string{"accessPolicies/123456789/accessLevels/eab_access_level"}, p.Spec.AccessLevels)
+	assert.Equal(t, []PerimeterPolicy{{
+		Title:        "storage-access_level-prj-seed",
+		IdentityType: "ANY_IDENTITY",
+		Identities:   []string{},
+		Sources:      []string{"accessPolicies/123456789/accessLevels/eab_access_level"},
+		Resources:    []string{"projects/111111111111"},
+		Services:     []string{"storage.googleapis.com"},
+	}}, p.Spec.EgressPolicies)
+	assert.Equal(t, []string{"projects/222222222222"}, p.Spec.IngressPolicies[0].Sources)
+	assert.Equal(t, []string{"serviceAccount:mt-sa@prj-seed.iam.gserviceaccount.com"}, p.Spec.IngressPolicies[0].Identities)
+}
+
+func TestListBillingAccountProjects(t *gotest.T) {
+	projects, err := os.ReadFile(filepath.Join(".", "testdata", "billing_projects.json"))
+	assert.NoError(t, err)
+	gcp := GCP{
+		Runf: func(t testing.TB, cmd string, args ...interface{}) gjson.Result {
+			return gjson.Parse(string(projects))
+		},
+	}
+	assert.Equal(t, []string{"prj-seed", "prj-p-svpc"}, gcp.ListBillingAccountProjects(t, "000000-000000-000000"))
+}
+
+func TestListObjects(t *gotest.T) {
+	objects, err := os.ReadFile(filepath.Join(".", "testdata", "state_objects.json"))
+	assert.NoError(t, err)
+	gcp := GCP{
+		Runf: func(t testing.TB, cmd string, args ...interface{}) gjson.Result {
+			return gjson.Parse(string(objects))
+		},
+	}
+	assert.Equal(t, []string{"terraform/bootstrap/default.tfstate", "terraform/multi_tenant/development/default.tfstate"}, gcp.ListObjects(t, "bkt-prj-seed-tf-state"))
+}
+
+func TestReconcileRelease(t *gotest.T) {
+	rollouts := map[string]string{"dev": ReleaseStatusSuccess}
+	promoted := []string{}
+	gcp := GCP{
+		Runf: func(t testing.TB, cmd string, args ...interface{}) gjson.Result {
+			switch {
+			case strings.HasPrefix(cmd, "deploy releases describe"):
+				return gjson.Parse(`[{"targetArtifacts":{"prod":{},"dev":{},"nonprod":{}}}]`)
+			case strings.HasPrefix(cmd, "deploy rollouts list"):
+				target := args[len(args)-1].(string)
+				if s, ok := rollouts[target]; ok {
+					return gjson.Parse(fmt.Sprintf(`[{"state":%q}]`, s))
+				}
+				return gjson.Parse(`[]`)
+			case strings.HasPrefix(cmd, "deploy releases promote"):
+				promoted = append(promoted, args[len(args)-1].(string))
+			}
+			return gjson.Result{}
+		},
+	}
+	assert.Equal(t, ReleaseStatusWorking, gcp.ReconcileRelease(t, "prj-c-hello", "us-central1", "hello-world", "a1b2c3d"))
+	assert.Equal(t, []string{"nonprod"}, promoted)
+
+	rollouts["nonprod"] = ReleaseStatusFailure
+	assert.Equal(t, ReleaseStatusFailure, gcp.ReconcileRelease(t, "prj-c-hello", "us-central1", "hello-world", "a1b2c3d"))
+
+	rollouts["nonprod"] = ReleaseStatusSuccess
+	rollouts["prod"] = ReleaseStatusSuccess
+	assert.Equal(t, ReleaseStatusSuccess, gcp.ReconcileRelease(t, "prj-c-hello", "us-central1", "hello-world", "a1b2c3d"))
+	assert.Equal(t, []string{"nonprod"}, promoted)
+}
+
+func TestOrderTargets(t *gotest.T) {
+	pipeline := []string{"hell-us-central1-deve", "hell-us-east4-deve", "hell-us-central1-prod", "hell-us-east4-prod"}
+	assert.Equal(t, pipeline, orderTargets(pipeline, []string{"hell-us-east4-prod", "hell-us-central1-prod", "hell-us-east4-deve", "hell-us-central1-deve"}))
+	assert.Equal(t, []string{"hell-us-central1-prod", "a", "b"}, orderTargets(pipeline, []string{"b", "hell-us-central1-prod", "a"}), "the targets that are not in the pipeline are sorted after it")
+}
+
+func TestReconcileReleaseRegions(t *gotest.T) {
+	rollouts := map[string]string{"hell-us-central1-deve": ReleaseStatusSuccess}
+	promoted := []string{}
+	gcp := GCP{
+		Runf: func(t testing.TB, cmd string, args ...interface{}) gjson.Result {
+			switch {
+			case strings.HasPrefix(cmd, "deploy delivery-pipelines list"):
+				return gjson.Parse(`[
+					{"name":"projects/prj-c-hello/locations/us-east4/deliveryPipelines/hello","serialPipeline":{"stages":[{"targetId":"hell-us-east4-deve"}]}},
+					{"name":"projects/prj-c-hello/locations/us-east4/deliveryPipelines/hello-world","serialPipeline":{"stages":[
+						{"targetId":"hell-us-central1-deve"},{"targetId":"hell-us-east4-deve"},{"targetId":"hell-us-central1-prod"},{"targetId":"hell-us-east4-prod"}]}}]`)
+			case strings.HasPrefix(cmd, "deploy releases describe"):
+				return gjson.Parse(`[{"targetArtifacts":{"hell-us-central1-prod":{},"hell-us-east4-prod":{},"hell-us-central1-deve":{},"hell-us-east4-deve":{}}}]`)
+			case strings.HasPrefix(cmd, "deploy rollouts list"):
+				target := args[len(args)-1].(string)
+				if s, ok := rollouts[target]; ok {
+					return gjson.Parse(fmt.Sprintf(`[{"state":%q}]`, s))
+				}
+				return gjson.Parse(`[]`)
+			case strings.HasPrefix(cmd, "deploy releases promote"):
+				promoted = append(promoted, args[len(args)-1].(string))
+			}
+			return gjson.Result{}
+		},
+	}
+	assert.Equal(t, ReleaseStatusWorking, gcp.ReconcileRelease(t, "prj-c-hello", "us-east4", "hello-world", "a1b2c3d"))
+	assert.Equal(t, []string{"hell-us-east4-deve"}, promoted, "the release is promoted to the next region of the environment")
+
+	rollouts["hell-us-east4-deve"] = ReleaseStatusSuccess
+	assert.Equal(t, ReleaseStatusWorking, gcp.ReconcileRelease(t, "prj-c-hello", "us-east4", "hello-world", "a1b2c3d"))
+	assert.Equal(t, []string{"hell-us-east4-deve", "hell-us-central1-prod"}, promoted)
+}
+
+func TestRemediationCommands(t *gotest.T) {
+	cmds := []string{}
+	gcp := GCP{
+		Runf: func(t testing.TB, cmd string, args ...interface{}) gjson.Result {
+			cmds = append(cmds, fmt.Sprintf(cmd, args...))
+			return gjson.Parse(`[{"name":"accessPolicies/123456"}]`)
+		},
+		RunCmdE: func(t testing.TB, cmd string, args ...interface{}) (string, error) {
+			cmds = append(cmds, fmt.Sprintf(cmd, args...))
+			return "", nil
+		},
+	}
+	assert.Equal(t, "123456", gcp.GetOrgACMPolicyID(t, "111"))
+	assert.NoError(t, gcp.CreateOrgACMPolicy(t, "111"))
+	assert.NoError(t, gcp.EnablePrivateGoogleAccess(t, "prj-d-svpc", "us-central1", "eab-development"))
+	assert.NoError(t, gcp.InstallComponents(t, []string{"beta", "terraform-tools"}))
+	assert.Equal(t, []string{
+		"access-context-manager policies list --organization 111 --filter parent:organizations/111 --quiet",
+		"access-context-manager policies create --organization 111 --title 'Organization access level policy' --quiet",
+		"compute networks subnets update eab-development --region=us-central1 --project=prj-d-svpc --enable-private-ip-google-access",
+		"components install beta terraform-tools --quiet",
+	}, cmds, "the commands should run with the runners of the identity")
+}
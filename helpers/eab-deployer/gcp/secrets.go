@@ -0,0 +1,212 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/mitchellh/go-testing-interface"
+	cloudbuildv2 "google.golang.org/api/cloudbuild/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/secretmanager/v1"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/credentials"
+)
+
+// Secrets manages the secrets of Secret Manager and the Cloud Build connections that use them. The values of the
+// secrets are only sent to the APIs, they are never passed to gcloud or logged.
+type Secrets struct {
+	GCP
+	id credentials.Identity
+}
+
+// NewSecrets creates a Secrets with the clients of an identity.
+func NewSecrets(id credentials.Identity) Secrets {
+	return Secrets{GCP: NewGCPWithIdentity(id), id: id}
+}
+
+func (s Secrets) options(ctx context.Context) ([]option.ClientOption, error) {
+	return s.id.ClientOptions(ctx)
+}
+
+func (s Secrets) secretManager(ctx context.Context) (*secretmanager.Service, error) {
+	opts, err := s.options(ctx)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := secretmanager.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Secret Manager service: %w", err)
+	}
+	return svc, nil
+}
+
+// SecretExists checks if a secret exists, name is projects/PROJECT/secrets/SECRET.
+func (s Secrets) SecretExists(t testing.TB, name string) (bool, error) {
+	ctx := context.Background()
+	svc, err := s.secretManager(ctx)
+	if err != nil {
+		return false, err
+	}
+	_, err = svc.Projects.Secrets.Get(name).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get secret %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// CreateSecret creates a secret with automatic replication and no versions.
+func (s Secrets) CreateSecret(t testing.TB, name string) error {
+	ctx := context.Background()
+	parent, id, ok := strings.Cut(name, "/secrets/")
+	if !ok {
+		return fmt.Errorf("invalid secret name %s", name)
+	}
+	svc, err := s.secretManager(ctx)
+	if err != nil {
+		return err
+	}
+	secret := &secretmanager.Secret{
+		Replication: &secretmanager.Replication{Automatic: &secretmanager.Automatic{}},
+		Labels:      map[string]string{"managed-by": "eab-deployer"},
+	}
+	_, err = svc.Projects.Secrets.Create(parent, secret).SecretId(id).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to create secret %s: %w", name, err)
+	}
+	return nil
+}
+
+// AddSecretVersion adds a version to a secret and returns the name of the version.
+func (s Secrets) AddSecretVersion(t testing.TB, name string, value []byte) (string, error) {
+	ctx := context.Background()
+	svc, err := s.secretManager(ctx)
+	if err != nil {
+		return "", err
+	}
+	req := &secretmanager.AddSecretVersionRequest{
+		Payload: &secretmanager.SecretPayload{Data: base64.StdEncoding.EncodeToString(value)},
+	}
+	v, err := svc.Projects.Secrets.AddVersion(name, req).Context(ctx).Do()
+	if err != nil {
+		// the error of the API does not contain the payload
+		return "", fmt.Errorf("failed to add a version to secret %s: %w", name, err)
+	}
+	return v.Name, nil
+}
+
+// GrantSecretAccess grants the secret accessor role of a secret to a member, if it is not granted.
+func (s Secrets) GrantSecretAccess(t testing.TB, name, member string) error {
+	ctx := context.Background()
+	svc, err := s.secretManager(ctx)
+	if err != nil {
+		return err
+	}
+	policy, err := svc.Projects.Secrets.GetIamPolicy(name).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get the IAM policy of secret %s: %w", name, err)
+	}
+	if !addBinding(policy, SecretAccessorRole, member) {
+		return nil
+	}
+	_, err = svc.Projects.Secrets.SetIamPolicy(name, &secretmanager.SetIamPolicyRequest{Policy: policy}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to grant %s on secret %s to %s: %w", SecretAccessorRole, name, member, err)
+	}
+	return nil
+}
+
+// SecretAccessorRole is the role granted to the Cloud Build service agent on the secrets of the connections.
+const SecretAccessorRole = "roles/secretmanager.secretAccessor"
+
+// addBinding adds a member to the binding of a role and reports if the policy changed.
+func addBinding(policy *secretmanager.Policy, role, member string) bool {
+	for _, b := range policy.Bindings {
+		if b.Role == role && b.Condition == nil {
+			if slices.Contains(b.Members, member) {
+				return false
+			}
+			b.Members = append(b.Members, member)
+			return true
+		}
+	}
+	policy.Bindings = append(policy.Bindings, &secretmanager.Binding{Role: role, Members: []string{member}})
+	return true
+}
+
+// RefreshConnections updates the Cloud Build connections of parent, projects/PROJECT/locations/REGION, that use a
+// version of a secret to use the given version. It returns the names of the updated connections.
+func (s Secrets) RefreshConnections(t testing.TB, parent, secret, version string) ([]string, error) {
+	ctx := context.Background()
+	opts, err := s.options(ctx)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := cloudbuildv2.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloud Build service: %w", err)
+	}
+	updated := []string{}
+	err = svc.Projects.Locations.Connections.List(parent).Pages(ctx, func(resp *cloudbuildv2.ListConnectionsResponse) error {
+		for _, c := range resp.Connections {
+			mask := useSecretVersion(c, secret, version)
+			if len(mask) == 0 {
+				continue
+			}
+			_, err := svc.Projects.Locations.Connections.Patch(c.Name, c).UpdateMask(strings.Join(mask, ",")).Etag(c.Etag).Context(ctx).Do()
+			if err != nil {
+				return fmt.Errorf("failed to update connection %s: %w", c.Name, err)
+			}
+			updated = append(updated, c.Name)
+		}
+		return nil
+	})
+	return updated, err
+}
+
+// useSecretVersion replaces the versions of a secret used by a connection and returns the update mask.
+func useSecretVersion(c *cloudbuildv2.Connection, secret, version string) []string {
+	mask := []string{}
+	replace := func(v *string, field string) {
+		if strings.HasPrefix(*v, secret+"/versions/") && *v != version {
+			*v = version
+			mask = append(mask, field)
+		}
+	}
+	if c.GithubConfig != nil && c.GithubConfig.AuthorizerCredential != nil {
+		replace(&c.GithubConfig.AuthorizerCredential.OauthTokenSecretVersion, "github_config.authorizer_credential.oauth_token_secret_version")
+	}
+	if g := c.GitlabConfig; g != nil {
+		if g.AuthorizerCredential != nil {
+			replace(&g.AuthorizerCredential.UserTokenSecretVersion, "gitlab_config.authorizer_credential.user_token_secret_version")
+		}
+		if g.ReadAuthorizerCredential != nil {
+			replace(&g.ReadAuthorizerCredential.UserTokenSecretVersion, "gitlab_config.read_authorizer_credential.user_token_secret_version")
+		}
+		replace(&g.WebhookSecretSecretVersion, "gitlab_config.webhook_secret_secret_version")
+	}
+	return mask
+}
@@ -0,0 +1,51 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	gotest "testing"
+
+	"github.com/stretchr/testify/assert"
+	cloudbuildv2 "google.golang.org/api/cloudbuild/v2"
+	"google.golang.org/api/secretmanager/v1"
+)
+
+func TestUseSecretVersion(t *gotest.T) {
+	secret := "projects/111/secrets/gitlab-api"
+	c := &cloudbuildv2.Connection{
+		GitlabConfig: &cloudbuildv2.GoogleDevtoolsCloudbuildV2GitLabConfig{
+			AuthorizerCredential:       &cloudbuildv2.UserCredential{UserTokenSecretVersion: secret + "/versions/1"},
+			ReadAuthorizerCredential:   &cloudbuildv2.UserCredential{UserTokenSecretVersion: "projects/111/secrets/gitlab-api-read/versions/1"},
+			WebhookSecretSecretVersion: "projects/111/secrets/webhook/versions/latest",
+		},
+	}
+	assert.Equal(t, []string{"gitlab_config.authorizer_credential.user_token_secret_version"}, useSecretVersion(c, secret, secret+"/versions/2"))
+	assert.Equal(t, secret+"/versions/2", c.GitlabConfig.AuthorizerCredential.UserTokenSecretVersion)
+	assert.Equal(t, "projects/111/secrets/gitlab-api-read/versions/1", c.GitlabConfig.ReadAuthorizerCredential.UserTokenSecretVersion)
+	assert.Empty(t, useSecretVersion(c, secret, secret+"/versions/2"), "the connection already uses the version")
+
+	gh := &cloudbuildv2.Connection{GithubConfig: &cloudbuildv2.GitHubConfig{AuthorizerCredential: &cloudbuildv2.OAuthCredential{OauthTokenSecretVersion: secret + "/versions/latest"}}}
+	assert.Equal(t, []string{"github_config.authorizer_credential.oauth_token_secret_version"}, useSecretVersion(gh, secret, secret+"/versions/3"))
+}
+
+func TestAddBinding(t *gotest.T) {
+	member := "serviceAccount:service-111@gcp-sa-cloudbuild.iam.gserviceaccount.com"
+	policy := &secretmanager.Policy{}
+	assert.True(t, addBinding(policy, SecretAccessorRole, member))
+	assert.False(t, addBinding(policy, SecretAccessorRole, member))
+	assert.True(t, addBinding(policy, SecretAccessorRole, "user:admin@example.com"))
+	assert.Len(t, policy.Bindings, 1)
+	assert.Equal(t, []string{member, "user:admin@example.com"}, policy.Bindings[0].Members)
+}
@@ -0,0 +1,214 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hooks loads the hooks file of a deployment: the commands and Go plugins executed before and after the
+// steps, and the custom stages defined by the user.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"plugin"
+	"slices"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/steps"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
+)
+
+// PluginSymbol is the name of the function of a Go plugin executed by a hook.
+const PluginSymbol = "Hook"
+
+// Func is the signature of the function of a Go plugin. It receives the input of the hook as JSON,
+// like the commands, and writes its messages in out.
+type Func = func(ctx context.Context, in io.Reader, out io.Writer) error
+
+// Hook is a command or a Go plugin executed before or after the steps that match its step.
+type Hook struct {
+	Name string `hcl:"name,label"`
+	// Step is the name of a step, or a path.Match pattern of step names. The name of a stage matches its top level step.
+	Step string `hcl:"step"`
+	// When is before or after.
+	When string `hcl:"when"`
+	// Operation is apply or destroy, defaults to apply.
+	Operation string `hcl:"operation,optional"`
+	// Command is the command and its arguments, executed in the directory of the hooks file.
+	Command []string `hcl:"command,optional"`
+	// Plugin is the path of a Go plugin with a Hook function of type Func.
+	Plugin string `hcl:"plugin,optional"`
+}
+
+// Config is the content of a hooks file.
+type Config struct {
+	Hooks  []Hook               `hcl:"hook,block"`
+	Stages []stages.CustomStage `hcl:"stage,block"`
+	// dir is the directory of the hooks file.
+	dir string
+}
+
+// Input is the JSON document received by the hooks in the standard input.
+type Input struct {
+	Step      string `json:"step"`
+	Stage     string `json:"stage,omitempty"`
+	Operation string `json:"operation"`
+	When      string `json:"when"`
+	// Outputs are the terraform outputs of the completed stages with local outputs, by stage name.
+	// The outputs of the custom stages are by stage and environment, for example 3a-inhouse.production.
+	Outputs map[string]map[string]any `json:"outputs"`
+}
+
+// Load reads and validates a hooks file. The relative paths of the file are relative to its directory.
+func Load(file string) (Config, error) {
+	var c Config
+	if err := utils.ReadTfvars(file, &c); err != nil {
+		return c, fmt.Errorf("failed to load hooks file %s: %w", file, err)
+	}
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return c, err
+	}
+	c.dir = dir
+	for i, h := range c.Hooks {
+		if h.Operation == "" {
+			c.Hooks[i].Operation = steps.Apply
+		}
+		if h.Plugin != "" {
+			c.Hooks[i].Plugin = c.path(h.Plugin)
+		}
+	}
+	for i, s := range c.Stages {
+		c.Stages[i].Repository = c.path(s.Repository)
+	}
+	return c, c.Validate()
+}
+
+// path resolves a path of the hooks file.
+func (c Config) path(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(c.dir, p)
+}
+
+// Validate checks the hooks and the custom stages.
+func (c Config) Validate() error {
+	names := map[string]bool{}
+	for _, h := range c.Hooks {
+		if names[h.Name] {
+			return fmt.Errorf("hook %s is defined more than once", h.Name)
+		}
+		names[h.Name] = true
+		if !slices.Contains([]string{steps.Before, steps.After}, h.When) {
+			return fmt.Errorf("hook %s: when must be %s or %s", h.Name, steps.Before, steps.After)
+		}
+		if !slices.Contains([]string{steps.Apply, steps.Destroy}, h.Operation) {
+			return fmt.Errorf("hook %s: operation must be %s or %s", h.Name, steps.Apply, steps.Destroy)
+		}
+		if _, err := path.Match(h.Step, ""); err != nil {
+			return fmt.Errorf("hook %s: invalid step pattern '%s': %w", h.Name, h.Step, err)
+		}
+		if (len(h.Command) == 0) == (h.Plugin == "") {
+			return fmt.Errorf("hook %s: one of command or plugin is required", h.Name)
+		}
+	}
+	for _, s := range c.Stages {
+		exists, err := utils.FileExists(s.Dir())
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("stage %s: terraform directory %s does not exist", s.Name, s.Dir())
+		}
+	}
+	return nil
+}
+
+// Matches checks if the hook is executed for a step, names are the name of the step and the names it is known by.
+func (h Hook) Matches(operation, when string, names ...string) bool {
+	if h.Operation != operation || h.When != when {
+		return false
+	}
+	for _, n := range names {
+		if ok, _ := path.Match(h.Step, n); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Match finds the hooks of a step in the order of the file.
+func (c Config) Match(operation, when string, names ...string) []Hook {
+	hooks := []Hook{}
+	for _, h := range c.Hooks {
+		if h.Matches(operation, when, names...) {
+			hooks = append(hooks, h)
+		}
+	}
+	return hooks
+}
+
+// Run executes a hook with the input. The output of the hook is written to out.
+func (c Config) Run(ctx context.Context, h Hook, in Input, out io.Writer) error {
+	content, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	if h.Plugin != "" {
+		err = runPlugin(ctx, h.Plugin, bytes.NewReader(content), out)
+	} else {
+		cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+		cmd.Dir = c.dir
+		cmd.Stdin = bytes.NewReader(content)
+		cmd.Stdout = out
+		cmd.Stderr = out
+		cmd.Env = append(os.Environ(),
+			"EAB_HOOK="+h.Name,
+			"EAB_STEP="+in.Step,
+			"EAB_STAGE="+in.Stage,
+			"EAB_OPERATION="+in.Operation,
+			"EAB_WHEN="+in.When,
+		)
+		err = cmd.Run()
+	}
+	if err != nil {
+		return fmt.Errorf("hook %s failed: %w", h.Name, err)
+	}
+	return nil
+}
+
+// runPlugin opens a Go plugin and calls its Hook function.
+func runPlugin(ctx context.Context, file string, in io.Reader, out io.Writer) error {
+	p, err := plugin.Open(file)
+	if err != nil {
+		return err
+	}
+	sym, err := p.Lookup(PluginSymbol)
+	if err != nil {
+		return err
+	}
+	switch f := sym.(type) {
+	case Func:
+		return f(ctx, in, out)
+	case *Func:
+		return (*f)(ctx, in, out)
+	}
+	return fmt.Errorf("%s of plugin %s is a %T, expected a %T", PluginSymbol, file, sym, Func(nil))
+}
@@ -0,0 +1,133 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+)
+
+func TestLoad(t *testing.T) {
+	c, err := Load(filepath.Join("testdata", "hooks.hcl"))
+	assert.NoError(t, err)
+	dir, err := filepath.Abs("testdata")
+	assert.NoError(t, err)
+
+	assert.Len(t, c.Hooks, 3)
+	assert.Equal(t, Hook{Name: "security-scan", Step: "5-appinfra", When: "before", Operation: "apply", Command: []string{"sh", "-c", "cat > scan-input.json"}}, c.Hooks[0])
+	assert.Equal(t, filepath.Join(dir, "plugins", "cmdb.so"), c.Hooks[1].Plugin)
+	assert.Equal(t, []stages.CustomStage{{
+		Name:       "3a-inhouse",
+		After:      "3-fleetscope",
+		DependsOn:  []string{"1-bootstrap"},
+		Repository: filepath.Join(dir, "inhouse"),
+		Envs:       []string{"production"},
+	}}, c.Stages)
+
+	_, err = Load(filepath.Join("testdata", "missing.hcl"))
+	assert.ErrorContains(t, err, "failed to load hooks file")
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		errMsg string
+	}{
+		{
+			name:   "duplicated",
+			config: Config{Hooks: []Hook{{Name: "scan", Step: "*", When: "before", Operation: "apply", Command: []string{"scan"}}, {Name: "scan", Step: "*", When: "after", Operation: "apply", Command: []string{"scan"}}}},
+			errMsg: "hook scan is defined more than once",
+		},
+		{
+			name:   "when",
+			config: Config{Hooks: []Hook{{Name: "scan", Step: "*", When: "during", Operation: "apply", Command: []string{"scan"}}}},
+			errMsg: "hook scan: when must be before or after",
+		},
+		{
+			name:   "operation",
+			config: Config{Hooks: []Hook{{Name: "scan", Step: "*", When: "before", Operation: "plan", Command: []string{"scan"}}}},
+			errMsg: "hook scan: operation must be apply or destroy",
+		},
+		{
+			name:   "pattern",
+			config: Config{Hooks: []Hook{{Name: "scan", Step: "[", When: "before", Operation: "apply", Command: []string{"scan"}}}},
+			errMsg: "hook scan: invalid step pattern '['",
+		},
+		{
+			name:   "command and plugin",
+			config: Config{Hooks: []Hook{{Name: "scan", Step: "*", When: "before", Operation: "apply", Command: []string{"scan"}, Plugin: "scan.so"}}},
+			errMsg: "hook scan: one of command or plugin is required",
+		},
+		{
+			name:   "stage directory",
+			config: Config{Stages: []stages.CustomStage{{Name: "3a-inhouse", Repository: t.TempDir()}}},
+			errMsg: "stage 3a-inhouse: terraform directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorContains(t, tt.config.Validate(), tt.errMsg)
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	c, err := Load(filepath.Join("testdata", "hooks.hcl"))
+	assert.NoError(t, err)
+
+	names := func(hooks []Hook) []string {
+		n := []string{}
+		for _, h := range hooks {
+			n = append(n, h.Name)
+		}
+		return n
+	}
+	assert.Equal(t, []string{"security-scan"}, names(c.Match("apply", "before", "appinfra-hello-world", "5-appinfra")))
+	assert.Empty(t, c.Match("apply", "after", "appinfra-hello-world", "5-appinfra"))
+	assert.Equal(t, []string{"register-cmdb"}, names(c.Match("apply", "after", "eab-applicationfactory.shared")))
+	assert.Empty(t, c.Match("apply", "after", "eab-applicationfactory"), "the pattern only matches the nested steps")
+	assert.Equal(t, []string{"archive-state"}, names(c.Match("destroy", "after", "gcp-bootstrap", "1-bootstrap")))
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{dir: dir}
+	in := Input{Step: "appinfra-hello-world", Stage: "5-appinfra", Operation: "apply", When: "before", Outputs: map[string]map[string]any{"1-bootstrap": {"project_id": "eab-cicd"}}}
+	var out bytes.Buffer
+
+	h := Hook{Name: "scan", Command: []string{"sh", "-c", "cat > input.json; echo $EAB_HOOK $EAB_STAGE $EAB_WHEN"}}
+	assert.NoError(t, c.Run(context.Background(), h, in, &out))
+	assert.Equal(t, "scan 5-appinfra before\n", out.String())
+	content, err := os.ReadFile(filepath.Join(dir, "input.json"))
+	assert.NoError(t, err)
+	var got Input
+	assert.NoError(t, json.Unmarshal(content, &got))
+	assert.Equal(t, in, got, "the input should be received in the standard input")
+
+	h = Hook{Name: "scan", Command: []string{"sh", "-c", "echo vulnerabilities found; exit 2"}}
+	assert.EqualError(t, c.Run(context.Background(), h, in, &out), "hook scan failed: exit status 2")
+
+	h = Hook{Name: "cmdb", Plugin: filepath.Join(dir, "missing.so")}
+	assert.ErrorContains(t, c.Run(context.Background(), h, in, &out), "hook cmdb failed")
+}
@@ -0,0 +1,25 @@
+hook "security-scan" {
+  step    = "5-appinfra"
+  when    = "before"
+  command = ["sh", "-c", "cat > scan-input.json"]
+}
+
+hook "register-cmdb" {
+  step   = "eab-applicationfactory.*"
+  when   = "after"
+  plugin = "plugins/cmdb.so"
+}
+
+hook "archive-state" {
+  step      = "*"
+  when      = "after"
+  operation = "destroy"
+  command   = ["./archive.sh"]
+}
+
+stage "3a-inhouse" {
+  after      = "3-fleetscope"
+  depends_on = ["1-bootstrap"]
+  repository = "inhouse"
+  envs       = ["production"]
+}
@@ -0,0 +1,3 @@
+output "cmdb_id" {
+  value = "inhouse-production"
+}
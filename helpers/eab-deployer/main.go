@@ -15,18 +15,22 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
 	gotest "testing"
 
 	"github.com/mitchellh/go-testing-interface"
 
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/credentials"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/deployer"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
 	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/msg"
 	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
-	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/steps"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/telemetry"
 	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/workspace"
 )
 
 var (
@@ -43,9 +47,23 @@ type cfg struct {
 	quiet         bool
 	help          bool
 	listSteps     bool
+	preview       bool
 	disablePrompt bool
 	validate      bool
 	destroy       bool
+	init          bool
+	fix           bool
+	workspace     string
+	workspacesDir string
+	tfCacheDir    string
+	hooksFile     string
+	notifyFile    string
+	otlpEndpoint  string
+	telemetryDir  string
+	credsFile     string
+	tokenFile     string
+	pushOnly      bool
+	reconcile     bool
 }
 
 func parseFlags() cfg {
@@ -57,14 +75,62 @@ func parseFlags() cfg {
 	flag.BoolVar(&c.quiet, "quiet", false, "If true, additional output is suppressed.")
 	flag.BoolVar(&c.help, "help", false, "Prints this help text and exits.")
 	flag.BoolVar(&c.listSteps, "list_steps", false, "List the existing steps.")
+	flag.BoolVar(&c.preview, "preview", false, "List the stages that will be applied, and why the completed stages will be applied again.")
 	flag.BoolVar(&c.disablePrompt, "disable_prompt", false, "Disable interactive prompt.")
 	flag.BoolVar(&c.validate, "validate", false, "Validate tfvars file inputs.")
 	flag.BoolVar(&c.destroy, "destroy", false, "Destroy the deployment.")
+	flag.BoolVar(&c.init, "init", false, "Interactively create a new tfvars file in the path provided in -tfvars_file.")
+	flag.BoolVar(&c.pushOnly, "push_only", false, "Push the code of the stages applied by pipelines and record the commits without waiting for their builds.")
+	flag.BoolVar(&c.reconcile, "reconcile", false, "Check the builds of the pushed commits and mark their steps as completed or failed.")
+	flag.BoolVar(&c.fix, "fix", false, "Fix the validation findings that can be fixed by the helper and validate again.")
+	flag.StringVar(&c.workspace, "workspace", "", "Name of the `workspace` to be used instead of the current workspace.")
+	flag.StringVar(&c.workspacesDir, "workspaces_dir", workspace.DefaultRoot(), "Root `directory` of the workspaces.")
+	flag.StringVar(&c.tfCacheDir, "terraform_cache_dir", deployer.DefaultTerraformCacheDir(), "Cache `directory` of the terraform binaries downloaded by the helper.")
+	flag.StringVar(&c.hooksFile, "hooks_file", "", "Path of the `file` with the step hooks and the custom stages.")
+	flag.StringVar(&c.notifyFile, "notifications_file", "", "Path of the `file` with the sinks of the step notifications.")
+	flag.StringVar(&c.credsFile, "credentials_file", "", "Service account key or workload identity federation configuration `file` used instead of the application default credentials.")
+	flag.StringVar(&c.tokenFile, "access_token_file", "", "Path of a `file` with a short-lived access token used instead of the application default credentials.")
+	flag.StringVar(&c.otlpEndpoint, "otlp_endpoint", "", "`URL` of an OTLP/HTTP collector of the traces and metrics of the execution.")
+	flag.StringVar(&c.telemetryDir, "telemetry_dir", "", "Local `directory` of the files with the traces and metrics of the execution, for offline analysis.")
 
 	flag.Parse()
 	return c
 }
 
+// shutdownTelemetry flushes the traces and metrics of the execution.
+var shutdownTelemetry = func(context.Context) error { return nil }
+
+// flushTelemetry exports the pending traces and metrics.
+func flushTelemetry() {
+	if err := shutdownTelemetry(context.Background()); err != nil {
+		fmt.Printf("# failed to export the telemetry. Error: %s\n", err.Error())
+	}
+}
+
+// closeDeployer sends the pending notifications of the deployment.
+var closeDeployer = func() {}
+
+// exit sends the pending notifications, flushes the traces and metrics and exits with the code.
+func exit(code int) {
+	closeDeployer()
+	flushTelemetry()
+	os.Exit(code)
+}
+
+// printEvent prints the progress of the stages.
+func printEvent(e deployer.Event) {
+	switch e.Type {
+	case deployer.StageStarted:
+		if e.Operation == "destroy" {
+			msg.PrintStageMsg(fmt.Sprintf("Destroying %s stage", e.Stage))
+		} else {
+			msg.PrintStageMsg(fmt.Sprintf("Deploying %s stage", e.Stage))
+		}
+	case deployer.Log:
+		fmt.Println(e.Message)
+	}
+}
+
 func main() {
 
 	cfg := parseFlags()
@@ -74,204 +140,235 @@ func main() {
 		return
 	}
 
-	// load tfvars
-	globalTFVars, err := stages.ReadGlobalTFVars(cfg.tfvarsFile)
+	if flag.Arg(0) == "workspace" {
+		err := runWorkspaceCommand(workspace.NewManager(cfg.workspacesDir), cfg.hooksFile, flag.Args()[1:])
+		if err != nil {
+			fmt.Printf("# Workspace command failed. Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "serve" {
+		err := runServeCommand(workspace.NewManager(cfg.workspacesDir), flag.Args()[1:])
+		if err != nil {
+			fmt.Printf("# Server failed. Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	ws, err := resolveWorkspace(&cfg)
 	if err != nil {
-		fmt.Printf("# Failed to read GlobalTFVars file. Error: %s\n", err.Error())
+		fmt.Printf("# Failed to load workspace. Error: %s\n", err.Error())
 		os.Exit(1)
 	}
 
-	// validate Directories
-	err = stages.ValidateDirectories(globalTFVars)
+	shutdown, err := telemetry.Setup(context.Background(), telemetry.Options{OTLPEndpoint: cfg.otlpEndpoint, Dir: cfg.telemetryDir})
 	if err != nil {
-		fmt.Printf("# Failed validating directories. Error: %s\n", err.Error())
+		fmt.Printf("# Failed to set up the telemetry. Error: %s\n", err.Error())
 		os.Exit(1)
 	}
+	shutdownTelemetry = shutdown
+	defer flushTelemetry()
 
-	// init infra
 	gotest.Init()
 	t := &testing.RuntimeT{}
-	conf := stages.CommonConf{
-		EABPath:       globalTFVars.EABCodePath,
-		CheckoutPath:  globalTFVars.CodeCheckoutPath,
-		PolicyPath:    filepath.Join(globalTFVars.EABCodePath, "policy-library"),
-		DisablePrompt: cfg.disablePrompt,
-		Logger:        utils.GetLogger(cfg.quiet),
+
+	id := credentials.Identity{CredentialsFile: cfg.credsFile, AccessTokenFile: cfg.tokenFile}
+
+	// create tfvars
+	if cfg.init {
+		if cfg.tfvarsFile == "" {
+			fmt.Println("# tfvars file is required")
+			exit(1)
+		}
+		g := stages.NewWizard(gcp.NewGCPWithIdentity(id), os.Stdin, os.Stdout).Run(t)
+		err := stages.WriteGlobalTFVars(cfg.tfvarsFile, g)
+		if err != nil {
+			fmt.Printf("# Failed to write GlobalTFVars file. Error: %s\n", err.Error())
+			exit(1)
+		}
+		fmt.Printf("# Configuration saved in %s\n", cfg.tfvarsFile)
 	}
 
-	// validate inputs
-	if cfg.validate {
-		stages.ValidateComponents(t)
-		stages.ValidateBasicFields(t, globalTFVars)
-		stages.ValidateDestroyFlags(t, globalTFVars)
-		stages.ValidatePermissions(t, globalTFVars)
-		stages.ValidateRequiredAPIs(t, globalTFVars)
-		stages.ValidateRepositories(t, globalTFVars)
-		stages.ValidateNetworkRequirementes(t, globalTFVars)
-		stages.ValidatePrivateWorkerPoolRequirementes(t, globalTFVars)
-		stages.ValidateVPCSCRequirements(t, globalTFVars)
-		return
+	c := deployer.Config{
+		TFVarsFile:        cfg.tfvarsFile,
+		StepsFile:         cfg.stepsFile,
+		DisablePrompt:     cfg.disablePrompt,
+		Logger:            utils.GetLogger(cfg.quiet),
+		OnEvent:           printEvent,
+		TerraformCacheDir: cfg.tfCacheDir,
+		HooksFile:         cfg.hooksFile,
+		NotificationsFile: cfg.notifyFile,
+		Identity:          id,
+	}
+	if ws != nil {
+		// the lock is released by the operating system when the process exits.
+		lock, err := ws.Lock(fmt.Sprintf("process %d", os.Getpid()))
+		if err != nil {
+			fmt.Printf("# %s\n", err.Error())
+			exit(1)
+		}
+		defer func() { _ = lock.Unlock() }()
+		c.CheckoutPath = ws.CheckoutPath()
+		c.StateBackupDir = ws.StateBackupsPath()
+		logFile, err := ws.NewLogFile()
+		if err != nil {
+			fmt.Printf("# Failed to create log file. Error: %s\n", err.Error())
+			exit(1)
+		}
+		defer logFile.Close()
+		c.Logger = utils.GetFileLogger(cfg.quiet, logFile)
 	}
 
-	s, err := steps.LoadSteps(cfg.stepsFile)
+	d, err := deployer.New(c)
 	if err != nil {
-		fmt.Printf("# failed to load state file %s. Error: %s\n", cfg.stepsFile, err.Error())
-		os.Exit(2)
+		fmt.Printf("# %s\n", err.Error())
+		exit(1)
 	}
+	closeDeployer = d.Close
+	defer d.Close()
+	ctx := context.Background()
 
-	if cfg.listSteps {
-		fmt.Println("# Executed steps:")
-		e := s.ListSteps()
-		if len(e) == 0 {
-			fmt.Println("# No steps executed")
-			return
-		}
-		for _, step := range e {
-			fmt.Println(step)
+	if flag.Arg(0) == "state" {
+		err := runStateCommand(ctx, d, flag.Args()[1:], cfg.disablePrompt)
+		if err != nil {
+			fmt.Printf("# State command failed. Error: %s\n", err.Error())
+			exit(3)
 		}
 		return
 	}
 
-	if cfg.resetStep != "" {
-		if err := s.ResetStep(cfg.resetStep); err != nil {
-			fmt.Printf("# Reset step failed. Error: %s\n", err.Error())
-			os.Exit(3)
+	if flag.Arg(0) == "adopt" {
+		err := runAdoptCommand(ctx, d, flag.Args()[1:])
+		if err != nil {
+			fmt.Printf("# Adopt failed. Error: %s\n", err.Error())
+			exit(3)
 		}
 		return
 	}
 
-	// destroy stages
-	if cfg.destroy {
-		// Note: destroy is only terraform destroy, local directories are not deleted.
-		// 5-appinfra
-		msg.PrintStageMsg("Destroying 5-appinfra stage")
-		err = s.RunDestroyStep("appinfra-hello-world", func() error {
-			io := stages.GetAppFactoryStepOutputs(t, filepath.Join(conf.CheckoutPath, globalTFVars.InfraCloudbuildV2RepositoryConfig.Repositories["applicationfactory"].RepositoryName))
-			return stages.DestroyAppInfraStage(t, s, globalTFVars, io, conf)
-		})
+	if flag.Arg(0) == "estimate" {
+		err := runEstimateCommand(ctx, d, flag.Args()[1:])
 		if err != nil {
-			fmt.Printf("# App Infra hello world step destroy failed. Error: %s\n", err.Error())
-			os.Exit(3)
+			fmt.Printf("# Estimate failed. Error: %s\n", err.Error())
+			exit(3)
 		}
+		return
+	}
 
-		// 4-appfactory
-		msg.PrintStageMsg("Destroying 4-appfactory stage")
-		err = s.RunDestroyStep("gcp-appfactory", func() error {
-			bo := stages.GetBootstrapStepOutputs(t, conf.EABPath)
-			return stages.DestroyAppFactoryStage(t, s, globalTFVars, bo, conf)
-		})
+	if flag.Arg(0) == "secrets" {
+		err := runSecretsCommand(ctx, d, flag.Args()[1:])
 		if err != nil {
-			fmt.Printf("# AppFactory step destroy failed. Error: %s\n", err.Error())
-			os.Exit(3)
+			fmt.Printf("# Secrets failed. Error: %s\n", err.Error())
+			exit(3)
 		}
+		return
+	}
 
-		// 3-fleetscope
-		msg.PrintStageMsg("Destroying 3-fleetscope stage")
-		err = s.RunDestroyStep("gcp-fleetscope", func() error {
-			bo := stages.GetBootstrapStepOutputs(t, conf.EABPath)
-			return stages.DestroyFleetscopeStage(t, s, globalTFVars, bo, conf)
-		})
-		if err != nil {
-			fmt.Printf("# Fleetscope step destroy failed. Error: %s\n", err.Error())
-			os.Exit(3)
+	// validate inputs
+	if cfg.validate || cfg.init || cfg.fix {
+		if cfg.fix {
+			err = d.Remediate(ctx, func(stages.RemediationPlan) bool {
+				return cfg.disablePrompt || msg.Confirm("# Apply the remediation plan?")
+			})
+		} else {
+			err = d.Validate(ctx)
 		}
-
-		// 2-multitenant
-		msg.PrintStageMsg("Destroying 2-multitenant stage")
-		err = s.RunDestroyStep("gcp-multitenant", func() error {
-			bo := stages.GetBootstrapStepOutputs(t, conf.EABPath)
-			return stages.DestroyMultitenantStage(t, s, globalTFVars, bo, conf)
-		})
 		if err != nil {
-			fmt.Printf("# Multitenant step destroy failed. Error: %s\n", err.Error())
-			os.Exit(3)
+			fmt.Printf("# Validation failed. Error: %s\n", err.Error())
+			exit(1)
 		}
+		return
+	}
 
-		// 1-bootstrap
-		msg.PrintStageMsg("Destroying 1-bootstrap stage")
-		err = s.RunDestroyStep("gcp-bootstrap", func() error {
-			return stages.DestroyBootstrapStage(t, s, globalTFVars, conf)
-		})
-		if err != nil {
-			fmt.Printf("# Bootstrap step destroy failed. Error: %s\n", err.Error())
-			os.Exit(3)
+	if cfg.listSteps {
+		fmt.Println("# Executed steps:")
+		e := d.ListSteps()
+		if len(e) == 0 {
+			fmt.Println("# No steps executed")
+			return
 		}
+		for _, step := range e {
+			fmt.Println(step)
+		}
+		return
+	}
 
-		// clean up the steps file
-		err = steps.DeleteStepsFile(cfg.stepsFile)
+	if cfg.preview {
+		plan, err := d.Plan(ctx)
 		if err != nil {
-			fmt.Printf("# failed to delete state file %s. Error: %s\n", cfg.stepsFile, err.Error())
-			os.Exit(3)
+			fmt.Printf("# Preview failed. Error: %s\n", err.Error())
+			exit(1)
+		}
+		for _, p := range plan {
+			if p.Reason != "" {
+				fmt.Printf("%s %s %s (%s)\n", p.Stage, p.Status, p.Action, p.Reason)
+			} else {
+				fmt.Printf("%s %s %s\n", p.Stage, p.Status, p.Action)
+			}
 		}
 		return
 	}
 
-	// deploy stages
-
-	// 1-bootstrap
-	msg.PrintStageMsg("Deploying 1-bootstrap stage")
-	err = s.RunStep("gcp-bootstrap", func() error {
-		return stages.DeployBootstrapStage(t, s, globalTFVars, conf)
-	})
-	if err != nil {
-		fmt.Printf("# Bootstrap step failed. Error: %s\n", err.Error())
-		os.Exit(3)
+	if cfg.reconcile {
+		results, err := d.Reconcile(ctx)
+		if err != nil {
+			fmt.Printf("# Reconcile failed. Error: %s\n", err.Error())
+			exit(3)
+		}
+		if len(results) == 0 {
+			fmt.Println("# No pushed steps")
+			return
+		}
+		failed := false
+		for _, r := range results {
+			fmt.Printf("%s %s %s\n", r.Step, r.Status, r.Message)
+			failed = failed || r.Status == deployer.ReconcileFailed
+		}
+		if failed {
+			exit(3)
+		}
+		return
 	}
 
-	bo := stages.GetBootstrapStepOutputs(t, conf.EABPath)
-
-	// 2-Multitenant
-	msg.PrintStageMsg("Deploying 2-Multitenant stage")
-	err = s.RunStep("gcp-multitenant", func() error {
-		return stages.DeployMultitenantStage(t, s, globalTFVars, bo, conf)
-	})
-	if err != nil {
-		fmt.Printf("# Multitenant step failed. Error: %s\n", err.Error())
-		os.Exit(3)
+	if cfg.resetStep != "" {
+		if err := d.ResetStep(cfg.resetStep); err != nil {
+			fmt.Printf("# Reset step failed. Error: %s\n", err.Error())
+			exit(3)
+		}
+		return
 	}
 
-	// 3-fleetscope
-	msg.PrintStageMsg("Deploying 3-fleetscope stage")
-	err = s.RunStep("gcp-fleetscope", func() error {
-		return stages.DeployFleetscopeStage(t, s, globalTFVars, bo, conf)
-	})
-	if err != nil {
-		fmt.Printf("# Fleetscope step failed. Error: %s\n", err.Error())
-		os.Exit(3)
+	// destroy stages
+	if cfg.destroy {
+		if err := d.Destroy(ctx, deployer.DestroyOptions{}); err != nil {
+			fmt.Printf("# Destroy failed. Error: %s\n", err.Error())
+			exit(3)
+		}
+		if ws != nil {
+			if err := ws.RecordDestroy(); err != nil {
+				fmt.Printf("# failed to update workspace %s. Error: %s\n", ws.Name, err.Error())
+			}
+		}
+		return
 	}
 
-	err = s.RunStep("gcp-appfactory", func() error {
-		// 4-appfactory
-		msg.PrintStageMsg("Deploying 4-appfactory stage")
-		msg.ConfirmQuota(bo.CBServiceAccountsEmails["applicationfactory"], conf.DisablePrompt)
-		return stages.DeployAppFactoryStage(t, s, globalTFVars, bo, conf)
-	})
-	if err != nil {
-		fmt.Printf("# Projects step failed. Error: %s\n", err.Error())
-		os.Exit(3)
+	// deploy stages
+	opts := deployer.ApplyOptions{
+		ConfirmStale: func([]deployer.StaleStage) bool {
+			return cfg.disablePrompt || msg.Confirm("# Apply the stale stages again?")
+		},
+		PushOnly: cfg.pushOnly,
 	}
-
-	// 5-appinfra
-	msg.PrintStageMsg("Deploying 5-appinfra stage")
-	io := stages.GetAppFactoryStepOutputs(t, filepath.Join(conf.CheckoutPath, globalTFVars.InfraCloudbuildV2RepositoryConfig.Repositories["applicationfactory"].RepositoryName))
-
-	err = s.RunStep("appinfra-hello-world", func() error {
-		return stages.DeployAppInfraStage(t, s, globalTFVars, bo, io, conf)
-	})
-	if err != nil {
-		fmt.Printf("# Example app infra step failed. Error: %s\n", err.Error())
-		os.Exit(3)
+	if err := d.Apply(ctx, opts); err != nil {
+		fmt.Printf("# Deploy failed. Error: %s\n", err.Error())
+		exit(3)
 	}
-
-	// // 6-appsource
-	msg.PrintStageMsg("Deploying 6-appsource stage")
-	appInfraOutputs := stages.GetAppInfraStepOutputs(t, filepath.Join(conf.CheckoutPath, globalTFVars.InfraCloudbuildV2RepositoryConfig.Repositories["hello-world"].RepositoryName))
-	err = s.RunStep("gcp-appsource-hello-world", func() error {
-		return stages.DeployAppSourceStage(t, s, globalTFVars, appInfraOutputs, conf)
-	})
-	if err != nil {
-		fmt.Printf("# Appsource step failed. Error: %s\n", err.Error())
-		os.Exit(3)
+	if ws != nil && !cfg.pushOnly {
+		if err := ws.RecordApply(); err != nil {
+			fmt.Printf("# failed to update workspace %s. Error: %s\n", ws.Name, err.Error())
+		}
 	}
-
 }
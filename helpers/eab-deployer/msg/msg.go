@@ -18,6 +18,7 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"regexp"
 	"strings"
 )
 
@@ -49,6 +50,19 @@ func PressEnter(msg string) {
 	}
 }
 
+// Confirm asks a yes or no question, the default answer is no.
+func Confirm(msg string) bool {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Printf("%s [y/N]: ", msg)
+	answer, err := reader.ReadString('\n')
+	if err != nil {
+		fmt.Printf("# Failed to read string. Error: %s\n", err.Error())
+		os.Exit(3)
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
 func pad(msg string, size int) string {
 	return fmt.Sprintf("%*s", ((size + len(msg)) / 2), msg)
 }
@@ -61,6 +75,14 @@ func BuildErrorURL(project, region, build string) string {
 	return fmt.Sprintf(buildErrorURL, region, build, project)
 }
 
+// buildErrorURLRegexp matches the URLs of BuildErrorURL.
+var buildErrorURLRegexp = regexp.MustCompile(`https://console\.cloud\.google\.com/cloud-build/builds;region=[^/\s]+/[^?\s]+\?project=\S+`)
+
+// FindBuildErrorURL finds the first build URL of BuildErrorURL in a text, like the error of a failed step.
+func FindBuildErrorURL(text string) string {
+	return buildErrorURLRegexp.FindString(text)
+}
+
 func PrintStageMsg(msg string) {
 	fmt.Println("")
 	fmt.Println(bar)
@@ -0,0 +1,365 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package notify sends notifications of the step transitions of a deployment to webhooks, chats and email.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/msg"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/steps"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
+)
+
+// Statuses of the step transitions that are notified.
+const (
+	Started         = "RUNNING"
+	Completed       = "COMPLETED"
+	Failed          = "FAILED"
+	WaitingApproval = "WAITING_APPROVAL"
+)
+
+// Sink types.
+const (
+	Webhook    = "webhook"
+	Slack      = "slack"
+	GoogleChat = "google_chat"
+	SMTP       = "smtp"
+)
+
+const (
+	defaultRateLimit  = 10
+	defaultRatePeriod = 10 * time.Minute
+	sendTimeout       = 30 * time.Second
+	// queueSize is the number of notifications waiting to be sent, the newer ones are dropped when it is full.
+	queueSize = 100
+)
+
+// DefaultTemplate is the template of the messages, the first line is the subject of the emails.
+const DefaultTemplate = `{{ if .Deployment }}[{{ .Deployment }}] {{ end }}{{ .Title }}
+{{- if .Error }}
+{{ .Error }}{{ end }}
+{{- if .BuildURL }}
+Build: {{ .BuildURL }}{{ end }}
+{{- if .Suppressed }}
+{{ .Suppressed }} notifications were suppressed by the rate limit.{{ end }}`
+
+// defaultStatuses are the notified statuses when a sink has no statuses.
+var defaultStatuses = []string{Started, Completed, Failed, WaitingApproval}
+
+// Notification is a step transition, it is the data of the templates and the body of the generic webhooks.
+type Notification struct {
+	Deployment string    `json:"deployment,omitempty"`
+	Step       string    `json:"step"`
+	Status     string    `json:"status"`
+	Title      string    `json:"title"`
+	Error      string    `json:"error,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	BuildURL   string    `json:"build_url,omitempty"`
+	Time       time.Time `json:"time"`
+	// Suppressed is the number of notifications of the sink dropped by the rate limit since the last one.
+	Suppressed int `json:"suppressed,omitempty"`
+}
+
+// Sink delivers the notifications with the message rendered by the template of the sink.
+type Sink interface {
+	Send(ctx context.Context, n Notification, message string) error
+}
+
+// SinkConfig is a sink of the notifications file.
+type SinkConfig struct {
+	Name string `hcl:"name,label"`
+	// Type is webhook, slack, google_chat or smtp.
+	Type string `hcl:"type"`
+	// URLEnv is the environment variable with the URL of the webhook, the URLs of chat webhooks are secrets.
+	URLEnv string `hcl:"url_env,optional"`
+	// Headers are the headers of the requests of a generic webhook.
+	Headers map[string]string `hcl:"headers,optional"`
+	// Address is the host:port of the SMTP relay.
+	Address  string   `hcl:"address,optional"`
+	From     string   `hcl:"from,optional"`
+	To       []string `hcl:"to,optional"`
+	Username string   `hcl:"username,optional"`
+	// PasswordEnv is the environment variable with the password of the SMTP user.
+	PasswordEnv string `hcl:"password_env,optional"`
+	// Steps are path.Match patterns of the notified steps, defaults to the top level steps.
+	Steps []string `hcl:"steps,optional"`
+	// Statuses are the notified statuses, defaults to RUNNING, COMPLETED, FAILED and WAITING_APPROVAL.
+	Statuses []string `hcl:"statuses,optional"`
+	// Template is the text/template of the messages, defaults to DefaultTemplate.
+	Template string `hcl:"template,optional"`
+	// RateLimit is the maximum number of notifications sent in RatePeriod, defaults to 10 in 10m.
+	// The failures and the approvals are never suppressed.
+	RateLimit  int    `hcl:"rate_limit,optional"`
+	RatePeriod string `hcl:"rate_period,optional"`
+}
+
+// Config is the content of a notifications file.
+type Config struct {
+	// Deployment is the name of the deployment in the messages.
+	Deployment string       `hcl:"deployment,optional"`
+	Sinks      []SinkConfig `hcl:"sink,block"`
+}
+
+// Load reads a notifications file.
+func Load(file string) (Config, error) {
+	var c Config
+	if err := utils.ReadTfvars(file, &c); err != nil {
+		return c, fmt.Errorf("failed to load notifications file %s: %w", file, err)
+	}
+	return c, nil
+}
+
+// target is a sink with its filters, template and rate limit.
+type target struct {
+	name     string
+	sink     Sink
+	steps    []string
+	statuses []string
+	tmpl     *template.Template
+	limiter  *limiter
+}
+
+// Notifier sends the step transitions to the sinks. The notifications are sent in the background, in order, so a
+// slow sink does not stall the steps. The errors of the sinks are reported to OnError and never fail the deployment.
+type Notifier struct {
+	deployment string
+	targets    []target
+	// OnError, if set, is called with the errors of the sinks.
+	OnError func(error)
+	now     func() time.Time
+
+	mu      sync.Mutex
+	closed  bool
+	queue   chan Notification
+	pending sync.WaitGroup
+}
+
+// New creates a Notifier with the sinks of the configuration. Close must be called to send the queued notifications.
+func New(c Config) (*Notifier, error) {
+	n := &Notifier{deployment: c.Deployment, now: time.Now}
+	names := map[string]bool{}
+	for _, sc := range c.Sinks {
+		if names[sc.Name] {
+			return nil, fmt.Errorf("sink %s is defined more than once", sc.Name)
+		}
+		names[sc.Name] = true
+		sink, err := newSink(sc)
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %w", sc.Name, err)
+		}
+		t, err := newTarget(sc, sink)
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %w", sc.Name, err)
+		}
+		n.targets = append(n.targets, t)
+	}
+	return n.start(), nil
+}
+
+// start starts sending the queued notifications.
+func (n *Notifier) start() *Notifier {
+	n.queue = make(chan Notification, queueSize)
+	go n.run()
+	return n
+}
+
+// newTarget validates the filters, the template and the rate limit of a sink.
+func newTarget(sc SinkConfig, sink Sink) (target, error) {
+	t := target{name: sc.Name, sink: sink, steps: sc.Steps, statuses: sc.Statuses}
+	if len(t.statuses) == 0 {
+		t.statuses = defaultStatuses
+	}
+	for _, s := range t.statuses {
+		if !slices.Contains(defaultStatuses, s) {
+			return t, fmt.Errorf("unknown status '%s', expected one of: %s", s, strings.Join(defaultStatuses, ", "))
+		}
+	}
+	for _, p := range t.steps {
+		if _, err := path.Match(p, ""); err != nil {
+			return t, fmt.Errorf("invalid step pattern '%s': %w", p, err)
+		}
+	}
+	text := sc.Template
+	if text == "" {
+		text = DefaultTemplate
+	}
+	tmpl, err := template.New(sc.Name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return t, fmt.Errorf("invalid template: %w", err)
+	}
+	t.tmpl = tmpl
+	limit, period := sc.RateLimit, defaultRatePeriod
+	if limit == 0 {
+		limit = defaultRateLimit
+	}
+	if sc.RatePeriod != "" {
+		if period, err = time.ParseDuration(sc.RatePeriod); err != nil {
+			return t, fmt.Errorf("invalid rate_period: %w", err)
+		}
+	}
+	if limit < 0 || period <= 0 {
+		return t, fmt.Errorf("the rate limit must be positive")
+	}
+	t.limiter = &limiter{limit: limit, period: period}
+	return t, nil
+}
+
+// matches checks the filters of the target.
+func (t target) matches(step, status string) bool {
+	if !slices.Contains(t.statuses, status) {
+		return false
+	}
+	if len(t.steps) == 0 {
+		return !strings.Contains(step, ".")
+	}
+	return slices.ContainsFunc(t.steps, func(p string) bool {
+		ok, _ := path.Match(p, step)
+		return ok
+	})
+}
+
+// title describes a step transition.
+func title(s steps.Step) string {
+	switch s.Status {
+	case Started:
+		return fmt.Sprintf("Step %s started", s.Name)
+	case Completed:
+		return fmt.Sprintf("Step %s completed", s.Name)
+	case Failed:
+		return fmt.Sprintf("Step %s failed", s.Name)
+	case WaitingApproval:
+		return fmt.Sprintf("Step %s is waiting for approval: %s", s.Name, s.Reason)
+	}
+	return fmt.Sprintf("Step %s is %s", s.Name, s.Status)
+}
+
+// Notify queues a step transition for the sinks that match it. It can be used as the OnChange of the steps.
+func (n *Notifier) Notify(s steps.Step) {
+	nt := Notification{
+		Deployment: n.deployment,
+		Step:       s.Name,
+		Status:     s.Status,
+		Title:      title(s),
+		Error:      s.Error,
+		Reason:     s.Reason,
+		BuildURL:   msg.FindBuildErrorURL(s.Error),
+		Time:       n.now().UTC(),
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	n.pending.Add(1)
+	select {
+	case n.queue <- nt:
+	default:
+		n.pending.Done()
+		n.report(fmt.Errorf("the notification of step %s was dropped, %d notifications are waiting to be sent", s.Name, queueSize))
+	}
+}
+
+// Flush waits until the queued notifications are sent.
+func (n *Notifier) Flush() {
+	n.pending.Wait()
+}
+
+// Close sends the queued notifications and stops the Notifier, the notifications queued after Close are dropped.
+func (n *Notifier) Close() {
+	n.mu.Lock()
+	closed := n.closed
+	n.closed = true
+	n.mu.Unlock()
+	if closed {
+		return
+	}
+	n.Flush()
+	close(n.queue)
+}
+
+// run sends the queued notifications until the Notifier is closed.
+func (n *Notifier) run() {
+	for nt := range n.queue {
+		n.send(nt)
+		n.pending.Done()
+	}
+}
+
+// send sends a step transition to the sinks that match it.
+func (n *Notifier) send(base Notification) {
+	for _, t := range n.targets {
+		if !t.matches(base.Step, base.Status) {
+			continue
+		}
+		urgent := base.Status == Failed || base.Status == WaitingApproval
+		suppressed, ok := t.limiter.allow(base.Time, urgent)
+		if !ok {
+			continue
+		}
+		nt := base
+		nt.Suppressed = suppressed
+		var message bytes.Buffer
+		if err := t.tmpl.Execute(&message, nt); err != nil {
+			n.report(fmt.Errorf("sink %s: failed to render the message: %w", t.name, err))
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err := t.sink.Send(ctx, nt, message.String())
+		cancel()
+		if err != nil {
+			n.report(fmt.Errorf("sink %s: failed to send the notification of step %s: %w", t.name, base.Step, err))
+		}
+	}
+}
+
+func (n *Notifier) report(err error) {
+	if n.OnError != nil {
+		n.OnError(err)
+	}
+}
+
+// limiter allows limit notifications in a sliding period, it counts the notifications it suppresses.
+type limiter struct {
+	mu         sync.Mutex
+	limit      int
+	period     time.Duration
+	sent       []time.Time
+	suppressed int
+}
+
+// allow records a notification at now. The urgent notifications are always allowed. It returns the number of
+// notifications suppressed before an allowed one.
+func (l *limiter) allow(now time.Time, urgent bool) (int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sent = slices.DeleteFunc(l.sent, func(t time.Time) bool { return now.Sub(t) >= l.period })
+	if !urgent && len(l.sent) >= l.limit {
+		l.suppressed++
+		return 0, false
+	}
+	l.sent = append(l.sent, now)
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return suppressed, true
+}
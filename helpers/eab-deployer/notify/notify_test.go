@@ -0,0 +1,270 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/msg"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/steps"
+)
+
+// fakeSink records the messages.
+type fakeSink struct {
+	messages *[]string
+}
+
+func (s fakeSink) Send(ctx context.Context, n Notification, message string) error {
+	*s.messages = append(*s.messages, message)
+	return nil
+}
+
+func TestLoad(t *testing.T) {
+	c, err := Load(filepath.Join("testdata", "notifications.hcl"))
+	assert.NoError(t, err)
+	assert.Equal(t, "eab-production", c.Deployment)
+	assert.Len(t, c.Sinks, 2)
+	assert.Equal(t, []string{"FAILED", "WAITING_APPROVAL"}, c.Sinks[0].Statuses)
+	assert.Equal(t, 5, c.Sinks[1].RateLimit)
+
+	_, err = New(c)
+	assert.EqualError(t, err, "sink ops-chat: environment variable EAB_TEST_CHAT_WEBHOOK is not set")
+	t.Setenv("EAB_TEST_CHAT_WEBHOOK", "https://chat.googleapis.com/v1/spaces/test/messages")
+	n, err := New(c)
+	assert.NoError(t, err)
+	assert.Len(t, n.targets, 2)
+
+	_, err = Load(filepath.Join("testdata", "missing.hcl"))
+	assert.ErrorContains(t, err, "failed to load notifications file")
+}
+
+func TestNewErrors(t *testing.T) {
+	t.Setenv("EAB_TEST_WEBHOOK", "http://localhost")
+	tests := []struct {
+		name   string
+		sink   SinkConfig
+		errMsg string
+	}{
+		{name: "type", sink: SinkConfig{Name: "s", Type: "pager"}, errMsg: "sink s: unknown type 'pager', expected one of: webhook, slack, google_chat, smtp"},
+		{name: "url", sink: SinkConfig{Name: "s", Type: Slack}, errMsg: "sink s: url_env is required"},
+		{name: "smtp", sink: SinkConfig{Name: "s", Type: SMTP, Address: "smtp.example.com:25"}, errMsg: "sink s: address, from and to are required"},
+		{name: "status", sink: SinkConfig{Name: "s", Type: Webhook, URLEnv: "EAB_TEST_WEBHOOK", Statuses: []string{"DESTROYED"}}, errMsg: "sink s: unknown status 'DESTROYED'"},
+		{name: "template", sink: SinkConfig{Name: "s", Type: Webhook, URLEnv: "EAB_TEST_WEBHOOK", Template: "{{ .Step"}, errMsg: "sink s: invalid template"},
+		{name: "rate", sink: SinkConfig{Name: "s", Type: Webhook, URLEnv: "EAB_TEST_WEBHOOK", RatePeriod: "hourly"}, errMsg: "sink s: invalid rate_period"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(Config{Sinks: []SinkConfig{tt.sink}})
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+	_, err := New(Config{Sinks: []SinkConfig{{Name: "s", Type: Slack, URLEnv: "EAB_TEST_WEBHOOK"}, {Name: "s", Type: Slack, URLEnv: "EAB_TEST_WEBHOOK"}}})
+	assert.EqualError(t, err, "sink s is defined more than once")
+}
+
+func TestNotify(t *testing.T) {
+	messages := []string{}
+	tg, err := newTarget(SinkConfig{Name: "fake"}, fakeSink{messages: &messages})
+	assert.NoError(t, err)
+	n := (&Notifier{deployment: "eab-production", targets: []target{tg}, now: time.Now}).start()
+
+	buildURL := msg.BuildErrorURL("eab-cicd", "us-central1", "1234-abcd")
+	n.Notify(steps.Step{Name: "gcp-multitenant", Status: Started})
+	n.Notify(steps.Step{Name: "eab-multitenant.development", Status: Failed, Error: "build failed"})
+	n.Notify(steps.Step{Name: "gcp-multitenant", Status: Failed, Error: fmt.Sprintf("Terraform eab-multitenant apply development build Failed.\nSee:\n%s\nfor details", buildURL)})
+	n.Notify(steps.Step{Name: "gcp-appfactory", Status: WaitingApproval, Reason: "billing quota increase for sa@eab.iam.gserviceaccount.com"})
+	n.Notify(steps.Step{Name: "gcp-bootstrap", Status: "DESTROYED"})
+	n.Close()
+
+	assert.Equal(t, []string{
+		"[eab-production] Step gcp-multitenant started",
+		"[eab-production] Step gcp-multitenant failed\nTerraform eab-multitenant apply development build Failed.\nSee:\n" + buildURL + "\nfor details\nBuild: " + buildURL,
+		"[eab-production] Step gcp-appfactory is waiting for approval: billing quota increase for sa@eab.iam.gserviceaccount.com",
+	}, messages, "only the top level steps and the notified statuses should be sent")
+}
+
+// blockingSink blocks the sends until it is released.
+type blockingSink struct {
+	release chan struct{}
+	sent    *[]string
+}
+
+func (s blockingSink) Send(ctx context.Context, n Notification, message string) error {
+	<-s.release
+	*s.sent = append(*s.sent, n.Step)
+	return nil
+}
+
+func TestNotifyInBackground(t *testing.T) {
+	release := make(chan struct{})
+	sent := []string{}
+	tg, err := newTarget(SinkConfig{Name: "slow"}, blockingSink{release: release, sent: &sent})
+	assert.NoError(t, err)
+	n := (&Notifier{targets: []target{tg}, now: time.Now}).start()
+
+	n.Notify(steps.Step{Name: "gcp-bootstrap", Status: Started})
+	n.Notify(steps.Step{Name: "gcp-bootstrap", Status: Completed})
+	assert.Empty(t, sent, "the steps should not wait for the sink")
+
+	close(release)
+	n.Close()
+	assert.Equal(t, []string{"gcp-bootstrap", "gcp-bootstrap"}, sent, "the queued notifications should be sent on close")
+	n.Notify(steps.Step{Name: "gcp-multitenant", Status: Started})
+	n.Close()
+	assert.Len(t, sent, 2, "the notifications after close should be dropped")
+}
+
+func TestRateLimit(t *testing.T) {
+	messages := []string{}
+	tg, err := newTarget(SinkConfig{Name: "fake", Steps: []string{"*"}, RateLimit: 2, RatePeriod: "1m", Template: "{{ .Step }} {{ .Suppressed }}"}, fakeSink{messages: &messages})
+	assert.NoError(t, err)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	n := (&Notifier{targets: []target{tg}, now: func() time.Time { return now }}).start()
+
+	for _, step := range []string{"a", "b", "c", "d"} {
+		n.Notify(steps.Step{Name: step, Status: Completed})
+	}
+	n.Notify(steps.Step{Name: "e", Status: Failed})
+	now = now.Add(time.Minute)
+	n.Notify(steps.Step{Name: "f", Status: Completed})
+	n.Notify(steps.Step{Name: "g", Status: Completed})
+	n.Close()
+
+	assert.Equal(t, []string{"a 0", "b 0", "e 2", "f 0", "g 0"}, messages, "failures are never suppressed and report the suppressed notifications")
+}
+
+func TestWebhookSinks(t *testing.T) {
+	bodies := []map[string]any{}
+	headers := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := io.ReadAll(r.Body)
+		var body map[string]any
+		assert.NoError(t, json.Unmarshal(content, &body))
+		bodies = append(bodies, body)
+		headers = append(headers, r.Header.Get("Authorization"))
+		if r.URL.Path == "/fail" {
+			http.Error(w, "invalid token", http.StatusForbidden)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("EAB_TEST_WEBHOOK", srv.URL+"/hook")
+	t.Setenv("EAB_TEST_CHAT", srv.URL+"/chat")
+	n, err := New(Config{Sinks: []SinkConfig{
+		{Name: "webhook", Type: Webhook, URLEnv: "EAB_TEST_WEBHOOK", Headers: map[string]string{"Authorization": "Bearer token"}},
+		{Name: "slack", Type: Slack, URLEnv: "EAB_TEST_CHAT"},
+	}})
+	assert.NoError(t, err)
+	errs := []error{}
+	n.OnError = func(err error) { errs = append(errs, err) }
+
+	n.Notify(steps.Step{Name: "gcp-fleetscope", Status: Completed})
+	n.Flush()
+	assert.Empty(t, errs)
+	assert.Len(t, bodies, 2)
+	assert.Equal(t, "gcp-fleetscope", bodies[0]["step"])
+	assert.Equal(t, "COMPLETED", bodies[0]["status"])
+	assert.Equal(t, "Step gcp-fleetscope completed", bodies[0]["message"])
+	assert.Equal(t, "Bearer token", headers[0])
+	assert.Equal(t, map[string]any{"text": "Step gcp-fleetscope completed"}, bodies[1])
+
+	err = ChatSink{URL: srv.URL + "/fail"}.Send(context.Background(), Notification{}, "failed")
+	assert.EqualError(t, err, "request failed with status 403 Forbidden: invalid token")
+	err = ChatSink{URL: "http://127.0.0.1:1/secret-token"}.Send(context.Background(), Notification{}, "failed")
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret-token", "the webhook URL should not be in the error")
+}
+
+func TestSMTPSink(t *testing.T) {
+	var sent []byte
+	s := SMTPSink{Address: "smtp.example.com:587", From: "eab@example.com", To: []string{"oncall@example.com", "ops@example.com"}}
+	s.send = func(ctx context.Context, addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		assert.Equal(t, "smtp.example.com:587", addr)
+		assert.Equal(t, []string{"oncall@example.com", "ops@example.com"}, to)
+		sent = msg
+		return nil
+	}
+	assert.NoError(t, s.Send(context.Background(), Notification{}, "Step gcp-multitenant failed\nbuild failed"))
+	assert.Equal(t, "From: eab@example.com\r\nTo: oncall@example.com, ops@example.com\r\nSubject: Step gcp-multitenant failed\r\n"+
+		"Content-Type: text/plain; charset=UTF-8\r\n\r\nbuild failed\r\n", string(sent))
+}
+
+// fakeRelay is an SMTP relay that accepts the messages, or only the connections when hang is set.
+func fakeRelay(t *testing.T, hang bool) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if hang {
+			_, _ = io.Copy(io.Discard, conn)
+			return
+		}
+		tc := textproto.NewConn(conn)
+		_ = tc.PrintfLine("220 relay ready")
+		for {
+			line, err := tc.ReadLine()
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "DATA"):
+				_ = tc.PrintfLine("354 go ahead")
+				data, _ := tc.ReadDotBytes()
+				received <- string(data)
+				_ = tc.PrintfLine("250 ok")
+			case strings.HasPrefix(line, "QUIT"):
+				_ = tc.PrintfLine("221 bye")
+				return
+			default:
+				_ = tc.PrintfLine("250 ok")
+			}
+		}
+	}()
+	return l.Addr().String(), received
+}
+
+func TestSendMail(t *testing.T) {
+	addr, received := fakeRelay(t, false)
+	s := SMTPSink{Address: addr, From: "eab@example.com", To: []string{"oncall@example.com"}}
+	assert.NoError(t, s.Send(context.Background(), Notification{}, "Step gcp-multitenant failed\nbuild failed"))
+	assert.Contains(t, <-received, "Subject: Step gcp-multitenant failed\n")
+
+	addr, _ = fakeRelay(t, true)
+	s.Address = addr
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Error(t, s.Send(ctx, Notification{}, "Step gcp-multitenant failed"), "a relay that does not answer should time out")
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
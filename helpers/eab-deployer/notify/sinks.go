@@ -0,0 +1,210 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// newSink creates the sink of a configuration. The URLs and passwords are read from the environment.
+func newSink(sc SinkConfig) (Sink, error) {
+	switch sc.Type {
+	case Webhook, Slack, GoogleChat:
+		if sc.URLEnv == "" {
+			return nil, fmt.Errorf("url_env is required")
+		}
+		endpoint := os.Getenv(sc.URLEnv)
+		if endpoint == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", sc.URLEnv)
+		}
+		if sc.Type == Webhook {
+			return WebhookSink{URL: endpoint, Headers: sc.Headers}, nil
+		}
+		return ChatSink{URL: endpoint}, nil
+	case SMTP:
+		if sc.Address == "" || sc.From == "" || len(sc.To) == 0 {
+			return nil, fmt.Errorf("address, from and to are required")
+		}
+		s := SMTPSink{Address: sc.Address, From: sc.From, To: sc.To}
+		if sc.Username != "" {
+			host, _, err := net.SplitHostPort(sc.Address)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address: %w", err)
+			}
+			s.Auth = smtp.PlainAuth("", sc.Username, os.Getenv(sc.PasswordEnv), host)
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown type '%s', expected one of: %s", sc.Type, strings.Join([]string{Webhook, Slack, GoogleChat, SMTP}, ", "))
+}
+
+// post sends a JSON document to a URL, the responses without a 2xx status are errors.
+func post(ctx context.Context, endpoint string, headers map[string]string, body any) error {
+	content, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// the URL is a secret of the webhook, it is removed from the error
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if len(bytes.TrimSpace(detail)) == 0 {
+			return fmt.Errorf("request failed with status %s", resp.Status)
+		}
+		return fmt.Errorf("request failed with status %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	return nil
+}
+
+// WebhookSink posts the notifications as JSON, with the message in the message field.
+type WebhookSink struct {
+	URL     string
+	Headers map[string]string
+}
+
+func (s WebhookSink) Send(ctx context.Context, n Notification, message string) error {
+	return post(ctx, s.URL, s.Headers, struct {
+		Notification
+		Message string `json:"message"`
+	}{n, message})
+}
+
+// ChatSink posts the messages to a Slack or Google Chat incoming webhook, both use the text field.
+type ChatSink struct {
+	URL string
+}
+
+func (s ChatSink) Send(ctx context.Context, n Notification, message string) error {
+	return post(ctx, s.URL, nil, map[string]string{"text": message})
+}
+
+// SMTPSink sends the messages by email through a relay, the first line of the message is the subject.
+type SMTPSink struct {
+	Address string
+	From    string
+	To      []string
+	// Auth, if set, authenticates the relay user.
+	Auth smtp.Auth
+	// send is sendMail, replaced by the tests.
+	send func(ctx context.Context, addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func (s SMTPSink) Send(ctx context.Context, n Notification, message string) error {
+	subject, body, _ := strings.Cut(message, "\n")
+	var m bytes.Buffer
+	fmt.Fprintf(&m, "From: %s\r\n", s.From)
+	fmt.Fprintf(&m, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&m, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&m, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	m.WriteString(strings.ReplaceAll(strings.TrimSpace(body), "\n", "\r\n"))
+	m.WriteString("\r\n")
+	send := s.send
+	if send == nil {
+		send = sendMail
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return send(ctx, s.Address, s.Auth, s.From, s.To, m.Bytes())
+}
+
+// sendMail is smtp.SendMail with the context: the connection is dialed with the context, has the deadline of
+// the context and is closed when the context is done, so a relay that does not answer never blocks the sink.
+func sendMail(ctx context.Context, addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if a != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(a); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
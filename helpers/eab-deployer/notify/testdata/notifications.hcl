@@ -0,0 +1,19 @@
+deployment = "eab-production"
+
+sink "ops-chat" {
+  type     = "google_chat"
+  url_env  = "EAB_TEST_CHAT_WEBHOOK"
+  statuses = ["FAILED", "WAITING_APPROVAL"]
+}
+
+sink "oncall-mail" {
+  type         = "smtp"
+  address      = "smtp.example.com:587"
+  from         = "eab-deployer@example.com"
+  to           = ["oncall@example.com"]
+  username     = "eab-deployer"
+  password_env = "EAB_TEST_SMTP_PASSWORD"
+  steps        = ["*"]
+  rate_limit   = 5
+  rate_period  = "1h"
+}
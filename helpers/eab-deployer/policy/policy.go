@@ -0,0 +1,396 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ResourceChangeTarget is the target of the constraint templates evaluated against Terraform resource changes.
+	ResourceChangeTarget = "validation.resourcechange.terraform.cloud.google.com"
+
+	waiversFile  = "waivers.yaml"
+	expiryLayout = "2006-01-02"
+)
+
+// Template is a constraint template of the policy library.
+type Template struct {
+	Name   string
+	Kind   string
+	Target string
+	Rego   string
+	Libs   []string
+}
+
+// Constraint is an instance of a constraint template with its parameters.
+type Constraint struct {
+	Name       string
+	Kind       string
+	Parameters map[string]interface{}
+	Addresses  []string
+	Excluded   []string
+	addresses  []*regexp.Regexp
+	excluded   []*regexp.Regexp
+}
+
+// Waiver accepts the violations of a constraint for the resource addresses matching a glob.
+type Waiver struct {
+	Constraint string `json:"constraint"`
+	Address    string `json:"address"`
+	Reason     string `json:"reason"`
+	Expires    string `json:"expires"`
+	address    *regexp.Regexp
+}
+
+// Violation is a policy violation found for a resource in the Terraform plan.
+type Violation struct {
+	Constraint string                 `json:"constraint"`
+	Address    string                 `json:"address"`
+	Message    string                 `json:"message"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+	Waived     bool                   `json:"waived"`
+	Reason     string                 `json:"reason,omitempty"`
+}
+
+// Set is the group of templates, constraints and waivers used to validate a stage.
+type Set struct {
+	Templates   map[string]Template
+	Constraints []Constraint
+	Waivers     []Waiver
+	// Skipped are the constraints which template target cannot be evaluated against a Terraform plan.
+	Skipped []string
+	libs    []string
+}
+
+type templateFile struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		CRD struct {
+			Spec struct {
+				Names struct {
+					Kind string `json:"kind"`
+				} `json:"names"`
+			} `json:"spec"`
+		} `json:"crd"`
+		Targets []struct {
+			Target string   `json:"target"`
+			Rego   string   `json:"rego"`
+			Libs   []string `json:"libs"`
+		} `json:"targets"`
+	} `json:"spec"`
+}
+
+type constraintFile struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Match struct {
+			Addresses         []string `json:"addresses"`
+			ExcludedAddresses []string `json:"excludedAddresses"`
+		} `json:"match"`
+		Parameters map[string]interface{} `json:"parameters"`
+	} `json:"spec"`
+}
+
+// LoadSet loads the policies of the policy library in policyPath that apply to the given stage.
+// Besides the common policies in the 'policies' directory, a stage can have its own constraints
+// in 'stages/STAGE/constraints' and waivers in 'stages/STAGE/waivers.yaml'.
+func LoadSet(policyPath, stage string) (Set, error) {
+	s := Set{
+		Templates: map[string]Template{},
+	}
+
+	libs, err := findFiles(filepath.Join(policyPath, "lib"), ".rego")
+	if err != nil {
+		return s, err
+	}
+	for _, l := range libs {
+		content, err := os.ReadFile(l)
+		if err != nil {
+			return s, err
+		}
+		if !strings.HasSuffix(l, "_test.rego") {
+			s.libs = append(s.libs, string(content))
+		}
+	}
+
+	templates, err := findFiles(filepath.Join(policyPath, "policies", "templates"), ".yaml")
+	if err != nil {
+		return s, err
+	}
+	for _, f := range templates {
+		var tf templateFile
+		if err := readYAML(f, &tf); err != nil {
+			return s, err
+		}
+		if tf.Kind != "ConstraintTemplate" || len(tf.Spec.Targets) == 0 {
+			continue
+		}
+		s.Templates[tf.Spec.CRD.Spec.Names.Kind] = Template{
+			Name:   tf.Metadata.Name,
+			Kind:   tf.Spec.CRD.Spec.Names.Kind,
+			Target: tf.Spec.Targets[0].Target,
+			Rego:   tf.Spec.Targets[0].Rego,
+			Libs:   tf.Spec.Targets[0].Libs,
+		}
+	}
+
+	constraintDirs := []string{filepath.Join(policyPath, "policies", "constraints")}
+	waiverFiles := []string{filepath.Join(policyPath, waiversFile)}
+	if stage != "" {
+		constraintDirs = append(constraintDirs, filepath.Join(policyPath, "stages", stage, "constraints"))
+		waiverFiles = append(waiverFiles, filepath.Join(policyPath, "stages", stage, waiversFile))
+	}
+	for _, dir := range constraintDirs {
+		constraints, err := findFiles(dir, ".yaml")
+		if err != nil {
+			return s, err
+		}
+		for _, f := range constraints {
+			var cf constraintFile
+			if err := readYAML(f, &cf); err != nil {
+				return s, err
+			}
+			c := Constraint{
+				Name:       cf.Metadata.Name,
+				Kind:       cf.Kind,
+				Parameters: cf.Spec.Parameters,
+				Addresses:  cf.Spec.Match.Addresses,
+				Excluded:   cf.Spec.Match.ExcludedAddresses,
+			}
+			if c.addresses, err = compileGlobs(c.Addresses); err != nil {
+				return s, fmt.Errorf("constraint %s in %s: %w", c.Name, f, err)
+			}
+			if c.excluded, err = compileGlobs(c.Excluded); err != nil {
+				return s, fmt.Errorf("constraint %s in %s: %w", c.Name, f, err)
+			}
+			if t, ok := s.Templates[c.Kind]; !ok || t.Target != ResourceChangeTarget {
+				s.Skipped = append(s.Skipped, c.Name)
+				continue
+			}
+			s.Constraints = append(s.Constraints, c)
+		}
+	}
+
+	for _, f := range waiverFiles {
+		_, err := os.Stat(f)
+		if os.IsNotExist(err) {
+			continue
+		}
+		var w []Waiver
+		if err := readYAML(f, &w); err != nil {
+			return s, err
+		}
+		for i := range w {
+			if w[i].address, err = compileGlob(w[i].Address); err != nil {
+				return s, fmt.Errorf("waiver of constraint %s in %s: %w", w[i].Constraint, f, err)
+			}
+		}
+		s.Waivers = append(s.Waivers, w...)
+	}
+	return s, nil
+}
+
+// Evaluate evaluates the resource changes of a Terraform plan in JSON format against the constraints of the set.
+func (s Set) Evaluate(ctx context.Context, jsonPlan []byte, now time.Time) ([]Violation, error) {
+	var plan struct {
+		ResourceChanges []map[string]interface{} `json:"resource_changes"`
+	}
+	if err := json.Unmarshal(jsonPlan, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse terraform plan: %w", err)
+	}
+
+	violations := []Violation{}
+	for _, c := range s.Constraints {
+		query, err := s.prepare(ctx, s.Templates[c.Kind])
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile template %s: %w", c.Kind, err)
+		}
+		for _, rc := range plan.ResourceChanges {
+			address, _ := rc["address"].(string)
+			if !matchAny(c.addresses, address, true) || matchAny(c.excluded, address, false) || isDeleteOnly(rc) {
+				continue
+			}
+			rs, err := query.Eval(ctx, rego.EvalInput(map[string]interface{}{
+				"review":     rc,
+				"parameters": c.Parameters,
+			}))
+			if err != nil {
+				return nil, fmt.Errorf("failed to evaluate constraint %s on %s: %w", c.Name, address, err)
+			}
+			for _, r := range rs {
+				for _, e := range r.Expressions {
+					results, _ := e.Value.([]interface{})
+					for _, result := range results {
+						v := Violation{
+							Constraint: c.Name,
+							Address:    address,
+						}
+						if m, ok := result.(map[string]interface{}); ok {
+							v.Message, _ = m["msg"].(string)
+							v.Details, _ = m["details"].(map[string]interface{})
+						}
+						v.Waived, v.Reason = s.waived(v, now)
+						violations = append(violations, v)
+					}
+				}
+			}
+		}
+	}
+	sort.Slice(violations, func(i, j int) bool {
+		if violations[i].Address == violations[j].Address {
+			return violations[i].Constraint < violations[j].Constraint
+		}
+		return violations[i].Address < violations[j].Address
+	})
+	return violations, nil
+}
+
+// prepare compiles the rego of the template together with the libraries of the policy library.
+func (s Set) prepare(ctx context.Context, t Template) (rego.PreparedEvalQuery, error) {
+	opts := ast.ParserOptions{RegoVersion: ast.RegoV0}
+	m, err := ast.ParseModuleWithOpts(t.Kind, t.Rego, opts)
+	if err != nil {
+		return rego.PreparedEvalQuery{}, err
+	}
+	args := []func(*rego.Rego){
+		rego.SetRegoVersion(ast.RegoV0),
+		rego.Query(fmt.Sprintf("%s.violation", m.Package.Path.String())),
+		rego.ParsedModule(m),
+	}
+	// templates built by the policy library have the libraries inlined
+	libs := t.Libs
+	if len(libs) == 0 {
+		libs = s.libs
+	}
+	for i, l := range libs {
+		lm, err := ast.ParseModuleWithOpts(fmt.Sprintf("%s-lib-%d", t.Kind, i), l, opts)
+		if err != nil {
+			return rego.PreparedEvalQuery{}, err
+		}
+		args = append(args, rego.ParsedModule(lm))
+	}
+	return rego.New(args...).PrepareForEval(ctx)
+}
+
+// waived checks if there is a waiver, not expired, for the violation.
+func (s Set) waived(v Violation, now time.Time) (bool, string) {
+	for _, w := range s.Waivers {
+		if w.Constraint != v.Constraint || !w.address.MatchString(v.Address) {
+			continue
+		}
+		if w.Expires != "" {
+			expires, err := time.Parse(expiryLayout, w.Expires)
+			if err != nil || !now.Before(expires) {
+				continue
+			}
+		}
+		return true, w.Reason
+	}
+	return false, ""
+}
+
+// isDeleteOnly checks if the resource change only deletes the resource.
+func isDeleteOnly(rc map[string]interface{}) bool {
+	change, _ := rc["change"].(map[string]interface{})
+	actions, _ := change["actions"].([]interface{})
+	return len(actions) == 1 && actions[0] == "delete"
+}
+
+// compileGlob compiles an address glob. A '*' in the glob matches any sequence of characters.
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	pattern := strings.ReplaceAll(regexp.QuoteMeta(glob), `\*`, ".*")
+	re, err := regexp.Compile(fmt.Sprintf("^%s$", pattern))
+	if err != nil {
+		return nil, fmt.Errorf("invalid address pattern '%s': %w", glob, err)
+	}
+	return re, nil
+}
+
+// compileGlobs compiles the address globs of a constraint.
+func compileGlobs(globs []string) ([]*regexp.Regexp, error) {
+	compiled := []*regexp.Regexp{}
+	for _, g := range globs {
+		re, err := compileGlob(g)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// matchAny checks if the address matches any of the compiled globs.
+func matchAny(globs []*regexp.Regexp, address string, emptyMatches bool) bool {
+	if len(globs) == 0 {
+		return emptyMatches
+	}
+	for _, re := range globs {
+		if re.MatchString(address) {
+			return true
+		}
+	}
+	return false
+}
+
+func readYAML(file string, val interface{}) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(content, val); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	return nil
+}
+
+// findFiles finds the files with the given extension under dir, a missing dir has no files.
+func findFiles(dir, ext string) ([]string, error) {
+	found := []string{}
+	_, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return found, nil
+	}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && filepath.Ext(path) == ext {
+			found = append(found, path)
+		}
+		return nil
+	})
+	sort.Strings(found)
+	return found, err
+}
@@ -0,0 +1,87 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSet(t *testing.T) {
+	s, err := LoadSet(filepath.Join(".", "testdata", "policy-library"), "")
+	assert.NoError(t, err)
+	assert.Len(t, s.Templates, 3, "should load all templates")
+	assert.Len(t, s.Constraints, 1, "should load only resource change constraints")
+	assert.Equal(t, []string{"asset_storage_location"}, s.Skipped)
+	assert.Len(t, s.Waivers, 0, "common policies have no waivers")
+
+	s, err = LoadSet(filepath.Join(".", "testdata", "policy-library"), "2-multitenant")
+	assert.NoError(t, err)
+	assert.Len(t, s.Constraints, 2, "should load stage constraints")
+	assert.Len(t, s.Waivers, 2, "should load stage waivers")
+}
+
+func TestEvaluate(t *testing.T) {
+	plan, err := os.ReadFile(filepath.Join(".", "testdata", "plan.json"))
+	assert.NoError(t, err)
+	s, err := LoadSet(filepath.Join(".", "testdata", "policy-library"), "2-multitenant")
+	assert.NoError(t, err)
+
+	violations, err := s.Evaluate(context.Background(), plan, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, []Violation{
+		{
+			Constraint: "no_public_ip",
+			Address:    "google_compute_instance.vm",
+			Message:    "instance google_compute_instance.vm has a public IP",
+		},
+		{
+			Constraint: "allow_only_us_buckets",
+			Address:    "google_storage_bucket.old",
+			Message:    "bucket google_storage_bucket.old location ASIA is not allowed",
+			Details:    map[string]interface{}{"location": "ASIA"},
+		},
+		{
+			Constraint: "allow_only_us_buckets",
+			Address:    "module.logs.google_storage_bucket.logs",
+			Message:    "bucket module.logs.google_storage_bucket.logs location EU is not allowed",
+			Details:    map[string]interface{}{"location": "EU"},
+			Waived:     true,
+			Reason:     "logs are stored in the EU by regulation",
+		},
+	}, violations)
+
+	_, err = s.Evaluate(context.Background(), []byte("not a plan"), time.Now())
+	assert.Error(t, err, "invalid plan should fail")
+}
+
+func TestCompileGlob(t *testing.T) {
+	re, err := compileGlob("module.logs.*[0]")
+	assert.NoError(t, err)
+	assert.True(t, re.MatchString("module.logs.google_storage_bucket.bucket[0]"))
+	assert.False(t, re.MatchString("module.logs.google_storage_bucket.bucket[1]"), "only '*' is a wildcard")
+	assert.False(t, re.MatchString("x.module.logs.bucket[0]"), "the glob matches the whole address")
+
+	globs, err := compileGlobs([]string{"a.*", "b"})
+	assert.NoError(t, err)
+	assert.True(t, matchAny(globs, "b", false))
+	assert.False(t, matchAny(globs, "c", false))
+	assert.True(t, matchAny(nil, "c", true), "no globs match when emptyMatches")
+}
@@ -0,0 +1,10 @@
+package validator.gcp.lib
+
+get_default(obj, param, _default) = out {
+	out = obj[param]
+}
+
+get_default(obj, param, _default) = out {
+	not obj[param]
+	out = _default
+}
@@ -0,0 +1,341 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package render renders the content of the stage repositories from the stage code, the built-in files and
+// the user overlays. Files with the .tmpl extension are Go templates executed with a typed Context.
+package render
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
+)
+
+const (
+	// ManifestFile is the file, in the root of the repository, with the list of rendered files.
+	ManifestFile = ".eab-manifest.json"
+	templateExt  = ".tmpl"
+)
+
+// DefaultPlaceholders are the values that must be replaced before the files are pushed to a repository.
+var DefaultPlaceholders = []string{
+	"UPDATE_ME",
+	"UPDATE_INFRA_REPO_STATE",
+	"UPDATE_PROJECTS_BACKEND",
+	"UPDATE_APP_INFRA_BUCKET",
+	"REPLACE_ME",
+}
+
+// placeholderExts are the extensions of the files checked for placeholders.
+// The Cloud Build files are not checked, they replace the placeholders of the backends on purpose.
+var placeholderExts = []string{".tf", ".tfvars"}
+
+//go:embed all:templates
+var builtin embed.FS
+
+// Context is the data available to the templates.
+type Context struct {
+	Repo           string
+	Step           string
+	Envs           []string
+	Region         string
+	CICDProject    string
+	ServiceAccount string
+	StateBucket    string
+	// Values are user defined values, from the template_values of the tfvars file.
+	Values map[string]string
+}
+
+// Source is a set of files rendered in a repository.
+type Source struct {
+	Name string
+	FS   fs.FS
+	// Target is the directory in the repository where the files are rendered.
+	Target string
+	// Files, if set, are the only files rendered from the source.
+	Files []string
+	// Content, if set, are generated files by path, used instead of FS.
+	Content map[string][]byte
+}
+
+// Rewrite replaces a value in a rendered file. New is a template executed with the Context.
+// The rendering fails when the old value is not found, to detect changes in the upstream files.
+type Rewrite struct {
+	File string
+	Old  string
+	New  string
+}
+
+// Renderer renders the sources in order, a file of a source replaces the same file of the previous sources.
+type Renderer struct {
+	Sources  []Source
+	Rewrites []Rewrite
+	// Placeholders are the values not allowed in the rendered terraform files, defaults to DefaultPlaceholders.
+	Placeholders []string
+}
+
+// ManifestEntry is a rendered file.
+type ManifestEntry struct {
+	Path     string `json:"path"`
+	Source   string `json:"source"`
+	Template bool   `json:"template"`
+	SHA256   string `json:"sha256"`
+}
+
+// Manifest lists the files rendered in a repository.
+type Manifest struct {
+	Repo  string          `json:"repo"`
+	Step  string          `json:"step"`
+	Files []ManifestEntry `json:"files"`
+}
+
+// Builtin is the source with the files generated by the helper in all the stage repositories.
+func Builtin() Source {
+	sub, err := fs.Sub(builtin, "templates")
+	if err != nil {
+		panic(err)
+	}
+	return Source{Name: "builtin", FS: sub}
+}
+
+// Overlay is a source with the files of a user directory, rendered in the root of the repository.
+func Overlay(dir string) Source {
+	return Source{Name: "overlay:" + dir, FS: os.DirFS(dir)}
+}
+
+var funcs = template.FuncMap{
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+func execute(name, text string, ctx Context) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ctx); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sourceFiles lists the files of a source skipping the terraform temporary files.
+func sourceFiles(s Source) ([]string, error) {
+	if len(s.Files) > 0 {
+		return s.Files, nil
+	}
+	if s.Content != nil {
+		return slices.Sorted(maps.Keys(s.Content)), nil
+	}
+	files := []string{}
+	err := fs.WalkDir(s.FS, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() == utils.TerraformTempDir && d.IsDir() {
+			return fs.SkipDir
+		}
+		if d.IsDir() || d.Name() == utils.TerraformLockFile {
+			return nil
+		}
+		files = append(files, p)
+		return nil
+	})
+	return files, err
+}
+
+// Render renders the sources in the dest directory and writes the manifest.
+func (r Renderer) Render(ctx Context, dest string) (Manifest, error) {
+	rendered := map[string]ManifestEntry{}
+	for _, s := range r.Sources {
+		files, err := sourceFiles(s)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("failed to list files of source %s: %w", s.Name, err)
+		}
+		for _, f := range files {
+			e, err := renderFile(s, f, ctx, dest)
+			if err != nil {
+				return Manifest{}, err
+			}
+			rendered[e.Path] = e
+		}
+	}
+
+	for _, rw := range r.Rewrites {
+		e, ok := rendered[rw.File]
+		if !ok {
+			return Manifest{}, fmt.Errorf("rewrite of %s: file was not rendered", rw.File)
+		}
+		sum, err := rewrite(rw, ctx, dest)
+		if err != nil {
+			return Manifest{}, err
+		}
+		e.SHA256 = sum
+		rendered[rw.File] = e
+	}
+
+	placeholders := r.Placeholders
+	if placeholders == nil {
+		placeholders = DefaultPlaceholders
+	}
+	unresolved := []string{}
+	for p := range rendered {
+		if !slices.Contains(placeholderExts, path.Ext(p)) {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dest, p))
+		if err != nil {
+			return Manifest{}, err
+		}
+		for _, ph := range placeholders {
+			if bytes.Contains(content, []byte(ph)) {
+				unresolved = append(unresolved, fmt.Sprintf("%s: %s", p, ph))
+			}
+		}
+	}
+	if len(unresolved) > 0 {
+		sort.Strings(unresolved)
+		return Manifest{}, fmt.Errorf("unresolved placeholders in rendered files:\n%s", strings.Join(unresolved, "\n"))
+	}
+
+	m := Manifest{Repo: ctx.Repo, Step: ctx.Step, Files: []ManifestEntry{}}
+	for _, e := range rendered {
+		m.Files = append(m.Files, e)
+	}
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+	f, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return m, err
+	}
+	return m, os.WriteFile(filepath.Join(dest, ManifestFile), append(f, '\n'), 0644)
+}
+
+// Checksum is a checksum of the files of the sources and of the rewrites, before the templates are executed.
+// It changes when the rendered repository can change for the same Context. The files with a base name that
+// matches one of the ignore patterns are not included.
+func (r Renderer) Checksum(ignore ...string) (string, error) {
+	h := sha256.New()
+	for _, s := range r.Sources {
+		files, err := sourceFiles(s)
+		if err != nil {
+			return "", fmt.Errorf("failed to list files of source %s: %w", s.Name, err)
+		}
+		for _, f := range files {
+			if slices.ContainsFunc(ignore, func(pattern string) bool {
+				ok, _ := path.Match(pattern, path.Base(f))
+				return ok
+			}) {
+				continue
+			}
+			content, perm, err := readFile(s, f)
+			if err != nil {
+				return "", fmt.Errorf("failed to read %s from source %s: %w", f, s.Name, err)
+			}
+			fmt.Fprintf(h, "%s\x00%s\x00%s\x00%o\x00%s\n", s.Name, s.Target, f, perm, checksum(content))
+		}
+	}
+	for _, rw := range r.Rewrites {
+		fmt.Fprintf(h, "rewrite\x00%s\x00%s\x00%s\n", rw.File, rw.Old, rw.New)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// renderFile renders a file of a source, a template is executed and written without the .tmpl extension.
+func renderFile(s Source, file string, ctx Context, dest string) (ManifestEntry, error) {
+	content, perm, err := readFile(s, file)
+	if err != nil {
+		return ManifestEntry{}, fmt.Errorf("failed to read %s from source %s: %w", file, s.Name, err)
+	}
+	e := ManifestEntry{Path: path.Join(s.Target, file), Source: s.Name}
+	if strings.HasSuffix(file, templateExt) {
+		e.Path = strings.TrimSuffix(e.Path, templateExt)
+		e.Template = true
+		content, err = execute(file, string(content), ctx)
+		if err != nil {
+			return ManifestEntry{}, fmt.Errorf("failed to render %s from source %s: %w", file, s.Name, err)
+		}
+	}
+	target := filepath.Join(dest, filepath.FromSlash(e.Path))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return ManifestEntry{}, err
+	}
+	// embedded files are read only
+	if err := os.WriteFile(target, content, perm|0200); err != nil {
+		return ManifestEntry{}, err
+	}
+	e.SHA256 = checksum(content)
+	return e, nil
+}
+
+// readFile reads the content and the permissions of a file of a source.
+func readFile(s Source, file string) ([]byte, fs.FileMode, error) {
+	if s.Content != nil {
+		content, ok := s.Content[file]
+		if !ok {
+			return nil, 0, fs.ErrNotExist
+		}
+		return content, 0644, nil
+	}
+	content, err := fs.ReadFile(s.FS, file)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := fs.Stat(s.FS, file)
+	if err != nil {
+		return nil, 0, err
+	}
+	return content, info.Mode().Perm(), nil
+}
+
+func rewrite(rw Rewrite, ctx Context, dest string) (string, error) {
+	target := filepath.Join(dest, filepath.FromSlash(rw.File))
+	content, err := os.ReadFile(target)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Contains(content, []byte(rw.Old)) {
+		return "", fmt.Errorf("rewrite of %s: value '%s' not found", rw.File, rw.Old)
+	}
+	value, err := execute(rw.File, rw.New, ctx)
+	if err != nil {
+		return "", fmt.Errorf("rewrite of %s: %w", rw.File, err)
+	}
+	content = bytes.ReplaceAll(content, []byte(rw.Old), value)
+	info, err := os.Stat(target)
+	if err != nil {
+		return "", err
+	}
+	return checksum(content), os.WriteFile(target, content, info.Mode().Perm())
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,173 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package render
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testContext() Context {
+	return Context{
+		Repo:           "eab-multitenant",
+		Step:           "2-multitenant",
+		Envs:           []string{"development", "production"},
+		ServiceAccount: "tf-cb-multitenant@prj-seed.iam.gserviceaccount.com",
+		StateBucket:    "bkt-prj-seed-tf-state",
+		Values:         map[string]string{"team": "platform"},
+	}
+}
+
+func TestRender(t *testing.T) {
+	stage := fstest.MapFS{
+		"envs/development/backend.tf":      {Data: []byte(`bucket = "bkt-prj-seed-tf-state"`)},
+		"envs/development/main.tf":         {Data: []byte(`module "env" {}`)},
+		".terraform/modules/modules.json":  {Data: []byte(`{}`)},
+		".terraform.lock.hcl":              {Data: []byte(`provider {}`)},
+		"envs/production/backend.tf.tmpl":  {Data: []byte(`bucket = "{{ .StateBucket }}"`)},
+		"modules/env/.terraform/plugin.so": {Data: []byte(``)},
+	}
+	build := fstest.MapFS{
+		"tf-wrapper.sh":   {Data: []byte(`leaf_regex_plan="^(development|nonproduction|production|shared)$"`), Mode: 0755},
+		"unused.yaml":     {Data: []byte(`steps: []`)},
+		"cloudbuild.yaml": {Data: []byte(`sed 's/UPDATE_ME/${_STATE_BUCKET_NAME}/'`)},
+	}
+	overlay := fstest.MapFS{
+		"CODEOWNERS.tmpl": {Data: []byte(`* @{{ .Values.team }} {{ .ServiceAccount }}`)},
+		".gitignore":      {Data: []byte(`*.tfstate`)},
+	}
+	r := Renderer{
+		Sources: []Source{
+			{Name: "2-multitenant", FS: stage},
+			{Name: "build", FS: build, Files: []string{"tf-wrapper.sh", "cloudbuild.yaml"}},
+			Builtin(),
+			{Name: "overlay", FS: overlay},
+		},
+		Rewrites: []Rewrite{
+			{File: "tf-wrapper.sh", Old: "^(development|nonproduction|production|shared)$", New: `^({{ join .Envs "|" }})$`},
+		},
+	}
+	dest := t.TempDir()
+	m, err := r.Render(testContext(), dest)
+	assert.NoError(t, err)
+
+	paths := []string{}
+	for _, f := range m.Files {
+		paths = append(paths, f.Path)
+	}
+	assert.Equal(t, []string{".gitignore", "CODEOWNERS", "cloudbuild.yaml", "envs/development/backend.tf", "envs/development/main.tf", "envs/production/backend.tf", "tf-wrapper.sh"}, paths)
+	assert.Equal(t, ManifestEntry{Path: ".gitignore", Source: "overlay", SHA256: checksum([]byte(`*.tfstate`))}, m.Files[0], "overlay should replace the built-in file")
+	assert.True(t, m.Files[1].Template)
+
+	content, err := os.ReadFile(filepath.Join(dest, "CODEOWNERS"))
+	assert.NoError(t, err)
+	assert.Equal(t, "* @platform tf-cb-multitenant@prj-seed.iam.gserviceaccount.com", string(content))
+	content, err = os.ReadFile(filepath.Join(dest, "envs/production/backend.tf"))
+	assert.NoError(t, err)
+	assert.Equal(t, `bucket = "bkt-prj-seed-tf-state"`, string(content))
+	content, err = os.ReadFile(filepath.Join(dest, "tf-wrapper.sh"))
+	assert.NoError(t, err)
+	assert.Equal(t, `leaf_regex_plan="^(development|production)$"`, string(content))
+	assert.Equal(t, checksum(content), m.Files[6].SHA256, "manifest should have the rewritten content")
+	info, err := os.Stat(filepath.Join(dest, "tf-wrapper.sh"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	assert.NoFileExists(t, filepath.Join(dest, ".terraform.lock.hcl"))
+	assert.NoDirExists(t, filepath.Join(dest, ".terraform"))
+
+	var saved Manifest
+	f, err := os.ReadFile(filepath.Join(dest, ManifestFile))
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(f, &saved))
+	assert.Equal(t, m, saved)
+}
+
+func TestRenderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		r      Renderer
+		errMsg string
+	}{
+		{
+			name:   "unresolved placeholders",
+			r:      Renderer{Sources: []Source{{Name: "stage", FS: fstest.MapFS{"envs/shared/backend.tf": {Data: []byte(`bucket = "UPDATE_ME"`)}, "main.tf": {Data: []byte(`# REPLACE_ME`)}}}}},
+			errMsg: "unresolved placeholders in rendered files:\nenvs/shared/backend.tf: UPDATE_ME\nmain.tf: REPLACE_ME",
+		},
+		{
+			name:   "missing value",
+			r:      Renderer{Sources: []Source{{Name: "overlay", FS: fstest.MapFS{"CODEOWNERS.tmpl": {Data: []byte(`{{ .Values.owner }}`)}}}}},
+			errMsg: "failed to render CODEOWNERS.tmpl from source overlay",
+		},
+		{
+			name:   "unknown field",
+			r:      Renderer{Sources: []Source{{Name: "overlay", FS: fstest.MapFS{"README.md.tmpl": {Data: []byte(`{{ .Bucket }}`)}}}}},
+			errMsg: "can't evaluate field Bucket",
+		},
+		{
+			name: "rewrite value not found",
+			r: Renderer{
+				Sources:  []Source{{Name: "build", FS: fstest.MapFS{"tf-wrapper.sh": {Data: []byte(`leaf_regex_plan="^(dev|prod)$"`)}}}},
+				Rewrites: []Rewrite{{File: "tf-wrapper.sh", Old: "^(development|production)$", New: "^(dev)$"}},
+			},
+			errMsg: "rewrite of tf-wrapper.sh: value '^(development|production)$' not found",
+		},
+		{
+			name:   "rewrite file not rendered",
+			r:      Renderer{Rewrites: []Rewrite{{File: "tf-wrapper.sh"}}},
+			errMsg: "rewrite of tf-wrapper.sh: file was not rendered",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := t.TempDir()
+			_, err := tt.r.Render(testContext(), dest)
+			assert.ErrorContains(t, err, tt.errMsg)
+			assert.NoFileExists(t, filepath.Join(dest, ManifestFile))
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	stage := fstest.MapFS{
+		"envs/development/main.tf":          {Data: []byte(`module "env" {}`)},
+		"envs/development/terraform.tfvars": {Data: []byte(`envs = {}`)},
+	}
+	r := Renderer{Sources: []Source{{Name: "2-multitenant", FS: stage}}}
+	sum, err := r.Checksum("terraform.tfvars")
+	assert.NoError(t, err)
+
+	stage["envs/development/terraform.tfvars"] = &fstest.MapFile{Data: []byte(`envs = {development = {}}`)}
+	same, err := r.Checksum("terraform.tfvars")
+	assert.NoError(t, err)
+	assert.Equal(t, sum, same, "ignored files should not change the checksum")
+
+	stage["envs/development/main.tf"] = &fstest.MapFile{Data: []byte(`module "env" { source = "../../modules/env" }`)}
+	changed, err := r.Checksum("terraform.tfvars")
+	assert.NoError(t, err)
+	assert.NotEqual(t, sum, changed)
+
+	r.Rewrites = []Rewrite{{File: "envs/development/main.tf", Old: "env", New: "{{ .Repo }}"}}
+	rewritten, err := r.Checksum("terraform.tfvars")
+	assert.NoError(t, err)
+	assert.NotEqual(t, changed, rewritten, "rewrites should change the checksum")
+
+	_, err = Renderer{Sources: []Source{{Name: "missing", FS: os.DirFS(filepath.Join(t.TempDir(), "missing"))}}}.Checksum()
+	assert.ErrorContains(t, err, "failed to list files of source missing")
+}
@@ -0,0 +1,72 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/deployer"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/stages"
+)
+
+const secretsUsage = `usage:
+  eab-deployer secrets [-stdin] [-rotate] [-rotate_webhook]`
+
+// runSecretsCommand creates and rotates the secrets of the repository configs with the values of the environment
+// variables, or of the standard input. Only the names of the secrets and the actions are printed.
+func runSecretsCommand(ctx context.Context, d *deployer.Deployer, args []string) error {
+	fs := flag.NewFlagSet("secrets", flag.ContinueOnError)
+	stdin := fs.Bool("stdin", false, fmt.Sprintf("Read the values as NAME=value lines from the standard input instead of the environment variables %s.", strings.Join(stages.SecretEnvs, ", ")))
+	rotate := fs.Bool("rotate", false, "Add a version to the existing secrets with a value and update the Cloud Build connections that use them.")
+	rotateWebhook := fs.Bool("rotate_webhook", false, "Generate a new version of the existing GitLab webhook secrets.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s\n%s", strings.Join(fs.Args(), " "), secretsUsage)
+	}
+
+	values := map[string]string{}
+	if *stdin {
+		v, err := stages.ReadSecretValues(os.Stdin)
+		if err != nil {
+			return err
+		}
+		values = v
+	} else {
+		for _, env := range stages.SecretEnvs {
+			if v, ok := os.LookupEnv(env); ok {
+				values[env] = v
+			}
+		}
+	}
+
+	results, err := d.Secrets(ctx, stages.SecretsOptions{Values: values, Rotate: *rotate, RotateWebhook: *rotateWebhook})
+	for _, r := range results {
+		if r.Version != "" {
+			fmt.Printf("%s %s %s\n", r.Name, r.Action, r.Version)
+		} else {
+			fmt.Printf("%s %s\n", r.Name, r.Action)
+		}
+		for _, c := range r.Connections {
+			fmt.Printf("  - connection %s updated\n", c)
+		}
+	}
+	return err
+}
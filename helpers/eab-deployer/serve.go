@@ -0,0 +1,83 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/server"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/workspace"
+)
+
+// tokenEnv is the environment variable with the bearer token of the server.
+const tokenEnv = "EAB_DEPLOYER_TOKEN"
+
+// runServeCommand runs the deployer as an HTTP server until it is interrupted.
+func runServeCommand(m workspace.Manager, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", ":8080", "Listen `address` of the server.")
+	maxRuns := fs.Int("max_concurrent_runs", 1, "Maximum number of runs of different workspaces executed at the same time, only 1 is supported.")
+	retention := fs.Duration("run_retention", 24*time.Hour, "How long the finished runs are kept.")
+	shutdownTimeout := fs.Duration("shutdown_timeout", 30*time.Minute, "How long to wait on shutdown for the cancelled runs to finish their current stage.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	token := os.Getenv(tokenEnv)
+	if token == "" {
+		return fmt.Errorf("the %s environment variable with the bearer token is required", tokenEnv)
+	}
+
+	s, err := server.New(server.Config{
+		Workspaces:        m,
+		Authenticate:      server.BearerToken(token),
+		MaxConcurrentRuns: *maxRuns,
+		RunRetention:      *retention,
+	})
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: *addr, Handler: s, ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// the runs are cancelled on shutdown, they stop at the end of the current stage and release
+	// the terraform state locks, the process must not exit before.
+	stopped := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		s.CancelAll()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdown)
+		wait, cancelWait := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancelWait()
+		stopped <- s.Wait(wait)
+	}()
+
+	fmt.Printf("# Listening on %s\n", *addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	fmt.Println("# Waiting for the runs to finish")
+	return <-stopped
+}
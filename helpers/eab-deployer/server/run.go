@@ -0,0 +1,140 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/deployer"
+)
+
+const (
+	RunQueued    = "QUEUED"
+	RunRunning   = "RUNNING"
+	RunSucceeded = "SUCCEEDED"
+	RunFailed    = "FAILED"
+	RunCancelled = "CANCELLED"
+)
+
+const (
+	OperationApply   = "apply"
+	OperationDestroy = "destroy"
+)
+
+// Run is the execution of an operation in a workspace.
+type Run struct {
+	ID         string    `json:"id"`
+	Workspace  string    `json:"workspace"`
+	Operation  string    `json:"operation"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// Done reports if the run finished.
+func (r Run) Done() bool {
+	switch r.Status {
+	case RunSucceeded, RunFailed, RunCancelled:
+		return true
+	}
+	return false
+}
+
+// execution tracks the state and the progress events of a run.
+type execution struct {
+	mu      sync.Mutex
+	run     Run
+	logFile string
+	events  []deployer.Event
+	// changed is closed and replaced each time the run changes, to wake up the event streams.
+	changed chan struct{}
+	cancel  context.CancelFunc
+}
+
+func newExecution(id, ws, operation string, cancel context.CancelFunc) *execution {
+	return &execution{
+		run: Run{
+			ID:        id,
+			Workspace: ws,
+			Operation: operation,
+			Status:    RunQueued,
+			CreatedAt: time.Now().UTC(),
+		},
+		changed: make(chan struct{}),
+		cancel:  cancel,
+	}
+}
+
+// notifyLocked wakes up the event streams, the lock must be held.
+func (e *execution) notifyLocked() {
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// addEvent records a progress event of the run.
+func (e *execution) addEvent(ev deployer.Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, ev)
+	e.notifyLocked()
+}
+
+func (e *execution) start(logFile string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.run.Status = RunRunning
+	e.run.StartedAt = time.Now().UTC()
+	e.logFile = logFile
+	e.notifyLocked()
+}
+
+func (e *execution) finish(status string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.run.Status = status
+	if err != nil {
+		e.run.Error = err.Error()
+	}
+	e.run.FinishedAt = time.Now().UTC()
+	e.notifyLocked()
+}
+
+// eventsFrom returns the events after the first n events, if the run finished and a channel closed on the next change.
+func (e *execution) eventsFrom(n int) ([]deployer.Event, bool, <-chan struct{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var events []deployer.Event
+	if n < len(e.events) {
+		events = append(events, e.events[n:]...)
+	}
+	return events, e.run.Done(), e.changed
+}
+
+// snapshot is a copy of the run state.
+func (e *execution) snapshot() Run {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.run
+}
+
+func (e *execution) logPath() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.logFile
+}
@@ -0,0 +1,423 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package server runs the deployer as a long-lived HTTP service. Deployments are submitted to workspaces
+// and their progress is streamed as server-sent events.
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/deployer"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/workspace"
+)
+
+// Runner runs the operations of a deployment, it is implemented by deployer.Deployer.
+type Runner interface {
+	Apply(ctx context.Context, opts deployer.ApplyOptions) error
+	Destroy(ctx context.Context, opts deployer.DestroyOptions) error
+	// Close is called at the end of the run.
+	Close()
+}
+
+// Config is the configuration of a Server.
+type Config struct {
+	Workspaces workspace.Manager
+	// Authenticate authorizes the requests, a request is rejected when it returns an error.
+	Authenticate func(r *http.Request) error
+	// NewRunner creates the runner of a deployment, defaults to deployer.New.
+	NewRunner func(c deployer.Config) (Runner, error)
+	// MaxConcurrentRuns is the number of runs of different workspaces executed at the same time, defaults to 1.
+	// Other runs are queued. Only one run at a time is supported, the runs share the state of the process.
+	MaxConcurrentRuns int
+	// RunRetention is how long the finished runs are kept, defaults to 24 hours.
+	RunRetention time.Duration
+}
+
+// Server is the HTTP server of the deployer.
+type Server struct {
+	c   Config
+	mux *http.ServeMux
+	// slots limits the concurrent runs.
+	slots chan struct{}
+
+	mu   sync.Mutex
+	runs map[string]*execution
+	// running tracks the runs that did not finish, to wait for them on shutdown.
+	running sync.WaitGroup
+}
+
+// SubmitRequest is the payload to submit a run.
+type SubmitRequest struct {
+	// Operation is apply or destroy, defaults to apply.
+	Operation string `json:"operation"`
+	// TFVars, if set, replaces the tfvars file of the workspace.
+	TFVars string `json:"tfvars"`
+	// UpTo is the last stage to be applied.
+	UpTo string `json:"up_to"`
+}
+
+// New creates a Server.
+func New(c Config) (*Server, error) {
+	if c.NewRunner == nil {
+		c.NewRunner = func(dc deployer.Config) (Runner, error) {
+			return deployer.New(dc)
+		}
+	}
+	if c.MaxConcurrentRuns <= 0 {
+		c.MaxConcurrentRuns = 1
+	}
+	if c.RunRetention <= 0 {
+		c.RunRetention = 24 * time.Hour
+	}
+	if c.MaxConcurrentRuns > 1 {
+		return nil, fmt.Errorf("%d concurrent runs requested, only one run at a time is supported", c.MaxConcurrentRuns)
+	}
+	s := &Server{
+		c:     c,
+		mux:   http.NewServeMux(),
+		slots: make(chan struct{}, c.MaxConcurrentRuns),
+		runs:  map[string]*execution{},
+	}
+	s.mux.HandleFunc("POST /workspaces/{workspace}/runs", s.submit)
+	s.mux.HandleFunc("GET /runs", s.list)
+	s.mux.HandleFunc("GET /runs/{id}", s.get)
+	s.mux.HandleFunc("GET /runs/{id}/events", s.events)
+	s.mux.HandleFunc("GET /runs/{id}/logs", s.logs)
+	s.mux.HandleFunc("POST /runs/{id}/cancel", s.cancel)
+	return s, nil
+}
+
+// BearerToken is an authentication hook that accepts the requests with the token in the Authorization header.
+func BearerToken(token string) func(r *http.Request) error {
+	return func(r *http.Request) error {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			return errors.New("invalid bearer token")
+		}
+		return nil
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.c.Authenticate != nil {
+		if err := s.c.Authenticate(r); err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// CancelAll cancels all the runs, used on shutdown.
+func (s *Server) CancelAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.runs {
+		e.cancel()
+	}
+}
+
+// Wait waits for the runs to finish, or for the context to be done.
+func (s *Server) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("runs did not finish: %w", ctx.Err())
+	}
+}
+
+// pruneLocked removes the runs that finished before the retention period, the lock must be held.
+func (s *Server) pruneLocked() {
+	cutoff := time.Now().UTC().Add(-s.c.RunRetention)
+	for id, e := range s.runs {
+		if r := e.snapshot(); r.Done() && r.FinishedAt.Before(cutoff) {
+			delete(s.runs, id)
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405Z"), hex.EncodeToString(b))
+}
+
+// openWorkspace gets the workspace, creating it when it does not exist.
+func (s *Server) openWorkspace(name string) (workspace.Workspace, error) {
+	ws, err := s.c.Workspaces.Get(name)
+	if err != nil {
+		return s.c.Workspaces.Create(name, "")
+	}
+	return ws, nil
+}
+
+// saveTFVars saves the tfvars file of the workspace, the workspace lock must be held.
+func saveTFVars(ws workspace.Workspace, tfvars string) error {
+	if tfvars != "" {
+		if err := os.WriteFile(ws.TFVarsFile(), []byte(tfvars), 0644); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(ws.TFVarsFile()); err != nil {
+		return fmt.Errorf("workspace '%s' has no tfvars file, provide it in the request", ws.Name)
+	}
+	return nil
+}
+
+func (s *Server) submit(w http.ResponseWriter, r *http.Request) {
+	var req SubmitRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if req.Operation == "" {
+		req.Operation = OperationApply
+	}
+	if req.Operation != OperationApply && req.Operation != OperationDestroy {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid operation '%s'", req.Operation))
+		return
+	}
+	name := r.PathValue("workspace")
+	if err := workspace.ValidateName(name); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ws, err := s.openWorkspace(name)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// the workspace lock is held from the submission, the tfvars file must not change during a run.
+	// It is the lock file of the workspace, shared with the CLI.
+	id := newID()
+	lock, err := ws.Lock("run " + id)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err := saveTFVars(ws, req.TFVars); err != nil {
+		_ = lock.Unlock()
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e := newExecution(id, name, req.Operation, cancel)
+	s.mu.Lock()
+	s.pruneLocked()
+	s.runs[e.run.ID] = e
+	s.running.Add(1)
+	s.mu.Unlock()
+	go s.execute(ctx, e, ws, lock, req)
+	writeJSON(w, http.StatusAccepted, e.snapshot())
+}
+
+// execute runs the operation when a slot is available and releases the workspace lock at the end.
+func (s *Server) execute(ctx context.Context, e *execution, ws workspace.Workspace, lock *workspace.Lock, req SubmitRequest) {
+	defer s.running.Done()
+	defer func() { _ = lock.Unlock() }()
+	defer e.cancel()
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		e.finish(RunCancelled, ctx.Err())
+		return
+	}
+
+	logFile, err := ws.NewLogFile()
+	if err != nil {
+		e.finish(RunFailed, fmt.Errorf("failed to create log file: %w", err))
+		return
+	}
+	defer logFile.Close()
+	e.start(logFile.Name())
+
+	runner, err := s.c.NewRunner(deployer.Config{
+		TFVarsFile:    ws.TFVarsFile(),
+		StepsFile:     ws.StepsFile(),
+		CheckoutPath:  ws.CheckoutPath(),
+		DisablePrompt: true,
+		Logger:        utils.GetFileLogger(true, logFile),
+		Out:           logFile,
+		OnEvent: func(ev deployer.Event) {
+			if ev.Type == deployer.Log {
+				fmt.Fprintln(logFile, ev.Message)
+			}
+			e.addEvent(ev)
+		},
+	})
+	if err != nil {
+		e.finish(RunFailed, err)
+		return
+	}
+
+	if req.Operation == OperationDestroy {
+		err = runner.Destroy(ctx, deployer.DestroyOptions{})
+		if err == nil {
+			err = ws.RecordDestroy()
+		}
+	} else {
+		err = runner.Apply(ctx, deployer.ApplyOptions{UpTo: req.UpTo})
+		if err == nil && req.UpTo == "" {
+			err = ws.RecordApply()
+		}
+	}
+	runner.Close()
+	switch {
+	case errors.Is(err, context.Canceled):
+		e.finish(RunCancelled, err)
+	case err != nil:
+		e.finish(RunFailed, err)
+	default:
+		e.finish(RunSucceeded, nil)
+	}
+}
+
+func (s *Server) find(w http.ResponseWriter, r *http.Request) *execution {
+	id := r.PathValue("id")
+	s.mu.Lock()
+	e, ok := s.runs[id]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("run %s not found", id))
+		return nil
+	}
+	return e
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.pruneLocked()
+	runs := []Run{}
+	for _, e := range s.runs {
+		runs = append(runs, e.snapshot())
+	}
+	s.mu.Unlock()
+	sort.Slice(runs, func(i, j int) bool { return runs[i].CreatedAt.Before(runs[j].CreatedAt) })
+	writeJSON(w, http.StatusOK, runs)
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	if e := s.find(w, r); e != nil {
+		writeJSON(w, http.StatusOK, e.snapshot())
+	}
+}
+
+func (s *Server) cancel(w http.ResponseWriter, r *http.Request) {
+	e := s.find(w, r)
+	if e == nil {
+		return
+	}
+	e.cancel()
+	writeJSON(w, http.StatusAccepted, e.snapshot())
+}
+
+func (s *Server) logs(w http.ResponseWriter, r *http.Request) {
+	e := s.find(w, r)
+	if e == nil {
+		return
+	}
+	path := e.logPath()
+	if path == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("run %s has not started", e.snapshot().ID))
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.Copy(w, f)
+}
+
+// events streams the progress events of a run as server-sent events, from the first event until the run finishes.
+// The last event, of type RUN_FINISHED, has the final state of the run.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	e := s.find(w, r)
+	if e == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	sent := 0
+	for {
+		events, done, changed := e.eventsFrom(sent)
+		for _, ev := range events {
+			writeEvent(w, string(ev.Type), ev)
+		}
+		sent += len(events)
+		if done {
+			writeEvent(w, "RUN_FINISHED", e.snapshot())
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w io.Writer, name string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+}
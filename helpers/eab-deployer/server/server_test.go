@@ -0,0 +1,262 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/deployer"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/workspace"
+)
+
+// fakeRunner emits a stage started event and waits for release or for the context to be cancelled.
+type fakeRunner struct {
+	c       deployer.Config
+	release chan error
+}
+
+func (f fakeRunner) Apply(ctx context.Context, opts deployer.ApplyOptions) error {
+	f.c.OnEvent(deployer.Event{Type: deployer.StageStarted, Stage: "1-bootstrap", Step: "gcp-bootstrap"})
+	f.c.OnEvent(deployer.Event{Type: deployer.Log, Message: "terraform apply"})
+	select {
+	case err := <-f.release:
+		if err == nil {
+			f.c.OnEvent(deployer.Event{Type: deployer.StageCompleted, Stage: "1-bootstrap", Step: "gcp-bootstrap"})
+		}
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f fakeRunner) Destroy(ctx context.Context, opts deployer.DestroyOptions) error {
+	return nil
+}
+
+func (f fakeRunner) Close() {}
+
+func newTestServer(t *testing.T, release chan error) (*httptest.Server, workspace.Manager) {
+	m := workspace.NewManager(t.TempDir())
+	s, err := New(Config{
+		Workspaces:   m,
+		Authenticate: BearerToken("secret"),
+		NewRunner: func(c deployer.Config) (Runner, error) {
+			return fakeRunner{c: c, release: release}, nil
+		},
+	})
+	assert.NoError(t, err)
+	ts := httptest.NewServer(s)
+	t.Cleanup(func() {
+		s.CancelAll()
+		ts.Close()
+	})
+	return ts, m
+}
+
+func request(t *testing.T, method, url, body string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	return resp
+}
+
+func decodeRun(t *testing.T, resp *http.Response) Run {
+	defer resp.Body.Close()
+	var r Run
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&r))
+	return r
+}
+
+func waitStatus(t *testing.T, url, status string) Run {
+	var r Run
+	assert.Eventually(t, func() bool {
+		r = decodeRun(t, request(t, http.MethodGet, url, ""))
+		return r.Status == status
+	}, 5*time.Second, 10*time.Millisecond)
+	return r
+}
+
+func TestAuthenticate(t *testing.T) {
+	ts, _ := newTestServer(t, make(chan error))
+	resp, err := http.Get(ts.URL + "/runs")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = request(t, http.MethodGet, ts.URL+"/runs", "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{Workspaces: workspace.NewManager(t.TempDir()), MaxConcurrentRuns: 2})
+	assert.ErrorContains(t, err, "only one run at a time is supported")
+}
+
+func TestSubmitInvalidWorkspace(t *testing.T) {
+	ts, m := newTestServer(t, make(chan error))
+	resp := request(t, http.MethodPost, ts.URL+"/workspaces/..%2Fescaped/runs", `{"tfvars": "org_id = \"123\"\n"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+	_, err := os.Stat(filepath.Join(filepath.Dir(m.Root), "escaped"))
+	assert.True(t, os.IsNotExist(err), "no directory should be created outside of the workspaces")
+}
+
+func TestSubmitAndStream(t *testing.T) {
+	release := make(chan error)
+	ts, m := newTestServer(t, release)
+
+	resp := request(t, http.MethodPost, ts.URL+"/workspaces/bu-retail/runs", `{}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "a new workspace requires the tfvars")
+	resp.Body.Close()
+	resp = request(t, http.MethodPost, ts.URL+"/workspaces/bu-retail/runs", `{"operation": "plan", "tfvars": "org_id = \"123\"\n"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+
+	resp = request(t, http.MethodPost, ts.URL+"/workspaces/bu-retail/runs", `{"tfvars": "org_id = \"123\"\n"}`)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	run := decodeRun(t, resp)
+	assert.Equal(t, "bu-retail", run.Workspace)
+	assert.Equal(t, OperationApply, run.Operation)
+
+	ws, err := m.Get("bu-retail")
+	assert.NoError(t, err)
+	content, err := os.ReadFile(ws.TFVarsFile())
+	assert.NoError(t, err)
+	assert.Equal(t, "org_id = \"123\"\n", string(content))
+
+	waitStatus(t, ts.URL+"/runs/"+run.ID, RunRunning)
+	resp = request(t, http.MethodPost, ts.URL+"/workspaces/bu-retail/runs", `{}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "the workspace should be locked")
+	resp.Body.Close()
+
+	stream := request(t, http.MethodGet, ts.URL+"/runs/"+run.ID+"/events", "")
+	assert.Equal(t, "text/event-stream", stream.Header.Get("Content-Type"))
+	release <- nil
+	body, err := io.ReadAll(stream.Body)
+	stream.Body.Close()
+	assert.NoError(t, err)
+	events := []string{}
+	for _, line := range strings.Split(string(body), "\n") {
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			events = append(events, name)
+		}
+	}
+	assert.Equal(t, []string{"STAGE_STARTED", "LOG", "STAGE_COMPLETED", "RUN_FINISHED"}, events)
+
+	run = waitStatus(t, ts.URL+"/runs/"+run.ID, RunSucceeded)
+	assert.False(t, run.FinishedAt.IsZero())
+	ws, err = m.Get("bu-retail")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, ws.LastApplyTFVarsHash, "successful apply should be recorded")
+
+	resp = request(t, http.MethodGet, ts.URL+"/runs/"+run.ID+"/logs", "")
+	logs, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "terraform apply\n", string(logs))
+
+	resp = request(t, http.MethodPost, ts.URL+"/workspaces/bu-retail/runs", `{}`)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode, "the lock should be released")
+	resp.Body.Close()
+}
+
+func TestCancel(t *testing.T) {
+	ts, _ := newTestServer(t, make(chan error))
+
+	first := decodeRun(t, request(t, http.MethodPost, ts.URL+"/workspaces/bu-retail/runs", `{"tfvars": "org_id = \"123\"\n"}`))
+	waitStatus(t, ts.URL+"/runs/"+first.ID, RunRunning)
+	second := decodeRun(t, request(t, http.MethodPost, ts.URL+"/workspaces/bu-finance/runs", `{"tfvars": "org_id = \"456\"\n"}`))
+	assert.Equal(t, RunQueued, second.Status, "only one run at a time by default")
+
+	resp := request(t, http.MethodPost, ts.URL+"/runs/"+second.ID+"/cancel", "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	waitStatus(t, ts.URL+"/runs/"+second.ID, RunCancelled)
+
+	resp = request(t, http.MethodPost, ts.URL+"/runs/"+first.ID+"/cancel", "")
+	resp.Body.Close()
+	run := waitStatus(t, ts.URL+"/runs/"+first.ID, RunCancelled)
+	assert.Equal(t, "context canceled", run.Error)
+
+	resp = request(t, http.MethodGet, ts.URL+"/runs/missing", "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestLockFile(t *testing.T) {
+	ts, m := newTestServer(t, make(chan error))
+	ws, err := m.Create("bu-retail", "")
+	assert.NoError(t, err)
+	lock, err := ws.Lock("process 42")
+	assert.NoError(t, err)
+
+	resp := request(t, http.MethodPost, ts.URL+"/workspaces/bu-retail/runs", `{"tfvars": "org_id = \"123\"\n"}`)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "the workspace is locked by the CLI")
+	assert.Contains(t, string(body), "workspace 'bu-retail' is locked by process 42")
+	_, err = os.Stat(ws.TFVarsFile())
+	assert.True(t, os.IsNotExist(err), "the tfvars file should not change while the workspace is locked")
+
+	assert.NoError(t, lock.Unlock())
+	run := decodeRun(t, request(t, http.MethodPost, ts.URL+"/workspaces/bu-retail/runs", `{"tfvars": "org_id = \"123\"\n"}`))
+	waitStatus(t, ts.URL+"/runs/"+run.ID, RunRunning)
+	_, err = ws.Lock("process 42")
+	assert.EqualError(t, err, "workspace 'bu-retail' is locked by run "+run.ID, "the CLI should not run while the server does")
+}
+
+func TestWaitAndRetention(t *testing.T) {
+	release := make(chan error)
+	s, err := New(Config{
+		Workspaces:   workspace.NewManager(t.TempDir()),
+		RunRetention: time.Millisecond,
+		NewRunner: func(c deployer.Config) (Runner, error) {
+			return fakeRunner{c: c, release: release}, nil
+		},
+	})
+	assert.NoError(t, err)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	run := decodeRun(t, request(t, http.MethodPost, ts.URL+"/workspaces/bu-retail/runs", `{"tfvars": "org_id = \"123\"\n"}`))
+	waitStatus(t, ts.URL+"/runs/"+run.ID, RunRunning)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorContains(t, s.Wait(ctx), "runs did not finish", "the run is still running")
+
+	release <- nil
+	assert.NoError(t, s.Wait(context.Background()))
+	time.Sleep(5 * time.Millisecond)
+	var runs []Run
+	resp := request(t, http.MethodGet, ts.URL+"/runs", "")
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&runs))
+	resp.Body.Close()
+	assert.Empty(t, runs, "the finished run should be removed after the retention period")
+}
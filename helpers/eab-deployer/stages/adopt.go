@@ -0,0 +1,250 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/backend"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
+)
+
+// Inspection is what was found of a deployment made without the helper, used to adopt it.
+// A nil field was not found, the errors of the inspection are in Errors by stage.
+type Inspection struct {
+	Bootstrap *BootstrapOutputs
+	// StateMigrated reports if the bootstrap state was migrated to the state bucket.
+	StateMigrated bool
+	// StateObjects are the objects of the state bucket.
+	StateObjects []string
+	// Repos are the checkouts of the stage repositories by repository name.
+	Repos      map[string]RepoInspection
+	AppFactory *AppFactoryOutputs
+	AppInfra   *AppInfraOutputs
+	// CustomStates are the environments of the custom stages with a state, by stage.
+	CustomStates map[string]map[string]bool
+	Errors       map[string]string
+}
+
+// RepoInspection is the checkout of a stage repository.
+type RepoInspection struct {
+	Path   string
+	Exists bool
+	// Branches are the commit SHAs of the branches that exist.
+	Branches map[string]string
+}
+
+// AdoptBranches are the branches inspected in the stage repositories: the branches of the environments,
+// production for the shared environment, plan for the infra repositories and main for the source repositories.
+func AdoptBranches(tfvars GlobalTFVars) []string {
+	branches := slices.Sorted(maps.Keys(tfvars.Envs))
+	for _, b := range []string{"main", "plan", "production"} {
+		if !slices.Contains(branches, b) {
+			branches = append(branches, b)
+		}
+	}
+	return branches
+}
+
+// InspectRepo finds the commits of the branches of a repository checkout.
+func InspectRepo(t testing.TB, path string, branches []string) (RepoInspection, error) {
+	r := RepoInspection{Path: path, Branches: map[string]string{}}
+	exists, err := utils.FileExists(path)
+	if err != nil || !exists {
+		return r, err
+	}
+	r.Exists = true
+	repo := utils.GetRepoOnly(t, path, logger.Discard)
+	for _, b := range branches {
+		sha, err := repo.GetBranchSha(b)
+		if err != nil {
+			return r, err
+		}
+		if sha != "" {
+			r.Branches[b] = sha
+		}
+	}
+	return r, nil
+}
+
+// envBranch is the branch of the code of an environment.
+func envBranch(env string) string {
+	if env == "shared" {
+		return "production"
+	}
+	return env
+}
+
+// adoption collects the steps and the gaps of a stage.
+type adoption struct {
+	steps []string
+	gaps  []string
+}
+
+func (a *adoption) gap(format string, args ...any) {
+	a.gaps = append(a.gaps, fmt.Sprintf(format, args...))
+}
+
+// repo checks that the checkout of the repository has the branches.
+func (a *adoption) repo(insp Inspection, repo string, branches ...string) {
+	r, ok := insp.Repos[repo]
+	if !ok || !r.Exists {
+		a.gap("repository %s not found in the checkout directory", repo)
+		return
+	}
+	for _, b := range branches {
+		if r.Branches[b] == "" {
+			a.gap("branch %s not found in repository %s", b, repo)
+		}
+	}
+}
+
+// state checks that the state of each environment is in the state bucket.
+func (a *adoption) state(insp Inspection, c CommonConf, dir string, envs []string) {
+	if insp.Bootstrap == nil {
+		return
+	}
+	for _, env := range envs {
+		object := path.Join(c.Backend.Prefix, "terraform", dir, env, "default.tfstate")
+		if !slices.Contains(insp.StateObjects, object) {
+			a.gap("state of environment %s not found in gs://%s/%s", env, insp.Bootstrap.StateBucket, object)
+		}
+	}
+}
+
+// infraStage checks an infra stage repository and state, and adds its nested steps.
+func (a *adoption) infraStage(insp Inspection, c CommonConf, repo, stateDir string, localSteps []string, envs []string) {
+	branches := []string{"plan"}
+	for _, env := range envs {
+		branches = append(branches, envBranch(env))
+	}
+	a.repo(insp, repo, branches...)
+	if stateDir != "" {
+		a.state(insp, c, stateDir, envs)
+	}
+	a.steps = append(a.steps, fmt.Sprintf("%s.copy-code", repo))
+	for _, l := range localSteps {
+		a.steps = append(a.steps, fmt.Sprintf("%s.%s", repo, l))
+	}
+	a.steps = append(a.steps, fmt.Sprintf("%s.plan", repo))
+	for _, env := range envs {
+		a.steps = append(a.steps, fmt.Sprintf("%s.%s", repo, env))
+	}
+}
+
+// AdoptStage finds the nested steps of a stage that was deployed without the helper, and the gaps that prevent
+// its adoption: missing outputs, repositories, branches or states.
+func AdoptStage(tfvars GlobalTFVars, c CommonConf, insp Inspection, stage string) ([]string, []string) {
+	a := &adoption{steps: []string{}, gaps: []string{}}
+	if e, ok := insp.Errors[stage]; ok {
+		a.gap("%s", e)
+	}
+	infraRepos := tfvars.InfraCloudbuildV2RepositoryConfig.Repositories
+	envs := slices.Sorted(maps.Keys(tfvars.Envs))
+	switch stage {
+	case BootstrapStep:
+		if insp.Bootstrap == nil {
+			a.gap("outputs of %s not found", stage)
+		}
+		if !insp.StateMigrated {
+			a.gap("state of %s not migrated to the state bucket, %s/backend.tf not found", stage, stage)
+		}
+		a.steps = append(a.steps, "gcp-bootstrap.migrate-state")
+	case MultitenantStep:
+		a.infraStage(insp, c, infraRepos["multitenant"].RepositoryName, "multi_tenant", nil, envs)
+	case FleetscopeStep:
+		a.infraStage(insp, c, infraRepos["fleetscope"].RepositoryName, "fleet_scope", nil, envs)
+	case AppFactoryStep:
+		a.infraStage(insp, c, infraRepos["applicationfactory"].RepositoryName, "appfactory", []string{"envs.apply-shared"}, []string{"shared"})
+		if insp.AppFactory == nil {
+			a.gap("outputs of %s not found", stage)
+		}
+	case AppInfraStep:
+		if insp.AppFactory == nil {
+			a.gap("outputs of %s are required to adopt %s", AppFactoryStep, stage)
+			break
+		}
+		if insp.AppInfra == nil {
+			a.gap("outputs of %s not found", stage)
+		}
+		for _, exampleName := range slices.Sorted(maps.Keys(tfvars.Applications)) {
+			for _, serviceName := range slices.Sorted(maps.Keys(tfvars.Applications[exampleName])) {
+				group, ok := insp.AppFactory.AppGroup[fmt.Sprintf("%s.%s", exampleName, serviceName)]
+				if !ok {
+					a.gap("application %s.%s not found in the outputs of %s", exampleName, serviceName, AppFactoryStep)
+					continue
+				}
+				serviceEnvs := []string{"shared"}
+				if len(group.AppInfraProjectIDs) > 0 {
+					serviceEnvs = append(serviceEnvs, envs...)
+				}
+				localStep := fmt.Sprintf("apps/%s/%s/envs/.apply-shared", exampleName, serviceName)
+				a.infraStage(insp, c, infraRepos[serviceName].RepositoryName, "", []string{localStep}, serviceEnvs)
+			}
+		}
+	case AppSourceStep:
+		if insp.AppInfra == nil {
+			a.gap("outputs of %s are required to adopt %s", AppInfraStep, stage)
+			break
+		}
+		repo := insp.AppInfra.ServiceRepositoryName
+		a.repo(insp, repo, "main")
+		a.steps = append(a.steps, fmt.Sprintf("%s.copy-code", repo), repo)
+	default:
+		a.gap("unknown stage '%s'", stage)
+	}
+	return a.steps, a.gaps
+}
+
+// InspectCustomStage finds the environments of a custom stage with a state in the bucket of their gcs backend.
+// list lists the objects of a bucket.
+func InspectCustomStage(tfvars GlobalTFVars, cs CustomStage, list func(bucket string) []string) (map[string]bool, error) {
+	deployed := map[string]bool{}
+	objects := map[string][]string{}
+	for _, env := range cs.StageEnvs(tfvars) {
+		bucket, object, err := backend.StateObject(cs.EnvDir(env))
+		if err != nil {
+			return deployed, err
+		}
+		if _, ok := objects[bucket]; !ok {
+			objects[bucket] = list(bucket)
+		}
+		deployed[env] = slices.Contains(objects[bucket], object)
+	}
+	return deployed, nil
+}
+
+// AdoptCustomStage finds the steps of a custom stage that was deployed without the helper, and the gaps that
+// prevent its adoption: the environments without a state.
+func AdoptCustomStage(tfvars GlobalTFVars, insp Inspection, cs CustomStage) ([]string, []string) {
+	a := &adoption{steps: []string{}, gaps: []string{}}
+	e, failed := insp.Errors[cs.Name]
+	if failed {
+		a.gap("%s", e)
+	}
+	for _, env := range cs.StageEnvs(tfvars) {
+		if !failed && !insp.CustomStates[cs.Name][env] {
+			a.gap("state of environment %s not found", env)
+		}
+		a.steps = append(a.steps, fmt.Sprintf("%s.%s", cs.Name, env))
+	}
+	return a.steps, a.gaps
+}
@@ -0,0 +1,111 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"path/filepath"
+	gotest "testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/backend"
+)
+
+// adoptTestInspection is the inspection of a complete deployment of stateTestConfig.
+func adoptTestInspection() Inspection {
+	allBranches := map[string]string{"plan": "1", "development": "2", "production": "3", "main": "4"}
+	return Inspection{
+		Bootstrap:     &BootstrapOutputs{StateBucket: "bkt-prj-seed-tf-state"},
+		StateMigrated: true,
+		StateObjects: []string{
+			"eab/terraform/bootstrap/default.tfstate",
+			"eab/terraform/multi_tenant/development/default.tfstate",
+			"eab/terraform/multi_tenant/production/default.tfstate",
+			"eab/terraform/fleet_scope/development/default.tfstate",
+			"eab/terraform/appfactory/shared/default.tfstate",
+		},
+		Repos: map[string]RepoInspection{
+			"eab-multitenant":                 {Exists: true, Branches: allBranches},
+			"eab-fleetscope":                  {Exists: true, Branches: map[string]string{"plan": "1", "development": "2"}},
+			"eab-applicationfactory":          {Exists: true, Branches: allBranches},
+			"eab-default-example-hello-world": {Exists: true, Branches: allBranches},
+		},
+		AppFactory: &AppFactoryOutputs{AppGroup: map[string]AppGroupOutput{
+			"default-example.hello-world": {AppInfraProjectIDs: map[string]string{"development": "prj-d-hello"}},
+		}},
+		AppInfra: &AppInfraOutputs{ServiceRepositoryName: "hello-world-i-r"},
+		Errors:   map[string]string{},
+	}
+}
+
+func TestAdoptStage(t *gotest.T) {
+	tfvars := stateTestConfig()
+	c := CommonConf{Backend: backend.Settings{Prefix: "eab"}}
+	insp := adoptTestInspection()
+
+	steps, gaps := AdoptStage(tfvars, c, insp, BootstrapStep)
+	assert.Empty(t, gaps)
+	assert.Equal(t, []string{"gcp-bootstrap.migrate-state"}, steps)
+
+	steps, gaps = AdoptStage(tfvars, c, insp, MultitenantStep)
+	assert.Empty(t, gaps)
+	assert.Equal(t, []string{"eab-multitenant.copy-code", "eab-multitenant.plan", "eab-multitenant.development", "eab-multitenant.production"}, steps)
+
+	_, gaps = AdoptStage(tfvars, c, insp, FleetscopeStep)
+	assert.Equal(t, []string{
+		"branch production not found in repository eab-fleetscope",
+		"state of environment production not found in gs://bkt-prj-seed-tf-state/eab/terraform/fleet_scope/production/default.tfstate",
+	}, gaps)
+
+	steps, gaps = AdoptStage(tfvars, c, insp, AppFactoryStep)
+	assert.Empty(t, gaps)
+	assert.Equal(t, []string{"eab-applicationfactory.copy-code", "eab-applicationfactory.envs.apply-shared", "eab-applicationfactory.plan", "eab-applicationfactory.shared"}, steps)
+
+	steps, gaps = AdoptStage(tfvars, c, insp, AppInfraStep)
+	assert.Empty(t, gaps)
+	assert.Equal(t, []string{
+		"eab-default-example-hello-world.copy-code",
+		"eab-default-example-hello-world.apps/default-example/hello-world/envs/.apply-shared",
+		"eab-default-example-hello-world.plan",
+		"eab-default-example-hello-world.shared",
+		"eab-default-example-hello-world.development",
+		"eab-default-example-hello-world.production",
+	}, steps)
+
+	steps, gaps = AdoptStage(tfvars, c, insp, AppSourceStep)
+	assert.Equal(t, []string{"repository hello-world-i-r not found in the checkout directory"}, gaps)
+	assert.Equal(t, []string{"hello-world-i-r.copy-code", "hello-world-i-r"}, steps)
+
+	insp.StateMigrated = false
+	insp.Bootstrap = nil
+	insp.Errors[BootstrapStep] = "terraform output failed"
+	_, gaps = AdoptStage(tfvars, c, insp, BootstrapStep)
+	assert.Equal(t, []string{
+		"terraform output failed",
+		"outputs of 1-bootstrap not found",
+		"state of 1-bootstrap not migrated to the state bucket, 1-bootstrap/backend.tf not found",
+	}, gaps)
+
+	insp.AppFactory = nil
+	_, gaps = AdoptStage(tfvars, c, insp, AppInfraStep)
+	assert.Equal(t, []string{"outputs of 4-appfactory are required to adopt 5-appinfra"}, gaps)
+}
+
+func TestInspectRepo(t *gotest.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	r, err := InspectRepo(t, missing, []string{"plan"})
+	assert.NoError(t, err)
+	assert.Equal(t, RepoInspection{Path: missing, Branches: map[string]string{}}, r)
+}
@@ -25,18 +25,23 @@ import (
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/mitchellh/go-testing-interface"
 
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/backend"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/credentials"
 	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/render"
 	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/steps"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/telemetry"
 	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
 )
 
-func DeployBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c CommonConf) error {
+// bootstrapTfvars are the inputs of the bootstrap stage.
+func bootstrapTfvars(tfvars GlobalTFVars) (BootstrapTfvars, error) {
 	var kmsProject *string
 	if tfvars.AttestationKMSKey != nil {
 		kmsInfo, err := extractInfoWithRegex(*tfvars.AttestationKMSKey, `projects/(?P<project>[^/]+)/locations/(?P<location>[^/]+)/keyRings/(?P<keyRing>[^/]+)/cryptoKeys/(?P<cryptoKey>[^/]+)`)
 		if err != nil {
 			fmt.Printf("# error extracting info for attestation KMS key. %v \n", err)
-			return err
+			return BootstrapTfvars{}, err
 		}
 
 		if len(kmsInfo) > 0 {
@@ -44,13 +49,13 @@ func DeployBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c Co
 			kmsProject = &auxProject
 		}
 	}
-	bootstrapTfvars := BootstrapTfvars{
+	return BootstrapTfvars{
 		ProjectID:                    tfvars.ProjectID,
 		BucketPrefix:                 tfvars.BucketPrefix,
 		BucketForceDestroy:           tfvars.BucketForceDestroy,
 		Location:                     tfvars.Location,
 		TriggerLocation:              tfvars.TriggerLocation,
-		TFApplyBranches:              slices.Collect(maps.Keys(tfvars.Envs)),
+		TFApplyBranches:              slices.Sorted(maps.Keys(tfvars.Envs)),
 		Envs:                         tfvars.Envs,
 		CommonFolderID:               tfvars.CommonFolderID,
 		CloudbuildV2RepositoryConfig: tfvars.InfraCloudbuildV2RepositoryConfig,
@@ -62,65 +67,110 @@ func DeployBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c Co
 		BucketKMSKey:                 tfvars.BucketKMSKey,
 		AttestationKMSProject:        kmsProject,
 		OrgID:                        tfvars.OrgID,
+	}, nil
+}
+
+// bootstrapGeneratedFiles are the files written by terraform and by the helper in the bootstrap working copy,
+// they are not replaced by the code of the blueprint.
+var bootstrapGeneratedFiles = append([]string{backend.File, backend.File + ".backup"}, generatedFiles...)
+
+// PrepareBootstrapDir creates the working copy of the bootstrap stage when it does not exist and returns its path.
+// The state and the backend of a bootstrap applied in the blueprint code, by the manual steps or by previous
+// versions of the helper, are copied to the new working copy.
+func PrepareBootstrapDir(t testing.TB, c CommonConf) (string, error) {
+	dir := c.BootstrapDir()
+	exists, err := utils.FileExists(dir)
+	if err != nil || exists {
+		return dir, err
+	}
+	src := filepath.Join(c.EABPath, BootstrapStep)
+	if err := utils.CopyDirectory(src, dir); err != nil {
+		return "", fmt.Errorf("failed to copy the code of %s: %w", BootstrapStep, err)
+	}
+	migrated, err := utils.FileExists(filepath.Join(src, backend.File))
+	if err != nil || !migrated {
+		return dir, err
 	}
+	// the state is in the bucket, the working copy is initialized to read it
+	options := &terraform.Options{
+		TerraformBinary: c.TerraformBinary,
+		TerraformDir:    dir,
+		Logger:          c.Logger,
+		NoColor:         true,
+		BackendConfig:   c.Backend.InitConfig(),
+	}
+	if err := c.Identity.SetTerraformEnv(options); err != nil {
+		return "", err
+	}
+	_, err = terraform.InitE(t, options)
+	return dir, err
+}
 
-	err := utils.WriteTfvars(filepath.Join(c.EABPath, BootstrapStep, "terraform.tfvars"), bootstrapTfvars)
+// copyBootstrapCode updates the code of the bootstrap working copy with the code of the blueprint.
+func copyBootstrapCode(t testing.TB, c CommonConf) (string, error) {
+	dir, err := PrepareBootstrapDir(t, c)
+	if err != nil {
+		return "", err
+	}
+	return dir, utils.CopyDirectoryExcept(filepath.Join(c.EABPath, BootstrapStep), dir, bootstrapGeneratedFiles...)
+}
+
+// writeBootstrapBackend generates the backend of the bootstrap working copy from its backend.tf.example, the
+// backend.tf of the blueprint code is never written.
+func writeBootstrapBackend(dir, bucket string, settings backend.Settings) error {
+	content, err := backend.Generate(filepath.Join(dir, "backend.tf.example"), bucket, settings)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, backend.File), content, 0644)
+}
+
+func DeployBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c CommonConf) error {
+	bootstrapTfvars, err := bootstrapTfvars(tfvars)
+	if err != nil {
+		return err
+	}
+	terraformDir, err := copyBootstrapCode(t, c)
+	if err != nil {
+		return err
+	}
+	err = utils.WriteTfvars(filepath.Join(terraformDir, "terraform.tfvars"), bootstrapTfvars)
 	if err != nil {
 		return err
 	}
 
-	terraformDir := filepath.Join(c.EABPath, BootstrapStep)
 	options := &terraform.Options{
+		TerraformBinary:    c.TerraformBinary,
 		TerraformDir:       terraformDir,
 		Logger:             c.Logger,
 		NoColor:            true,
+		BackendConfig:      c.Backend.InitConfig(),
 		MaxRetries:         MaxErrorRetries,
 		TimeBetweenRetries: TimeBetweenErrorRetries,
 	}
 	// terraform deploy
-	err = applyLocal(t, options, "", c.PolicyPath, c.ValidatorProject)
+	err = applyLocal(t, options, c.Identity, c.PolicyPath, BootstrapStep)
 	if err != nil {
 		return err
 	}
 
 	backendBucket := terraform.Output(t, options, "state_bucket")
 
-	// replace backend and terraform init migrate
+	// generate backend and terraform init migrate
 	err = s.RunStep("gcp-bootstrap.migrate-state", func() error {
-		options.MigrateState = true
-		err = utils.CopyFile(filepath.Join(options.TerraformDir, "backend.tf.example"), filepath.Join(options.TerraformDir, "backend.tf"))
+		err := writeBootstrapBackend(terraformDir, backendBucket, c.Backend)
 		if err != nil {
 			return err
 		}
-		err = utils.ReplaceStringInFile(filepath.Join(options.TerraformDir, "backend.tf"), "UPDATE_ME", backendBucket)
-		if err != nil {
-			return err
-		}
-		_, err := terraform.InitE(t, options)
+		options.MigrateState = true
+		_, err = terraform.InitE(t, options)
 		return err
 	})
 	if err != nil {
 		return err
 	}
 
-	// replace all backend files
-	err = s.RunStep("gcp-bootstrap.replace-backend-files", func() error {
-		files, err := utils.FindFiles(c.EABPath, "backend.tf")
-		if err != nil {
-			return err
-		}
-		for _, file := range files {
-			err = utils.ReplaceStringInFile(file, "UPDATE_ME", backendBucket)
-			if err != nil {
-				return err
-			}
-			// err = utils.ReplaceStringInFile(file, "UPDATE_PROJECTS_BACKEND", backendBucketProjects)
-			// if err != nil {
-			// 	return err
-			// }
-		}
-		return nil
-	})
+	err = DeployPoliciesRepo(t, s, tfvars, c)
 	if err != nil {
 		return err
 	}
@@ -130,14 +180,20 @@ func DeployBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c Co
 	return nil
 }
 
-func DeployMultitenantStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outputs BootstrapOutputs, c CommonConf) error {
-
+// multitenantTfvars are the inputs of the multitenant stage.
+func multitenantTfvars(tfvars GlobalTFVars) (MultiTenantTfvars, error) {
 	workerPoolInfo, err := extractInfoWithRegex(tfvars.WorkerPoolID, `projects/(?P<project>[^/]+)/locations/(?P<location>[^/]+)/workerPools/(?P<workerPool>[^/]+)`)
 	if err != nil {
 		fmt.Printf("# error extracting info for private workerpool. %v \n", err)
-		return err
+		return MultiTenantTfvars{}, err
+	}
+	if tfvars.ServicePerimeterMode == nil {
+		return MultiTenantTfvars{}, fmt.Errorf("service_perimeter_mode is required")
 	}
-	multitenantTfvars := MultiTenantTfvars{
+	if err := checkRegions(tfvars); err != nil {
+		return MultiTenantTfvars{}, err
+	}
+	return MultiTenantTfvars{
 		Envs:                         tfvars.Envs,
 		Apps:                         tfvars.Apps,
 		ServicePerimeterName:         tfvars.ServicePerimeterName,
@@ -145,8 +201,11 @@ func DeployMultitenantStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, ou
 		CBPrivateWorkerpoolProjectID: workerPoolInfo["project"],
 		AccessLevelName:              tfvars.AccessLevelName,
 		DeletionProtection:           tfvars.DeletionProtection,
-	}
-	err = utils.WriteTfvars(filepath.Join(c.EABPath, MultitenantStep, "terraform.tfvars"), multitenantTfvars)
+	}, nil
+}
+
+func DeployMultitenantStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outputs BootstrapOutputs, c CommonConf) error {
+	multitenantTfvars, err := multitenantTfvars(tfvars)
 	if err != nil {
 		return err
 	}
@@ -154,7 +213,7 @@ func DeployMultitenantStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, ou
 	repoConfig := tfvars.InfraCloudbuildV2RepositoryConfig
 	multitenantRepo := repoConfig.Repositories["multitenant"]
 	gitPath := filepath.Join(c.CheckoutPath, multitenantRepo.RepositoryName)
-	conf := utils.GitClone(t, repoConfig.RepoType, multitenantRepo.RepositoryName, multitenantRepo.RepositoryURL, gitPath, outputs.ProjectID, c.Logger)
+	conf := utils.GitClone(t, repoConfig.RepoType, multitenantRepo.RepositoryName, multitenantRepo.RepositoryURL, gitPath, outputs.ProjectID, c.Identity.GcloudEnv(), c.Logger)
 
 	stageConf := StageConf{
 		Stage:         tfvars.InfraCloudbuildV2RepositoryConfig.Repositories["multitenant"].RepositoryName,
@@ -162,21 +221,23 @@ func DeployMultitenantStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, ou
 		Step:          MultitenantStep,
 		Repo:          tfvars.InfraCloudbuildV2RepositoryConfig.Repositories["multitenant"].RepositoryName,
 		StageSA:       outputs.CBServiceAccountsEmails["multitenant"],
+		StateBucket:   outputs.StateBucket,
 		GitConf:       conf,
 		Envs:          slices.Collect(maps.Keys(tfvars.Envs)),
 		DefaultRegion: tfvars.TriggerLocation,
+		Tfvars:        map[string][]byte{"terraform.tfvars": utils.EncodeTfvars(multitenantTfvars)},
 	}
 
 	return deployStage(t, stageConf, s, c)
 }
 
-func DeployFleetscopeStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outputs BootstrapOutputs, c CommonConf) error {
-
-	fleetscopeTfvars := FleetscopeTfvars{
-		RemoteStateBucket:           outputs.StateBucket,
+// fleetscopeTfvars are the inputs of the fleetscope stage.
+func fleetscopeTfvars(tfvars GlobalTFVars, stateBucket string) FleetscopeTfvars {
+	return FleetscopeTfvars{
+		RemoteStateBucket:           stateBucket,
 		NamespaceIDs:                tfvars.NamespaceIDs,
 		ConfigSyncSecretType:        tfvars.ConfigSyncSecretType,
-		ConfigSyncRepositoryURL:     tfvars.ConfigSyncRepositoryURL,
+		ConfigSyncRepositoryURL:     configSyncRepositoryURL(tfvars),
 		DisableIstioOnNamespaces:    tfvars.DisableIstioOnNamespaces,
 		ConfigSyncPolicyDir:         tfvars.ConfigSyncPolicyDir,
 		ConfigSyncBranch:            tfvars.ConfigSyncBranch,
@@ -185,15 +246,19 @@ func DeployFleetscopeStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, out
 		EnableKueue:                 tfvars.EnableKueue,
 		EnableMulticlusterDiscovery: tfvars.EnableMulticlusterDiscovery,
 	}
-	err := utils.WriteTfvars(filepath.Join(c.EABPath, FleetscopeStep, "terraform.tfvars"), fleetscopeTfvars)
+}
+
+func DeployFleetscopeStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outputs BootstrapOutputs, c CommonConf) error {
+	repoConfig := tfvars.InfraCloudbuildV2RepositoryConfig
+	fleetscopeRepo := repoConfig.Repositories["fleetscope"]
+
+	err := deployConfigSyncRepo(t, s, tfvars, c, fmt.Sprintf("%s.config-sync-repo", fleetscopeRepo.RepositoryName))
 	if err != nil {
 		return err
 	}
 
-	repoConfig := tfvars.InfraCloudbuildV2RepositoryConfig
-	fleetscopeRepo := repoConfig.Repositories["fleetscope"]
 	gitPath := filepath.Join(c.CheckoutPath, fleetscopeRepo.RepositoryName)
-	conf := utils.GitClone(t, repoConfig.RepoType, fleetscopeRepo.RepositoryName, fleetscopeRepo.RepositoryURL, gitPath, outputs.ProjectID, c.Logger)
+	conf := utils.GitClone(t, repoConfig.RepoType, fleetscopeRepo.RepositoryName, fleetscopeRepo.RepositoryURL, gitPath, outputs.ProjectID, c.Identity.GcloudEnv(), c.Logger)
 
 	stageConf := StageConf{
 		Stage:         tfvars.InfraCloudbuildV2RepositoryConfig.Repositories["fleetscope"].RepositoryName,
@@ -201,16 +266,18 @@ func DeployFleetscopeStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, out
 		Step:          FleetscopeStep,
 		Repo:          tfvars.InfraCloudbuildV2RepositoryConfig.Repositories["fleetscope"].RepositoryName,
 		StageSA:       outputs.CBServiceAccountsEmails["fleetscope"],
+		StateBucket:   outputs.StateBucket,
 		GitConf:       conf,
 		Envs:          slices.Collect(maps.Keys(tfvars.Envs)),
 		DefaultRegion: tfvars.TriggerLocation,
+		Tfvars:        map[string][]byte{"terraform.tfvars": utils.EncodeTfvars(fleetscopeTfvars(tfvars, outputs.StateBucket))},
 	}
 
 	return deployStage(t, stageConf, s, c)
 }
 
-func DeployAppFactoryStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outputs BootstrapOutputs, c CommonConf) error {
-
+// appFactoryTfvars are the inputs of the app factory stage.
+func appFactoryTfvars(tfvars GlobalTFVars, stateBucket string) (AppFactoryTfvars, error) {
 	var kmsProject *string
 	if tfvars.BucketKMSKey != nil {
 		kmsInfo, err := extractInfoWithRegex(*tfvars.BucketKMSKey, `projects/(?P<project>[^/]+)/locations/(?P<location>[^/]+)/keyRings/(?P<keyRing>[^/]+)/cryptoKeys/(?P<cryptoKey>[^/]+)`)
@@ -222,8 +289,11 @@ func DeployAppFactoryStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, out
 			kmsProject = &auxProject
 		}
 	}
-	appFactory := AppFactoryTfvars{
-		RemoteStateBucket:            outputs.StateBucket,
+	if tfvars.ServicePerimeterMode == nil {
+		return AppFactoryTfvars{}, fmt.Errorf("service_perimeter_mode is required")
+	}
+	return AppFactoryTfvars{
+		RemoteStateBucket:            stateBucket,
 		CommonFolderID:               tfvars.CommonFolderID,
 		OrgID:                        tfvars.OrgID,
 		BillingAccount:               tfvars.BillingAccount,
@@ -232,15 +302,18 @@ func DeployAppFactoryStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, out
 		BucketForceDestroy:           tfvars.BucketForceDestroy,
 		Location:                     tfvars.Location,
 		TriggerLocation:              tfvars.TriggerLocation,
-		TFApplyBranches:              slices.Collect(maps.Keys(tfvars.Envs)),
+		TFApplyBranches:              slices.Sorted(maps.Keys(tfvars.Envs)),
 		Applications:                 tfvars.Applications,
 		CloudbuildV2RepositoryConfig: tfvars.InfraCloudbuildV2RepositoryConfig,
 		KMSProjectID:                 kmsProject,
 		ServicePerimeterName:         tfvars.ServicePerimeterName,
 		ServicePerimeterMode:         *tfvars.ServicePerimeterMode,
 		InfraProjectAPIs:             tfvars.InfraProjectAPIs,
-	}
-	err := utils.WriteTfvars(filepath.Join(c.EABPath, AppFactoryStep, "terraform.tfvars"), appFactory)
+	}, nil
+}
+
+func DeployAppFactoryStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outputs BootstrapOutputs, c CommonConf) error {
+	appFactory, err := appFactoryTfvars(tfvars, outputs.StateBucket)
 	if err != nil {
 		return err
 	}
@@ -248,11 +321,12 @@ func DeployAppFactoryStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, out
 	repoConfig := tfvars.InfraCloudbuildV2RepositoryConfig
 	appFactoryRepo := repoConfig.Repositories["applicationfactory"]
 	gitPath := filepath.Join(c.CheckoutPath, appFactoryRepo.RepositoryName)
-	conf := utils.GitClone(t, repoConfig.RepoType, appFactoryRepo.RepositoryName, appFactoryRepo.RepositoryURL, gitPath, outputs.ProjectID, c.Logger)
+	conf := utils.GitClone(t, repoConfig.RepoType, appFactoryRepo.RepositoryName, appFactoryRepo.RepositoryURL, gitPath, outputs.ProjectID, c.Identity.GcloudEnv(), c.Logger)
 
 	stageConf := StageConf{
 		Stage:         tfvars.InfraCloudbuildV2RepositoryConfig.Repositories["applicationfactory"].RepositoryName,
 		StageSA:       outputs.CBServiceAccountsEmails["applicationfactory"],
+		StateBucket:   outputs.StateBucket,
 		CICDProject:   outputs.ProjectID,
 		Step:          AppFactoryStep,
 		Repo:          tfvars.InfraCloudbuildV2RepositoryConfig.Repositories["applicationfactory"].RepositoryName,
@@ -262,18 +336,18 @@ func DeployAppFactoryStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, out
 		Envs:          []string{"shared"},
 		GroupingUnits: []string{"envs"},
 		DefaultRegion: tfvars.TriggerLocation,
+		Tfvars:        map[string][]byte{"terraform.tfvars": utils.EncodeTfvars(appFactory)},
 	}
 	return deployStage(t, stageConf, s, c)
 }
 
-func DeployAppInfraStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, bootstrapOutputs BootstrapOutputs, outputs AppFactoryOutputs, c CommonConf) error {
-	//for each environment
-
-	appInfraTfvars := AppInfraTfvars{
+// appInfraTfvars are the inputs of the app infra stage, the same for all the services.
+func appInfraTfvars(tfvars GlobalTFVars, stateBucket string) AppInfraTfvars {
+	return AppInfraTfvars{
 		Region:                       tfvars.Region,
 		BucketsForceDestroy:          tfvars.BucketForceDestroy,
-		RemoteStateBucket:            bootstrapOutputs.StateBucket,
-		EnvironmentNames:             slices.Collect(maps.Keys(tfvars.Envs)),
+		RemoteStateBucket:            stateBucket,
+		EnvironmentNames:             slices.Sorted(maps.Keys(tfvars.Envs)),
 		CloudbuildV2RepositoryConfig: tfvars.AppServicesCloudbuildV2RepositoryConfig,
 		AccessLevelName:              tfvars.AccessLevelName,
 		LoggingBucket:                tfvars.LoggingBucket,
@@ -281,6 +355,11 @@ func DeployAppInfraStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, boots
 		AttestationKMSKey:            tfvars.AttestationKMSKey,
 		BucketPrefix:                 tfvars.BucketPrefix,
 	}
+}
+
+func DeployAppInfraStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, bootstrapOutputs BootstrapOutputs, outputs AppFactoryOutputs, c CommonConf) error {
+	//for each environment
+	appInfraTfvars := appInfraTfvars(tfvars, bootstrapOutputs.StateBucket)
 
 	var err error
 	for exampleName, services := range tfvars.Applications {
@@ -291,27 +370,18 @@ func DeployAppInfraStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, boots
 				envs = append(envs, slices.Collect(maps.Keys(tfvars.Envs))...)
 			}
 
-			err := utils.WriteTfvars(filepath.Join(c.EABPath, AppInfraStep, "apps", exampleName, serviceName, "envs", "shared", "terraform.tfvars"), appInfraTfvars)
-			if err != nil {
-				return err
-			}
-
-			for _, env := range envs {
-				err = utils.ReplaceStringInFile(filepath.Join(c.EABPath, AppInfraStep, "apps", exampleName, serviceName, "envs", env, "backend.tf"), "UPDATE_INFRA_REPO_STATE", strings.SplitAfter(outputs.AppGroup[appGroupIndex].AppCloudbuildWorkspaceStateBucketName, "https://www.googleapis.com/storage/v1/b/")[1])
-				if err != nil {
-					return err
-				}
-			}
+			stateBucket := strings.SplitAfter(outputs.AppGroup[appGroupIndex].AppCloudbuildWorkspaceStateBucketName, "https://www.googleapis.com/storage/v1/b/")[1]
 
 			repoConfig := tfvars.InfraCloudbuildV2RepositoryConfig
 			serviceRepo := repoConfig.Repositories[serviceName]
 			gitPath := filepath.Join(c.CheckoutPath, serviceRepo.RepositoryName)
-			conf := utils.GitClone(t, repoConfig.RepoType, serviceRepo.RepositoryName, serviceRepo.RepositoryURL, gitPath, outputs.AppGroup[appGroupIndex].AppAdminProjectID, c.Logger)
+			conf := utils.GitClone(t, repoConfig.RepoType, serviceRepo.RepositoryName, serviceRepo.RepositoryURL, gitPath, outputs.AppGroup[appGroupIndex].AppAdminProjectID, c.Identity.GcloudEnv(), c.Logger)
 
 			serviceAccountID := strings.Split(outputs.AppGroup[appGroupIndex].AppCloudbuildWorkspaceCloudbuildSAEmail, "/")
 			stageConf := StageConf{
 				Stage:         tfvars.InfraCloudbuildV2RepositoryConfig.Repositories[serviceName].RepositoryName,
 				StageSA:       serviceAccountID[len(serviceAccountID)-1],
+				StateBucket:   stateBucket,
 				CICDProject:   outputs.AppGroup[appGroupIndex].AppAdminProjectID,
 				Step:          AppInfraStep,
 				Repo:          tfvars.InfraCloudbuildV2RepositoryConfig.Repositories[serviceName].RepositoryName,
@@ -321,6 +391,7 @@ func DeployAppInfraStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, boots
 				GroupingUnits: []string{fmt.Sprintf("apps/%s/%s/envs/", exampleName, serviceName)},
 				Envs:          envs,
 				DefaultRegion: tfvars.TriggerLocation,
+				Tfvars:        map[string][]byte{fmt.Sprintf("apps/%s/%s/envs/shared/terraform.tfvars", exampleName, serviceName): utils.EncodeTfvars(appInfraTfvars)},
 			}
 
 			err = deployStage(t, stageConf, s, c)
@@ -338,15 +409,16 @@ func DeployAppSourceStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outp
 	for _, repository := range tfvars.AppServicesCloudbuildV2RepositoryConfig.Repositories {
 
 		gitPath := filepath.Join(c.CheckoutPath, outputs.ServiceRepositoryName)
-		conf := utils.GitClone(t, tfvars.AppServicesCloudbuildV2RepositoryConfig.RepoType, repository.RepositoryName, repository.RepositoryURL, gitPath, outputs.ServiceRepositoryProjectID, c.Logger)
+		conf := utils.GitClone(t, tfvars.AppServicesCloudbuildV2RepositoryConfig.RepoType, repository.RepositoryName, repository.RepositoryURL, gitPath, outputs.ServiceRepositoryProjectID, c.Identity.GcloudEnv(), c.Logger)
 
+		// the CI trigger and the delivery pipeline of the service are created by 5-appinfra in region
 		stageConf := StageConf{
 			Stage:         outputs.ServiceRepositoryName,
 			CICDProject:   outputs.ServiceRepositoryProjectID,
 			Step:          filepath.Join(AppSourceStep, "hello-world"),
 			Repo:          outputs.ServiceRepositoryName,
 			GitConf:       conf,
-			DefaultRegion: tfvars.TriggerLocation,
+			DefaultRegion: tfvars.Region,
 			Envs:          slices.Collect(maps.Keys(tfvars.Envs)),
 			SkipPlan:      true,
 		}
@@ -367,7 +439,7 @@ func deployStage(t testing.TB, sc StageConf, s steps.Steps, c CommonConf) error
 	}
 
 	err = s.RunStep(fmt.Sprintf("%s.copy-code", sc.Stage), func() error {
-		return copyStepCode(t, sc.GitConf, c.EABPath, c.CheckoutPath, sc.Repo, sc.Step, sc.CustomTargetDirPath, sc.Envs)
+		return copyStepCode(t, sc, c)
 	})
 	if err != nil {
 		return err
@@ -381,15 +453,17 @@ func deployStage(t testing.TB, sc StageConf, s steps.Steps, c CommonConf) error
 	for _, bu := range groupunit {
 		for _, localStep := range sc.LocalSteps {
 			buOptions := &terraform.Options{
+				TerraformBinary:    c.TerraformBinary,
 				TerraformDir:       filepath.Join(filepath.Join(c.CheckoutPath, sc.Repo), bu, localStep),
 				Logger:             c.Logger,
 				NoColor:            true,
+				BackendConfig:      c.Backend.InitConfig(),
 				MaxRetries:         MaxErrorRetries,
 				TimeBetweenRetries: TimeBetweenErrorRetries,
 			}
 
 			err := s.RunStep(fmt.Sprintf("%s.%s.apply-%s", sc.Stage, bu, localStep), func() error {
-				return applyLocal(t, buOptions, sc.StageSA, c.PolicyPath, c.ValidatorProject)
+				return applyLocal(t, buOptions, c.Identity.Impersonate(sc.StageSA), c.PolicyPath, sc.Step)
 			})
 			if err != nil {
 				return err
@@ -397,20 +471,20 @@ func deployStage(t testing.TB, sc StageConf, s steps.Steps, c CommonConf) error
 		}
 	}
 
-	err = s.RunStep(fmt.Sprintf("%s.plan", sc.Stage), func() error {
-		return planStage(t, sc.GitConf, sc.CICDProject, sc.DefaultRegion, sc.Repo)
+	err = pipelineStep(t, s, c, fmt.Sprintf("%s.plan", sc.Stage), func() (steps.Push, error) {
+		return pushPlan(sc.GitConf, sc.CICDProject, sc.DefaultRegion, sc.Repo)
 	})
 	if err != nil {
 		return err
 	}
 
 	for _, env := range sc.Envs {
-		err = s.RunStep(fmt.Sprintf("%s.%s", sc.Stage, env), func() error {
+		err = pipelineStep(t, s, c, fmt.Sprintf("%s.%s", sc.Stage, env), func() (steps.Push, error) {
 			aEnv := env
 			if env == "shared" {
 				aEnv = "production"
 			}
-			return applyEnv(t, sc.GitConf, sc.CICDProject, sc.DefaultRegion, sc.Repo, aEnv)
+			return pushEnv(sc.GitConf, sc.CICDProject, sc.DefaultRegion, sc.Repo, aEnv)
 		})
 		if err != nil {
 			return err
@@ -435,8 +509,8 @@ func deployApp(t testing.TB, sc StageConf, s steps.Steps, c CommonConf) error {
 		return err
 	}
 
-	err = s.RunStep(sc.Stage, func() error {
-		return deployEnvApp(t, sc.GitConf, sc.CICDProject, sc.DefaultRegion, sc.Repo, "hello-world", sc.Envs)
+	err = pipelineStep(t, s, c, sc.Stage, func() (steps.Push, error) {
+		return pushApp(sc.GitConf, sc.CICDProject, sc.DefaultRegion, sc.Repo, "hello-world")
 	})
 	if err != nil {
 		return err
@@ -446,22 +520,6 @@ func deployApp(t testing.TB, sc StageConf, s steps.Steps, c CommonConf) error {
 	return nil
 }
 
-func preparePoliciesRepo(policiesConf utils.GitRepo, policiesBranch, EABPath, gcpPoliciesPath string) error {
-	err := policiesConf.CheckoutBranch(policiesBranch)
-	if err != nil {
-		return err
-	}
-	err = utils.CopyDirectory(filepath.Join(EABPath, "policy-library"), gcpPoliciesPath)
-	if err != nil {
-		return err
-	}
-	err = policiesConf.CommitFiles("Initialize policy library repo")
-	if err != nil {
-		return err
-	}
-	return policiesConf.PushBranch(policiesBranch, "origin")
-}
-
 func copyAppSourceCode(t testing.TB, conf utils.GitRepo, EABPath, checkoutPath, repo, step, customPath string) error {
 	gcpPath := filepath.Join(checkoutPath, repo)
 	targetDir := gcpPath
@@ -472,225 +530,197 @@ func copyAppSourceCode(t testing.TB, conf utils.GitRepo, EABPath, checkoutPath,
 	return err
 }
 
-func copyStepCode(t testing.TB, conf utils.GitRepo, EABPath, checkoutPath, repo, step, customPath string, environmentNames []string) error {
-	gcpPath := filepath.Join(checkoutPath, repo)
-	targetDir := gcpPath
-	fmt.Println(targetDir)
-	if customPath != "" {
-		targetDir = filepath.Join(gcpPath, customPath)
-	}
-	err := utils.CopyDirectory(filepath.Join(EABPath, step), targetDir)
-	if err != nil {
-		return err
-	}
-	err = utils.CopyFile(filepath.Join(EABPath, "build/cloudbuild-tf-apply.yaml"), filepath.Join(gcpPath, "cloudbuild-tf-apply.yaml"))
-	if err != nil {
-		return err
-	}
-	err = utils.CopyFile(filepath.Join(EABPath, "build/cloudbuild-tf-plan.yaml"), filepath.Join(gcpPath, "cloudbuild-tf-plan.yaml"))
-	if err != nil {
-		return err
-	}
+// envsRegex is the regex of the environments in the upstream tf-wrapper.sh, replaced with the environments of the deployment.
+const envsRegex = "^(development|nonproduction|production|shared)$"
 
-	fileName := filepath.Join(gcpPath, ".gitignore")
-	content := `### https://raw.github.com/github/gitignore/90f149de451a5433aebd94d02d11b0e28843a1af/Terraform.gitignore
-# Local .terraform directories
-*.terraform*
-**/.terraform/*
-
-# .tfstate files
-*.tfstate
-*.tfstate.*
-# tf lock file
-.terraform.lock.hcl
-`
-	file, err := os.Create(fileName)
-	if err != nil {
-		fmt.Printf("Error creating file: %v\n", err)
+// stepCodeRenderer renders the stage code, the Cloud Build files, the built-in files and the user overlays of the repository.
+func stepCodeRenderer(EABPath, step, repo, customPath string, overlays map[string]string) render.Renderer {
+	sources := []render.Source{
+		{Name: step, FS: os.DirFS(filepath.Join(EABPath, step)), Target: customPath},
+		{Name: "build", FS: os.DirFS(filepath.Join(EABPath, "build")), Files: []string{"cloudbuild-tf-apply.yaml", "cloudbuild-tf-plan.yaml", "tf-wrapper.sh"}},
+		render.Builtin(),
 	}
-	// Ensure the file is closed when the function exits.
-	// This is crucial for releasing resources and flushing any buffered data.
-	defer file.Close()
-
-	// Write the string content to the file.
-	// file.WriteString returns the number of bytes written and an error.
-	_, err = file.WriteString(content)
-	if err != nil {
-		return err
+	for _, key := range []string{"*", repo} {
+		if dir, ok := overlays[key]; ok {
+			sources = append(sources, render.Overlay(dir))
+		}
 	}
-	err = utils.CopyFile(filepath.Join(EABPath, "build/tf-wrapper.sh"), filepath.Join(gcpPath, "tf-wrapper.sh"))
-	if err != nil {
-		return err
+	return render.Renderer{
+		Sources: sources,
+		Rewrites: []render.Rewrite{
+			{File: "tf-wrapper.sh", Old: envsRegex, New: `^({{ join .Envs "|" }})$`},
+		},
 	}
+}
 
-	oldValue := "^(development|nonproduction|production|shared)$"
-	newValue := fmt.Sprintf("^(%s)$", strings.Join(environmentNames, "|"))
-	err = utils.ReplaceStringInFile(filepath.Join(gcpPath, "tf-wrapper.sh"), oldValue, newValue)
-	if err != nil {
-		return err
-	}
-	s, err := os.Stat(filepath.Join(gcpPath, "tf-wrapper.sh"))
-	if err != nil {
-		return err
+func copyStepCode(t testing.TB, sc StageConf, c CommonConf) error {
+	gcpPath := filepath.Join(c.CheckoutPath, sc.Repo)
+	r := stepCodeRenderer(c.EABPath, sc.Step, sc.Repo, sc.CustomTargetDirPath, c.TemplateOverlays)
+	if sc.StateBucket != "" {
+		// the backend files of the blueprint are replaced by the generated ones
+		files, err := backend.GenerateDir(filepath.Join(c.EABPath, sc.Step), sc.StateBucket, c.Backend)
+		if err != nil {
+			return err
+		}
+		r.Sources = slices.Insert(r.Sources, 1, render.Source{Name: "backend", Target: sc.CustomTargetDirPath, Content: files})
 	}
-	permissions := s.Mode().Perm()
-	newPermissions := permissions | 0111
-	err = os.Chmod(filepath.Join(gcpPath, "tf-wrapper.sh"), newPermissions)
-	if err != nil {
-		return err
+	if len(sc.Tfvars) > 0 {
+		r.Sources = slices.Insert(r.Sources, 1, render.Source{Name: "tfvars", Target: sc.CustomTargetDirPath, Content: sc.Tfvars})
 	}
-
-	return nil
-}
-
-func planStage(t testing.TB, conf utils.GitRepo, project, region, repo string) error {
-
-	err := conf.CommitFiles(fmt.Sprintf("Initialize %s repo", repo))
-	if err != nil {
-		return err
+	ctx := render.Context{
+		Repo:           sc.Repo,
+		Step:           sc.Step,
+		Envs:           slices.Sorted(slices.Values(sc.Envs)),
+		Region:         sc.DefaultRegion,
+		CICDProject:    sc.CICDProject,
+		ServiceAccount: sc.StageSA,
+		StateBucket:    sc.StateBucket,
+		Values:         c.TemplateValues,
 	}
-	err = conf.PushBranch("plan", "origin")
+	m, err := r.Render(ctx, gcpPath)
 	if err != nil {
 		return err
 	}
+	c.Logger.Logf(t, "Rendered %d files in %s", len(m.Files), gcpPath)
 
-	commitSha, err := conf.GetCommitSha()
+	s, err := os.Stat(filepath.Join(gcpPath, "tf-wrapper.sh"))
 	if err != nil {
 		return err
 	}
-
-	return gcp.NewGCP().WaitBuildSuccess(t, project, region, repo, commitSha, fmt.Sprintf("Terraform %s plan build Failed.", repo), MaxBuildRetries, MaxErrorRetries, TimeBetweenErrorRetries)
+	return os.Chmod(filepath.Join(gcpPath, "tf-wrapper.sh"), s.Mode().Perm()|0111)
 }
 
-func saveBootstrapCodeOnly(t testing.TB, sc StageConf, s steps.Steps, c CommonConf) error {
-
-	err := sc.GitConf.CheckoutBranch("plan")
-	if err != nil {
-		return err
-	}
-
-	err = s.RunStep(fmt.Sprintf("%s.copy-code", sc.Stage), func() error {
-		return copyStepCode(t, sc.GitConf, c.EABPath, c.CheckoutPath, sc.Repo, sc.Step, sc.CustomTargetDirPath, sc.Envs)
-	})
-	if err != nil {
-		return err
+// pipelineStep runs a step applied by a pipeline: push pushes the code of the step and the step waits for the
+// build of the commit. In push only mode the commit is recorded in the step and the step does not wait.
+func pipelineStep(t testing.TB, s steps.Steps, c CommonConf, step string, push func() (steps.Push, error)) error {
+	t.Logf("Running step %s as %s", step, c.Identity)
+	if c.PushOnly {
+		return s.RunPushStep(step, push)
 	}
-
-	err = s.RunStep(fmt.Sprintf("%s.plan", sc.Stage), func() error {
-		err := sc.GitConf.CommitFiles(fmt.Sprintf("Initialize %s repo", sc.Repo))
+	return s.RunStep(step, func() error {
+		p, err := push()
 		if err != nil {
 			return err
 		}
-		return sc.GitConf.PushBranch("plan", "origin")
+		return waitPush(t, c.Identity, p)
 	})
+}
 
-	if err != nil {
-		return err
-	}
-
-	for _, env := range sc.Envs {
-		err = s.RunStep(fmt.Sprintf("%s.%s", sc.Stage, env), func() error {
-			aEnv := env
-			if env == "shared" {
-				aEnv = "production"
-			}
-			err := sc.GitConf.CheckoutBranch(aEnv)
-			if err != nil {
-				return err
-			}
-			return sc.GitConf.PushBranch(aEnv, "origin")
-		})
+// waitPush waits, as the identity, for the build of a pushed commit, and for the rollouts of its release when it
+// has a service.
+func waitPush(t testing.TB, id credentials.Identity, p steps.Push) error {
+	t.Logf("Waiting for the build of %s branch %s commit %s as %s", p.Repo, p.Branch, p.Commit, id)
+	g := gcp.NewGCPWithIdentity(id)
+	switch {
+	case p.Service != "":
+		commit, err := p.ShortCommit()
 		if err != nil {
 			return err
 		}
+		err = g.WaitBuildSuccess(t, p.Project, p.Region, p.Repo, p.Commit, fmt.Sprintf("Build %s env %s build Failed.", p.Repo, p.Service), MaxBuildRetries, MaxErrorRetries, TimeBetweenErrorRetries)
+		if err != nil {
+			return err
+		}
+		return g.WaitReleaseSuccess(t, p.Project, p.Region, p.Service, commit, fmt.Sprintf("Deploy %s env %s build Failed.", p.Repo, p.Service), MaxBuildRetries)
+	case p.Branch == "plan":
+		return g.WaitBuildSuccess(t, p.Project, p.Region, p.Repo, p.Commit, fmt.Sprintf("Terraform %s plan build Failed.", p.Repo), MaxBuildRetries, MaxErrorRetries, TimeBetweenErrorRetries)
 	}
-
-	fmt.Println("end of", sc.Step, "deploy")
-	return nil
+	return g.WaitBuildSuccess(t, p.Project, p.Region, p.Repo, p.Commit, fmt.Sprintf("Terraform %s apply %s build Failed.", p.Repo, p.Branch), MaxBuildRetries, MaxErrorRetries, TimeBetweenErrorRetries)
 }
 
-func deployEnvApp(t testing.TB, conf utils.GitRepo, project, region, repo, service string, envs []string) error {
-	var err error
+// pushed is the push of the current commit of a branch.
+func pushed(conf utils.GitRepo, project, region, repo, branch string) (steps.Push, error) {
+	commitSha, err := conf.GetCommitSha()
+	if err != nil {
+		return steps.Push{}, err
+	}
+	return steps.Push{Project: project, Region: region, Repo: repo, Branch: branch, Commit: commitSha}, nil
+}
 
-	err = conf.CommitFiles(fmt.Sprintf("Initialize %s repo", repo))
+func pushPlan(conf utils.GitRepo, project, region, repo string) (steps.Push, error) {
+	err := conf.CommitFiles(fmt.Sprintf("Initialize %s repo", repo))
 	if err != nil {
-		return err
+		return steps.Push{}, err
 	}
-	err = conf.PushBranch("main", "origin")
+	err = conf.PushBranch("plan", "origin")
 	if err != nil {
-		return err
+		return steps.Push{}, err
 	}
+	return pushed(conf, project, region, repo, "plan")
+}
 
-	commitSha, err := conf.GetCommitSha()
+func pushApp(conf utils.GitRepo, project, region, repo, service string) (steps.Push, error) {
+	err := conf.CommitFiles(fmt.Sprintf("Initialize %s repo", repo))
 	if err != nil {
-		return err
+		return steps.Push{}, err
 	}
-
-	err = gcp.NewGCP().WaitBuildSuccess(t, project, region, repo, commitSha, fmt.Sprintf("Build %s env %s build Failed.", repo, service), MaxBuildRetries, MaxErrorRetries, TimeBetweenErrorRetries)
+	err = conf.PushBranch("main", "origin")
 	if err != nil {
-		return err
+		return steps.Push{}, err
 	}
-
-	err = gcp.NewGCP().WaitReleaseSuccess(t, project, region, service, commitSha[0:7], fmt.Sprintf("Deploy %s env %s build Failed.", repo, service), MaxBuildRetries)
-
-	return err
+	p, err := pushed(conf, project, region, repo, "main")
+	p.Service = service
+	return p, err
 }
 
-func applyEnv(t testing.TB, conf utils.GitRepo, project, region, repo, environment string) error {
+func pushEnv(conf utils.GitRepo, project, region, repo, environment string) (steps.Push, error) {
 	err := conf.CheckoutBranch(environment)
 	if err != nil {
-		return err
+		return steps.Push{}, err
 	}
 	err = conf.PushBranch(environment, "origin")
 	if err != nil {
-		return err
+		return steps.Push{}, err
 	}
-	commitSha, err := conf.GetCommitSha()
-	if err != nil {
-		return err
-	}
-
-	return gcp.NewGCP().WaitBuildSuccess(t, project, region, repo, commitSha, fmt.Sprintf("Terraform %s apply %s build Failed.", repo, environment), MaxBuildRetries, MaxErrorRetries, TimeBetweenErrorRetries)
+	return pushed(conf, project, region, repo, environment)
 }
 
-func applyLocal(t testing.TB, options *terraform.Options, serviceAccount, policyPath, validatorProjectId string) error {
-	var err error
-
-	if serviceAccount != "" {
-		t.Logf("Setting GOOGLE_IMPERSONATE_SERVICE_ACCOUNT as %s", serviceAccount)
-		err = os.Setenv("GOOGLE_IMPERSONATE_SERVICE_ACCOUNT", serviceAccount)
-		if err != nil {
-			return err
-		}
+// applyLocal applies a terraform directory with the identity, the options get the environment of the identity.
+func applyLocal(t testing.TB, options *terraform.Options, id credentials.Identity, policyPath, stage string) error {
+	err := id.SetTerraformEnv(options)
+	if err != nil {
+		return err
 	}
+	t.Logf("Running terraform in %s as %s", options.TerraformDir, id)
 
-	_, err = terraform.InitE(t, options)
+	err = runTerraform(t, stage, "init", options, func() (string, error) { return terraform.InitE(t, options) })
 	if err != nil {
 		return err
 	}
-	_, err = terraform.PlanE(t, options)
+
+	// the plan is saved so that the plan checked against the policies is the plan applied
+	planDir, err := os.MkdirTemp("", "tf-plan-")
 	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(planDir)
+	planOptions := *options
+	planOptions.PlanFilePath = filepath.Join(planDir, "plan.tfplan")
 
-	// Runs gcloud terraform vet
-	if validatorProjectId != "" {
-		err = TerraformVet(t, options.TerraformDir, policyPath, validatorProjectId)
-		if err != nil {
-			return err
-		}
+	err = runTerraform(t, stage, "plan", &planOptions, func() (string, error) { return terraform.PlanE(t, &planOptions) })
+	if err != nil {
+		return err
 	}
 
-	_, err = terraform.ApplyE(t, options)
+	// Runs terraform vet when the policy library is available
+	hasPolicies, err := utils.FileExists(policyPath)
 	if err != nil {
 		return err
 	}
-
-	if serviceAccount != "" {
-		err = os.Unsetenv("GOOGLE_IMPERSONATE_SERVICE_ACCOUNT")
+	if hasPolicies {
+		err = TerraformVet(t, &planOptions, policyPath, stage)
 		if err != nil {
 			return err
 		}
 	}
-	return nil
+
+	return runTerraform(t, stage, "apply", &planOptions, func() (string, error) { return terraform.ApplyE(t, &planOptions) })
+}
+
+// runTerraform runs a terraform command of a stage in a span.
+func runTerraform(t testing.TB, stage, command string, options *terraform.Options, f func() (string, error)) error {
+	return telemetry.Terraform(telemetry.TraceOf(t), command, stage, options.TerraformDir, func() error {
+		_, err := f()
+		return err
+	})
 }
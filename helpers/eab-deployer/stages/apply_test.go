@@ -0,0 +1,150 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"os"
+	"path/filepath"
+	gotest "testing"
+
+	"github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/backend"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/render"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
+)
+
+func TestCopyStepCode(t *gotest.T) {
+	eabPath := t.TempDir()
+	// the upstream build files must have the values replaced by the helper
+	assert.NoError(t, utils.CopyDirectory(filepath.Join("..", "..", "..", "build"), filepath.Join(eabPath, "build")))
+	stepDir := filepath.Join(eabPath, FleetscopeStep, "envs", "production")
+	assert.NoError(t, os.MkdirAll(stepDir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(stepDir, "backend.tf"), []byte(`bucket = "bkt-state"`), 0644))
+
+	overlay := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(overlay, "CODEOWNERS.tmpl"), []byte("* @{{ .Values.owners }}\n"), 0644))
+
+	c := CommonConf{
+		EABPath:          eabPath,
+		CheckoutPath:     t.TempDir(),
+		TemplateOverlays: map[string]string{"eab-fleetscope": overlay},
+		TemplateValues:   map[string]string{"owners": "platform-team"},
+	}
+	sc := StageConf{
+		Repo:   "eab-fleetscope",
+		Step:   FleetscopeStep,
+		Envs:   []string{"production", "development"},
+		Tfvars: map[string][]byte{"terraform.tfvars": []byte("remote_state_bucket = \"bkt-state\"\n")},
+	}
+	err := copyStepCode(&testing.RuntimeT{}, sc, c)
+	assert.NoError(t, err)
+
+	gcpPath := filepath.Join(c.CheckoutPath, sc.Repo)
+	content, err := os.ReadFile(filepath.Join(gcpPath, "terraform.tfvars"))
+	assert.NoError(t, err)
+	assert.Equal(t, "remote_state_bucket = \"bkt-state\"\n", string(content))
+	assert.NoFileExists(t, filepath.Join(eabPath, FleetscopeStep, "terraform.tfvars"), "the tfvars are only written in the repository")
+	for _, f := range []string{"envs/production/backend.tf", "cloudbuild-tf-apply.yaml", "cloudbuild-tf-plan.yaml", ".gitignore", render.ManifestFile} {
+		assert.FileExists(t, filepath.Join(gcpPath, f))
+	}
+	content, err = os.ReadFile(filepath.Join(gcpPath, "CODEOWNERS"))
+	assert.NoError(t, err)
+	assert.Equal(t, "* @platform-team\n", string(content))
+	content, err = os.ReadFile(filepath.Join(gcpPath, "tf-wrapper.sh"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "^(development|production)$")
+	assert.NotContains(t, string(content), envsRegex)
+	info, err := os.Stat(filepath.Join(gcpPath, "tf-wrapper.sh"))
+	assert.NoError(t, err)
+	assert.NotZero(t, info.Mode().Perm()&0111, "tf-wrapper.sh should be executable")
+
+	assert.NoError(t, os.WriteFile(filepath.Join(stepDir, "backend.tf"), []byte(`bucket = "UPDATE_ME"`), 0644))
+	err = copyStepCode(&testing.RuntimeT{}, sc, c)
+	assert.ErrorContains(t, err, "envs/production/backend.tf: UPDATE_ME")
+
+	// the backend files are generated when the state bucket is known
+	blueprintBackend := "terraform {\n  backend \"gcs\" {\n    bucket = \"UPDATE_ME\"\n    prefix = \"terraform/fleetscope/production\"\n  }\n}\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(stepDir, "backend.tf"), []byte(blueprintBackend), 0644))
+	sc.StateBucket = "bkt-prj-seed-tf-state"
+	c.Backend = backend.Settings{Prefix: "bu-retail"}
+	err = copyStepCode(&testing.RuntimeT{}, sc, c)
+	assert.NoError(t, err)
+	content, err = os.ReadFile(filepath.Join(gcpPath, "envs/production/backend.tf"))
+	assert.NoError(t, err)
+	assert.Equal(t, "terraform {\n  backend \"gcs\" {\n    bucket = \"bkt-prj-seed-tf-state\"\n    prefix = \"bu-retail/terraform/fleetscope/production\"\n  }\n}\n", string(content))
+	content, err = os.ReadFile(filepath.Join(stepDir, "backend.tf"))
+	assert.NoError(t, err)
+	assert.Equal(t, blueprintBackend, string(content), "blueprint code should not change")
+}
+
+func TestPrepareBootstrapDir(t *gotest.T) {
+	eabPath := t.TempDir()
+	src := filepath.Join(eabPath, BootstrapStep)
+	assert.NoError(t, os.MkdirAll(src, 0755))
+	// a bootstrap applied in the blueprint code before the working copies
+	for f, content := range map[string]string{"main.tf": "# v1", "terraform.tfvars": `project_id = "prj-seed"`, "terraform.tfstate": "{}"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(src, f), []byte(content), 0644))
+	}
+
+	c := CommonConf{EABPath: eabPath, CheckoutPath: t.TempDir()}
+	dir, err := PrepareBootstrapDir(&testing.RuntimeT{}, c)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(c.CheckoutPath, StagesDir, BootstrapStep), dir)
+	for _, f := range []string{"main.tf", "terraform.tfvars", "terraform.tfstate"} {
+		assert.FileExists(t, filepath.Join(dir, f), "the state of the blueprint code is copied to the new working copy")
+	}
+
+	// the code is updated and the files of the deployment are kept
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "main.tf"), []byte("# v2"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "terraform.tfstate"), []byte(`{"serial": 1}`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "terraform.tfstate"), []byte(`{"serial": 7}`), 0644))
+	dir, err = copyBootstrapCode(&testing.RuntimeT{}, c)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(filepath.Join(dir, "main.tf"))
+	assert.NoError(t, err)
+	assert.Equal(t, "# v2", string(content))
+	content, err = os.ReadFile(filepath.Join(dir, "terraform.tfstate"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"serial": 7}`, string(content))
+
+	// another deployment has its own working copy
+	other := CommonConf{EABPath: eabPath, CheckoutPath: t.TempDir()}
+	assert.NotEqual(t, c.BootstrapDir(), other.BootstrapDir())
+}
+
+func TestWriteBootstrapBackend(t *gotest.T) {
+	eabPath := t.TempDir()
+	src := filepath.Join(eabPath, BootstrapStep)
+	assert.NoError(t, os.MkdirAll(src, 0755))
+	example := "terraform {\n  backend \"gcs\" {\n    bucket = \"UPDATE_ME\"\n    prefix = \"terraform/bootstrap\"\n  }\n}\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "backend.tf.example"), []byte(example), 0644))
+
+	c := CommonConf{EABPath: eabPath, CheckoutPath: t.TempDir()}
+	dir, err := copyBootstrapCode(&testing.RuntimeT{}, c)
+	assert.NoError(t, err)
+	assert.NoError(t, writeBootstrapBackend(dir, "bkt-prj-seed-tf-state", backend.Settings{Prefix: "bu-retail"}))
+
+	content, err := os.ReadFile(filepath.Join(dir, backend.File))
+	assert.NoError(t, err)
+	assert.Equal(t, "terraform {\n  backend \"gcs\" {\n    bucket = \"bkt-prj-seed-tf-state\"\n    prefix = \"bu-retail/terraform/bootstrap\"\n  }\n}\n", string(content))
+	assert.NoFileExists(t, filepath.Join(src, backend.File), "blueprint code should not change")
+
+	// the generated backend is kept when the code of the working copy is updated
+	_, err = copyBootstrapCode(&testing.RuntimeT{}, c)
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, backend.File))
+}
@@ -0,0 +1,171 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/steps"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/test/integration/testutils"
+)
+
+// defaultCustomTerraformDir is the directory of the environments in the repository of a custom stage.
+const defaultCustomTerraformDir = "envs"
+
+// CustomStage is a stage defined by the user. The helper applies the terraform directory of each environment
+// of the stage with a nested step named after the stage and the environment.
+type CustomStage struct {
+	Name string `hcl:"name,label"`
+	// After is the stage executed before the custom stage, the custom stage is the last one when empty.
+	After string `hcl:"after,optional"`
+	// DependsOn are the stages used by the custom stage, it is applied again when one of them is applied again.
+	DependsOn []string `hcl:"depends_on,optional"`
+	// Repository is the path of the repository with the code of the stage.
+	Repository string `hcl:"repository"`
+	// TerraformDir is the directory of the repository with a directory for each environment, defaults to envs.
+	TerraformDir string `hcl:"terraform_dir,optional"`
+	// Envs are the environments of the stage in apply order, defaults to the environments of the deployment.
+	Envs []string `hcl:"envs,optional"`
+	// ServiceAccount, if set, is impersonated by the terraform commands of the stage.
+	ServiceAccount string `hcl:"service_account,optional"`
+}
+
+// StageEnvs are the environments of the custom stage.
+func (cs CustomStage) StageEnvs(tfvars GlobalTFVars) []string {
+	if len(cs.Envs) > 0 {
+		return cs.Envs
+	}
+	return slices.Sorted(maps.Keys(tfvars.Envs))
+}
+
+// Dir is the terraform directory of the stage with the environments.
+func (cs CustomStage) Dir() string {
+	if cs.TerraformDir == "" {
+		return filepath.Join(cs.Repository, defaultCustomTerraformDir)
+	}
+	return filepath.Join(cs.Repository, cs.TerraformDir)
+}
+
+// EnvDir is the terraform directory of an environment of the stage.
+func (cs CustomStage) EnvDir(env string) string {
+	return filepath.Join(cs.Dir(), env)
+}
+
+func (cs CustomStage) options(env string, c CommonConf) *terraform.Options {
+	return &terraform.Options{
+		TerraformBinary:          c.TerraformBinary,
+		TerraformDir:             cs.EnvDir(env),
+		Logger:                   c.Logger,
+		NoColor:                  true,
+		BackendConfig:            c.Backend.InitConfig(),
+		RetryableTerraformErrors: testutils.RetryableTransientErrors,
+		MaxRetries:               MaxErrorRetries,
+		TimeBetweenRetries:       TimeBetweenErrorRetries,
+	}
+}
+
+// DeployCustomStage applies the environments of a custom stage in order.
+func DeployCustomStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, cs CustomStage, c CommonConf) error {
+	for _, env := range cs.StageEnvs(tfvars) {
+		err := s.RunStep(fmt.Sprintf("%s.%s", cs.Name, env), func() error {
+			return applyLocal(t, cs.options(env, c), c.Identity.Impersonate(cs.ServiceAccount), c.PolicyPath, cs.Name)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println("end of", cs.Name, "deploy")
+	return nil
+}
+
+// DestroyCustomStage destroys the environments of a custom stage in reverse order.
+func DestroyCustomStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, cs CustomStage, c CommonConf) error {
+	envs := slices.Clone(cs.StageEnvs(tfvars))
+	slices.Reverse(envs)
+	for _, env := range envs {
+		err := s.RunDestroyStep(fmt.Sprintf("%s.%s", cs.Name, env), func() error {
+			return destroyEnv(t, cs.options(env, c), c.Identity.Impersonate(cs.ServiceAccount), cs.Name)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println("end of", cs.Name, "destroy")
+	return nil
+}
+
+// CustomStageInputs are the checksums of the inputs of a custom stage: its environments, the code of its
+// terraform directory and the backend settings. The hidden files, like the .terraform directories, and the
+// generated files are not part of the code.
+func CustomStageInputs(tfvars GlobalTFVars, c CommonConf, cs CustomStage) (map[string]string, error) {
+	code, err := customStageCode(cs.Dir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the code of stage %s: %w", cs.Name, err)
+	}
+	inputs := map[string]string{InputCode: code}
+	if inputs[InputEnvs], err = jsonChecksum(cs.StageEnvs(tfvars)); err != nil {
+		return nil, err
+	}
+	if inputs[InputBackend], err = jsonChecksum(c.Backend); err != nil {
+		return nil, err
+	}
+	return inputs, nil
+}
+
+// customStageCode is the checksum of the files of a directory.
+func customStageCode(dir string) (string, error) {
+	h := sha256.New()
+	err := fs.WalkDir(os.DirFS(dir), ".", func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := e.Name()
+		if p != "." && strings.HasPrefix(name, ".") {
+			if e.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if e.IsDir() || slices.ContainsFunc(generatedFiles, func(pattern string) bool {
+			ok, _ := path.Match(pattern, name)
+			return ok
+		}) {
+			return nil
+		}
+		content, err := os.ReadFile(filepath.Join(dir, p))
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		fmt.Fprintf(h, "%s\x00%s\n", p, hex.EncodeToString(sum[:]))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"os"
+	"path/filepath"
+	gotest "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCustomStage(t *gotest.T) {
+	cs := CustomStage{Name: "3a-inhouse", Repository: "/repos/inhouse"}
+	assert.Equal(t, []string{"development", "production"}, cs.StageEnvs(stateTestConfig()))
+	assert.Equal(t, filepath.Join("/repos/inhouse", "envs", "production"), cs.EnvDir("production"))
+
+	cs.TerraformDir = "terraform"
+	cs.Envs = []string{"production", "development"}
+	assert.Equal(t, []string{"production", "development"}, cs.StageEnvs(stateTestConfig()), "the environments keep their order")
+	assert.Equal(t, filepath.Join("/repos/inhouse", "terraform", "development"), cs.EnvDir("development"))
+}
+
+func TestCustomStageInputs(t *gotest.T) {
+	repo := t.TempDir()
+	for _, f := range []string{"envs/development/main.tf", "envs/development/terraform.tfstate", "envs/development/.terraform/providers/google", "README.md"} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(repo, f)), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(repo, f), []byte(f), 0644))
+	}
+	cs := CustomStage{Name: "3a-inhouse", Repository: repo}
+	tfvars := stateTestConfig()
+
+	inputs, err := CustomStageInputs(tfvars, CommonConf{}, cs)
+	assert.NoError(t, err)
+	assert.Len(t, inputs, 3)
+
+	for _, f := range []string{"envs/development/terraform.tfstate", "envs/development/.terraform/providers/google", "README.md"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(repo, f), []byte("changed"), 0644))
+	}
+	same, err := CustomStageInputs(tfvars, CommonConf{}, cs)
+	assert.NoError(t, err)
+	assert.Equal(t, inputs, same, "only the code of the terraform directory is an input")
+
+	assert.NoError(t, os.WriteFile(filepath.Join(repo, "envs/development/main.tf"), []byte("changed"), 0644))
+	cs.Envs = []string{"development"}
+	changed, err := CustomStageInputs(tfvars, CommonConf{}, cs)
+	assert.NoError(t, err)
+	assert.NotEqual(t, inputs[InputCode], changed[InputCode])
+	assert.NotEqual(t, inputs[InputEnvs], changed[InputEnvs])
+	assert.Equal(t, inputs[InputBackend], changed[InputBackend])
+
+	_, err = CustomStageInputs(tfvars, CommonConf{}, CustomStage{Name: "missing", Repository: filepath.Join(repo, "missing")})
+	assert.ErrorContains(t, err, "failed to read the code of stage missing")
+}
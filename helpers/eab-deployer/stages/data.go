@@ -26,6 +26,8 @@ import (
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/mitchellh/go-testing-interface"
 
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/backend"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/credentials"
 	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
 )
 
@@ -41,20 +43,42 @@ const (
 	MaxErrorRetries         = 2
 	TimeBetweenErrorRetries = 2 * time.Minute
 	MaxBuildRetries         = 60
+	// StagesDir is the directory, in the checkout path of the deployment, with the working copies of the stages
+	// applied locally.
+	StagesDir = ".eab-stages"
 )
 
 type CommonConf struct {
-	EABPath          string
-	CheckoutPath     string
-	PolicyPath       string
-	ValidatorProject string
-	DisablePrompt    bool
-	Logger           *logger.Logger
+	EABPath       string
+	CheckoutPath  string
+	PolicyPath    string
+	DisablePrompt bool
+	Logger        *logger.Logger
+	// TemplateOverlays are the overlay directories rendered in the repositories, by repository name or "*" for all.
+	TemplateOverlays map[string]string
+	// TemplateValues are the user defined values available to the templates.
+	TemplateValues map[string]string
+	Backend        backend.Settings
+	// TerraformBinary is the terraform binary used by the stages, defaults to terraform in the PATH.
+	TerraformBinary string
+	// Identity is the base identity of the terraform and gcloud commands, the commands of a stage impersonate
+	// its service account with it.
+	Identity credentials.Identity
+	// PushOnly pushes the code of the steps applied by pipelines and records the commits without waiting for
+	// their builds.
+	PushOnly bool
+}
+
+// BootstrapDir is the working copy of the bootstrap stage of the deployment, with its tfvars, its local state
+// and its generated backend. The code of the blueprint is not modified.
+func (c CommonConf) BootstrapDir() string {
+	return filepath.Join(c.CheckoutPath, StagesDir, BootstrapStep)
 }
 
 type StageConf struct {
 	Stage               string
 	StageSA             string
+	StateBucket         string
 	CICDProject         string
 	DefaultRegion       string
 	Step                string
@@ -66,6 +90,9 @@ type StageConf struct {
 	Envs                []string
 	LocalSteps          []string
 	SkipPlan            bool
+	// Tfvars are the generated tfvars files of the stage by path in the repository, they are rendered with the
+	// code of the stage.
+	Tfvars map[string][]byte
 }
 
 type BootstrapOutputs struct {
@@ -152,6 +179,48 @@ type GlobalTFVars struct {
 	Region                                  string                                   `hcl:"region"`
 	EABCodePath                             string                                   `hcl:"eab_code_path"`
 	CodeCheckoutPath                        string                                   `hcl:"code_checkout_path"`
+	TemplateOverlays                        map[string]string                        `hcl:"template_overlays,optional"`
+	TemplateValues                          map[string]string                        `hcl:"template_values,optional"`
+	BackendPrefix                           *string                                  `hcl:"backend_prefix,optional"`
+	BackendKMSEncryptionKey                 *string                                  `hcl:"backend_kms_encryption_key,optional"`
+	BackendImpersonateServiceAccount        *string                                  `hcl:"backend_impersonate_service_account,optional"`
+	TerraformBinary                         *string                                  `hcl:"terraform_binary,optional"`
+	PoliciesRepository                      *Repository                              `hcl:"policies_repository,optional"`
+	ConfigSyncRepository                    *Repository                              `hcl:"config_sync_repository,optional"`
+	PolicyLibraryPath                       *string                                  `hcl:"policy_library_path,optional"`
+	Regions                                 []string                                 `hcl:"regions,optional"`
+	ConnectionLocation                      *string                                  `hcl:"connection_location,optional"`
+}
+
+// CloudBuildConnectionLocation is the region of the Cloud Build connections of the repositories.
+func (g GlobalTFVars) CloudBuildConnectionLocation() string {
+	if g.ConnectionLocation != nil && *g.ConnectionLocation != "" {
+		return *g.ConnectionLocation
+	}
+	return defaultConnectionLocation
+}
+
+// PolicyLibrary is the directory of the policy library, the policy-library directory of the EAB code by default.
+func (g GlobalTFVars) PolicyLibrary() string {
+	if g.PolicyLibraryPath != nil && *g.PolicyLibraryPath != "" {
+		return *g.PolicyLibraryPath
+	}
+	return filepath.Join(g.EABCodePath, "policy-library")
+}
+
+// BackendSettings are the backend settings of the deployment.
+func (g GlobalTFVars) BackendSettings() backend.Settings {
+	s := backend.Settings{}
+	if g.BackendPrefix != nil {
+		s.Prefix = *g.BackendPrefix
+	}
+	if g.BackendKMSEncryptionKey != nil {
+		s.KMSEncryptionKey = *g.BackendKMSEncryptionKey
+	}
+	if g.BackendImpersonateServiceAccount != nil {
+		s.ImpersonateServiceAccount = *g.BackendImpersonateServiceAccount
+	}
+	return s
 }
 
 type Env struct {
@@ -280,14 +349,19 @@ type AppInfraTfvars struct {
 	AttestationKMSKey            *string                      `hcl:"attestation_kms_key"`
 }
 
-func GetBootstrapStepOutputs(t testing.TB, eabPath string) BootstrapOutputs {
+// GetBootstrapStepOutputs reads the outputs of the bootstrap stage from its working copy, see CommonConf.BootstrapDir.
+func GetBootstrapStepOutputs(t testing.TB, bootstrapDir, terraformBinary string, id credentials.Identity) BootstrapOutputs {
 	options := &terraform.Options{
-		TerraformDir:       filepath.Join(eabPath, "1-bootstrap"),
+		TerraformBinary:    terraformBinary,
+		TerraformDir:       bootstrapDir,
 		Logger:             logger.Discard,
 		NoColor:            true,
 		MaxRetries:         MaxErrorRetries,
 		TimeBetweenRetries: TimeBetweenErrorRetries,
 	}
+	if err := id.SetTerraformEnv(options); err != nil {
+		t.Fatal(err)
+	}
 	return BootstrapOutputs{
 		ProjectID:                       terraform.Output(t, options, "project_id"),
 		StateBucket:                     terraform.Output(t, options, "state_bucket"),
@@ -304,11 +378,15 @@ func GetBootstrapStepOutputs(t testing.TB, eabPath string) BootstrapOutputs {
 	}
 }
 
-func GetAppInfraStepOutputs(t testing.TB, eabPath string) AppInfraOutputs {
+func GetAppInfraStepOutputs(t testing.TB, eabPath, terraformBinary string, id credentials.Identity) AppInfraOutputs {
 	options := &terraform.Options{
-		TerraformDir: filepath.Join(eabPath, "apps/default-example/hello-world/envs/shared"),
-		Logger:       logger.Discard,
-		NoColor:      true,
+		TerraformBinary: terraformBinary,
+		TerraformDir:    filepath.Join(eabPath, "apps/default-example/hello-world/envs/shared"),
+		Logger:          logger.Discard,
+		NoColor:         true,
+	}
+	if err := id.SetTerraformEnv(options); err != nil {
+		t.Fatal(err)
 	}
 	terraform.Init(t, options)
 	t.Logf("Getting outputs from %s", options.TerraformDir)
@@ -443,11 +521,15 @@ func convertToAppFactoryOutputs(input map[string]interface{}) (AppFactoryOutputs
 	return outputs, nil
 }
 
-func GetAppFactoryStepOutputs(t testing.TB, eabPath string) AppFactoryOutputs {
+func GetAppFactoryStepOutputs(t testing.TB, eabPath, terraformBinary string, id credentials.Identity) AppFactoryOutputs {
 	options := &terraform.Options{
-		TerraformDir: filepath.Join(eabPath, "envs/shared"),
-		Logger:       logger.Discard,
-		NoColor:      true,
+		TerraformBinary: terraformBinary,
+		TerraformDir:    filepath.Join(eabPath, "envs/shared"),
+		Logger:          logger.Discard,
+		NoColor:         true,
+	}
+	if err := id.SetTerraformEnv(options); err != nil {
+		t.Fatal(err)
 	}
 
 	output, err := convertToAppFactoryOutputs(terraform.OutputAll(t, options))
@@ -25,6 +25,7 @@ import (
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/mitchellh/go-testing-interface"
 
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/credentials"
 	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/steps"
 	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
 	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/test/integration/testutils"
@@ -32,7 +33,11 @@ import (
 
 func DestroyBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c CommonConf) error {
 
-	if err := forceBackendMigration(t, filepath.Join(c.EABPath, BootstrapStep), c); err != nil {
+	dir, err := PrepareBootstrapDir(t, c)
+	if err != nil {
+		return err
+	}
+	if err := forceBackendMigration(t, dir, c); err != nil {
 		return err
 	}
 
@@ -45,7 +50,8 @@ func DestroyBootstrapStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c C
 }
 
 // forceBackendMigration removes backend.tf file to force migration of the
-// terraform state from GCS to the local directory.
+// terraform state from GCS to the local directory. The backend.tf file is backed up
+// in backend.tf.backup, it is generated again in the next deploy.
 // Before changing the backend we ensure it is has been initialized.
 func forceBackendMigration(t testing.TB, tfDir string, c CommonConf) error {
 	backendF := filepath.Join(tfDir, "backend.tf")
@@ -53,12 +59,17 @@ func forceBackendMigration(t testing.TB, tfDir string, c CommonConf) error {
 	exist, _ := utils.FileExists(backendF)
 
 	options := &terraform.Options{
+		TerraformBinary:    c.TerraformBinary,
 		TerraformDir:       tfDir,
 		Logger:             c.Logger,
 		NoColor:            true,
+		BackendConfig:      c.Backend.InitConfig(),
 		MaxRetries:         MaxErrorRetries,
 		TimeBetweenRetries: TimeBetweenErrorRetries,
 	}
+	if err := c.Identity.SetTerraformEnv(options); err != nil {
+		return err
+	}
 	if exist {
 		_, err := terraform.InitE(t, options)
 		if err != nil {
@@ -150,11 +161,13 @@ func DestroyAppInfraStage(t testing.TB, s steps.Steps, tfvars GlobalTFVars, outp
 func destroyStage(t testing.TB, sc StageConf, s steps.Steps, tfvars GlobalTFVars, c CommonConf) error {
 	for _, e := range sc.Envs {
 		err := s.RunDestroyStep(fmt.Sprintf("%s.%s", sc.Repo, e), func() error {
-			for _, g := range sc.GroupingUnits {
+			for _, dir := range sc.TerraformDirs(c, e) {
 				options := &terraform.Options{
-					TerraformDir:             filepath.Join(c.CheckoutPath, sc.Repo, g, e),
+					TerraformBinary:          c.TerraformBinary,
+					TerraformDir:             dir,
 					Logger:                   c.Logger,
 					NoColor:                  true,
+					BackendConfig:            c.Backend.InitConfig(),
 					RetryableTerraformErrors: testutils.RetryableTransientErrors,
 					MaxRetries:               MaxErrorRetries,
 					TimeBetweenRetries:       TimeBetweenErrorRetries,
@@ -189,7 +202,7 @@ func destroyStage(t testing.TB, sc StageConf, s steps.Steps, tfvars GlobalTFVars
 				}
 
 				// The 'terraform destroy' is executed like original.
-				err = destroyEnv(t, options, sc.StageSA)
+				err = destroyEnv(t, options, c.Identity.Impersonate(sc.StageSA), sc.Step)
 				if err != nil {
 					return err
 				}
@@ -204,31 +217,17 @@ func destroyStage(t testing.TB, sc StageConf, s steps.Steps, tfvars GlobalTFVars
 	return nil
 }
 
-func destroyEnv(t testing.TB, options *terraform.Options, serviceAccount string) error {
-	var err error
-
-	if serviceAccount != "" {
-		t.Log(fmt.Sprintf("Setting GOOGLE_IMPERSONATE_SERVICE_ACCOUNT=%s", serviceAccount))
-		err = os.Setenv("GOOGLE_IMPERSONATE_SERVICE_ACCOUNT", serviceAccount)
-		if err != nil {
-			return err
-		}
-	}
-
-	_, err = terraform.InitE(t, options)
+// destroyEnv destroys a terraform directory with the identity.
+func destroyEnv(t testing.TB, options *terraform.Options, id credentials.Identity, stage string) error {
+	err := id.SetTerraformEnv(options)
 	if err != nil {
 		return err
 	}
-	_, err = terraform.DestroyE(t, options)
+	t.Logf("Running terraform in %s as %s", options.TerraformDir, id)
+
+	err = runTerraform(t, stage, "init", options, func() (string, error) { return terraform.InitE(t, options) })
 	if err != nil {
 		return err
 	}
-
-	if serviceAccount != "" {
-		err = os.Unsetenv("GOOGLE_IMPERSONATE_SERVICE_ACCOUNT")
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return runTerraform(t, stage, "destroy", options, func() (string, error) { return terraform.DestroyE(t, options) })
 }
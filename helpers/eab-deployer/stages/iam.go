@@ -0,0 +1,422 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+)
+
+// Scope is the kind of resource where an identity needs permissions.
+type Scope string
+
+const (
+	ScopeOrganization          Scope = "organization"
+	ScopeCommonFolder          Scope = "common-folder"
+	ScopeEnvFolder             Scope = "env-folder"
+	ScopeSeedProject           Scope = "seed-project"
+	ScopeNetworkProject        Scope = "network-project"
+	ScopeKMSProject            Scope = "kms-project"
+	ScopeAttestationKMSProject Scope = "attestation-kms-project"
+	ScopeWorkerPoolProject     Scope = "worker-pool-project"
+	ScopeSecretProject         Scope = "secret-project"
+
+	projectIAMAdmin = "roles/resourcemanager.projectIamAdmin"
+	// maxTestedPermissions is the maximum number of permissions in a testIamPermissions request
+	maxTestedPermissions = 100
+)
+
+// PermissionRequirement is a set of permissions an identity needs on the resources of a scope,
+// Role is the predefined role suggested to grant them.
+type PermissionRequirement struct {
+	Scope       Scope
+	Role        string
+	Permissions []string
+	// When restricts the requirement to some configurations, a nil When always applies.
+	When func(g GlobalTFVars) bool
+}
+
+// MissingPermissions are the permissions an identity is missing on a resource.
+type MissingPermissions struct {
+	Stage       string
+	Member      string
+	Resource    string
+	Role        string
+	Permissions []string
+}
+
+// PermissionTester tests which of the permissions an identity has on a resource.
+// An empty service account tests the permissions of the caller.
+type PermissionTester interface {
+	TestPermissions(t testing.TB, serviceAccount, resource string, permissions []string) ([]string, error)
+}
+
+var (
+	bucketKMSKeyNotProvided = func(g GlobalTFVars) bool { return g.BucketKMSKey == nil }
+	bucketKMSKeyProvided    = func(g GlobalTFVars) bool { return g.BucketKMSKey != nil }
+
+	projectIAMAdminPermissions = []string{"resourcemanager.projects.getIamPolicy", "resourcemanager.projects.setIamPolicy"}
+
+	// stageServiceAccounts maps the stages to the keys of the cb_service_accounts_emails output of 1-bootstrap
+	stageServiceAccounts = map[string]string{
+		MultitenantStep: "multitenant",
+		FleetscopeStep:  "fleetscope",
+		AppFactoryStep:  "applicationfactory",
+	}
+
+	// permissionRequirements are the permissions needed by each stage. The requirements of 1-bootstrap
+	// apply to the identity running the helper, the others to the Cloud Build service account of the stage.
+	permissionRequirements = map[string][]PermissionRequirement{
+		BootstrapStep: {
+			{Scope: ScopeOrganization, Role: "roles/accesscontextmanager.policyAdmin", Permissions: []string{"accesscontextmanager.policies.get", "accesscontextmanager.accessLevels.update", "accesscontextmanager.servicePerimeters.update"}},
+			{Scope: ScopeOrganization, Role: "roles/resourcemanager.organizationAdmin", Permissions: []string{"resourcemanager.organizations.getIamPolicy", "resourcemanager.organizations.setIamPolicy"}},
+			{Scope: ScopeCommonFolder, Role: "roles/resourcemanager.folderAdmin", Permissions: []string{"resourcemanager.folders.getIamPolicy", "resourcemanager.folders.setIamPolicy"}},
+			{Scope: ScopeCommonFolder, Role: "roles/resourcemanager.projectCreator", Permissions: []string{"resourcemanager.projects.create"}},
+			{Scope: ScopeEnvFolder, Role: "roles/resourcemanager.folderAdmin", Permissions: []string{"resourcemanager.folders.getIamPolicy", "resourcemanager.folders.setIamPolicy"}},
+			{Scope: ScopeEnvFolder, Role: "roles/compute.xpnAdmin", Permissions: []string{"compute.organizations.enableXpnResource"}},
+			{Scope: ScopeSeedProject, Role: "roles/cloudbuild.connectionAdmin", Permissions: []string{"cloudbuild.connections.create", "cloudbuild.connections.get", "cloudbuild.repositories.create"}},
+			{Scope: ScopeSeedProject, Role: "roles/compute.networkAdmin", Permissions: []string{"compute.networks.get", "compute.subnetworks.get"}},
+			{Scope: ScopeSeedProject, Role: projectIAMAdmin, Permissions: projectIAMAdminPermissions},
+			{Scope: ScopeSecretProject, Role: "roles/secretmanager.admin", Permissions: []string{"secretmanager.secrets.getIamPolicy", "secretmanager.secrets.setIamPolicy", "secretmanager.versions.access"}},
+			{Scope: ScopeKMSProject, Role: projectIAMAdmin, Permissions: projectIAMAdminPermissions, When: bucketKMSKeyProvided},
+			{Scope: ScopeAttestationKMSProject, Role: projectIAMAdmin, Permissions: projectIAMAdminPermissions},
+			{Scope: ScopeWorkerPoolProject, Role: "roles/cloudbuild.workerPoolUser", Permissions: []string{"cloudbuild.workerpools.use"}},
+			{Scope: ScopeWorkerPoolProject, Role: projectIAMAdmin, Permissions: projectIAMAdminPermissions},
+		},
+		MultitenantStep: {
+			{Scope: ScopeOrganization, Role: "roles/accesscontextmanager.policyAdmin", Permissions: []string{"accesscontextmanager.servicePerimeters.update"}},
+			{Scope: ScopeOrganization, Role: "roles/compute.xpnAdmin", Permissions: []string{"compute.organizations.enableXpnResource"}},
+			{Scope: ScopeEnvFolder, Role: "roles/resourcemanager.projectCreator", Permissions: []string{"resourcemanager.projects.create"}},
+			{Scope: ScopeEnvFolder, Role: "roles/gkehub.admin", Permissions: []string{"gkehub.memberships.create", "gkehub.features.update"}},
+			{Scope: ScopeEnvFolder, Role: projectIAMAdmin, Permissions: projectIAMAdminPermissions},
+			{Scope: ScopeEnvFolder, Role: "roles/iam.serviceAccountAdmin", Permissions: []string{"iam.serviceAccounts.create"}},
+			{Scope: ScopeNetworkProject, Role: projectIAMAdmin, Permissions: projectIAMAdminPermissions},
+			{Scope: ScopeNetworkProject, Role: "roles/compute.networkUser", Permissions: []string{"compute.subnetworks.use"}},
+			{Scope: ScopeSeedProject, Role: "roles/viewer", Permissions: []string{"resourcemanager.projects.get"}},
+			{Scope: ScopeKMSProject, Role: "roles/cloudkms.cryptoKeyEncrypterDecrypter", Permissions: []string{"cloudkms.cryptoKeyVersions.useToEncrypt", "cloudkms.cryptoKeyVersions.useToDecrypt"}, When: bucketKMSKeyNotProvided},
+			{Scope: ScopeWorkerPoolProject, Role: "roles/cloudbuild.workerPoolUser", Permissions: []string{"cloudbuild.workerpools.use"}},
+		},
+		FleetscopeStep: {
+			{Scope: ScopeOrganization, Role: "roles/accesscontextmanager.policyAdmin", Permissions: []string{"accesscontextmanager.servicePerimeters.update"}},
+			{Scope: ScopeEnvFolder, Role: "roles/gkehub.admin", Permissions: []string{"gkehub.features.update", "gkehub.scopes.create"}},
+			{Scope: ScopeEnvFolder, Role: projectIAMAdmin, Permissions: projectIAMAdminPermissions},
+			{Scope: ScopeEnvFolder, Role: "roles/iam.serviceAccountAdmin", Permissions: []string{"iam.serviceAccounts.create"}},
+			{Scope: ScopeEnvFolder, Role: "roles/source.admin", Permissions: []string{"source.repos.create"}},
+			{Scope: ScopeEnvFolder, Role: "roles/artifactregistry.admin", Permissions: []string{"artifactregistry.repositories.create"}},
+			{Scope: ScopeEnvFolder, Role: "roles/containeranalysis.admin", Permissions: []string{"containeranalysis.notes.create"}},
+			{Scope: ScopeEnvFolder, Role: "roles/binaryauthorization.attestorsAdmin", Permissions: []string{"binaryauthorization.attestors.create"}},
+			{Scope: ScopeEnvFolder, Role: "roles/binaryauthorization.policyAdmin", Permissions: []string{"binaryauthorization.policy.update"}},
+			{Scope: ScopeNetworkProject, Role: projectIAMAdmin, Permissions: projectIAMAdminPermissions},
+			{Scope: ScopeSeedProject, Role: "roles/viewer", Permissions: []string{"resourcemanager.projects.get"}},
+			{Scope: ScopeKMSProject, Role: "roles/cloudkms.cryptoKeyEncrypterDecrypter", Permissions: []string{"cloudkms.cryptoKeyVersions.useToEncrypt", "cloudkms.cryptoKeyVersions.useToDecrypt"}, When: bucketKMSKeyNotProvided},
+			{Scope: ScopeAttestationKMSProject, Role: "roles/cloudkms.signerVerifier", Permissions: []string{"cloudkms.cryptoKeyVersions.useToSign"}},
+			{Scope: ScopeWorkerPoolProject, Role: "roles/cloudbuild.workerPoolUser", Permissions: []string{"cloudbuild.workerpools.use"}},
+		},
+		AppFactoryStep: {
+			{Scope: ScopeOrganization, Role: "roles/resourcemanager.organizationAdmin", Permissions: []string{"resourcemanager.organizations.getIamPolicy", "resourcemanager.organizations.setIamPolicy"}},
+			{Scope: ScopeOrganization, Role: "roles/resourcemanager.folderViewer", Permissions: []string{"resourcemanager.folders.get", "resourcemanager.folders.list"}},
+			{Scope: ScopeOrganization, Role: "roles/compute.xpnAdmin", Permissions: []string{"compute.organizations.enableXpnResource"}},
+			{Scope: ScopeCommonFolder, Role: "roles/resourcemanager.folderCreator", Permissions: []string{"resourcemanager.folders.create"}},
+			{Scope: ScopeCommonFolder, Role: "roles/resourcemanager.folderEditor", Permissions: []string{"resourcemanager.folders.update"}},
+			{Scope: ScopeCommonFolder, Role: "roles/resourcemanager.projectCreator", Permissions: []string{"resourcemanager.projects.create"}},
+			{Scope: ScopeEnvFolder, Role: "roles/resourcemanager.projectCreator", Permissions: []string{"resourcemanager.projects.create"}},
+			{Scope: ScopeEnvFolder, Role: projectIAMAdmin, Permissions: projectIAMAdminPermissions},
+			{Scope: ScopeEnvFolder, Role: "roles/iam.serviceAccountAdmin", Permissions: []string{"iam.serviceAccounts.create"}},
+			{Scope: ScopeEnvFolder, Role: "roles/source.admin", Permissions: []string{"source.repos.create"}},
+			{Scope: ScopeEnvFolder, Role: "roles/artifactregistry.admin", Permissions: []string{"artifactregistry.repositories.create"}},
+			{Scope: ScopeNetworkProject, Role: projectIAMAdmin, Permissions: projectIAMAdminPermissions},
+			{Scope: ScopeSeedProject, Role: "roles/viewer", Permissions: []string{"resourcemanager.projects.get"}},
+			{Scope: ScopeSecretProject, Role: "roles/secretmanager.admin", Permissions: []string{"secretmanager.secrets.create", "secretmanager.secrets.setIamPolicy"}},
+			{Scope: ScopeKMSProject, Role: "roles/cloudkms.admin", Permissions: []string{"cloudkms.keyRings.create", "cloudkms.cryptoKeys.create"}},
+			{Scope: ScopeKMSProject, Role: "roles/cloudkms.cryptoKeyEncrypterDecrypter", Permissions: []string{"cloudkms.cryptoKeyVersions.useToEncrypt", "cloudkms.cryptoKeyVersions.useToDecrypt"}, When: bucketKMSKeyNotProvided},
+			{Scope: ScopeWorkerPoolProject, Role: "roles/cloudbuild.workerPoolUser", Permissions: []string{"cloudbuild.workerpools.use"}},
+			{Scope: ScopeWorkerPoolProject, Role: projectIAMAdmin, Permissions: projectIAMAdminPermissions},
+		},
+	}
+
+	terraformNameRe = regexp.MustCompile(`[^a-z0-9_]+`)
+)
+
+// resources resolves the resources of a scope in the given configuration.
+func (s Scope) resources(g GlobalTFVars) []string {
+	resources := []string{}
+	addProject := func(p string) {
+		if p != "" && !slices.Contains(resources, fmt.Sprintf("projects/%s", p)) {
+			resources = append(resources, fmt.Sprintf("projects/%s", p))
+		}
+	}
+	kmsProject := func(key *string) string {
+		if key == nil {
+			return ""
+		}
+		info, err := extractInfoWithRegex(*key, `projects/(?P<project>[^/]+)/locations/`)
+		if err != nil {
+			return ""
+		}
+		return info["project"]
+	}
+	switch s {
+	case ScopeOrganization:
+		resources = append(resources, fmt.Sprintf("organizations/%s", g.OrgID))
+	case ScopeCommonFolder:
+		resources = append(resources, folderResource(g.CommonFolderID))
+	case ScopeEnvFolder:
+		for _, env := range slices.Sorted(maps.Keys(g.Envs)) {
+			if f := folderResource(g.Envs[env].FolderID); !slices.Contains(resources, f) {
+				resources = append(resources, f)
+			}
+		}
+	case ScopeSeedProject:
+		addProject(g.ProjectID)
+	case ScopeNetworkProject:
+		for _, env := range slices.Sorted(maps.Keys(g.Envs)) {
+			addProject(g.Envs[env].NetworkProjectID)
+		}
+	case ScopeKMSProject:
+		if g.BucketKMSKey == nil {
+			addProject(g.ProjectID)
+		} else {
+			addProject(kmsProject(g.BucketKMSKey))
+		}
+	case ScopeAttestationKMSProject:
+		addProject(kmsProject(g.AttestationKMSKey))
+	case ScopeWorkerPoolProject:
+		info, err := extractInfoWithRegex(g.WorkerPoolID, `projects/(?P<project>[^/]+)/locations/`)
+		if err == nil {
+			addProject(info["project"])
+		}
+	case ScopeSecretProject:
+		for _, c := range []CloudbuildV2RepositoryConfig{g.InfraCloudbuildV2RepositoryConfig, g.AppServicesCloudbuildV2RepositoryConfig} {
+			if c.SecretProjectID != nil {
+				addProject(*c.SecretProjectID)
+			}
+		}
+	}
+	return resources
+}
+
+func folderResource(folder string) string {
+	if strings.HasPrefix(folder, "folders/") {
+		return folder
+	}
+	return fmt.Sprintf("folders/%s", folder)
+}
+
+// iamMember is the IAM member of an account.
+func iamMember(account string) string {
+	if strings.HasSuffix(account, ".gserviceaccount.com") {
+		return fmt.Sprintf("serviceAccount:%s", account)
+	}
+	return fmt.Sprintf("user:%s", account)
+}
+
+// AnalyzePermissions checks the permissions required by each stage on the resources of the configuration.
+// The 1-bootstrap requirements are checked for the caller and the other stages for their service accounts,
+// stages without a service account in serviceAccounts are not checked.
+func AnalyzePermissions(t testing.TB, g GlobalTFVars, caller string, serviceAccounts map[string]string, tester PermissionTester) ([]MissingPermissions, error) {
+	missing := []MissingPermissions{}
+	for _, stage := range []string{BootstrapStep, MultitenantStep, FleetscopeStep, AppFactoryStep} {
+		account := caller
+		serviceAccount := ""
+		if stage != BootstrapStep {
+			serviceAccount = serviceAccounts[stageServiceAccounts[stage]]
+			if serviceAccount == "" {
+				continue
+			}
+			account = serviceAccount
+		}
+
+		// group the permissions by resource to test each resource once
+		required := map[string][]string{}
+		roles := map[string]map[string]string{}
+		for _, r := range permissionRequirements[stage] {
+			if r.When != nil && !r.When(g) {
+				continue
+			}
+			for _, resource := range r.Scope.resources(g) {
+				if roles[resource] == nil {
+					roles[resource] = map[string]string{}
+				}
+				for _, p := range r.Permissions {
+					if _, ok := roles[resource][p]; !ok {
+						required[resource] = append(required[resource], p)
+						roles[resource][p] = r.Role
+					}
+				}
+			}
+		}
+
+		for _, resource := range slices.Sorted(maps.Keys(required)) {
+			granted, err := tester.TestPermissions(t, serviceAccount, resource, required[resource])
+			if err != nil {
+				return missing, fmt.Errorf("failed to test the permissions of %s on %s: %w", account, resource, err)
+			}
+			byRole := map[string][]string{}
+			for _, p := range required[resource] {
+				if !slices.Contains(granted, p) {
+					byRole[roles[resource][p]] = append(byRole[roles[resource][p]], p)
+				}
+			}
+			for _, role := range slices.Sorted(maps.Keys(byRole)) {
+				missing = append(missing, MissingPermissions{
+					Stage:       stage,
+					Member:      iamMember(account),
+					Resource:    resource,
+					Role:        role,
+					Permissions: byRole[role],
+				})
+			}
+		}
+	}
+	return missing, nil
+}
+
+// GcloudBindings returns the gcloud commands that grant the roles with the missing permissions.
+func GcloudBindings(missing []MissingPermissions) string {
+	var b strings.Builder
+	for _, m := range missing {
+		kind, id, _ := strings.Cut(m.Resource, "/")
+		switch kind {
+		case "organizations":
+			fmt.Fprintf(&b, "gcloud organizations add-iam-policy-binding %s", id)
+		case "folders":
+			fmt.Fprintf(&b, "gcloud resource-manager folders add-iam-policy-binding %s", id)
+		default:
+			fmt.Fprintf(&b, "gcloud projects add-iam-policy-binding %s", id)
+		}
+		fmt.Fprintf(&b, " --member=\"%s\" --role=\"%s\" --condition=None\n", m.Member, m.Role)
+	}
+	return b.String()
+}
+
+// TerraformBindings returns the Terraform IAM members that grant the roles with the missing permissions.
+func TerraformBindings(missing []MissingPermissions) string {
+	var b strings.Builder
+	for _, m := range missing {
+		kind, id, _ := strings.Cut(m.Resource, "/")
+		resourceType, attribute := "google_project_iam_member", "project"
+		switch kind {
+		case "organizations":
+			resourceType, attribute = "google_organization_iam_member", "org_id"
+		case "folders":
+			resourceType, attribute = "google_folder_iam_member", "folder"
+			id = m.Resource
+		}
+		// Terraform names cannot start with the digits of the stage name
+		name := strings.TrimLeft(terraformNameRe.ReplaceAllString(strings.ToLower(fmt.Sprintf("%s_%s_%s", m.Stage, id, strings.TrimPrefix(m.Role, "roles/"))), "_"), "0123456789_")
+		fmt.Fprintf(&b, "resource \"%s\" \"%s\" {\n", resourceType, name)
+		fmt.Fprintf(&b, "  %s = \"%s\"\n", attribute, id)
+		fmt.Fprintf(&b, "  role = \"%s\"\n", m.Role)
+		fmt.Fprintf(&b, "  member = \"%s\"\n", m.Member)
+		fmt.Fprintln(&b, "}")
+		fmt.Fprintln(&b, "")
+	}
+	return string(hclwrite.Format([]byte(b.String())))
+}
+
+// crmPermissionTester tests the permissions with the Cloud Resource Manager testIamPermissions API,
+// impersonating the service accounts.
+type crmPermissionTester struct {
+	gcp gcp.GCP
+}
+
+func (c crmPermissionTester) TestPermissions(t testing.TB, serviceAccount, resource string, permissions []string) ([]string, error) {
+	token := ""
+	if serviceAccount == "" {
+		token = c.gcp.GetAuthToken(t)
+	} else {
+		token = c.gcp.GetImpersonatedAuthToken(t, serviceAccount)
+	}
+	return testIAMPermissions(token, permissions, resource)
+}
+
+// ValidatePermissions checks if the caller has the permissions required to deploy 1-bootstrap and,
+// when the service accounts of the stages are provided, if they have the permissions required by their stages.
+// The roles with missing permissions are printed as gcloud commands and Terraform resources.
+// The caller is the identity of gcpConf.
+func ValidatePermissions(t testing.TB, g GlobalTFVars, gcpConf gcp.GCP, serviceAccounts map[string]string) {
+	fmt.Println("")
+	fmt.Println("# Validating if identities have the required permissions.")
+
+	missing, err := AnalyzePermissions(t, g, gcpConf.GetActiveAccount(t), serviceAccounts, crmPermissionTester{gcp: gcpConf})
+	if err != nil {
+		fmt.Printf("# Error testing permissions: %v\n", err)
+	}
+	printMissingPermissions(os.Stdout, missing)
+}
+
+func printMissingPermissions(out io.Writer, missing []MissingPermissions) {
+	if len(missing) == 0 {
+		fmt.Fprintln(out, "# No missing permissions found.")
+		return
+	}
+	for _, m := range missing {
+		fmt.Fprintf(out, "# %s (%s) is missing on %s: %s\n", m.Member, m.Stage, m.Resource, strings.Join(m.Permissions, ", "))
+	}
+	fmt.Fprintln(out, "# Grant the missing permissions with gcloud:")
+	fmt.Fprint(out, GcloudBindings(missing))
+	fmt.Fprintln(out, "# Or with Terraform:")
+	fmt.Fprint(out, TerraformBindings(missing))
+}
+
+// testIAMPermissions checks a set of permissions against a parent (projects/PROJECT_ID or folders/FORLDER_ID of organizations/ORG_ID) using the cloudresourcemanager:testIamPermissions V3 API
+func testIAMPermissions(token string, permissions []string, parent string) ([]string, error) {
+	client := &http.Client{}
+	identityPermissions := []string{}
+
+	// avoid "The number of permissions (xxx) is greater than the maximum allowed (100).
+	for chunk := range slices.Chunk(permissions, maxTestedPermissions) {
+		jsonBody, err := json.Marshal(map[string][]string{"permissions": chunk})
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest("POST", fmt.Sprintf("https://cloudresourcemanager.googleapis.com/v3/%s:testIamPermissions", parent), bytes.NewBuffer(jsonBody))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Authorization", "Bearer "+token)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("error making request: %w", err)
+		}
+		bodyBytes, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response body: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("request failed with status code: %d, body: %s", resp.StatusCode, string(bodyBytes))
+		}
+		bodyJson := map[string][]string{}
+		if err := json.Unmarshal(bodyBytes, &bodyJson); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON: %w", err)
+		}
+		identityPermissions = append(identityPermissions, bodyJson["permissions"]...)
+	}
+	return identityPermissions, nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"fmt"
+	"slices"
+	gotest "testing"
+
+	"github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePermissionTester grants all permissions except the denied ones, by identity and resource.
+type fakePermissionTester struct {
+	denied map[string][]string
+	tested []string
+}
+
+func (f *fakePermissionTester) TestPermissions(t testing.TB, serviceAccount, resource string, permissions []string) ([]string, error) {
+	key := fmt.Sprintf("%s %s", serviceAccount, resource)
+	f.tested = append(f.tested, key)
+	granted := []string{}
+	for _, p := range permissions {
+		if !slices.Contains(f.denied[key], p) {
+			granted = append(granted, p)
+		}
+	}
+	return granted, nil
+}
+
+func iamTestConfig() GlobalTFVars {
+	secretProject := "prj-secrets"
+	return GlobalTFVars{
+		OrgID:          "123456789012",
+		ProjectID:      "prj-seed",
+		CommonFolderID: "folders/111111111111",
+		WorkerPoolID:   "projects/prj-pool/locations/us-central1/workerPools/cb-pool",
+		Envs: map[string]Env{
+			"development": {FolderID: "folders/222222222222", NetworkProjectID: "prj-d-svpc"},
+			"production":  {FolderID: "333333333333", NetworkProjectID: "prj-p-svpc"},
+		},
+		InfraCloudbuildV2RepositoryConfig: CloudbuildV2RepositoryConfig{SecretProjectID: &secretProject},
+	}
+}
+
+func TestScopeResources(t *gotest.T) {
+	g := iamTestConfig()
+	assert.Equal(t, []string{"folders/222222222222", "folders/333333333333"}, ScopeEnvFolder.resources(g))
+	assert.Equal(t, []string{"projects/prj-seed"}, ScopeKMSProject.resources(g), "the seed project has the KMS keys when no key is provided")
+	assert.Equal(t, []string{"projects/prj-pool"}, ScopeWorkerPoolProject.resources(g))
+	assert.Equal(t, []string{"projects/prj-secrets"}, ScopeSecretProject.resources(g))
+	assert.Empty(t, ScopeAttestationKMSProject.resources(g))
+
+	key := "projects/prj-kms/locations/us-central1/keyRings/ring/cryptoKeys/key"
+	g.BucketKMSKey = &key
+	assert.Equal(t, []string{"projects/prj-kms"}, ScopeKMSProject.resources(g))
+}
+
+func TestAnalyzePermissions(t *gotest.T) {
+	g := iamTestConfig()
+	tester := &fakePermissionTester{
+		denied: map[string][]string{
+			" organizations/123456789012":                                 {"resourcemanager.organizations.setIamPolicy"},
+			"mt-sa@prj-seed.iam.gserviceaccount.com folders/333333333333": {"resourcemanager.projects.create", "gkehub.memberships.create", "gkehub.features.update"},
+			"mt-sa@prj-seed.iam.gserviceaccount.com projects/prj-p-svpc":  {"compute.subnetworks.use"},
+			"af-sa@prj-seed.iam.gserviceaccount.com projects/prj-secrets": {"secretmanager.secrets.create"},
+		},
+	}
+
+	missing, err := AnalyzePermissions(t, g, "admin@example.com", map[string]string{
+		"multitenant":        "mt-sa@prj-seed.iam.gserviceaccount.com",
+		"applicationfactory": "af-sa@prj-seed.iam.gserviceaccount.com",
+	}, tester)
+	assert.NoError(t, err)
+	assert.Equal(t, []MissingPermissions{
+		{
+			Stage:       BootstrapStep,
+			Member:      "user:admin@example.com",
+			Resource:    "organizations/123456789012",
+			Role:        "roles/resourcemanager.organizationAdmin",
+			Permissions: []string{"resourcemanager.organizations.setIamPolicy"},
+		},
+		{
+			Stage:       MultitenantStep,
+			Member:      "serviceAccount:mt-sa@prj-seed.iam.gserviceaccount.com",
+			Resource:    "folders/333333333333",
+			Role:        "roles/gkehub.admin",
+			Permissions: []string{"gkehub.memberships.create", "gkehub.features.update"},
+		},
+		{
+			Stage:       MultitenantStep,
+			Member:      "serviceAccount:mt-sa@prj-seed.iam.gserviceaccount.com",
+			Resource:    "folders/333333333333",
+			Role:        "roles/resourcemanager.projectCreator",
+			Permissions: []string{"resourcemanager.projects.create"},
+		},
+		{
+			Stage:       MultitenantStep,
+			Member:      "serviceAccount:mt-sa@prj-seed.iam.gserviceaccount.com",
+			Resource:    "projects/prj-p-svpc",
+			Role:        "roles/compute.networkUser",
+			Permissions: []string{"compute.subnetworks.use"},
+		},
+		{
+			Stage:       AppFactoryStep,
+			Member:      "serviceAccount:af-sa@prj-seed.iam.gserviceaccount.com",
+			Resource:    "projects/prj-secrets",
+			Role:        "roles/secretmanager.admin",
+			Permissions: []string{"secretmanager.secrets.create"},
+		},
+	}, missing)
+	assert.Contains(t, tester.tested, "mt-sa@prj-seed.iam.gserviceaccount.com folders/222222222222", "all environment folders should be tested")
+	for _, tested := range tester.tested {
+		assert.NotContains(t, tested, "fs-sa", "stages without service account should not be tested")
+	}
+
+	assert.Equal(t, `gcloud organizations add-iam-policy-binding 123456789012 --member="user:admin@example.com" --role="roles/resourcemanager.organizationAdmin" --condition=None
+gcloud resource-manager folders add-iam-policy-binding 333333333333 --member="serviceAccount:mt-sa@prj-seed.iam.gserviceaccount.com" --role="roles/gkehub.admin" --condition=None
+`, GcloudBindings(missing[:2]))
+
+	assert.Equal(t, `resource "google_organization_iam_member" "bootstrap_123456789012_resourcemanager_organizationadmin" {
+  org_id = "123456789012"
+  role   = "roles/resourcemanager.organizationAdmin"
+  member = "user:admin@example.com"
+}
+
+resource "google_project_iam_member" "multitenant_prj_p_svpc_compute_networkuser" {
+  project = "prj-p-svpc"
+  role    = "roles/compute.networkUser"
+  member  = "serviceAccount:mt-sa@prj-seed.iam.gserviceaccount.com"
+}
+
+`, TerraformBindings([]MissingPermissions{missing[0], missing[3]}))
+}
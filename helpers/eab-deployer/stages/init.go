@@ -0,0 +1,308 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/test/integration/testutils"
+)
+
+const (
+	defaultEnvs          = "development,nonproduction,production"
+	defaultRegion        = "us-central1"
+	defaultRepoType      = "CSR"
+	defaultExampleName   = "default-example"
+	defaultServiceName   = "hello-world"
+	defaultServicePrefix = "eab"
+)
+
+var (
+	defaultInfraProjectAPIs = []string{
+		"iam.googleapis.com",
+		"cloudresourcemanager.googleapis.com",
+		"serviceusage.googleapis.com",
+		"cloudbilling.googleapis.com",
+	}
+
+	// tfvarsComments are the comments written in the generated tfvars file
+	tfvarsComments = map[string]string{
+		"code_checkout_path":                   "The directory where the helper will git clone the repositories that will host the code for each one of the stages",
+		"eab_code_path":                        "The directory where the user has created a fresh git clone of the Enterprise Application Blueprint repository",
+		"template_overlays":                    "Directories with custom files rendered in the stage repositories, by repository name or \"*\" for all - OPTIONAL",
+		"template_values":                      "Values available to the templates as .Values - OPTIONAL",
+		"backend_prefix":                       "Prefix prepended to the terraform state prefix of each stage, to host several deployments in the same bucket - OPTIONAL",
+		"backend_kms_encryption_key":           "Cloud KMS key used to encrypt the terraform state files - OPTIONAL",
+		"backend_impersonate_service_account":  "Service account impersonated by the helper to access the terraform state - OPTIONAL",
+		"terraform_binary":                     "Terraform binary used instead of downloading the version pinned by the blueprint - OPTIONAL",
+		"policies_repository":                  "Repository of the policy library created and populated by the helper, the Cloud Source Repositories are created - OPTIONAL",
+		"config_sync_repository":               "Repository of Config Sync scaffolded by the helper, its URL is the default of config_sync_repository_url - OPTIONAL",
+		"policy_library_path":                  "Directory of the policy library pushed to policies_repository, policy-library in eab_code_path by default - OPTIONAL",
+		"org_id":                               "Organization where the blueprint is going to be deployed - MANDATORY",
+		"billing_account":                      "Billing account used to create projects - MANDATORY",
+		"project_id":                           "Project where the CI/CD pipelines will be created for infra deployment - MANDATORY",
+		"common_folder_id":                     "Folder where the admin project for applications will be created - MANDATORY",
+		"workerpool_id":                        "Private worker pool used by the CI/CD pipelines - MANDATORY",
+		"envs":                                 "Environments to be deployed. At least one of the environments MUST be production",
+		"location":                             "Location for build buckets",
+		"trigger_location":                     "Location of the Cloud Build triggers",
+		"region":                               "CI/CD region used by 5-appinfra",
+		"connection_location":                  "Region of the Cloud Build connections of the repositories, us-central1 by default - OPTIONAL",
+		"regions":                              "Regions checked against the subnetworks of each environment, in promotion order, only used for validation - OPTIONAL",
+		"namespace_ids":                        "Namespaces to be created in the clusters and the groups that will administer them",
+		"apps":                                 "Applications used to create the 2-multitenant resources",
+		"applications":                         "Applications to be created by 4-appfactory - admin and infra projects and CI/CD pipelines",
+		"infra_cloudbuildv2_repository_config": "Repositories for the infrastructure stages",
+		"app_services_cloudbuildv2_repository_config": "Repositories for the application source code",
+		"infra_project_apis":                          "APIs to be enabled on infra projects",
+		"service_perimeter_mode":                      "The service perimeter mode, DRY_RUN or ENFORCE",
+	}
+)
+
+// Wizard builds the configuration of a new deployment interactively,
+// discovering the existing resources of the organization.
+type Wizard struct {
+	gcp    gcp.GCP
+	reader *bufio.Reader
+	out    io.Writer
+}
+
+// NewWizard creates a new Wizard that reads the answers from in and writes the questions to out.
+func NewWizard(g gcp.GCP, in io.Reader, out io.Writer) Wizard {
+	return Wizard{
+		gcp:    g,
+		reader: bufio.NewReader(in),
+		out:    out,
+	}
+}
+
+// ask asks a question and returns the answer or the default value if no answer is provided.
+func (w Wizard) ask(question, defaultValue string) string {
+	if defaultValue != "" {
+		fmt.Fprintf(w.out, "# %s [%s]: ", question, defaultValue)
+	} else {
+		fmt.Fprintf(w.out, "# %s: ", question)
+	}
+	answer, _ := w.reader.ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return defaultValue
+	}
+	return answer
+}
+
+// choose asks the user to select one of the options by number.
+// A value that is not an option number is returned as provided.
+func (w Wizard) choose(question string, options []string) string {
+	if len(options) == 0 {
+		return w.ask(question, "")
+	}
+	fmt.Fprintf(w.out, "# %s\n", question)
+	for i, o := range options {
+		fmt.Fprintf(w.out, "#   %d) %s\n", i+1, o)
+	}
+	answer := w.ask("Option number or value", "1")
+	i, err := strconv.Atoi(answer)
+	if err != nil {
+		return answer
+	}
+	if i < 1 || i > len(options) {
+		fmt.Fprintf(w.out, "# Invalid option %d\n", i)
+		return w.choose(question, options)
+	}
+	return options[i-1]
+}
+
+// chooseMany asks the user to select a comma separated list of options by number.
+func (w Wizard) chooseMany(question string, options []string) []string {
+	if len(options) == 0 {
+		return splitList(w.ask(question, ""))
+	}
+	fmt.Fprintf(w.out, "# %s\n", question)
+	for i, o := range options {
+		fmt.Fprintf(w.out, "#   %d) %s\n", i+1, o)
+	}
+	selected := []string{}
+	for _, answer := range splitList(w.ask("Comma separated option numbers or values", "all")) {
+		if answer == "all" {
+			return options
+		}
+		i, err := strconv.Atoi(answer)
+		if err != nil {
+			selected = append(selected, answer)
+			continue
+		}
+		if i >= 1 && i <= len(options) {
+			selected = append(selected, options[i-1])
+		}
+	}
+	return selected
+}
+
+// splitList splits a comma separated list removing empty values.
+func splitList(s string) []string {
+	l := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if strings.TrimSpace(v) != "" {
+			l = append(l, strings.TrimSpace(v))
+		}
+	}
+	return l
+}
+
+// Run asks the questions needed to create the configuration of a new deployment.
+func (w Wizard) Run(t testing.TB) GlobalTFVars {
+	cwd, _ := os.Getwd()
+
+	fmt.Fprintln(w.out, "# Creating a new Enterprise Application Blueprint configuration.")
+	g := GlobalTFVars{
+		BucketPrefix:              "bkt",
+		BucketForceDestroy:        false,
+		DeletionProtection:        true,
+		AttestationEvaluationMode: "ALWAYS_ALLOW",
+	}
+	g.EABCodePath = w.ask("Full path to the Enterprise Application Blueprint code", cwd)
+	g.CodeCheckoutPath = w.ask("Full path to the directory where the stage repositories will be checked out", cwd)
+	g.OrgID = w.ask("Organization ID", "")
+	g.BillingAccount = w.ask("Billing account", "")
+	g.ProjectID = w.ask("Seed project ID", "")
+	g.Region = w.ask("Region", defaultRegion)
+	g.Location = w.ask("Location for build buckets", g.Region)
+	g.TriggerLocation = w.ask("Location for the Cloud Build triggers", g.Region)
+
+	folders := w.gcp.ListFolders(t, g.OrgID)
+	folderOptions := slices.Sorted(maps.Keys(folders))
+	folderLabels := []string{}
+	for _, f := range folderOptions {
+		folderLabels = append(folderLabels, fmt.Sprintf("%s (%s)", f, folders[f]))
+	}
+	pickFolder := func(question string) string {
+		folder, _, _ := strings.Cut(w.choose(question, folderLabels), " ")
+		return folder
+	}
+	g.CommonFolderID = pickFolder("Folder where the application admin projects will be created")
+
+	hostProjects := w.gcp.ListSharedVPCHostProjects(t, g.OrgID)
+	g.Envs = map[string]Env{}
+	for _, env := range splitList(w.ask("Environments", defaultEnvs)) {
+		fmt.Fprintf(w.out, "# Configuring environment %s.\n", env)
+		e := Env{
+			BillingAccount: g.BillingAccount,
+			OrgID:          g.OrgID,
+		}
+		e.FolderID = pickFolder(fmt.Sprintf("Folder for the %s environment projects", env))
+		e.NetworkProjectID = w.choose(fmt.Sprintf("Shared VPC host project for the %s environment", env), hostProjects)
+		e.NetworkSelfLink = w.choose(fmt.Sprintf("Network for the %s environment", env), w.gcp.ListNetworks(t, e.NetworkProjectID))
+		e.SubnetsSelfLinks = w.chooseMany(fmt.Sprintf("Subnetworks for the %s environment clusters, one cluster is created for each subnetwork", env),
+			w.gcp.ListSubnetworks(t, e.NetworkProjectID, testutils.GetLastSplitElement(e.NetworkSelfLink, "/")))
+		g.Envs[env] = e
+	}
+
+	workerPoolProject := w.ask("Project of the Cloud Build private worker pool", g.ProjectID)
+	g.WorkerPoolID = w.choose("Cloud Build private worker pool", w.gcp.ListWorkerPools(t, workerPoolProject, g.TriggerLocation))
+
+	g.Apps = map[string]App{
+		defaultExampleName: {
+			Acronym:        "de",
+			IPAddressNames: []string{},
+			Certificates:   map[string][]string{},
+		},
+	}
+	g.Applications = map[string]map[string]ApplicationService{
+		defaultExampleName: {
+			defaultServiceName: {
+				CreateInfraProject: false,
+				CreateAdminProject: true,
+			},
+		},
+	}
+
+	domain := w.gcp.GetOrganizationDomain(t, g.OrgID)
+	g.NamespaceIDs = map[string]string{
+		defaultServiceName: w.ask(fmt.Sprintf("Group that will administer the %s namespace", defaultServiceName), fmt.Sprintf("%s@%s", defaultServiceName, domain)),
+	}
+
+	repoType := w.choose("Repository type", []string{"CSR", "GITHUBv2", "GITLABv2"})
+	repoBaseURL := ""
+	if repoType != defaultRepoType {
+		repoBaseURL = strings.TrimSuffix(w.ask("Base URL of the repositories, like https://github.com/ORGANIZATION", ""), "/")
+	}
+	repositoryURL := func(name string) string {
+		if repoBaseURL == "" {
+			return ""
+		}
+		return fmt.Sprintf("%s/%s.git", repoBaseURL, name)
+	}
+	infraRepos := map[string]string{
+		"multitenant":        fmt.Sprintf("%s-multitenant", defaultServicePrefix),
+		"fleetscope":         fmt.Sprintf("%s-fleetscope", defaultServicePrefix),
+		"applicationfactory": fmt.Sprintf("%s-appfactory", defaultServicePrefix),
+		defaultServiceName:   fmt.Sprintf("%s-admin", defaultServiceName),
+	}
+	g.InfraCloudbuildV2RepositoryConfig = CloudbuildV2RepositoryConfig{
+		RepoType:     repoType,
+		Repositories: map[string]Repository{},
+	}
+	for _, k := range slices.Sorted(maps.Keys(infraRepos)) {
+		name := w.ask(fmt.Sprintf("Name of the %s repository", k), infraRepos[k])
+		g.InfraCloudbuildV2RepositoryConfig.Repositories[k] = Repository{
+			RepositoryName: name,
+			RepositoryURL:  repositoryURL(name),
+		}
+	}
+	appSourceName := w.ask(fmt.Sprintf("Name of the %s application source repository", defaultServiceName), fmt.Sprintf("%s-i-r", defaultServiceName))
+	g.AppServicesCloudbuildV2RepositoryConfig = CloudbuildV2RepositoryConfig{
+		RepoType: repoType,
+		Repositories: map[string]Repository{
+			fmt.Sprintf("%s-%s-%s", defaultServicePrefix, defaultExampleName, defaultServiceName): {
+				RepositoryName: appSourceName,
+				RepositoryURL:  repositoryURL(appSourceName),
+			},
+		},
+	}
+	if repoType != defaultRepoType {
+		fmt.Fprintln(w.out, "# Update the secret IDs of the repository configurations in the generated file before deploying.")
+	}
+
+	apis := slices.Clone(defaultInfraProjectAPIs)
+	g.InfraProjectAPIs = &apis
+	mode := "DRY_RUN"
+	g.ServicePerimeterMode = &mode
+
+	return g
+}
+
+// WriteGlobalTFVars writes the configuration of the deploy into a commented tfvars file.
+func WriteGlobalTFVars(file string, g GlobalTFVars) error {
+	exist, err := utils.FileExists(file)
+	if err != nil {
+		return err
+	}
+	if exist {
+		return fmt.Errorf("tfvars file '%s' already exists", file)
+	}
+	return utils.WriteTfvarsWithComments(file, g, tfvarsComments)
+}
@@ -0,0 +1,111 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	gotest "testing"
+
+	"github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+)
+
+// recordedGCP returns a GCP wrapper that answers the gcloud commands with the recorded responses in testdata.
+func recordedGCP(t *gotest.T, dir string, responses map[string]string) gcp.GCP {
+	return gcp.GCP{
+		Runf: func(tb testing.TB, cmd string, args ...interface{}) gjson.Result {
+			c := fmt.Sprintf(cmd, args...)
+			file, ok := responses[c]
+			if !ok {
+				t.Fatalf("unexpected command: %s", c)
+			}
+			content, err := os.ReadFile(filepath.Join(".", "testdata", dir, file))
+			assert.NoError(t, err)
+			return gjson.Parse(string(content))
+		},
+	}
+}
+
+func TestWizard(t *gotest.T) {
+	g := recordedGCP(t, "init", map[string]string{
+		"resource-manager folders list --organization 123456789012":               "folders.json",
+		"compute shared-vpc organizations list-host-projects 123456789012":        "host_projects.json",
+		"compute networks list --project prj-d-svpc":                              "networks_d.json",
+		"compute networks list --project prj-p-svpc":                              "networks_p.json",
+		"compute networks subnets list --project prj-d-svpc --network vpc-d-svpc": "subnets_d.json",
+		"compute networks subnets list --project prj-p-svpc --network vpc-p-svpc": "subnets_p.json",
+		"builds worker-pools list --project prj-seed --region us-central1":        "worker_pools.json",
+		"organizations describe 123456789012":                                     "organization.json",
+	})
+
+	answers := []string{
+		"/tmp/eab",               // EAB code path
+		"/tmp/checkout",          // checkout path
+		"123456789012",           // organization
+		"000000-000000-000000",   // billing account
+		"prj-seed",               // seed project
+		"",                       // region
+		"",                       // location
+		"",                       // trigger location
+		"1",                      // common folder
+		"development,production", // environments
+		"2", "1", "", "",         // development folder, host project, network and subnetworks
+		"3", "2", "1", "1", // production folder, host project, network and subnetworks
+		"",                           // worker pool project
+		"",                           // worker pool
+		"",                           // namespace group
+		"2",                          // repository type
+		"https://github.com/my-org/", // repository base URL
+		"", "", "", "", "",           // repository names
+	}
+
+	w := NewWizard(g, strings.NewReader(strings.Join(answers, "\n")+"\n"), io.Discard)
+	tfvars := w.Run(t)
+
+	assert.Equal(t, "123456789012", tfvars.OrgID)
+	assert.Equal(t, "us-central1", tfvars.TriggerLocation)
+	assert.Equal(t, "folders/111111111111", tfvars.CommonFolderID)
+	assert.Equal(t, "projects/prj-seed/locations/us-central1/workerPools/cb-pool", tfvars.WorkerPoolID)
+	assert.Len(t, tfvars.Envs, 2, "should have two environments")
+	assert.Equal(t, "folders/222222222222", tfvars.Envs["development"].FolderID)
+	assert.Equal(t, "prj-d-svpc", tfvars.Envs["development"].NetworkProjectID)
+	assert.Len(t, tfvars.Envs["development"].SubnetsSelfLinks, 2, "all subnetworks should be selected")
+	assert.Equal(t, []string{"https://www.googleapis.com/compute/v1/projects/prj-p-svpc/regions/us-central1/subnetworks/sb-p-us-central1"}, tfvars.Envs["production"].SubnetsSelfLinks)
+	assert.Equal(t, "hello-world@example.com", tfvars.NamespaceIDs["hello-world"])
+	assert.Equal(t, "GITHUBv2", tfvars.InfraCloudbuildV2RepositoryConfig.RepoType)
+	assert.Equal(t, "https://github.com/my-org/eab-multitenant.git", tfvars.InfraCloudbuildV2RepositoryConfig.Repositories["multitenant"].RepositoryURL)
+	assert.Equal(t, "hello-world-i-r", tfvars.AppServicesCloudbuildV2RepositoryConfig.Repositories["eab-default-example-hello-world"].RepositoryName)
+
+	file := filepath.Join(t.TempDir(), "global.tfvars")
+	err := WriteGlobalTFVars(file, tfvars)
+	assert.NoError(t, err)
+	err = WriteGlobalTFVars(file, tfvars)
+	assert.Error(t, err, "existing file should not be overwritten")
+
+	content, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "// Project where the CI/CD pipelines will be created", "should have comments")
+
+	read, err := ReadGlobalTFVars(file)
+	assert.NoError(t, err)
+	assert.Equal(t, tfvars, read, "generated file should be a valid configuration")
+}
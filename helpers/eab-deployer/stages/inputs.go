@@ -0,0 +1,142 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/render"
+)
+
+// Inputs of a stage, a stage is applied again when the checksum of one of them changes.
+const (
+	InputTfvars  = "tfvars"
+	InputEnvs    = "envs"
+	InputCode    = "code"
+	InputBackend = "backend"
+)
+
+// generatedFiles are the files written by terraform and by previous versions of the helper in the code of the
+// blueprint, they are not part of the code input. The terraform.tfvars files are the tfvars input.
+var generatedFiles = []string{"terraform.tfvars", "*.tfstate", "*.tfstate.backup"}
+
+// StageInputs are the checksums of the inputs of a stage: its tfvars, its environments, the code rendered in its
+// repositories and the backend settings. The outputs of the previous stages are not part of the inputs, the stages
+// that depend on a changed stage are applied again because of the dependency.
+func StageInputs(tfvars GlobalTFVars, c CommonConf, stage string) (map[string]string, error) {
+	envs := slices.Sorted(maps.Keys(tfvars.Envs))
+	infraRepos := tfvars.InfraCloudbuildV2RepositoryConfig.Repositories
+	var stageTfvars any
+	var code []render.Renderer
+	var err error
+	switch stage {
+	case BootstrapStep:
+		stageTfvars, err = bootstrapTfvars(tfvars)
+		code = []render.Renderer{{Sources: []render.Source{{Name: stage, FS: os.DirFS(filepath.Join(c.EABPath, stage))}}}}
+	case MultitenantStep:
+		stageTfvars, err = multitenantTfvars(tfvars)
+		code = []render.Renderer{stepCodeRenderer(c.EABPath, stage, infraRepos["multitenant"].RepositoryName, "", c.TemplateOverlays)}
+	case FleetscopeStep:
+		stageTfvars = fleetscopeTfvars(tfvars, "")
+		code = []render.Renderer{stepCodeRenderer(c.EABPath, stage, infraRepos["fleetscope"].RepositoryName, "", c.TemplateOverlays)}
+	case AppFactoryStep:
+		stageTfvars, err = appFactoryTfvars(tfvars, "")
+		code = []render.Renderer{stepCodeRenderer(c.EABPath, stage, infraRepos["applicationfactory"].RepositoryName, "", c.TemplateOverlays)}
+		envs = []string{"shared"}
+	case AppInfraStep:
+		stageTfvars = appInfraTfvars(tfvars, "")
+		for _, service := range applicationServices(tfvars) {
+			code = append(code, stepCodeRenderer(c.EABPath, stage, infraRepos[service].RepositoryName, "", c.TemplateOverlays))
+		}
+		envs = append([]string{"shared"}, envs...)
+	case AppSourceStep:
+		stageTfvars = tfvars.AppServicesCloudbuildV2RepositoryConfig
+		step := filepath.Join(AppSourceStep, "hello-world")
+		code = []render.Renderer{{Sources: []render.Source{{Name: step, FS: os.DirFS(filepath.Join(c.EABPath, step))}}}}
+	default:
+		return nil, fmt.Errorf("unknown stage '%s'", stage)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := map[string]string{}
+	if inputs[InputTfvars], err = jsonChecksum(stageTfvars); err != nil {
+		return nil, err
+	}
+	if inputs[InputEnvs], err = jsonChecksum(envs); err != nil {
+		return nil, err
+	}
+	if inputs[InputBackend], err = jsonChecksum(c.Backend); err != nil {
+		return nil, err
+	}
+	sums := []string{}
+	for _, r := range code {
+		sum, err := r.Checksum(generatedFiles...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the code of stage %s: %w", stage, err)
+		}
+		sums = append(sums, sum)
+	}
+	if inputs[InputCode], err = jsonChecksum(map[string]any{"code": sums, "values": c.TemplateValues}); err != nil {
+		return nil, err
+	}
+	return inputs, nil
+}
+
+// StageStepPrefixes are the prefixes of the nested steps of a stage, the names of its repositories.
+// The nested steps of the bootstrap stage use the name of the top level step.
+func StageStepPrefixes(tfvars GlobalTFVars, stage string) []string {
+	infraRepos := tfvars.InfraCloudbuildV2RepositoryConfig.Repositories
+	switch stage {
+	case MultitenantStep:
+		return []string{infraRepos["multitenant"].RepositoryName}
+	case FleetscopeStep:
+		return []string{infraRepos["fleetscope"].RepositoryName}
+	case AppFactoryStep:
+		return []string{infraRepos["applicationfactory"].RepositoryName}
+	case AppInfraStep:
+		prefixes := []string{}
+		for _, service := range applicationServices(tfvars) {
+			prefixes = append(prefixes, infraRepos[service].RepositoryName)
+		}
+		return prefixes
+	case AppSourceStep:
+		prefixes := []string{}
+		for _, r := range tfvars.AppServicesCloudbuildV2RepositoryConfig.Repositories {
+			prefixes = append(prefixes, r.RepositoryName)
+		}
+		slices.Sort(prefixes)
+		return prefixes
+	}
+	return []string{}
+}
+
+// jsonChecksum is the checksum of the JSON encoding of a value, the keys of the maps are sorted by the encoding.
+func jsonChecksum(v any) (string, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
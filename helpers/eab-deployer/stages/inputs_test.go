@@ -0,0 +1,69 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	gotest "testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/backend"
+)
+
+func TestStageInputs(t *gotest.T) {
+	eab := t.TempDir()
+	for _, f := range []string{"6-appsource/hello-world/skaffold.yaml", "1-bootstrap/main.tf", "1-bootstrap/terraform.tfstate"} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(eab, f)), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(eab, f), []byte(f), 0644))
+	}
+	tfvars := stateTestConfig()
+	c := CommonConf{EABPath: eab}
+
+	inputs, err := StageInputs(tfvars, c, AppSourceStep)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{InputTfvars, InputEnvs, InputCode, InputBackend}, slices.Collect(maps.Keys(inputs)))
+
+	tfvars.Envs["nonproduction"] = Env{}
+	changed, err := StageInputs(tfvars, c, AppSourceStep)
+	assert.NoError(t, err)
+	assert.NotEqual(t, inputs[InputEnvs], changed[InputEnvs])
+	assert.Equal(t, inputs[InputCode], changed[InputCode])
+
+	c.Backend = backend.Settings{Prefix: "eab"}
+	c.TemplateValues = map[string]string{"team": "platform"}
+	changed, err = StageInputs(tfvars, c, AppSourceStep)
+	assert.NoError(t, err)
+	assert.NotEqual(t, inputs[InputBackend], changed[InputBackend])
+	assert.NotEqual(t, inputs[InputCode], changed[InputCode], "template values should change the code")
+
+	bootstrap, err := StageInputs(tfvars, c, BootstrapStep)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(eab, "1-bootstrap", "terraform.tfstate"), []byte("{}"), 0644))
+	same, err := StageInputs(tfvars, c, BootstrapStep)
+	assert.NoError(t, err)
+	assert.Equal(t, bootstrap, same, "the local state is not an input")
+
+	_, err = StageInputs(tfvars, c, MultitenantStep)
+	assert.EqualError(t, err, "no match found.")
+	_, err = StageInputs(tfvars, c, "7-unknown")
+	assert.EqualError(t, err, "unknown stage '7-unknown'")
+
+	assert.Equal(t, []string{"eab-default-example-hello-world"}, StageStepPrefixes(tfvars, AppInfraStep))
+	assert.Empty(t, StageStepPrefixes(tfvars, BootstrapStep))
+}
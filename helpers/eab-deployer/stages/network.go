@@ -0,0 +1,383 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+)
+
+const (
+	clusterTypeAutopilot = "AUTOPILOT"
+	// defaultClusterType is the default of the cluster_type input of 2-multitenant/modules/env_baseline
+	defaultClusterType = "STANDARD-NAP"
+	// minSecondaryRangeSize is the minimum prefix length of the pods and services ranges
+	minSecondaryRangeSize = 18
+	// reservedSubnetIPs are the addresses of a primary range that cannot be used by nodes
+	reservedSubnetIPs = 4
+	// regionalZones is the number of zones used by the regional node pools
+	regionalZones = 3
+	// armRegion is the only region where 2-multitenant creates an ARM node pool
+	armRegion = "us-central1"
+)
+
+var (
+	// masterIPv4CIDRBlocks is the default of the master_ipv4_cidr_blocks input of 2-multitenant/modules/env_baseline,
+	// one range is used for the control plane of each cluster.
+	masterIPv4CIDRBlocks = []string{"10.11.10.0/28", "10.11.20.0/28"}
+
+	// maxPodsPerNode is the default maximum number of pods per node for each cluster type
+	maxPodsPerNode = map[string]int{
+		"STANDARD":           110,
+		"STANDARD-NAP":       110,
+		clusterTypeAutopilot: 32,
+	}
+
+	// masterPorts are the ports the control plane uses to reach the nodes
+	masterPorts = []int{443, 10250}
+
+	clusterTypeRe = regexp.MustCompile(`cluster_type\s*=\s*"([^"]+)"`)
+)
+
+// NetworkInfo provides the network data used to analyze the IP plan of the deployment.
+type NetworkInfo interface {
+	GetSubnetwork(t testing.TB, project, region, name string) gcp.Subnetwork
+	ListRouters(t testing.TB, project, region string) []gcp.Router
+	ListFirewallRules(t testing.TB, project, network string) []gcp.FirewallRule
+	GetWorkerPoolNetwork(t testing.TB, project, region, name string) gcp.WorkerPoolNetwork
+}
+
+// ClusterCapacity is the IP capacity of one of the clusters created by 2-multitenant.
+type ClusterCapacity struct {
+	Env           string
+	Cluster       string
+	Type          string
+	NodesRange    string
+	PodsRange     string
+	ServicesRange string
+	MasterRange   string
+	MaxNodes      int
+	MaxPods       int
+	MaxServices   int
+	RequiredNodes int
+}
+
+// IPPlan is the result of the analysis of the networks used by the deployment.
+type IPPlan struct {
+	Clusters []ClusterCapacity
+	Findings []string
+}
+
+// namedRange is an IP range and where it is used.
+type namedRange struct {
+	name    string
+	env     string
+	network string
+	// reused ranges are the same in all environments by design
+	reused bool
+	ipNet  *net.IPNet
+}
+
+func (p *IPPlan) addFinding(format string, args ...interface{}) {
+	p.Findings = append(p.Findings, fmt.Sprintf(format, args...))
+}
+
+// AnalyzeIPPlan computes the capacity of the clusters that 2-multitenant will create in each environment
+// and checks the subnetworks, Cloud NAT, firewall rules and range overlaps of the environment networks.
+func AnalyzeIPPlan(t testing.TB, g GlobalTFVars, n NetworkInfo) IPPlan {
+	p := IPPlan{
+		Clusters: []ClusterCapacity{},
+		Findings: []string{},
+	}
+	ranges := []namedRange{}
+
+	workerPoolInfo, err := extractInfoWithRegex(g.WorkerPoolID, `projects/(?P<project>[^/]+)/locations/(?P<location>[^/]+)/workerPools/(?P<workerPool>[^/]+)`)
+	if err == nil {
+		wp := n.GetWorkerPoolNetwork(t, workerPoolInfo["project"], workerPoolInfo["location"], workerPoolInfo["workerPool"])
+		peered := wp.AllocatedRanges
+		if !strings.HasPrefix(wp.PeeredNetworkIPRange, "/") && wp.PeeredNetworkIPRange != "" {
+			peered = append(peered, wp.PeeredNetworkIPRange)
+		}
+		for _, r := range peered {
+			_, ipNet, err := net.ParseCIDR(r)
+			if err != nil {
+				p.addFinding("Invalid worker pool peering range %s.", r)
+				continue
+			}
+			ranges = append(ranges, namedRange{name: fmt.Sprintf("worker pool peering range %s", r), network: wp.PeeredNetwork, ipNet: ipNet})
+		}
+	}
+
+	for _, env := range slices.Sorted(maps.Keys(g.Envs)) {
+		e := g.Envs[env]
+		clusterType := envClusterType(g.EABCodePath, env)
+		if len(e.SubnetsSelfLinks) > len(masterIPv4CIDRBlocks) {
+			p.addFinding("Environment %s has %d subnetworks but only %d control plane ranges are available, one cluster is created for each subnetwork.", env, len(e.SubnetsSelfLinks), len(masterIPv4CIDRBlocks))
+		}
+
+		regions := []string{}
+		for i, subnet := range e.SubnetsSelfLinks {
+			subnetInfo, err := extractInfoWithRegex(subnet, `projects/(?P<project>[^/]+)/regions/(?P<region>[^/]+)/subnetworks/(?P<subnet>[^/]+)`)
+			if err != nil {
+				p.addFinding("Subnetwork %s of environment %s is not a valid self link.", subnet, env)
+				continue
+			}
+			s := n.GetSubnetwork(t, subnetInfo["project"], subnetInfo["region"], subnetInfo["subnet"])
+			if !slices.Contains(regions, subnetInfo["region"]) {
+				regions = append(regions, subnetInfo["region"])
+			}
+
+			if !s.PrivateIPGoogleAccess {
+				p.addFinding("Subnetwork %s should have Private Google Access enabled.", s.Name)
+			}
+			if len(s.SecondaryRanges) < 2 {
+				p.addFinding("Subnetwork %s should have at least 2 secondary ranges, the first for pods and the second for services.", s.Name)
+			}
+			for _, r := range s.SecondaryRanges {
+				if !ipRangeSize(r.IPCIDRRange, minSecondaryRangeSize) {
+					p.addFinding("Secondary range %s of subnetwork %s should be at least a /%d. Current: %s", r.Name, s.Name, minSecondaryRangeSize, r.IPCIDRRange)
+				}
+			}
+
+			c := ClusterCapacity{
+				Env:        env,
+				Cluster:    fmt.Sprintf("cluster-%s-%s", subnetInfo["region"], env),
+				Type:       clusterType,
+				NodesRange: s.IPCIDRRange,
+			}
+			if len(s.SecondaryRanges) > 0 {
+				c.PodsRange = s.SecondaryRanges[0].IPCIDRRange
+			}
+			if len(s.SecondaryRanges) > 1 {
+				c.ServicesRange = s.SecondaryRanges[1].IPCIDRRange
+			}
+			if i < len(masterIPv4CIDRBlocks) {
+				c.MasterRange = masterIPv4CIDRBlocks[i]
+			}
+			c.MaxNodes, c.MaxPods, c.MaxServices = clusterCapacity(clusterType, c.NodesRange, c.PodsRange, c.ServicesRange)
+			c.RequiredNodes = requiredNodes(clusterType, subnetInfo["region"])
+			if c.MaxNodes < c.RequiredNodes {
+				p.addFinding("Cluster %s needs at least %d nodes but its ranges only allow %d.", c.Cluster, c.RequiredNodes, c.MaxNodes)
+			}
+			p.Clusters = append(p.Clusters, c)
+
+			ranges = appendRange(ranges, &p, namedRange{name: fmt.Sprintf("%s primary range %s", s.Name, s.IPCIDRRange), env: env, network: e.NetworkSelfLink}, s.IPCIDRRange)
+			for _, r := range s.SecondaryRanges {
+				ranges = appendRange(ranges, &p, namedRange{name: fmt.Sprintf("%s secondary range %s %s", s.Name, r.Name, r.IPCIDRRange), env: env, network: e.NetworkSelfLink}, r.IPCIDRRange)
+			}
+			if c.MasterRange != "" {
+				ranges = appendRange(ranges, &p, namedRange{name: fmt.Sprintf("%s control plane range %s", c.Cluster, c.MasterRange), env: env, network: e.NetworkSelfLink, reused: true}, c.MasterRange)
+			}
+		}
+
+		for _, region := range regions {
+			if !hasCloudNAT(n.ListRouters(t, e.NetworkProjectID, region), e.NetworkSelfLink) {
+				p.addFinding("Network %s has no Cloud NAT in region %s, the private nodes will not be able to reach the internet.", e.NetworkSelfLink, region)
+			}
+		}
+
+		rules := n.ListFirewallRules(t, e.NetworkProjectID, e.NetworkSelfLink)
+		for i := range e.SubnetsSelfLinks {
+			if i >= len(masterIPv4CIDRBlocks) {
+				break
+			}
+			for _, port := range masterPorts {
+				if !allowsIngress(rules, masterIPv4CIDRBlocks[i], port) {
+					p.addFinding("Network %s has no firewall rule allowing the control plane range %s to reach the nodes on tcp:%d.", e.NetworkSelfLink, masterIPv4CIDRBlocks[i], port)
+				}
+			}
+		}
+	}
+
+	for i := range ranges {
+		for j := i + 1; j < len(ranges); j++ {
+			a, b := ranges[i], ranges[j]
+			if !overlaps(a.ipNet, b.ipNet) {
+				continue
+			}
+			switch {
+			case a.env == "" || b.env == "":
+				p.addFinding("Overlapping ranges: %s and %s.", a.name, b.name)
+			case a.network == b.network:
+				p.addFinding("Overlapping ranges in environment %s: %s and %s.", b.env, a.name, b.name)
+			case !a.reused && !b.reused:
+				p.addFinding("Overlapping ranges in environments %s and %s, this is only an issue if the networks are connected: %s and %s.", a.env, b.env, a.name, b.name)
+			}
+		}
+	}
+	return p
+}
+
+// CapacityTable writes the capacity of the clusters as a table.
+func (p IPPlan) CapacityTable(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENV\tCLUSTER\tTYPE\tNODES RANGE\tPODS RANGE\tSERVICES RANGE\tCONTROL PLANE\tMAX NODES\tMAX PODS\tMAX SERVICES\tREQUIRED NODES")
+	for _, c := range p.Clusters {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\n", c.Env, c.Cluster, c.Type, c.NodesRange, c.PodsRange, c.ServicesRange, c.MasterRange, c.MaxNodes, c.MaxPods, c.MaxServices, c.RequiredNodes)
+	}
+	w.Flush()
+}
+
+// envClusterType reads the cluster type configured for the environment in 2-multitenant.
+func envClusterType(eabCodePath, env string) string {
+	content, err := os.ReadFile(filepath.Join(eabCodePath, MultitenantStep, "envs", env, "main.tf"))
+	if err != nil {
+		return defaultClusterType
+	}
+	match := clusterTypeRe.FindStringSubmatch(string(content))
+	if len(match) == 0 {
+		return defaultClusterType
+	}
+	return match[1]
+}
+
+// clusterCapacity computes the maximum number of nodes, pods and services of a cluster.
+// Each node reserves a pods range with twice the addresses of its maximum number of pods.
+func clusterCapacity(clusterType, nodesRange, podsRange, servicesRange string) (int, int, int) {
+	podsPerNode := maxPodsPerNode[clusterType]
+	if podsPerNode == 0 {
+		podsPerNode = maxPodsPerNode[defaultClusterType]
+	}
+	nodeRangeSize := 1
+	for nodeRangeSize < podsPerNode*2 {
+		nodeRangeSize *= 2
+	}
+
+	maxNodes := rangeAddresses(nodesRange) - reservedSubnetIPs
+	if byPods := rangeAddresses(podsRange) / nodeRangeSize; byPods < maxNodes {
+		maxNodes = byPods
+	}
+	if maxNodes < 0 {
+		maxNodes = 0
+	}
+	return maxNodes, maxNodes * podsPerNode, rangeAddresses(servicesRange)
+}
+
+// requiredNodes is the minimum number of nodes of a cluster, one per zone for each node pool plus a surge node during upgrades.
+func requiredNodes(clusterType, region string) int {
+	nodes := regionalZones + 1
+	if clusterType != clusterTypeAutopilot && region == armRegion {
+		nodes += regionalZones + 1
+	}
+	return nodes
+}
+
+// rangeAddresses is the number of addresses of a CIDR range, an invalid range has no addresses.
+func rangeAddresses(ipRange string) int {
+	_, ipNet, err := net.ParseCIDR(ipRange)
+	if err != nil {
+		return 0
+	}
+	ones, bits := ipNet.Mask.Size()
+	return 1 << (bits - ones)
+}
+
+// appendRange parses the range and appends it to the ranges checked for overlaps.
+func appendRange(ranges []namedRange, p *IPPlan, r namedRange, ipRange string) []namedRange {
+	_, ipNet, err := net.ParseCIDR(ipRange)
+	if err != nil {
+		p.addFinding("Invalid range %s.", r.name)
+		return ranges
+	}
+	r.ipNet = ipNet
+	return append(ranges, r)
+}
+
+func overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// hasCloudNAT checks if any of the routers of the network has a Cloud NAT gateway.
+func hasCloudNAT(routers []gcp.Router, network string) bool {
+	for _, r := range routers {
+		if r.Network == network && len(r.NATs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsIngress checks if an enabled ingress rule allows the source range to reach the tcp port.
+func allowsIngress(rules []gcp.FirewallRule, source string, port int) bool {
+	_, sourceNet, err := net.ParseCIDR(source)
+	if err != nil {
+		return false
+	}
+	for _, r := range rules {
+		if r.Disabled || r.Direction != "INGRESS" || !containsRange(r.SourceRanges, sourceNet) {
+			continue
+		}
+		for _, a := range r.Allowed {
+			if (a.Protocol == "tcp" || a.Protocol == "all") && allowsPort(a.Ports, port) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// containsRange checks if any of the ranges contains the whole network.
+func containsRange(ranges []string, n *net.IPNet) bool {
+	ones, _ := n.Mask.Size()
+	for _, r := range ranges {
+		_, ipNet, err := net.ParseCIDR(r)
+		if err != nil {
+			continue
+		}
+		rOnes, _ := ipNet.Mask.Size()
+		if rOnes <= ones && ipNet.Contains(n.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsPort checks if the port is in the list of ports or port ranges, an empty list allows all ports.
+func allowsPort(ports []string, port int) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	for _, p := range ports {
+		first, last, isRange := strings.Cut(p, "-")
+		if !isRange {
+			last = first
+		}
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			continue
+		}
+		end, err := strconv.Atoi(last)
+		if err != nil {
+			continue
+		}
+		if port >= start && port <= end {
+			return true
+		}
+	}
+	return false
+}
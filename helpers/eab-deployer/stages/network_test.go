@@ -0,0 +1,201 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	gotest "testing"
+
+	"github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+)
+
+const (
+	devNetwork  = "https://www.googleapis.com/compute/v1/projects/prj-d-svpc/global/networks/vpc-d-svpc"
+	prodNetwork = "https://www.googleapis.com/compute/v1/projects/prj-p-svpc/global/networks/vpc-p-svpc"
+)
+
+// fakeNetwork is a NetworkInfo with fixed data.
+type fakeNetwork struct {
+	subnets    map[string]gcp.Subnetwork
+	routers    map[string][]gcp.Router
+	rules      map[string][]gcp.FirewallRule
+	workerPool gcp.WorkerPoolNetwork
+}
+
+func (f fakeNetwork) GetSubnetwork(t testing.TB, project, region, name string) gcp.Subnetwork {
+	return f.subnets[name]
+}
+
+func (f fakeNetwork) ListRouters(t testing.TB, project, region string) []gcp.Router {
+	return f.routers[fmt.Sprintf("%s/%s", project, region)]
+}
+
+func (f fakeNetwork) ListFirewallRules(t testing.TB, project, network string) []gcp.FirewallRule {
+	return f.rules[network]
+}
+
+func (f fakeNetwork) GetWorkerPoolNetwork(t testing.TB, project, region, name string) gcp.WorkerPoolNetwork {
+	return f.workerPool
+}
+
+func masterRule(network string, ports ...string) gcp.FirewallRule {
+	return gcp.FirewallRule{
+		Name:         "allow-masters",
+		Network:      network,
+		Direction:    "INGRESS",
+		SourceRanges: []string{"10.11.0.0/16"},
+		Allowed:      []gcp.FirewallAllowed{{Protocol: "tcp", Ports: ports}},
+	}
+}
+
+func TestIPRangeSize(t *gotest.T) {
+	assert.True(t, ipRangeSize("192.168.0.0/18", 18), "same size")
+	assert.True(t, ipRangeSize("10.0.0.0/16", 18), "larger range")
+	assert.False(t, ipRangeSize("192.168.0.0/20", 18), "smaller range")
+	assert.False(t, ipRangeSize("invalid", 18), "invalid range")
+}
+
+func TestClusterCapacity(t *gotest.T) {
+	nodes, pods, services := clusterCapacity("STANDARD-NAP", "10.1.20.0/24", "192.168.0.0/18", "192.168.64.0/20")
+	assert.Equal(t, 64, nodes, "a /18 has 64 node pod ranges of /24")
+	assert.Equal(t, 64*110, pods)
+	assert.Equal(t, 4096, services)
+
+	nodes, pods, _ = clusterCapacity(clusterTypeAutopilot, "10.1.20.0/24", "192.168.0.0/18", "192.168.64.0/20")
+	assert.Equal(t, 252, nodes, "autopilot nodes are limited by the primary range")
+	assert.Equal(t, 252*32, pods)
+
+	nodes, _, _ = clusterCapacity("STANDARD", "10.1.20.0/24", "", "")
+	assert.Equal(t, 0, nodes, "no pods range")
+}
+
+func TestAllowsPort(t *gotest.T) {
+	assert.True(t, allowsPort([]string{}, 443), "empty allows all")
+	assert.True(t, allowsPort([]string{"10000-11000"}, 10250))
+	assert.False(t, allowsPort([]string{"80", "8080"}, 443))
+}
+
+func TestAnalyzeIPPlan(t *gotest.T) {
+	eab := t.TempDir()
+	devDir := filepath.Join(eab, MultitenantStep, "envs", "development")
+	assert.NoError(t, os.MkdirAll(devDir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(devDir, "main.tf"), []byte("module \"env\" {\n  cluster_type = \"AUTOPILOT\"\n}\n"), 0644))
+
+	g := GlobalTFVars{
+		EABCodePath:  eab,
+		WorkerPoolID: "projects/prj-seed/locations/us-central1/workerPools/cb-pool",
+		Envs: map[string]Env{
+			"development": {
+				NetworkProjectID: "prj-d-svpc",
+				NetworkSelfLink:  devNetwork,
+				SubnetsSelfLinks: []string{
+					"https://www.googleapis.com/compute/v1/projects/prj-d-svpc/regions/us-central1/subnetworks/sb-d-us-central1",
+				},
+			},
+			"production": {
+				NetworkProjectID: "prj-p-svpc",
+				NetworkSelfLink:  prodNetwork,
+				SubnetsSelfLinks: []string{
+					"https://www.googleapis.com/compute/v1/projects/prj-p-svpc/regions/us-central1/subnetworks/sb-p-us-central1",
+					"https://www.googleapis.com/compute/v1/projects/prj-p-svpc/regions/us-east4/subnetworks/sb-p-us-east4",
+				},
+			},
+		},
+	}
+	n := fakeNetwork{
+		subnets: map[string]gcp.Subnetwork{
+			"sb-d-us-central1": {
+				Name:                  "sb-d-us-central1",
+				IPCIDRRange:           "10.1.20.0/24",
+				PrivateIPGoogleAccess: true,
+				SecondaryRanges:       []gcp.SecondaryRange{{Name: "pods", IPCIDRRange: "192.168.0.0/18"}, {Name: "services", IPCIDRRange: "192.168.64.0/18"}},
+			},
+			"sb-p-us-central1": {
+				Name:                  "sb-p-us-central1",
+				IPCIDRRange:           "10.1.20.0/24",
+				PrivateIPGoogleAccess: true,
+				SecondaryRanges:       []gcp.SecondaryRange{{Name: "pods", IPCIDRRange: "192.168.0.0/18"}, {Name: "services", IPCIDRRange: "192.168.64.0/18"}},
+			},
+			"sb-p-us-east4": {
+				Name:            "sb-p-us-east4",
+				IPCIDRRange:     "10.1.10.0/24",
+				SecondaryRanges: []gcp.SecondaryRange{{Name: "pods", IPCIDRRange: "192.168.64.0/23"}},
+			},
+		},
+		routers: map[string][]gcp.Router{
+			"prj-d-svpc/us-central1": {{Name: "nat-router", Network: devNetwork, NATs: []string{"cloud-nat"}}},
+			"prj-p-svpc/us-central1": {{Name: "nat-router", Network: prodNetwork, NATs: []string{"cloud-nat"}}},
+			"prj-p-svpc/us-east4":    {{Name: "router", Network: prodNetwork}},
+		},
+		rules: map[string][]gcp.FirewallRule{
+			devNetwork:  {masterRule(devNetwork, "443", "10000-11000")},
+			prodNetwork: {masterRule(prodNetwork, "443"), {Name: "disabled", Direction: "INGRESS", Disabled: true, SourceRanges: []string{"0.0.0.0/0"}}},
+		},
+		workerPool: gcp.WorkerPoolNetwork{
+			PeeredNetwork:        "projects/123/global/networks/vpc-b-pool",
+			PeeredNetworkIPRange: "/24",
+			AllocatedRanges:      []string{"10.1.20.128/25"},
+		},
+	}
+
+	p := AnalyzeIPPlan(t, g, n)
+
+	assert.Len(t, p.Clusters, 3, "one cluster per subnetwork")
+	assert.Equal(t, ClusterCapacity{
+		Env:           "development",
+		Cluster:       "cluster-us-central1-development",
+		Type:          clusterTypeAutopilot,
+		NodesRange:    "10.1.20.0/24",
+		PodsRange:     "192.168.0.0/18",
+		ServicesRange: "192.168.64.0/18",
+		MasterRange:   "10.11.10.0/28",
+		MaxNodes:      252,
+		MaxPods:       252 * 32,
+		MaxServices:   16384,
+		RequiredNodes: 4,
+	}, p.Clusters[0])
+	assert.Equal(t, "STANDARD-NAP", p.Clusters[1].Type, "default cluster type")
+	assert.Equal(t, 8, p.Clusters[1].RequiredNodes, "us-central1 has an ARM node pool")
+	assert.Equal(t, "10.11.20.0/28", p.Clusters[2].MasterRange)
+	assert.Equal(t, 2, p.Clusters[2].MaxNodes)
+
+	assert.Equal(t, []string{
+		"Subnetwork sb-p-us-east4 should have Private Google Access enabled.",
+		"Subnetwork sb-p-us-east4 should have at least 2 secondary ranges, the first for pods and the second for services.",
+		"Secondary range pods of subnetwork sb-p-us-east4 should be at least a /18. Current: 192.168.64.0/23",
+		"Cluster cluster-us-east4-production needs at least 4 nodes but its ranges only allow 2.",
+		"Network " + prodNetwork + " has no Cloud NAT in region us-east4, the private nodes will not be able to reach the internet.",
+		"Network " + prodNetwork + " has no firewall rule allowing the control plane range 10.11.10.0/28 to reach the nodes on tcp:10250.",
+		"Network " + prodNetwork + " has no firewall rule allowing the control plane range 10.11.20.0/28 to reach the nodes on tcp:10250.",
+		"Overlapping ranges: worker pool peering range 10.1.20.128/25 and sb-d-us-central1 primary range 10.1.20.0/24.",
+		"Overlapping ranges: worker pool peering range 10.1.20.128/25 and sb-p-us-central1 primary range 10.1.20.0/24.",
+		"Overlapping ranges in environments development and production, this is only an issue if the networks are connected: sb-d-us-central1 primary range 10.1.20.0/24 and sb-p-us-central1 primary range 10.1.20.0/24.",
+		"Overlapping ranges in environments development and production, this is only an issue if the networks are connected: sb-d-us-central1 secondary range pods 192.168.0.0/18 and sb-p-us-central1 secondary range pods 192.168.0.0/18.",
+		"Overlapping ranges in environments development and production, this is only an issue if the networks are connected: sb-d-us-central1 secondary range services 192.168.64.0/18 and sb-p-us-central1 secondary range services 192.168.64.0/18.",
+		"Overlapping ranges in environments development and production, this is only an issue if the networks are connected: sb-d-us-central1 secondary range services 192.168.64.0/18 and sb-p-us-east4 secondary range pods 192.168.64.0/23.",
+		"Overlapping ranges in environment production: sb-p-us-central1 secondary range services 192.168.64.0/18 and sb-p-us-east4 secondary range pods 192.168.64.0/23.",
+	}, p.Findings)
+
+	var out bytes.Buffer
+	validateNetworkRequirementes(t, g, n, &out)
+	assert.Contains(t, out.String(), "# Overlapping ranges in environment production")
+	assert.Contains(t, out.String(), "cluster-us-east4-production")
+}
@@ -0,0 +1,200 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+)
+
+const (
+	// targetNameLength is the maximum length of the Cloud Deploy target names of 5-appinfra/modules/cicd-pipeline.
+	targetNameLength = 21
+	// targetServiceLength is the length of the service name prefix of the Cloud Deploy target names.
+	targetServiceLength = 4
+	// nodeMachineCPUs are the vCPUs of the e2-standard-4 nodes of the node pool created by 2-multitenant.
+	nodeMachineCPUs = 4
+	// cpusQuota is the Compute Engine quota of the vCPUs of a region.
+	cpusQuota      = "CPUS"
+	regionStatusUp = "UP"
+)
+
+var subnetRegionRe = regexp.MustCompile(`projects/[^/]+/regions/([^/]+)/subnetworks/[^/]+$`)
+
+// RegionInfo is the information from Google Cloud needed to check the regions of the clusters.
+type RegionInfo interface {
+	ListRegions(t testing.TB, project string) []gcp.Region
+	ListFolderProjects(t testing.TB, folderID, name string) []string
+}
+
+// envRegions are the regions of the subnetworks of an environment in order, 2-multitenant creates a cluster in
+// each of them. The self links that are not valid are skipped, they are reported by AnalyzeIPPlan.
+func envRegions(e Env) []string {
+	regions := []string{}
+	for _, subnet := range e.SubnetsSelfLinks {
+		if m := subnetRegionRe.FindStringSubmatch(subnet); m != nil {
+			regions = append(regions, m[1])
+		}
+	}
+	return regions
+}
+
+// clusterName is the name of the cluster of 2-multitenant in a region of an environment.
+func clusterName(region, env string) string {
+	return fmt.Sprintf("cluster-%s-%s", region, env)
+}
+
+// targetName is the name of the Cloud Deploy target of a service for the cluster of a region of an environment.
+func targetName(service, region, env string) string {
+	name := fmt.Sprintf("%s-%s-%s", service[:min(len(service), targetServiceLength)], region, env)
+	return strings.TrimSuffix(name[:min(len(name), targetNameLength)], "-")
+}
+
+// RegionFindings checks the regions of the subnetworks of each environment against the regions of the
+// deployment, and the names of the clusters and of the Cloud Deploy targets of each region.
+func RegionFindings(g GlobalTFVars) []string {
+	findings := []string{}
+	for _, env := range slices.Sorted(maps.Keys(g.Envs)) {
+		regions := envRegions(g.Envs[env])
+		seen := []string{}
+		for _, r := range regions {
+			if slices.Contains(seen, r) {
+				findings = append(findings, fmt.Sprintf("Environment %s has more than one subnetwork in region %s, both clusters would be named %s.", env, r, clusterName(r, env)))
+				continue
+			}
+			seen = append(seen, r)
+		}
+		if len(g.Regions) == 0 {
+			continue
+		}
+		for _, r := range g.Regions {
+			if !slices.Contains(seen, r) {
+				findings = append(findings, fmt.Sprintf("Environment %s has no subnetwork in region %s.", env, r))
+			}
+		}
+		for _, r := range seen {
+			if !slices.Contains(g.Regions, r) {
+				findings = append(findings, fmt.Sprintf("Environment %s has a subnetwork in region %s that is not in regions.", env, r))
+			}
+		}
+		if len(seen) == len(g.Regions) && !slices.Equal(seen, g.Regions) && !slices.ContainsFunc(seen, func(r string) bool { return !slices.Contains(g.Regions, r) }) {
+			findings = append(findings, fmt.Sprintf("The subnetworks of environment %s are in the regions %s, they must be in the order of regions %s, the releases are promoted in the order of the subnetworks.", env, strings.Join(seen, ", "), strings.Join(g.Regions, ", ")))
+		}
+	}
+
+	services := []string{}
+	for _, app := range slices.Sorted(maps.Keys(g.Applications)) {
+		for _, service := range slices.Sorted(maps.Keys(g.Applications[app])) {
+			if !slices.Contains(services, service) {
+				services = append(services, service)
+			}
+		}
+	}
+	for _, service := range services {
+		clusters := map[string]string{}
+		for _, env := range slices.Sorted(maps.Keys(g.Envs)) {
+			for _, region := range envRegions(g.Envs[env]) {
+				name := targetName(service, region, env)
+				cluster := clusterName(region, env)
+				if other, ok := clusters[name]; ok && other != cluster {
+					findings = append(findings, fmt.Sprintf("The Cloud Deploy targets of service %s for clusters %s and %s have the same name %s, the names are truncated to %d characters.", service, other, cluster, name, targetNameLength))
+					continue
+				}
+				clusters[name] = cluster
+			}
+		}
+	}
+	return findings
+}
+
+// checkRegions returns an error with the findings of the regions when the regions of the deployment are set.
+func checkRegions(g GlobalTFVars) error {
+	if len(g.Regions) == 0 {
+		return nil
+	}
+	errs := []error{}
+	for _, f := range RegionFindings(g) {
+		errs = append(errs, errors.New(f))
+	}
+	return errors.Join(errs...)
+}
+
+// requiredCPUs are the vCPUs needed by the nodes of a cluster, one node per zone and a surge node.
+func requiredCPUs(clusterType string) int {
+	if clusterType == clusterTypeAutopilot {
+		// the nodes of Autopilot depend on the workloads
+		return 0
+	}
+	return (regionalZones + 1) * nodeMachineCPUs
+}
+
+// QuotaFindings checks that the regions of the clusters exist, their status and their CPU quota. The quota is checked in
+// the cluster project of each environment created by 2-multitenant, before it exists only the status of the
+// regions is checked in the network project.
+func QuotaFindings(t testing.TB, g GlobalTFVars, info RegionInfo) []string {
+	findings := []string{}
+	for _, env := range slices.Sorted(maps.Keys(g.Envs)) {
+		e := g.Envs[env]
+		project := e.NetworkProjectID
+		clusterProject := false
+		if projects := info.ListFolderProjects(t, e.FolderID, fmt.Sprintf("eab-gke-%s", env)); len(projects) > 0 {
+			project = projects[0]
+			clusterProject = true
+		}
+		projectRegions := info.ListRegions(t, project)
+		regions := slices.Compact(slices.Sorted(slices.Values(append(envRegions(e), g.Regions...))))
+		for _, region := range regions {
+			j := slices.IndexFunc(projectRegions, func(r gcp.Region) bool { return r.Name == region })
+			if j < 0 {
+				findings = append(findings, fmt.Sprintf("Region %s of environment %s does not exist in project %s.", region, env, project))
+				continue
+			}
+			r := projectRegions[j]
+			if r.Status != regionStatusUp {
+				findings = append(findings, fmt.Sprintf("Region %s of environment %s is %s.", region, env, r.Status))
+				continue
+			}
+			required := requiredCPUs(envClusterType(g.EABCodePath, env))
+			if !clusterProject || required == 0 {
+				continue
+			}
+			i := slices.IndexFunc(r.Quotas, func(q gcp.RegionQuota) bool { return q.Metric == cpusQuota })
+			if i < 0 {
+				continue
+			}
+			if available := int(r.Quotas[i].Limit - r.Quotas[i].Usage); available < required {
+				findings = append(findings, fmt.Sprintf("The cluster of region %s of environment %s needs %d %s but project %s has %d available.", region, env, required, cpusQuota, project, available))
+			}
+		}
+	}
+	return findings
+}
+
+// ValidateRegions checks the regions of the clusters of each environment, the findings are written to out.
+func ValidateRegions(t testing.TB, g GlobalTFVars, info RegionInfo, out io.Writer) {
+	fmt.Fprintln(out, "# Checking the regions of the clusters.")
+	for _, f := range append(RegionFindings(g), QuotaFindings(t, g, info)...) {
+		fmt.Fprintf(out, "# %s\n", f)
+	}
+}
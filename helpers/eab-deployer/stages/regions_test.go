@@ -0,0 +1,151 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	gotest "testing"
+
+	"github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+)
+
+// fakeRegions is a RegionInfo with fixed data.
+type fakeRegions struct {
+	regions  map[string]gcp.Region
+	projects map[string][]string
+}
+
+func (f fakeRegions) ListRegions(t testing.TB, project string) []gcp.Region {
+	regions := []gcp.Region{}
+	for _, key := range slices.Sorted(maps.Keys(f.regions)) {
+		if p, name, _ := strings.Cut(key, "/"); p == project {
+			r := f.regions[key]
+			r.Name = name
+			regions = append(regions, r)
+		}
+	}
+	return regions
+}
+
+func (f fakeRegions) ListFolderProjects(t testing.TB, folderID, name string) []string {
+	return f.projects[fmt.Sprintf("%s/%s", folderID, name)]
+}
+
+func subnet(project, region string) string {
+	return fmt.Sprintf("https://www.googleapis.com/compute/v1/projects/%s/regions/%s/subnetworks/eab-%s", project, region, region)
+}
+
+func TestTargetName(t *gotest.T) {
+	assert.Equal(t, "hell-us-central1-deve", targetName("hello-world", "us-central1", "development"))
+	assert.Equal(t, "web-us-east4-prod", targetName("web", "us-east4", "prod"))
+	assert.Equal(t, "hell-asia-northeast1", targetName("hello-world", "asia-northeast1", "production"), "the trailing dash is trimmed")
+}
+
+func TestRegionFindings(t *gotest.T) {
+	g := GlobalTFVars{
+		Envs: map[string]Env{
+			"development": {SubnetsSelfLinks: []string{subnet("prj-d-svpc", "us-central1"), subnet("prj-d-svpc", "us-east4")}},
+			"production":  {SubnetsSelfLinks: []string{subnet("prj-p-svpc", "us-central1"), subnet("prj-p-svpc", "us-east4")}},
+		},
+		Applications: map[string]map[string]ApplicationService{"default-example": {"hello-world": {}}},
+	}
+	assert.Empty(t, RegionFindings(g))
+	assert.NoError(t, checkRegions(g))
+
+	g.Regions = []string{"us-central1", "us-east4"}
+	assert.Empty(t, RegionFindings(g))
+
+	g.Regions = []string{"us-east4", "us-central1"}
+	findings := RegionFindings(g)
+	assert.Len(t, findings, 2)
+	assert.Contains(t, findings[0], "must be in the order of regions us-east4, us-central1")
+	assert.Error(t, checkRegions(g))
+
+	g.Regions = []string{"us-central1", "us-west1"}
+	assert.Equal(t, []string{
+		"Environment development has no subnetwork in region us-west1.",
+		"Environment development has a subnetwork in region us-east4 that is not in regions.",
+		"Environment production has no subnetwork in region us-west1.",
+		"Environment production has a subnetwork in region us-east4 that is not in regions.",
+	}, RegionFindings(g))
+
+	g.Regions = nil
+	g.Envs["development"] = Env{SubnetsSelfLinks: []string{subnet("prj-d-svpc", "us-central1"), subnet("prj-d-svpc", "us-central1")}}
+	assert.Equal(t, []string{"Environment development has more than one subnetwork in region us-central1, both clusters would be named cluster-us-central1-development."}, RegionFindings(g))
+	assert.NoError(t, checkRegions(g), "the regions are only enforced when set")
+
+	g.Envs["development"] = Env{SubnetsSelfLinks: []string{subnet("prj-d-svpc", "northamerica-northeast1"), subnet("prj-d-svpc", "northamerica-northeast2")}}
+	assert.Equal(t, []string{"The Cloud Deploy targets of service hello-world for clusters cluster-northamerica-northeast1-development and cluster-northamerica-northeast2-development have the same name hell-northamerica-nor, the names are truncated to 21 characters."}, RegionFindings(g))
+}
+
+func TestQuotaFindings(t *gotest.T) {
+	g := GlobalTFVars{
+		Envs: map[string]Env{
+			"development": {FolderID: "folders/111", NetworkProjectID: "prj-d-svpc", SubnetsSelfLinks: []string{subnet("prj-d-svpc", "us-central1"), subnet("prj-d-svpc", "us-east4")}},
+		},
+	}
+	cpus := func(limit, usage float64) []gcp.RegionQuota {
+		return []gcp.RegionQuota{{Metric: "CPUS", Limit: limit, Usage: usage}}
+	}
+	info := fakeRegions{
+		regions: map[string]gcp.Region{
+			"prj-d-svpc/us-central1": {Status: "UP"},
+			"prj-d-svpc/us-east4":    {Status: "DOWN"},
+		},
+		projects: map[string][]string{},
+	}
+	assert.Equal(t, []string{"Region us-east4 of environment development is DOWN."}, QuotaFindings(t, g, info))
+
+	g.Regions = []string{"us-centrall1"}
+	assert.Equal(t, []string{
+		"Region us-centrall1 of environment development does not exist in project prj-d-svpc.",
+		"Region us-east4 of environment development is DOWN.",
+	}, QuotaFindings(t, g, info), "a mistyped region should be a finding")
+	g.Regions = nil
+
+	info.projects["folders/111/eab-gke-development"] = []string{"eab-gke-development-a1b2"}
+	info.regions["eab-gke-development-a1b2/us-central1"] = gcp.Region{Status: "UP", Quotas: cpus(24, 12)}
+	info.regions["eab-gke-development-a1b2/us-east4"] = gcp.Region{Status: "UP", Quotas: cpus(24, 0)}
+	assert.Equal(t, []string{"The cluster of region us-central1 of environment development needs 16 CPUS but project eab-gke-development-a1b2 has 12 available."}, QuotaFindings(t, g, info))
+
+	var out bytes.Buffer
+	ValidateRegions(t, g, info, &out)
+	assert.Equal(t, "# Checking the regions of the clusters.\n# The cluster of region us-central1 of environment development needs 16 CPUS but project eab-gke-development-a1b2 has 12 available.\n", out.String())
+}
+
+func TestMultitenantTfvarsRegions(t *gotest.T) {
+	mode := "ENFORCE"
+	g := GlobalTFVars{
+		WorkerPoolID:         "projects/prj-c-workerpool/locations/us-central1/workerPools/cb-pool",
+		ServicePerimeterMode: &mode,
+		Regions:              []string{"us-central1", "us-east4"},
+		Envs: map[string]Env{
+			"development": {SubnetsSelfLinks: []string{subnet("prj-d-svpc", "us-central1")}},
+		},
+	}
+	_, err := multitenantTfvars(g)
+	assert.ErrorContains(t, err, "Environment development has no subnetwork in region us-east4.")
+
+	g.Envs["development"] = Env{SubnetsSelfLinks: []string{subnet("prj-d-svpc", "us-central1"), subnet("prj-d-svpc", "us-east4")}}
+	_, err = multitenantTfvars(g)
+	assert.NoError(t, err)
+}
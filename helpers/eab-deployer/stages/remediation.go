@@ -0,0 +1,149 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+)
+
+// Fixer is the information from Google Cloud and the operations needed to fix the validation findings.
+type Fixer interface {
+	IsComponentInstalled(t testing.TB, componentID string) bool
+	InstallComponents(t testing.TB, components []string) error
+	IsApiEnabled(t testing.TB, project, api string) bool
+	EnableApis(t testing.TB, project string, apis []string)
+	GetSubnetwork(t testing.TB, project, region, name string) gcp.Subnetwork
+	EnablePrivateGoogleAccess(t testing.TB, project, region, name string) error
+	GetOrgACMPolicyID(t testing.TB, orgID string) string
+	CreateOrgACMPolicy(t testing.TB, orgID string) error
+}
+
+// Remediation is an action that fixes a validation finding.
+type Remediation struct {
+	Finding string
+	Action  string
+	apply   func(t testing.TB) error
+}
+
+// RemediationPlan is the list of actions that fix the validation findings.
+type RemediationPlan []Remediation
+
+// BuildRemediationPlan checks the validation findings that can be fixed by the helper and creates the plan to fix them.
+func BuildRemediationPlan(t testing.TB, g GlobalTFVars, f Fixer) RemediationPlan {
+	plan := RemediationPlan{}
+
+	// gcloud components
+	components := []string{}
+	for _, c := range requiredComponents {
+		if !f.IsComponentInstalled(t, c) {
+			components = append(components, c)
+		}
+	}
+	if len(components) > 0 {
+		plan = append(plan, Remediation{
+			Finding: fmt.Sprintf("Missing Google Cloud SDK components: %s", strings.Join(components, ", ")),
+			Action:  fmt.Sprintf("gcloud components install %s", strings.Join(components, " ")),
+			apply: func(t testing.TB) error {
+				return f.InstallComponents(t, components)
+			},
+		})
+	}
+
+	// seed project APIs
+	apis := []string{}
+	for _, api := range requiredAPIs {
+		if !f.IsApiEnabled(t, g.ProjectID, api) {
+			apis = append(apis, api)
+		}
+	}
+	if len(apis) > 0 {
+		plan = append(plan, Remediation{
+			Finding: fmt.Sprintf("Project %s is missing required APIs: %s", g.ProjectID, strings.Join(apis, ", ")),
+			Action:  fmt.Sprintf("gcloud services enable %s --project %s", strings.Join(apis, " "), g.ProjectID),
+			apply: func(t testing.TB) error {
+				f.EnableApis(t, g.ProjectID, apis)
+				return nil
+			},
+		})
+	}
+
+	// Private Google Access of the cluster subnetworks
+	for _, env := range slices.Sorted(maps.Keys(g.Envs)) {
+		for _, subnet := range g.Envs[env].SubnetsSelfLinks {
+			s, err := extractInfoWithRegex(subnet, `projects/(?P<project>[^/]+)/regions/(?P<region>[^/]+)/subnetworks/(?P<subnet>[^/]+)`)
+			if err != nil {
+				continue
+			}
+			if f.GetSubnetwork(t, s["project"], s["region"], s["subnet"]).PrivateIPGoogleAccess {
+				continue
+			}
+			plan = append(plan, Remediation{
+				Finding: fmt.Sprintf("Subnetwork %s should have Private Google Access enabled.", s["subnet"]),
+				Action:  fmt.Sprintf("gcloud compute networks subnets update %s --region=%s --project=%s --enable-private-ip-google-access", s["subnet"], s["region"], s["project"]),
+				apply: func(t testing.TB) error {
+					return f.EnablePrivateGoogleAccess(t, s["project"], s["region"], s["subnet"])
+				},
+			})
+		}
+	}
+
+	// Access Context Manager policy used by the service perimeter
+	if g.ServicePerimeterName != nil && *g.ServicePerimeterName != "" && f.GetOrgACMPolicyID(t, g.OrgID) == "" {
+		plan = append(plan, Remediation{
+			Finding: fmt.Sprintf("Organization %s has no Access Context Manager policy for the service perimeter.", g.OrgID),
+			Action:  fmt.Sprintf("gcloud access-context-manager policies create --organization %s --title 'Organization access level policy'", g.OrgID),
+			apply: func(t testing.TB) error {
+				return f.CreateOrgACMPolicy(t, g.OrgID)
+			},
+		})
+	}
+
+	return plan
+}
+
+// Print writes the plan findings and actions.
+func (p RemediationPlan) Print(w io.Writer) {
+	if len(p) == 0 {
+		fmt.Fprintln(w, "# No findings that can be fixed by the helper.")
+		return
+	}
+	fmt.Fprintln(w, "# Remediation plan:")
+	for i, r := range p {
+		fmt.Fprintf(w, "# %d. %s\n", i+1, r.Finding)
+		fmt.Fprintf(w, "#    %s\n", r.Action)
+	}
+}
+
+// Apply runs the actions of the plan. All the actions are run, even if one of them fails.
+func (p RemediationPlan) Apply(t testing.TB, w io.Writer) error {
+	var errs []error
+	for i, r := range p {
+		fmt.Fprintf(w, "# Applying %d/%d: %s\n", i+1, len(p), r.Action)
+		if err := r.apply(t); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.Action, err))
+			fmt.Fprintf(w, "# Failed: %s\n", err.Error())
+		}
+	}
+	return errors.Join(errs...)
+}
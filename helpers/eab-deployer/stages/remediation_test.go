@@ -0,0 +1,146 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	gotest "testing"
+
+	"github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+)
+
+// fakeFixer records the fixes and reports the fixed findings as resolved.
+type fakeFixer struct {
+	components []string
+	apis       []string
+	pga        []string
+	acmPolicy  string
+	failPGA    bool
+	applied    []string
+}
+
+func (f *fakeFixer) IsComponentInstalled(t testing.TB, componentID string) bool {
+	return slices.Contains(f.components, componentID)
+}
+
+func (f *fakeFixer) InstallComponents(t testing.TB, components []string) error {
+	f.components = append(f.components, components...)
+	f.applied = append(f.applied, fmt.Sprintf("components %v", components))
+	return nil
+}
+
+func (f *fakeFixer) IsApiEnabled(t testing.TB, project, api string) bool {
+	return slices.Contains(f.apis, api)
+}
+
+func (f *fakeFixer) EnableApis(t testing.TB, project string, apis []string) {
+	f.apis = append(f.apis, apis...)
+	f.applied = append(f.applied, fmt.Sprintf("apis %s %d", project, len(apis)))
+}
+
+func (f *fakeFixer) GetSubnetwork(t testing.TB, project, region, name string) gcp.Subnetwork {
+	return gcp.Subnetwork{Name: name, PrivateIPGoogleAccess: slices.Contains(f.pga, name)}
+}
+
+func (f *fakeFixer) EnablePrivateGoogleAccess(t testing.TB, project, region, name string) error {
+	if f.failPGA {
+		return fmt.Errorf("permission denied")
+	}
+	f.pga = append(f.pga, name)
+	f.applied = append(f.applied, fmt.Sprintf("pga %s/%s/%s", project, region, name))
+	return nil
+}
+
+func (f *fakeFixer) GetOrgACMPolicyID(t testing.TB, orgID string) string {
+	return f.acmPolicy
+}
+
+func (f *fakeFixer) CreateOrgACMPolicy(t testing.TB, orgID string) error {
+	f.acmPolicy = "123"
+	f.applied = append(f.applied, fmt.Sprintf("acm %s", orgID))
+	return nil
+}
+
+func remediationTestConfig() GlobalTFVars {
+	perimeter := "accessPolicies/REPLACE_ME/servicePerimeters/eab"
+	return GlobalTFVars{
+		OrgID:                "123456789012",
+		ProjectID:            "prj-seed",
+		ServicePerimeterName: &perimeter,
+		Envs: map[string]Env{
+			"development": {SubnetsSelfLinks: []string{"https://www.googleapis.com/compute/v1/projects/prj-d-svpc/regions/us-central1/subnetworks/sb-d-us-central1"}},
+			"production":  {SubnetsSelfLinks: []string{"https://www.googleapis.com/compute/v1/projects/prj-p-svpc/regions/us-central1/subnetworks/sb-p-us-central1"}},
+		},
+	}
+}
+
+func TestBuildRemediationPlan(t *gotest.T) {
+	g := remediationTestConfig()
+	f := &fakeFixer{
+		components: []string{"beta"},
+		apis:       slices.Clone(requiredAPIs[2:]),
+		pga:        []string{"sb-p-us-central1"},
+	}
+
+	plan := BuildRemediationPlan(t, g, f)
+	var out bytes.Buffer
+	plan.Print(&out)
+	assert.Equal(t, `# Remediation plan:
+# 1. Missing Google Cloud SDK components: terraform-tools
+#    gcloud components install terraform-tools
+# 2. Project prj-seed is missing required APIs: accesscontextmanager.googleapis.com, artifactregistry.googleapis.com
+#    gcloud services enable accesscontextmanager.googleapis.com artifactregistry.googleapis.com --project prj-seed
+# 3. Subnetwork sb-d-us-central1 should have Private Google Access enabled.
+#    gcloud compute networks subnets update sb-d-us-central1 --region=us-central1 --project=prj-d-svpc --enable-private-ip-google-access
+# 4. Organization 123456789012 has no Access Context Manager policy for the service perimeter.
+#    gcloud access-context-manager policies create --organization 123456789012 --title 'Organization access level policy'
+`, out.String())
+
+	out.Reset()
+	assert.NoError(t, plan.Apply(t, &out))
+	assert.Equal(t, []string{
+		"components [terraform-tools]",
+		"apis prj-seed 2",
+		"pga prj-d-svpc/us-central1/sb-d-us-central1",
+		"acm 123456789012",
+	}, f.applied)
+
+	assert.Empty(t, BuildRemediationPlan(t, g, f), "all the findings are fixed")
+	out.Reset()
+	RemediationPlan{}.Print(&out)
+	assert.Equal(t, "# No findings that can be fixed by the helper.\n", out.String())
+}
+
+func TestApplyRemediationPlanErrors(t *gotest.T) {
+	f := &fakeFixer{
+		components: slices.Clone(requiredComponents),
+		apis:       slices.Clone(requiredAPIs),
+		failPGA:    true,
+		acmPolicy:  "123",
+	}
+	plan := BuildRemediationPlan(t, remediationTestConfig(), f)
+	assert.Len(t, plan, 2)
+
+	var out bytes.Buffer
+	err := plan.Apply(t, &out)
+	assert.ErrorContains(t, err, "sb-d-us-central1 --region=us-central1 --project=prj-d-svpc --enable-private-ip-google-access: permission denied")
+	assert.ErrorContains(t, err, "sb-p-us-central1 --region=us-central1 --project=prj-p-svpc --enable-private-ip-google-access: permission denied")
+	assert.Contains(t, out.String(), "# Applying 2/2: ")
+}
@@ -0,0 +1,475 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+)
+
+const (
+	repoTypeCSR    = "CSR"
+	repoTypeGitHub = "GITHUBv2"
+	repoTypeGitLab = "GITLABv2"
+
+	defaultGitHubHost = "https://github.com"
+	defaultGitLabHost = "https://gitlab.com"
+	csrCredentialKey  = "credential.https://source.developers.google.com.helper"
+
+	// defaultConnectionLocation is the default region of the Cloud Build connections created by the cloudbuild_repo_connection module.
+	defaultConnectionLocation = "us-central1"
+
+	// GitLab access levels, see https://docs.gitlab.com/api/access_requests/#valid-access-levels
+	gitlabDeveloperAccess  = 30
+	gitlabMaintainerAccess = 40
+)
+
+var serviceDirectoryServiceRe = regexp.MustCompile(`^projects/(?P<project>[^/]+)/locations/(?P<location>[^/]+)/namespaces/(?P<namespace>[^/]+)/services/[^/]+$`)
+
+// RepositoryHostInfo is the information from Google Cloud needed to check the repositories.
+type RepositoryHostInfo interface {
+	GetSecretValue(t testing.TB, secretID string) string
+	IsApiEnabled(t testing.TB, project, api string) bool
+	ListConnections(t testing.TB, project, region string) []gcp.Connection
+	ListServiceDirectoryServices(t testing.TB, project, location, namespace string) []string
+}
+
+// RepositoryChecker checks if the repositories of a repository config can be used by the deployment.
+type RepositoryChecker struct {
+	info      RepositoryHostInfo
+	lookupIP  func(host string) ([]net.IP, error)
+	gitConfig func(key string) []string
+	timeout   time.Duration
+	// connectionLocation is the region of the Cloud Build connections.
+	connectionLocation string
+}
+
+// NewRepositoryChecker creates a RepositoryChecker that uses gcloud, the system resolver and the local git configuration.
+func NewRepositoryChecker(info RepositoryHostInfo) RepositoryChecker {
+	return RepositoryChecker{
+		info:               info,
+		lookupIP:           net.LookupIP,
+		gitConfig:          localGitConfig,
+		timeout:            30 * time.Second,
+		connectionLocation: defaultConnectionLocation,
+	}
+}
+
+// repositoryAccess is the access of the token to a repository.
+type repositoryAccess struct {
+	canPush  bool
+	canHooks bool
+	id       string
+}
+
+// Check checks the repositories of the config and returns the findings.
+// The branches are the branches the helper pushes to each repository.
+// When connectionProject is not empty, the Cloud Build connections of the project are checked for the repositories host.
+func (r RepositoryChecker) Check(t testing.TB, name string, config CloudbuildV2RepositoryConfig, branches []string, connectionProject string) []string {
+	switch config.RepoType {
+	case repoTypeCSR:
+		return r.checkCSR(t, name, connectionProject)
+	case repoTypeGitHub:
+		// GitHub token scopes are checked with the repository, they are returned in the X-OAuth-Scopes header
+		return r.checkHost(t, name, config, branches, connectionProject, nil, r.githubRepository, r.githubBranch)
+	case repoTypeGitLab:
+		return r.checkHost(t, name, config, branches, connectionProject, r.checkGitLabToken, r.gitlabRepository, r.gitlabBranch)
+	default:
+		return []string{fmt.Sprintf("%s: unknown repository type %q, valid types are %s, %s and %s.", name, config.RepoType, repoTypeCSR, repoTypeGitHub, repoTypeGitLab)}
+	}
+}
+
+func (r RepositoryChecker) checkCSR(t testing.TB, name, project string) []string {
+	findings := []string{}
+	if project != "" && !r.info.IsApiEnabled(t, project, "sourcerepo.googleapis.com") {
+		findings = append(findings, fmt.Sprintf("%s: API sourcerepo.googleapis.com must be enabled in project %s to use Cloud Source Repositories.", name, project))
+	}
+	helpers := r.gitConfig(csrCredentialKey)
+	if !slices.ContainsFunc(helpers, func(h string) bool { return strings.Contains(h, "gcloud") }) {
+		findings = append(findings, fmt.Sprintf("%s: git is not configured to use gcloud as the credential helper for Cloud Source Repositories, run: git config --global %s gcloud.sh", name, csrCredentialKey))
+	}
+	return findings
+}
+
+type tokenCheck func(t testing.TB, client *http.Client, apiURL string, config CloudbuildV2RepositoryConfig) []string
+type repositoryCheck func(client *http.Client, apiURL, token, path string) (repositoryAccess, int, error)
+type branchCheck func(client *http.Client, apiURL, token string, access repositoryAccess, path, branch string) (bool, error)
+
+func (r RepositoryChecker) checkHost(t testing.TB, name string, config CloudbuildV2RepositoryConfig, branches []string, connectionProject string, checkToken tokenCheck, getRepository repositoryCheck, hasBranch branchCheck) []string {
+	findings := []string{}
+	hostURI, apiURL := hostURLs(config)
+
+	client, err := r.httpClient(config)
+	if err != nil {
+		return append(findings, fmt.Sprintf("%s: %s", name, err.Error()))
+	}
+
+	findings = append(findings, r.checkReachability(t, name, config, hostURI)...)
+	if connectionProject != "" {
+		findings = append(findings, r.checkConnection(t, name, connectionProject, hostURI)...)
+	}
+
+	secretID := tokenSecretID(config)
+	if secretID == nil || *secretID == "" {
+		return append(findings, fmt.Sprintf("%s: the secret with the %s token is not set.", name, config.RepoType))
+	}
+	token := r.info.GetSecretValue(t, *secretID)
+	if token == "" {
+		return append(findings, fmt.Sprintf("%s: the secret %s has no value.", name, *secretID))
+	}
+	if checkToken != nil {
+		for _, f := range checkToken(t, client, apiURL, config) {
+			findings = append(findings, fmt.Sprintf("%s: %s", name, f))
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(config.Repositories)) {
+		repo := config.Repositories[key]
+		u, err := url.Parse(repo.RepositoryURL)
+		if err != nil || u.Host == "" {
+			findings = append(findings, fmt.Sprintf("%s: repository %s has an invalid URL %q.", name, key, repo.RepositoryURL))
+			continue
+		}
+		if !strings.EqualFold(u.Host, hostOf(hostURI)) {
+			findings = append(findings, fmt.Sprintf("%s: repository %s URL %s is not in host %s.", name, key, repo.RepositoryURL, hostURI))
+			continue
+		}
+		path := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+
+		access, status, err := getRepository(client, apiURL, token, path)
+		if err != nil {
+			findings = append(findings, fmt.Sprintf("%s: failed to check repository %s: %s", name, repo.RepositoryURL, err.Error()))
+			continue
+		}
+		if status != http.StatusOK {
+			findings = append(findings, fmt.Sprintf("%s: repository %s is not accessible with the token, status: %d.", name, repo.RepositoryURL, status))
+			continue
+		}
+		if !access.canPush {
+			findings = append(findings, fmt.Sprintf("%s: the token cannot push to repository %s.", name, repo.RepositoryURL))
+		}
+		if !access.canHooks {
+			findings = append(findings, fmt.Sprintf("%s: the token cannot manage the webhooks of repository %s.", name, repo.RepositoryURL))
+		}
+
+		for _, branch := range branches {
+			exists, err := hasBranch(client, apiURL, token, access, path, branch)
+			if err != nil {
+				findings = append(findings, fmt.Sprintf("%s: failed to check branch %s of repository %s: %s", name, branch, repo.RepositoryURL, err.Error()))
+				continue
+			}
+			if !exists && !access.canPush {
+				findings = append(findings, fmt.Sprintf("%s: branch %s does not exist in repository %s and cannot be created with the token.", name, branch, repo.RepositoryURL))
+			}
+		}
+	}
+	return findings
+}
+
+// checkReachability checks if the host can be reached by the Cloud Build connection.
+func (r RepositoryChecker) checkReachability(t testing.TB, name string, config CloudbuildV2RepositoryConfig, hostURI string) []string {
+	if config.GitlabEnterpriseServiceDirectory != nil && *config.GitlabEnterpriseServiceDirectory != "" {
+		sd := *config.GitlabEnterpriseServiceDirectory
+		parts, err := extractInfoWithRegex(sd, serviceDirectoryServiceRe.String())
+		if err != nil {
+			return []string{fmt.Sprintf("%s: invalid Service Directory service %q, the format is projects/PROJECT/locations/LOCATION/namespaces/NAMESPACE/services/SERVICE.", name, sd)}
+		}
+		if !slices.Contains(r.info.ListServiceDirectoryServices(t, parts["project"], parts["location"], parts["namespace"]), sd) {
+			return []string{fmt.Sprintf("%s: Service Directory service %s used to reach %s does not exist.", name, sd, hostURI)}
+		}
+		return []string{}
+	}
+
+	host := hostOf(hostURI)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ips, err := r.lookupIP(host)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: host %s cannot be resolved: %s", name, host, err.Error())}
+	}
+	if len(ips) > 0 && !slices.ContainsFunc(ips, func(ip net.IP) bool { return !ip.IsPrivate() && !ip.IsLoopback() }) {
+		return []string{fmt.Sprintf("%s: host %s only has private addresses, the Cloud Build connection needs gitlab_enterprise_service_directory to reach it.", name, host)}
+	}
+	return []string{}
+}
+
+// checkConnection checks if an existing Cloud Build connection to the host is installed.
+func (r RepositoryChecker) checkConnection(t testing.TB, name, project, hostURI string) []string {
+	for _, c := range r.info.ListConnections(t, project, r.connectionLocation) {
+		if !strings.EqualFold(strings.TrimSuffix(c.HostURI, "/"), hostURI) {
+			continue
+		}
+		if c.Stage != "" && c.Stage != "COMPLETE" {
+			return []string{fmt.Sprintf("%s: Cloud Build connection %s to %s is not installed, stage: %s. %s", name, c.Name, hostURI, c.Stage, c.Message)}
+		}
+		return []string{}
+	}
+	return []string{fmt.Sprintf("%s: there is no Cloud Build connection to %s in project %s region %s, it will be created by %s.", name, hostURI, project, r.connectionLocation, BootstrapStep)}
+}
+
+func (r RepositoryChecker) httpClient(config CloudbuildV2RepositoryConfig) (*http.Client, error) {
+	client := &http.Client{Timeout: r.timeout}
+	if config.GitlabEnterpriseCACertificate == nil || *config.GitlabEnterpriseCACertificate == "" {
+		return client, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM([]byte(*config.GitlabEnterpriseCACertificate)) {
+		return nil, fmt.Errorf("gitlab_enterprise_ca_certificate has no valid PEM certificate")
+	}
+	client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}}
+	return client, nil
+}
+
+// githubRepository gets the token permissions in a repository. Classic tokens are also checked for the repo scopes.
+func (r RepositoryChecker) githubRepository(client *http.Client, apiURL, token, path string) (repositoryAccess, int, error) {
+	var repo struct {
+		Permissions struct {
+			Admin bool `json:"admin"`
+			Push  bool `json:"push"`
+		} `json:"permissions"`
+	}
+	header, status, err := apiGet(client, fmt.Sprintf("%s/repos/%s", apiURL, path), "Authorization", "Bearer "+token, &repo)
+	if err != nil || status != http.StatusOK {
+		return repositoryAccess{}, status, err
+	}
+	access := repositoryAccess{canPush: repo.Permissions.Push, canHooks: repo.Permissions.Admin, id: path}
+	// fine-grained tokens have no scopes header
+	if scopesHeader, ok := header["X-Oauth-Scopes"]; ok {
+		scopes := splitScopes(strings.Join(scopesHeader, ","))
+		access.canPush = access.canPush && (slices.Contains(scopes, "repo") || slices.Contains(scopes, "public_repo"))
+		access.canHooks = access.canHooks && (slices.Contains(scopes, "repo") || slices.Contains(scopes, "admin:repo_hook"))
+	}
+	return access, status, nil
+}
+
+func (r RepositoryChecker) githubBranch(client *http.Client, apiURL, token string, access repositoryAccess, path, branch string) (bool, error) {
+	_, status, err := apiGet(client, fmt.Sprintf("%s/repos/%s/branches/%s", apiURL, path, url.PathEscape(branch)), "Authorization", "Bearer "+token, nil)
+	return status == http.StatusOK, err
+}
+
+// checkGitLabToken checks the scopes of the GitLab tokens, the authorizer token needs the api scope and the read token the read_api scope.
+func (r RepositoryChecker) checkGitLabToken(t testing.TB, client *http.Client, apiURL string, config CloudbuildV2RepositoryConfig) []string {
+	findings := []string{}
+	tokens := []struct {
+		secretID *string
+		scope    string
+	}{
+		{config.GitlabAuthorizerCredentialSecretID, "api"},
+		{config.GitlabReadAuthorizerCredentialSecretID, "read_api"},
+	}
+	for _, tk := range tokens {
+		if tk.secretID == nil || *tk.secretID == "" {
+			findings = append(findings, fmt.Sprintf("the secret with the GitLab token with scope %s is not set.", tk.scope))
+			continue
+		}
+		scopes, status, err := gitlabTokenScopes(client, apiURL, r.info.GetSecretValue(t, *tk.secretID))
+		if err != nil {
+			findings = append(findings, fmt.Sprintf("failed to check the scopes of the token in secret %s: %s", *tk.secretID, err.Error()))
+			continue
+		}
+		if status != http.StatusOK {
+			findings = append(findings, fmt.Sprintf("the token in secret %s is not valid, status: %d.", *tk.secretID, status))
+			continue
+		}
+		if !slices.Contains(scopes, tk.scope) && !slices.Contains(scopes, "api") {
+			findings = append(findings, fmt.Sprintf("the token in secret %s must have the %s scope. Current scopes: %s", *tk.secretID, tk.scope, strings.Join(scopes, ", ")))
+		}
+	}
+	return findings
+}
+
+// gitlabTokenScopes gets the scopes of a GitLab token.
+func gitlabTokenScopes(client *http.Client, apiURL, token string) ([]string, int, error) {
+	var self struct {
+		Scopes []string `json:"scopes"`
+	}
+	_, status, err := apiGet(client, apiURL+"/personal_access_tokens/self", "PRIVATE-TOKEN", token, &self)
+	return self.Scopes, status, err
+}
+
+// gitlabRepository gets the token access level in a project, the highest of the project and the group access.
+func (r RepositoryChecker) gitlabRepository(client *http.Client, apiURL, token, path string) (repositoryAccess, int, error) {
+	var project struct {
+		ID          int `json:"id"`
+		Permissions struct {
+			ProjectAccess *struct {
+				AccessLevel int `json:"access_level"`
+			} `json:"project_access"`
+			GroupAccess *struct {
+				AccessLevel int `json:"access_level"`
+			} `json:"group_access"`
+		} `json:"permissions"`
+	}
+	_, status, err := apiGet(client, fmt.Sprintf("%s/projects/%s", apiURL, url.PathEscape(path)), "PRIVATE-TOKEN", token, &project)
+	if err != nil || status != http.StatusOK {
+		return repositoryAccess{}, status, err
+	}
+	level := 0
+	if project.Permissions.ProjectAccess != nil {
+		level = project.Permissions.ProjectAccess.AccessLevel
+	}
+	if project.Permissions.GroupAccess != nil {
+		level = max(level, project.Permissions.GroupAccess.AccessLevel)
+	}
+	return repositoryAccess{
+		canPush:  level >= gitlabDeveloperAccess,
+		canHooks: level >= gitlabMaintainerAccess,
+		id:       fmt.Sprint(project.ID),
+	}, status, nil
+}
+
+func (r RepositoryChecker) gitlabBranch(client *http.Client, apiURL, token string, access repositoryAccess, path, branch string) (bool, error) {
+	_, status, err := apiGet(client, fmt.Sprintf("%s/projects/%s/repository/branches/%s", apiURL, access.id, url.PathEscape(branch)), "PRIVATE-TOKEN", token, nil)
+	return status == http.StatusOK, err
+}
+
+// apiGet calls a repository host API and decodes the response in out when the status is 200.
+func apiGet(client *http.Client, apiURL, authHeader, authValue string, out any) (http.Header, int, error) {
+	req, err := http.NewRequest(http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set(authHeader, authValue)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return resp.Header, resp.StatusCode, err
+	}
+	return resp.Header, resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// hostURLs returns the repositories host and its API URL.
+// The GitHub host is the host of the first repository, all the repositories of a config must be in the same host.
+func hostURLs(config CloudbuildV2RepositoryConfig) (string, string) {
+	if config.RepoType == repoTypeGitLab {
+		host := defaultGitLabHost
+		if config.GitlabEnterpriseHostURI != nil && *config.GitlabEnterpriseHostURI != "" {
+			host = strings.TrimSuffix(*config.GitlabEnterpriseHostURI, "/")
+		}
+		return host, host + "/api/v4"
+	}
+	host := defaultGitHubHost
+	for _, key := range slices.Sorted(maps.Keys(config.Repositories)) {
+		if u, err := url.Parse(config.Repositories[key].RepositoryURL); err == nil && u.Host != "" {
+			host = fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+			break
+		}
+	}
+	if host == defaultGitHubHost {
+		return host, "https://api.github.com"
+	}
+	// GitHub Enterprise Server
+	return host, host + "/api/v3"
+}
+
+func hostOf(hostURI string) string {
+	u, err := url.Parse(hostURI)
+	if err != nil {
+		return hostURI
+	}
+	return u.Host
+}
+
+func tokenSecretID(config CloudbuildV2RepositoryConfig) *string {
+	if config.RepoType == repoTypeGitLab {
+		return config.GitlabAuthorizerCredentialSecretID
+	}
+	return config.GithubSecretID
+}
+
+func splitScopes(header string) []string {
+	scopes := []string{}
+	for _, s := range strings.Split(header, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+func localGitConfig(key string) []string {
+	out, err := exec.Command("git", "config", "--get-all", key).Output()
+	if err != nil {
+		return []string{}
+	}
+	return strings.Fields(string(out))
+}
+
+// infraBranches returns the branches pushed to the infra repositories.
+func infraBranches(g GlobalTFVars) []string {
+	branches := []string{"plan"}
+	for _, env := range slices.Sorted(maps.Keys(g.Envs)) {
+		if env == "shared" {
+			env = "production"
+		}
+		if !slices.Contains(branches, env) {
+			branches = append(branches, env)
+		}
+	}
+	return branches
+}
+
+// ValidateRepositories checks if the infra and the application repositories can be used by the deployment.
+func ValidateRepositories(t testing.TB, g GlobalTFVars, gcpConf gcp.GCP) {
+	validateRepositories(t, g, NewRepositoryChecker(gcpConf), os.Stdout)
+}
+
+func validateRepositories(t testing.TB, g GlobalTFVars, r RepositoryChecker, out io.Writer) {
+	r.connectionLocation = g.CloudBuildConnectionLocation()
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "# Validating if repositories are accessible.")
+	findings := r.Check(t, "infra_cloudbuildv2_repository_config", g.InfraCloudbuildV2RepositoryConfig, infraBranches(g), g.ProjectID)
+	// the application connections are created by 4-appfactory in the admin projects
+	findings = append(findings, r.Check(t, "app_services_cloudbuildv2_repository_config", g.AppServicesCloudbuildV2RepositoryConfig, []string{"main"}, "")...)
+	// the policy library and Config Sync repositories use the host and the token of the infra repositories
+	if g.PoliciesRepository != nil {
+		config := g.InfraCloudbuildV2RepositoryConfig
+		config.Repositories = map[string]Repository{"policies": policiesRepository(g)}
+		findings = append(findings, r.Check(t, "policies_repository", config, []string{PoliciesBranch}, "")...)
+	}
+	if g.ConfigSyncRepository != nil {
+		config := g.InfraCloudbuildV2RepositoryConfig
+		config.Repositories = map[string]Repository{"config_sync": *g.ConfigSyncRepository}
+		findings = append(findings, r.Check(t, "config_sync_repository", config, []string{configSyncBranch(g)}, "")...)
+	}
+	for _, f := range findings {
+		fmt.Fprintf(out, "# %s\n", f)
+	}
+}
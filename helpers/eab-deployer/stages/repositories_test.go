@@ -0,0 +1,235 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	gotest "testing"
+	"time"
+
+	"github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+)
+
+// fakeRepositoryHost is a RepositoryHostInfo with fixed data.
+type fakeRepositoryHost struct {
+	secrets     map[string]string
+	apis        []string
+	connections []gcp.Connection
+	// connectionRegion is the region of the connections, any region when empty
+	connectionRegion string
+	services         []string
+}
+
+func (f fakeRepositoryHost) GetSecretValue(t testing.TB, secretID string) string {
+	return f.secrets[secretID]
+}
+
+func (f fakeRepositoryHost) IsApiEnabled(t testing.TB, project, api string) bool {
+	for _, a := range f.apis {
+		if a == api {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fakeRepositoryHost) ListConnections(t testing.TB, project, region string) []gcp.Connection {
+	if f.connectionRegion != "" && f.connectionRegion != region {
+		return nil
+	}
+	return f.connections
+}
+
+func (f fakeRepositoryHost) ListServiceDirectoryServices(t testing.TB, project, location, namespace string) []string {
+	return f.services
+}
+
+func testRepositoryChecker(info RepositoryHostInfo, ips []net.IP, helpers []string) RepositoryChecker {
+	return RepositoryChecker{
+		info:      info,
+		lookupIP:  func(string) ([]net.IP, error) { return ips, nil },
+		gitConfig: func(string) []string { return helpers },
+		timeout:   5 * time.Second,
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestCheckCSR(t *gotest.T) {
+	r := testRepositoryChecker(fakeRepositoryHost{}, nil, []string{})
+	assert.Equal(t, []string{
+		"infra: API sourcerepo.googleapis.com must be enabled in project prj-seed to use Cloud Source Repositories.",
+		"infra: git is not configured to use gcloud as the credential helper for Cloud Source Repositories, run: git config --global credential.https://source.developers.google.com.helper gcloud.sh",
+	}, r.Check(t, "infra", CloudbuildV2RepositoryConfig{RepoType: "CSR"}, []string{"plan"}, "prj-seed"))
+
+	r = testRepositoryChecker(fakeRepositoryHost{apis: []string{"sourcerepo.googleapis.com"}}, nil, []string{"gcloud.sh"})
+	assert.Empty(t, r.Check(t, "infra", CloudbuildV2RepositoryConfig{RepoType: "CSR"}, []string{"plan"}, "prj-seed"))
+}
+
+func TestHostURLs(t *gotest.T) {
+	host, api := hostURLs(CloudbuildV2RepositoryConfig{RepoType: "GITHUBv2", Repositories: map[string]Repository{"a": {RepositoryURL: "https://github.com/org/a.git"}}})
+	assert.Equal(t, "https://github.com", host)
+	assert.Equal(t, "https://api.github.com", api)
+
+	host, api = hostURLs(CloudbuildV2RepositoryConfig{RepoType: "GITHUBv2", Repositories: map[string]Repository{"a": {RepositoryURL: "https://ghes.example.com/org/a.git"}}})
+	assert.Equal(t, "https://ghes.example.com", host)
+	assert.Equal(t, "https://ghes.example.com/api/v3", api, "GitHub Enterprise Server API")
+
+	_, api = hostURLs(CloudbuildV2RepositoryConfig{RepoType: "GITLABv2"})
+	assert.Equal(t, "https://gitlab.com/api/v4", api)
+
+	_, api = hostURLs(CloudbuildV2RepositoryConfig{RepoType: "GITLABv2", GitlabEnterpriseHostURI: strPtr("https://gitlab.example.com/")})
+	assert.Equal(t, "https://gitlab.example.com/api/v4", api)
+}
+
+func TestCheckGitHubEnterprise(t *gotest.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/repos/org/multitenant", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer gh-token", r.Header.Get("Authorization"))
+		w.Header().Set("X-OAuth-Scopes", "repo, read:org")
+		w.Write([]byte(`{"permissions": {"admin": true, "push": true}}`))
+	})
+	mux.HandleFunc("/api/v3/repos/org/fleetscope", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-OAuth-Scopes", "public_repo")
+		w.Write([]byte(`{"permissions": {"admin": false, "push": false}}`))
+	})
+	mux.HandleFunc("/api/v3/repos/org/multitenant/branches/plan", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name": "plan"}`))
+	})
+	mux.HandleFunc("/api/v3/repos/org/fleetscope/branches/plan", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name": "plan"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	info := fakeRepositoryHost{
+		secrets:     map[string]string{"projects/prj-secrets/secrets/github-pat": "gh-token"},
+		connections: []gcp.Connection{{Name: "ghes", HostURI: server.URL + "/", Stage: "PENDING_INSTALL_APP", Message: "Please install the app."}},
+	}
+	r := testRepositoryChecker(info, []net.IP{net.ParseIP("35.1.2.3")}, nil)
+	config := CloudbuildV2RepositoryConfig{
+		RepoType:       "GITHUBv2",
+		GithubSecretID: strPtr("projects/prj-secrets/secrets/github-pat"),
+		Repositories: map[string]Repository{
+			"multitenant": {RepositoryName: "multitenant", RepositoryURL: server.URL + "/org/multitenant.git"},
+			"fleetscope":  {RepositoryName: "fleetscope", RepositoryURL: server.URL + "/org/fleetscope.git"},
+			"other":       {RepositoryName: "other", RepositoryURL: "https://github.com/org/other.git"},
+		},
+	}
+
+	assert.Equal(t, []string{
+		"infra: Cloud Build connection ghes to " + server.URL + " is not installed, stage: PENDING_INSTALL_APP. Please install the app.",
+		"infra: the token cannot push to repository " + server.URL + "/org/fleetscope.git.",
+		"infra: the token cannot manage the webhooks of repository " + server.URL + "/org/fleetscope.git.",
+		"infra: branch production does not exist in repository " + server.URL + "/org/fleetscope.git and cannot be created with the token.",
+		"infra: repository other URL https://github.com/org/other.git is not in host " + server.URL + ".",
+	}, r.Check(t, "infra", config, []string{"plan", "production"}, "prj-seed"))
+}
+
+func TestCheckGitLabSelfManaged(t *gotest.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/personal_access_tokens/self", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("PRIVATE-TOKEN") {
+		case "api-token":
+			w.Write([]byte(`{"scopes": ["api"]}`))
+		case "read-token":
+			w.Write([]byte(`{"scopes": ["read_repository"]}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	mux.HandleFunc("/api/v4/projects/group%2Fsub%2Fhello-world", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 42, "permissions": {"project_access": {"access_level": 30}, "group_access": {"access_level": 10}}}`))
+	})
+	mux.HandleFunc("/api/v4/projects/42/repository/branches/main", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	server := httptest.NewUnstartedServer(mux)
+	// the check without the CA fails the handshake
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	info := fakeRepositoryHost{
+		secrets: map[string]string{"api": "api-token", "read": "read-token"},
+	}
+	r := testRepositoryChecker(info, []net.IP{net.ParseIP("10.0.0.10")}, nil)
+	config := CloudbuildV2RepositoryConfig{
+		RepoType:                               "GITLABv2",
+		GitlabAuthorizerCredentialSecretID:     strPtr("api"),
+		GitlabReadAuthorizerCredentialSecretID: strPtr("read"),
+		GitlabEnterpriseHostURI:                strPtr(server.URL),
+		GitlabEnterpriseCACertificate:          strPtr(string(ca)),
+		Repositories: map[string]Repository{
+			"hello-world": {RepositoryName: "hello-world", RepositoryURL: server.URL + "/group/sub/hello-world.git"},
+		},
+	}
+
+	assert.Equal(t, []string{
+		"app: host 127.0.0.1 only has private addresses, the Cloud Build connection needs gitlab_enterprise_service_directory to reach it.",
+		"app: the token in secret read must have the read_api scope. Current scopes: read_repository",
+		"app: the token cannot manage the webhooks of repository " + server.URL + "/group/sub/hello-world.git.",
+	}, r.Check(t, "app", config, []string{"main"}, ""), "the missing branch can be created by a developer")
+
+	config.GitlabEnterpriseServiceDirectory = strPtr("projects/prj-net/locations/us-central1/namespaces/gitlab/services/gitlab")
+	assert.Contains(t, r.Check(t, "app", config, []string{"main"}, ""), "app: Service Directory service projects/prj-net/locations/us-central1/namespaces/gitlab/services/gitlab used to reach "+server.URL+" does not exist.")
+
+	config.GitlabEnterpriseCACertificate = nil
+	config.GitlabEnterpriseServiceDirectory = nil
+	findings := r.Check(t, "app", config, []string{"main"}, "")
+	assert.Contains(t, findings[1], "failed to check the scopes", "the server certificate is not trusted without the CA")
+}
+
+func TestValidateRepositories(t *gotest.T) {
+	g := GlobalTFVars{
+		ProjectID:                               "prj-seed",
+		Envs:                                    map[string]Env{"production": {}, "development": {}},
+		InfraCloudbuildV2RepositoryConfig:       CloudbuildV2RepositoryConfig{RepoType: "CSR"},
+		AppServicesCloudbuildV2RepositoryConfig: CloudbuildV2RepositoryConfig{RepoType: "BITBUCKET"},
+	}
+	assert.Equal(t, []string{"plan", "development", "production"}, infraBranches(g))
+
+	var out bytes.Buffer
+	validateRepositories(t, g, testRepositoryChecker(fakeRepositoryHost{apis: []string{"sourcerepo.googleapis.com"}}, nil, []string{"gcloud.sh"}), &out)
+	assert.Equal(t, "\n# Validating if repositories are accessible.\n# app_services_cloudbuildv2_repository_config: unknown repository type \"BITBUCKET\", valid types are CSR, GITHUBv2 and GITLABv2.\n", out.String())
+}
+
+func TestCheckConnectionLocation(t *gotest.T) {
+	info := fakeRepositoryHost{
+		connections:      []gcp.Connection{{Name: "github", HostURI: "https://github.com", Stage: "COMPLETE"}},
+		connectionRegion: "europe-west1",
+	}
+	r := testRepositoryChecker(info, nil, nil)
+	r.connectionLocation = defaultConnectionLocation
+	assert.Equal(t, []string{"infra: there is no Cloud Build connection to https://github.com in project prj-seed region us-central1, it will be created by 1-bootstrap."}, r.checkConnection(t, "infra", "prj-seed", "https://github.com"))
+
+	g := GlobalTFVars{ConnectionLocation: strPtr("europe-west1")}
+	assert.Equal(t, "europe-west1", g.CloudBuildConnectionLocation())
+	assert.Equal(t, defaultConnectionLocation, GlobalTFVars{}.CloudBuildConnectionLocation())
+	r.connectionLocation = g.CloudBuildConnectionLocation()
+	assert.Empty(t, r.checkConnection(t, "infra", "prj-seed", "https://github.com"))
+}
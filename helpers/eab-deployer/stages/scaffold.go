@@ -0,0 +1,240 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/steps"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
+)
+
+const (
+	// PoliciesBranch is the branch of the policy library checked out by the tf-wrapper.sh of the pipelines.
+	PoliciesBranch = "main"
+	// defaultConfigSyncBranch is the default config_sync_branch of the fleetscope stage.
+	defaultConfigSyncBranch = "master"
+)
+
+// csrURL is the URL of a Cloud Source Repository.
+func csrURL(project, name string) string {
+	return fmt.Sprintf("https://source.developers.google.com/p/%s/r/%s", project, name)
+}
+
+// repositoryURL is the URL of a repository with the type of the infra repositories. The Cloud Source Repositories
+// are in the project of the bootstrap stage.
+func repositoryURL(tfvars GlobalTFVars, repo Repository) string {
+	if tfvars.InfraCloudbuildV2RepositoryConfig.RepoType == repoTypeCSR {
+		return csrURL(tfvars.ProjectID, repo.RepositoryName)
+	}
+	return repo.RepositoryURL
+}
+
+// policiesRepository is the repository of the policy library, the name defaults to the repository cloned by the
+// pipelines.
+func policiesRepository(tfvars GlobalTFVars) Repository {
+	repo := *tfvars.PoliciesRepository
+	if repo.RepositoryName == "" {
+		repo.RepositoryName = PoliciesRepo
+	}
+	return repo
+}
+
+// configSyncRepositoryURL is the config_sync_repository_url of the fleetscope stage. When it is not set, it is the
+// URL of the Config Sync repository scaffolded by the helper.
+func configSyncRepositoryURL(tfvars GlobalTFVars) *string {
+	if tfvars.ConfigSyncRepositoryURL != nil || tfvars.ConfigSyncRepository == nil {
+		return tfvars.ConfigSyncRepositoryURL
+	}
+	u := repositoryURL(tfvars, *tfvars.ConfigSyncRepository)
+	return &u
+}
+
+// configSyncBranch is the branch synced by Config Sync.
+func configSyncBranch(tfvars GlobalTFVars) string {
+	if tfvars.ConfigSyncBranch != nil && *tfvars.ConfigSyncBranch != "" {
+		return *tfvars.ConfigSyncBranch
+	}
+	return defaultConfigSyncBranch
+}
+
+// configSyncFiles are the files of the scaffolding of the Config Sync repository, by path, in
+// config_sync_policy_dir. The fleetscope stage syncs the same directory to the clusters of all the environments and
+// creates a namespace <namespace>-<env> for each namespace of namespace_ids, so each namespace has a dynamic
+// NamespaceSelector of its namespaces in all the environments, that only matches the namespace of the environment
+// of the cluster, and a RoleBinding of its group selected by it. Config Sync only reads the YAML and JSON files, the
+// README files are not synced.
+func configSyncFiles(tfvars GlobalTFVars) map[string]string {
+	envs := slices.Sorted(maps.Keys(tfvars.Envs))
+	dir := ""
+	if tfvars.ConfigSyncPolicyDir != nil {
+		dir = *tfvars.ConfigSyncPolicyDir
+	}
+	files := map[string]string{
+		filepath.Join(dir, "README.md"): fmt.Sprintf("# Config Sync repository\n\n"+
+			"The configuration synced to the clusters of the fleet, in the unstructured format.\n"+
+			"The same configuration is synced to the clusters of all the environments: %s\n\n"+
+			"- `cluster`: cluster scoped objects.\n"+
+			"- `namespaces/<namespace>`: objects of the namespace `<namespace>-<env>` created by the fleet scope in each\n"+
+			"  environment, selected by the NamespaceSelector of the directory.\n", strings.Join(envs, ", ")),
+		filepath.Join(dir, "cluster", "README.md"): "# Cluster\n\nCluster scoped objects.\n",
+	}
+	for _, ns := range slices.Sorted(maps.Keys(tfvars.NamespaceIDs)) {
+		var values strings.Builder
+		for _, env := range envs {
+			fmt.Fprintf(&values, "    - %s-%s\n", ns, env)
+		}
+		files[filepath.Join(dir, "namespaces", ns, "namespace-selector.yaml")] = fmt.Sprintf(`# The namespaces of %[1]s created by the fleet scope, only the namespace of the environment of the cluster exists.
+apiVersion: configmanagement.gke.io/v1
+kind: NamespaceSelector
+metadata:
+  name: %[1]s
+spec:
+  mode: dynamic
+  selector:
+    matchExpressions:
+    - key: kubernetes.io/metadata.name
+      operator: In
+      values:
+%[2]s`, ns, values.String())
+		files[filepath.Join(dir, "namespaces", ns, "rolebinding.yaml")] = fmt.Sprintf(`apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: %[1]s-editors
+  annotations:
+    configmanagement.gke.io/namespace-selector: %[1]s
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: edit
+subjects:
+- apiGroup: rbac.authorization.k8s.io
+  kind: Group
+  name: %[2]s
+`, ns, tfvars.NamespaceIDs[ns])
+	}
+	return files
+}
+
+// checkConfigSync checks that the fleetscope stage syncs the Config Sync repository scaffolded by the helper.
+func checkConfigSync(tfvars GlobalTFVars) error {
+	if tfvars.ConfigSyncRepository == nil {
+		return nil
+	}
+	if tfvars.ConfigSyncSecretType == nil || *tfvars.ConfigSyncSecretType == "gcpserviceaccount" {
+		return fmt.Errorf("config_sync_repository is not synced when config_sync_secret_type is gcpserviceaccount, the fleetscope stage creates its own repository")
+	}
+	return nil
+}
+
+// cloneRepository clones a repository with the type of the infra repositories in the checkout path. The Cloud
+// Source Repositories are created when they do not exist, the other repositories must exist.
+func cloneRepository(t testing.TB, g gcp.GCP, tfvars GlobalTFVars, repo Repository, c CommonConf) utils.GitRepo {
+	repoType := tfvars.InfraCloudbuildV2RepositoryConfig.RepoType
+	if repoType == repoTypeCSR && !g.HasSourceRepo(t, tfvars.ProjectID, repo.RepositoryName) {
+		fmt.Printf("# creating repository %s in project %s\n", repo.RepositoryName, tfvars.ProjectID)
+		g.CreateSourceRepo(t, tfvars.ProjectID, repo.RepositoryName)
+	}
+	return utils.GitClone(t, repoType, repo.RepositoryName, repo.RepositoryURL, filepath.Join(c.CheckoutPath, repo.RepositoryName), tfvars.ProjectID, c.Identity.GcloudEnv(), c.Logger)
+}
+
+// DeployPoliciesRepo creates the repository of the policy library, when it is configured, and pushes the policy
+// library to it.
+func DeployPoliciesRepo(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c CommonConf) error {
+	if tfvars.PoliciesRepository == nil {
+		return nil
+	}
+	return s.RunStep(fmt.Sprintf("%s.policies-repo", BootstrapRepo), func() error {
+		repo := policiesRepository(tfvars)
+		conf := cloneRepository(t, gcp.NewGCPWithIdentity(c.Identity), tfvars, repo, c)
+		return preparePoliciesRepo(conf, PoliciesBranch, tfvars.PolicyLibrary(), filepath.Join(c.CheckoutPath, repo.RepositoryName))
+	})
+}
+
+func preparePoliciesRepo(policiesConf utils.GitRepo, policiesBranch, policyLibraryPath, gcpPoliciesPath string) error {
+	err := policiesConf.CheckoutBranch(policiesBranch)
+	if err != nil {
+		return err
+	}
+	// the .git directory of a cloned policy library would replace the remotes of the checkout
+	err = utils.CopyDirectoryExcept(policyLibraryPath, gcpPoliciesPath, ".git")
+	if err != nil {
+		return err
+	}
+	err = policiesConf.CommitFiles("Initialize policy library repo")
+	if err != nil {
+		return err
+	}
+	return policiesConf.PushBranch(policiesBranch, "origin")
+}
+
+// deployConfigSyncRepo creates the Config Sync repository, when it is configured, and pushes the scaffolding of
+// the namespaces to the synced branch.
+func deployConfigSyncRepo(t testing.TB, s steps.Steps, tfvars GlobalTFVars, c CommonConf, step string) error {
+	if tfvars.ConfigSyncRepository == nil {
+		return nil
+	}
+	if err := checkConfigSync(tfvars); err != nil {
+		return err
+	}
+	return s.RunStep(step, func() error {
+		repo := *tfvars.ConfigSyncRepository
+		conf := cloneRepository(t, gcp.NewGCPWithIdentity(c.Identity), tfvars, repo, c)
+		return scaffoldConfigSyncRepo(conf, configSyncBranch(tfvars), filepath.Join(c.CheckoutPath, repo.RepositoryName), configSyncFiles(tfvars))
+	})
+}
+
+// scaffoldConfigSyncRepo writes the files of the scaffolding that do not exist, the files changed by the users are
+// kept, and pushes the branch.
+func scaffoldConfigSyncRepo(conf utils.GitRepo, branch, path string, files map[string]string) error {
+	err := conf.CheckoutBranch(branch)
+	if err != nil {
+		return err
+	}
+	err = writeScaffolding(path, files)
+	if err != nil {
+		return err
+	}
+	err = conf.CommitFiles("Initialize Config Sync repo")
+	if err != nil {
+		return err
+	}
+	return conf.PushBranch(branch, "origin")
+}
+
+// writeScaffolding writes the files that do not exist in a directory.
+func writeScaffolding(path string, files map[string]string) error {
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		f := filepath.Join(path, name)
+		if _, err := os.Stat(f); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(f, []byte(files[name]), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	gotest "testing"
+
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/yaml"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
+)
+
+func TestConfigSyncRepositoryURL(t *gotest.T) {
+	url := "https://github.com/example/eab-config-sync.git"
+	tfvars := GlobalTFVars{
+		ProjectID:                         "prj-b-cicd",
+		InfraCloudbuildV2RepositoryConfig: CloudbuildV2RepositoryConfig{RepoType: "GITHUBv2"},
+	}
+	assert.Nil(t, configSyncRepositoryURL(tfvars))
+
+	tfvars.ConfigSyncRepository = &Repository{RepositoryName: "eab-config-sync", RepositoryURL: url}
+	assert.Equal(t, url, *configSyncRepositoryURL(tfvars))
+	assert.Equal(t, url, *fleetscopeTfvars(tfvars, "").ConfigSyncRepositoryURL, "the URL should be wired into the fleetscope tfvars")
+
+	tfvars.InfraCloudbuildV2RepositoryConfig.RepoType = "CSR"
+	assert.Equal(t, "https://source.developers.google.com/p/prj-b-cicd/r/eab-config-sync", *configSyncRepositoryURL(tfvars))
+
+	explicit := "https://gitlab.com/example/acm.git"
+	tfvars.ConfigSyncRepositoryURL = &explicit
+	assert.Equal(t, explicit, *configSyncRepositoryURL(tfvars), "an explicit URL should be kept")
+
+	tfvars.PoliciesRepository = &Repository{}
+	assert.Equal(t, PoliciesRepo, policiesRepository(tfvars).RepositoryName)
+}
+
+func TestConfigSyncFiles(t *gotest.T) {
+	tfvars := GlobalTFVars{
+		Envs:         map[string]Env{"production": {}, "development": {}},
+		NamespaceIDs: map[string]string{"cb-frontend": "frontend@example.com", "cb-ledger": "ledger@example.com"},
+	}
+	files := configSyncFiles(tfvars)
+	assert.Equal(t, []string{
+		"README.md",
+		"cluster/README.md",
+		"namespaces/cb-frontend/namespace-selector.yaml",
+		"namespaces/cb-frontend/rolebinding.yaml",
+		"namespaces/cb-ledger/namespace-selector.yaml",
+		"namespaces/cb-ledger/rolebinding.yaml",
+	}, slices.Sorted(maps.Keys(files)))
+	assert.Contains(t, files["README.md"], "development, production")
+	assert.Contains(t, files["namespaces/cb-ledger/namespace-selector.yaml"], "mode: dynamic")
+	assert.Contains(t, files["namespaces/cb-ledger/namespace-selector.yaml"], "      values:\n    - cb-ledger-development\n    - cb-ledger-production\n",
+		"the selector should match the namespace of each environment")
+	assert.Contains(t, files["namespaces/cb-ledger/rolebinding.yaml"], "configmanagement.gke.io/namespace-selector: cb-ledger\n")
+	assert.Contains(t, files["namespaces/cb-ledger/rolebinding.yaml"], "  kind: Group\n  name: ledger@example.com\n")
+	for name, content := range files {
+		if filepath.Ext(name) != ".yaml" {
+			continue
+		}
+		var manifest map[string]any
+		assert.NoError(t, yaml.Unmarshal([]byte(content), &manifest), name)
+		assert.NotEmpty(t, manifest["kind"], name)
+	}
+
+	policyDir := "fleet"
+	tfvars.ConfigSyncPolicyDir = &policyDir
+	files = configSyncFiles(tfvars)
+	assert.Contains(t, files, "fleet/namespaces/cb-frontend/rolebinding.yaml", "the scaffolding should be in config_sync_policy_dir")
+
+	dir := t.TempDir()
+	custom := filepath.Join(dir, "fleet", "cluster", "README.md")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(custom), 0755))
+	assert.NoError(t, os.WriteFile(custom, []byte("custom"), 0644))
+	assert.NoError(t, writeScaffolding(dir, files))
+	content, err := os.ReadFile(custom)
+	assert.NoError(t, err)
+	assert.Equal(t, "custom", string(content), "existing files should be kept")
+	assert.FileExists(t, filepath.Join(dir, "fleet", "namespaces", "cb-frontend", "rolebinding.yaml"))
+}
+
+func TestCheckConfigSync(t *gotest.T) {
+	tfvars := GlobalTFVars{}
+	assert.NoError(t, checkConfigSync(tfvars))
+
+	tfvars.ConfigSyncRepository = &Repository{RepositoryName: "eab-config-sync"}
+	assert.Error(t, checkConfigSync(tfvars), "the default secret type should be rejected")
+	secretType := "gcpserviceaccount"
+	tfvars.ConfigSyncSecretType = &secretType
+	assert.Error(t, checkConfigSync(tfvars))
+	secretType = "token"
+	assert.NoError(t, checkConfigSync(tfvars))
+}
+
+func TestValidatePolicyLibrary(t *gotest.T) {
+	eab := t.TempDir()
+	tfvars := GlobalTFVars{EABCodePath: eab, CodeCheckoutPath: t.TempDir()}
+	assert.NoError(t, ValidateDirectories(tfvars))
+	assert.Equal(t, filepath.Join(eab, "policy-library"), tfvars.PolicyLibrary())
+
+	tfvars.PoliciesRepository = &Repository{}
+	assert.Error(t, ValidateDirectories(tfvars), "the policy library should exist when policies_repository is set")
+
+	policies := t.TempDir()
+	tfvars.PolicyLibraryPath = &policies
+	assert.NoError(t, ValidateDirectories(tfvars))
+	assert.Equal(t, policies, tfvars.PolicyLibrary())
+}
+
+func TestRepositoriesTfvars(t *gotest.T) {
+	file := filepath.Join(t.TempDir(), "global.tfvars")
+	tfvars := GlobalTFVars{
+		PoliciesRepository:   &Repository{RepositoryName: "gcp-policies", RepositoryURL: "https://github.com/example/gcp-policies.git"},
+		ConfigSyncRepository: &Repository{RepositoryName: "eab-config-sync", RepositoryURL: "https://github.com/example/eab-config-sync.git"},
+	}
+	assert.NoError(t, utils.WriteTfvars(file, tfvars))
+	read, err := ReadGlobalTFVars(file)
+	assert.NoError(t, err)
+	assert.Equal(t, tfvars.PoliciesRepository, read.PoliciesRepository)
+	assert.Equal(t, tfvars.ConfigSyncRepository, read.ConfigSyncRepository)
+}
+
+func TestPreparePoliciesRepo(t *gotest.T) {
+	origin := filepath.Join(t.TempDir(), "gcp-policies.git")
+	checkout := filepath.Join(t.TempDir(), "gcp-policies")
+	assert.NoError(t, exec.Command("git", "init", "-q", "--bare", origin).Run())
+	assert.NoError(t, exec.Command("git", "clone", "-q", origin, checkout).Run())
+
+	library := t.TempDir()
+	for name, content := range map[string]string{
+		"policies/constraints/serviceusage_allow_basic_apis.yaml": "kind: GCPServiceUsageConstraintV1\n",
+		".git/config": "[remote \"origin\"]\n\turl = https://github.com/GoogleCloudPlatform/policy-library.git\n",
+	} {
+		f := filepath.Join(library, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(f), 0755))
+		assert.NoError(t, os.WriteFile(f, []byte(content), 0644))
+	}
+
+	conf := utils.GitClone(t, "GITHUBv2", "gcp-policies", origin, checkout, "", nil, logger.Discard)
+	assert.NoError(t, preparePoliciesRepo(conf, PoliciesBranch, library, checkout))
+
+	config, err := os.ReadFile(filepath.Join(checkout, ".git", "config"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(config), origin, "the remote of the checkout should be kept")
+	assert.NotContains(t, string(config), "policy-library.git")
+	out, err := exec.Command("git", "--git-dir", origin, "ls-tree", "-r", "--name-only", PoliciesBranch).Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "policies/constraints/serviceusage_allow_basic_apis.yaml\n", string(out), "the policy library should be pushed to the repository")
+}
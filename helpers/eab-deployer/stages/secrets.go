@@ -0,0 +1,352 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/go-testing-interface"
+)
+
+// Environment variables with the values of the repository secrets.
+const (
+	GithubTokenEnv     = "EAB_GITHUB_TOKEN"
+	GithubAppIDEnv     = "EAB_GITHUB_APP_ID"
+	GitlabTokenEnv     = "EAB_GITLAB_TOKEN"
+	GitlabReadTokenEnv = "EAB_GITLAB_READ_TOKEN"
+)
+
+// SecretEnvs are the environment variables read by the secrets command.
+var SecretEnvs = []string{GithubTokenEnv, GithubAppIDEnv, GitlabTokenEnv, GitlabReadTokenEnv}
+
+// Actions of the secrets command on a secret.
+const (
+	SecretCreated   = "CREATED"
+	SecretRotated   = "ROTATED"
+	SecretUnchanged = "UNCHANGED"
+)
+
+// webhookSecretSize is the number of random bytes of a generated GitLab webhook secret.
+const webhookSecretSize = 32
+
+type secretKind int
+
+const (
+	githubToken secretKind = iota
+	githubAppID
+	gitlabToken
+	gitlabReadToken
+	gitlabWebhook
+)
+
+// RepositorySecret is a secret of the repository configs. The values of the tokens are read from the environment
+// variables and the GitLab webhook secret is generated.
+type RepositorySecret struct {
+	// Name is the secret, projects/PROJECT/secrets/SECRET.
+	Name string
+	// Fields are the fields of the tfvars file with the secret.
+	Fields []string
+	// Env is the environment variable with the value, empty for a generated secret.
+	Env       string
+	Generated bool
+	// App reports if the secret is used by the application repositories, their connections are in the admin projects.
+	App    bool
+	kind   secretKind
+	config CloudbuildV2RepositoryConfig
+}
+
+// SecretsOptions are the options of ApplySecrets.
+type SecretsOptions struct {
+	// Values are the values of the secrets by environment variable.
+	Values map[string]string
+	// Rotate adds a version to the existing secrets with a value.
+	Rotate bool
+	// RotateWebhook generates a new version of the existing GitLab webhook secrets.
+	RotateWebhook bool
+	// AppConnectionProjects are the projects with the connections of the application repositories.
+	AppConnectionProjects []string
+}
+
+// SecretResult is the result of the secrets command for a secret, it never has the value of the secret.
+type SecretResult struct {
+	Name    string `json:"name"`
+	Action  string `json:"action"`
+	Version string `json:"version,omitempty"`
+	// Connections are the Cloud Build connections updated to the new version.
+	Connections []string `json:"connections,omitempty"`
+}
+
+// SecretManager manages the secrets and the Cloud Build connections that use them.
+type SecretManager interface {
+	GetProjectNumber(t testing.TB, project string) string
+	SecretExists(t testing.TB, name string) (bool, error)
+	CreateSecret(t testing.TB, name string) error
+	AddSecretVersion(t testing.TB, name string, value []byte) (string, error)
+	GrantSecretAccess(t testing.TB, name, member string) error
+	RefreshConnections(t testing.TB, parent, secret, version string) ([]string, error)
+}
+
+// CloudBuildServiceAgent is the member of the Cloud Build service agent of a project, it reads the secrets of the
+// connections.
+func CloudBuildServiceAgent(projectNumber string) string {
+	return fmt.Sprintf("serviceAccount:service-%s@gcp-sa-cloudbuild.iam.gserviceaccount.com", projectNumber)
+}
+
+// secretName is the full name of a secret of a repository config. The IDs that are not full names are in the
+// secret_project_id of the config.
+func secretName(id string, config CloudbuildV2RepositoryConfig) (string, error) {
+	if strings.HasPrefix(id, "projects/") && strings.Contains(id, "/secrets/") {
+		name, _, _ := strings.Cut(id, "/versions/")
+		return name, nil
+	}
+	if config.SecretProjectID == nil || *config.SecretProjectID == "" {
+		return "", fmt.Errorf("%s is not a secret name, projects/PROJECT/secrets/SECRET, and secret_project_id is not set", id)
+	}
+	return fmt.Sprintf("projects/%s/secrets/%s", *config.SecretProjectID, id), nil
+}
+
+// RepositorySecrets are the secrets of the infra and the application repository configs. A secret used by both
+// configs must have the same kind of value.
+func RepositorySecrets(g GlobalTFVars) ([]RepositorySecret, error) {
+	secrets := []RepositorySecret{}
+	errs := []error{}
+	add := func(field string, id *string, env string, kind secretKind, config CloudbuildV2RepositoryConfig, app bool) {
+		if id == nil || *id == "" {
+			return
+		}
+		name, err := secretName(*id, config)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+			return
+		}
+		i := slices.IndexFunc(secrets, func(s RepositorySecret) bool { return s.Name == name })
+		if i < 0 {
+			secrets = append(secrets, RepositorySecret{Name: name, Fields: []string{field}, Env: env, Generated: kind == gitlabWebhook, App: app, kind: kind, config: config})
+			return
+		}
+		if secrets[i].kind != kind {
+			errs = append(errs, fmt.Errorf("%s: secret %s is also used by %s for a different value", field, name, strings.Join(secrets[i].Fields, ", ")))
+			return
+		}
+		secrets[i].Fields = append(secrets[i].Fields, field)
+		secrets[i].App = secrets[i].App || app
+	}
+	configs := []struct {
+		field  string
+		config CloudbuildV2RepositoryConfig
+		app    bool
+	}{
+		{"infra_cloudbuildv2_repository_config", g.InfraCloudbuildV2RepositoryConfig, false},
+		{"app_services_cloudbuildv2_repository_config", g.AppServicesCloudbuildV2RepositoryConfig, true},
+	}
+	for _, c := range configs {
+		switch c.config.RepoType {
+		case repoTypeGitHub:
+			add(c.field+".github_secret_id", c.config.GithubSecretID, GithubTokenEnv, githubToken, c.config, c.app)
+			add(c.field+".github_app_id_secret_id", c.config.GithubAppIDSecretID, GithubAppIDEnv, githubAppID, c.config, c.app)
+		case repoTypeGitLab:
+			add(c.field+".gitlab_authorizer_credential_secret_id", c.config.GitlabAuthorizerCredentialSecretID, GitlabTokenEnv, gitlabToken, c.config, c.app)
+			add(c.field+".gitlab_read_authorizer_credential_secret_id", c.config.GitlabReadAuthorizerCredentialSecretID, GitlabReadTokenEnv, gitlabReadToken, c.config, c.app)
+			add(c.field+".gitlab_webhook_secret_id", c.config.GitlabWebhookSecretID, "", gitlabWebhook, c.config, c.app)
+		}
+	}
+	return secrets, errors.Join(errs...)
+}
+
+// ReadSecretValues reads the values of the secrets from NAME=value lines, the empty lines and the lines starting
+// with # are ignored. Only the variables of SecretEnvs are accepted.
+func ReadSecretValues(r io.Reader) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			// the line is not in the error, it can be a value
+			return nil, fmt.Errorf("line %d is not NAME=value", line)
+		}
+		name = strings.TrimSpace(name)
+		if !slices.Contains(SecretEnvs, name) {
+			return nil, fmt.Errorf("line %d: unknown variable %s, valid variables are %s", line, name, strings.Join(SecretEnvs, ", "))
+		}
+		values[name] = strings.TrimSpace(value)
+	}
+	return values, scanner.Err()
+}
+
+// checkSecretValue checks a value before it is saved: the tokens must be valid in the repositories host and have
+// the scopes needed by the Cloud Build connections. The errors never have the value.
+func (r RepositoryChecker) checkSecretValue(s RepositorySecret, value string) error {
+	if s.kind == githubAppID {
+		if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+			return fmt.Errorf("the GitHub App installation ID must be a number")
+		}
+		return nil
+	}
+	client, err := r.httpClient(s.config)
+	if err != nil {
+		return err
+	}
+	_, apiURL := hostURLs(s.config)
+	switch s.kind {
+	case githubToken:
+		header, status, err := apiGet(client, apiURL+"/user", "Authorization", "Bearer "+value, nil)
+		if err != nil {
+			return fmt.Errorf("failed to check the GitHub token: %w", err)
+		}
+		if status != http.StatusOK {
+			return fmt.Errorf("the GitHub token is not valid, status: %d", status)
+		}
+		// fine-grained tokens have no scopes header
+		if scopesHeader, ok := header["X-Oauth-Scopes"]; ok {
+			scopes := splitScopes(strings.Join(scopesHeader, ","))
+			if !slices.Contains(scopes, "repo") || !slices.ContainsFunc([]string{"read:user", "user", "read:org", "admin:org"}, func(s string) bool { return slices.Contains(scopes, s) }) {
+				return fmt.Errorf("the GitHub token must have the repo and the read:user or read:org scopes. Current scopes: %s", strings.Join(scopes, ", "))
+			}
+		}
+	case gitlabToken, gitlabReadToken:
+		scope := "api"
+		if s.kind == gitlabReadToken {
+			scope = "read_api"
+		}
+		scopes, status, err := gitlabTokenScopes(client, apiURL, value)
+		if err != nil {
+			return fmt.Errorf("failed to check the GitLab token: %w", err)
+		}
+		if status != http.StatusOK {
+			return fmt.Errorf("the GitLab token is not valid, status: %d", status)
+		}
+		if !slices.Contains(scopes, scope) && !slices.Contains(scopes, "api") {
+			return fmt.Errorf("the GitLab token must have the %s scope. Current scopes: %s", scope, strings.Join(scopes, ", "))
+		}
+	}
+	return nil
+}
+
+func generateWebhookSecret() ([]byte, error) {
+	b := make([]byte, webhookSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return []byte(hex.EncodeToString(b)), nil
+}
+
+// plannedSecret is a secret with the value that will be added, nil if no version is added.
+type plannedSecret struct {
+	RepositorySecret
+	exists bool
+	value  []byte
+}
+
+// ApplySecrets creates the repository secrets that do not exist and, with Rotate, adds a version to the existing
+// secrets with a value. All the values are checked before a secret is changed. The Cloud Build service agent of
+// the project of the bootstrap stage is granted access to the secrets, and the connections that use a rotated
+// secret are updated to the new version. It fails when no connection uses a rotated secret.
+func ApplySecrets(t testing.TB, g GlobalTFVars, sm SecretManager, r RepositoryChecker, opts SecretsOptions) ([]SecretResult, error) {
+	secrets, err := RepositorySecrets(g)
+	if err != nil {
+		return nil, err
+	}
+	planned := []plannedSecret{}
+	errs := []error{}
+	for _, s := range secrets {
+		p := plannedSecret{RepositorySecret: s}
+		p.exists, err = sm.SecretExists(t, s.Name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		value := opts.Values[s.Env]
+		switch {
+		case s.Generated:
+			if !p.exists || opts.RotateWebhook {
+				if p.value, err = generateWebhookSecret(); err != nil {
+					errs = append(errs, fmt.Errorf("failed to generate the webhook secret %s: %w", s.Name, err))
+				}
+			}
+		case value == "":
+			if !p.exists {
+				errs = append(errs, fmt.Errorf("secret %s does not exist, set %s with its value", s.Name, s.Env))
+			}
+		case p.exists && !opts.Rotate:
+			// the value is only used to create the secret or to rotate it
+		default:
+			if err := r.checkSecretValue(s, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s for secret %s: %w", s.Env, s.Name, err))
+				continue
+			}
+			p.value = []byte(value)
+		}
+		planned = append(planned, p)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	member := CloudBuildServiceAgent(sm.GetProjectNumber(t, g.ProjectID))
+	results := []SecretResult{}
+	for _, p := range planned {
+		result := SecretResult{Name: p.Name, Action: SecretUnchanged}
+		if !p.exists {
+			if err := sm.CreateSecret(t, p.Name); err != nil {
+				return results, err
+			}
+			result.Action = SecretCreated
+		}
+		if err := sm.GrantSecretAccess(t, p.Name, member); err != nil {
+			return results, err
+		}
+		if p.value != nil {
+			result.Version, err = sm.AddSecretVersion(t, p.Name, p.value)
+			if err != nil {
+				return results, err
+			}
+		}
+		if p.exists && p.value != nil {
+			result.Action = SecretRotated
+			projects := []string{g.ProjectID}
+			if p.App {
+				projects = append(projects, opts.AppConnectionProjects...)
+			}
+			for _, project := range projects {
+				updated, err := sm.RefreshConnections(t, fmt.Sprintf("projects/%s/locations/%s", project, g.CloudBuildConnectionLocation()), p.Name, result.Version)
+				if err != nil {
+					return results, err
+				}
+				result.Connections = append(result.Connections, updated...)
+			}
+			if len(result.Connections) == 0 && p.kind != githubAppID {
+				return append(results, result), fmt.Errorf("secret %s was rotated but no Cloud Build connection in %s of projects %s uses it, check connection_location",
+					p.Name, g.CloudBuildConnectionLocation(), strings.Join(projects, ", "))
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
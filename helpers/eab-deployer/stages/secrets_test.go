@@ -0,0 +1,246 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	gotest "testing"
+
+	"github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSecretManager is a SecretManager that records the changes.
+type fakeSecretManager struct {
+	secrets     map[string][]string
+	grants      map[string][]string
+	connections map[string][]string
+	refreshed   []string
+}
+
+func newFakeSecretManager(existing ...string) *fakeSecretManager {
+	f := &fakeSecretManager{secrets: map[string][]string{}, grants: map[string][]string{}, connections: map[string][]string{}}
+	for _, s := range existing {
+		f.secrets[s] = []string{"old"}
+	}
+	return f
+}
+
+func (f *fakeSecretManager) GetProjectNumber(t testing.TB, project string) string {
+	return "111111111111"
+}
+
+func (f *fakeSecretManager) SecretExists(t testing.TB, name string) (bool, error) {
+	_, ok := f.secrets[name]
+	return ok, nil
+}
+
+func (f *fakeSecretManager) CreateSecret(t testing.TB, name string) error {
+	f.secrets[name] = []string{}
+	return nil
+}
+
+func (f *fakeSecretManager) AddSecretVersion(t testing.TB, name string, value []byte) (string, error) {
+	f.secrets[name] = append(f.secrets[name], string(value))
+	return fmt.Sprintf("%s/versions/%d", name, len(f.secrets[name])), nil
+}
+
+func (f *fakeSecretManager) GrantSecretAccess(t testing.TB, name, member string) error {
+	f.grants[name] = append(f.grants[name], member)
+	return nil
+}
+
+func (f *fakeSecretManager) RefreshConnections(t testing.TB, parent, secret, version string) ([]string, error) {
+	f.refreshed = append(f.refreshed, parent+" "+version)
+	return f.connections[parent], nil
+}
+
+// fakeTokenHost is a GitHub and GitLab API that accepts the tokens of the scopes map.
+func fakeTokenHost(scopes map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/user":
+			s, ok := scopes[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("X-OAuth-Scopes", s)
+			fmt.Fprint(w, `{"login":"eab"}`)
+		case "/api/v4/personal_access_tokens/self":
+			s, ok := scopes[r.Header.Get("PRIVATE-TOKEN")]
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprintf(w, `{"scopes":["%s"]}`, strings.Join(strings.Split(s, ", "), `","`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func githubSecretsConfig(host string) GlobalTFVars {
+	return GlobalTFVars{
+		ProjectID: "prj-seed",
+		InfraCloudbuildV2RepositoryConfig: CloudbuildV2RepositoryConfig{
+			RepoType:            repoTypeGitHub,
+			GithubSecretID:      strPtr("projects/111111111111/secrets/github-pat"),
+			GithubAppIDSecretID: strPtr("github-app-id"),
+			SecretProjectID:     strPtr("prj-secrets"),
+			Repositories:        map[string]Repository{"multitenant": {RepositoryURL: host + "/org/eab-multitenant.git"}},
+		},
+		AppServicesCloudbuildV2RepositoryConfig: CloudbuildV2RepositoryConfig{
+			RepoType:            repoTypeGitHub,
+			GithubSecretID:      strPtr("projects/111111111111/secrets/github-pat"),
+			GithubAppIDSecretID: strPtr("projects/111111111111/secrets/github-app-id"),
+			Repositories:        map[string]Repository{"hello-world": {RepositoryURL: host + "/org/hello-world.git"}},
+		},
+	}
+}
+
+func TestRepositorySecrets(t *gotest.T) {
+	secrets, err := RepositorySecrets(githubSecretsConfig("https://github.example.com"))
+	assert.NoError(t, err)
+	assert.Len(t, secrets, 3)
+	assert.Equal(t, "projects/111111111111/secrets/github-pat", secrets[0].Name)
+	assert.Equal(t, []string{"infra_cloudbuildv2_repository_config.github_secret_id", "app_services_cloudbuildv2_repository_config.github_secret_id"}, secrets[0].Fields)
+	assert.True(t, secrets[0].App)
+	assert.Equal(t, "projects/prj-secrets/secrets/github-app-id", secrets[1].Name)
+	assert.False(t, secrets[1].App)
+	assert.Equal(t, GithubAppIDEnv, secrets[2].Env)
+
+	g := GlobalTFVars{
+		InfraCloudbuildV2RepositoryConfig: CloudbuildV2RepositoryConfig{
+			RepoType:                               repoTypeGitLab,
+			GitlabAuthorizerCredentialSecretID:     strPtr("projects/p/secrets/gitlab"),
+			GitlabReadAuthorizerCredentialSecretID: strPtr("projects/p/secrets/gitlab"),
+			GitlabWebhookSecretID:                  strPtr("webhook"),
+		},
+	}
+	_, err = RepositorySecrets(g)
+	assert.ErrorContains(t, err, "secret projects/p/secrets/gitlab is also used by infra_cloudbuildv2_repository_config.gitlab_authorizer_credential_secret_id for a different value")
+	assert.ErrorContains(t, err, "gitlab_webhook_secret_id: webhook is not a secret name")
+}
+
+func TestReadSecretValues(t *gotest.T) {
+	values, err := ReadSecretValues(strings.NewReader("# tokens\nEAB_GITHUB_TOKEN=ghp_abc=\n\nEAB_GITHUB_APP_ID = 123\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{GithubTokenEnv: "ghp_abc=", GithubAppIDEnv: "123"}, values)
+
+	_, err = ReadSecretValues(strings.NewReader("ghp_abc\n"))
+	assert.EqualError(t, err, "line 1 is not NAME=value")
+	_, err = ReadSecretValues(strings.NewReader("TOKEN=ghp_abc\n"))
+	assert.ErrorContains(t, err, "line 1: unknown variable TOKEN")
+}
+
+func TestApplySecretsCreate(t *gotest.T) {
+	host := fakeTokenHost(map[string]string{"good": "repo, read:user", "narrow": "public_repo"})
+	defer host.Close()
+	r := testRepositoryChecker(fakeRepositoryHost{}, nil, nil)
+	g := githubSecretsConfig(host.URL)
+
+	sm := newFakeSecretManager()
+	_, err := ApplySecrets(t, g, sm, r, SecretsOptions{Values: map[string]string{GithubTokenEnv: "narrow", GithubAppIDEnv: "app"}})
+	assert.ErrorContains(t, err, "EAB_GITHUB_TOKEN for secret projects/111111111111/secrets/github-pat: the GitHub token must have the repo and the read:user or read:org scopes. Current scopes: public_repo")
+	assert.ErrorContains(t, err, "the GitHub App installation ID must be a number")
+	assert.NotContains(t, err.Error(), "narrow")
+	assert.Empty(t, sm.secrets, "no secret is changed when a value is not valid")
+
+	_, err = ApplySecrets(t, g, sm, r, SecretsOptions{Values: map[string]string{GithubTokenEnv: "good"}})
+	assert.ErrorContains(t, err, "secret projects/prj-secrets/secrets/github-app-id does not exist, set EAB_GITHUB_APP_ID with its value")
+
+	results, err := ApplySecrets(t, g, sm, r, SecretsOptions{Values: map[string]string{GithubTokenEnv: "good", GithubAppIDEnv: "123"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []SecretResult{
+		{Name: "projects/111111111111/secrets/github-pat", Action: SecretCreated, Version: "projects/111111111111/secrets/github-pat/versions/1"},
+		{Name: "projects/prj-secrets/secrets/github-app-id", Action: SecretCreated, Version: "projects/prj-secrets/secrets/github-app-id/versions/1"},
+		{Name: "projects/111111111111/secrets/github-app-id", Action: SecretCreated, Version: "projects/111111111111/secrets/github-app-id/versions/1"},
+	}, results)
+	assert.Equal(t, []string{"good"}, sm.secrets["projects/111111111111/secrets/github-pat"])
+	assert.Equal(t, []string{"serviceAccount:service-111111111111@gcp-sa-cloudbuild.iam.gserviceaccount.com"}, sm.grants["projects/prj-secrets/secrets/github-app-id"])
+	assert.Empty(t, sm.refreshed)
+
+	// without rotate the existing secrets are not changed
+	results, err = ApplySecrets(t, g, sm, r, SecretsOptions{Values: map[string]string{GithubTokenEnv: "good"}})
+	assert.NoError(t, err)
+	assert.Equal(t, SecretUnchanged, results[0].Action)
+	assert.Len(t, sm.secrets["projects/111111111111/secrets/github-pat"], 1)
+}
+
+func TestApplySecretsRotate(t *gotest.T) {
+	host := fakeTokenHost(map[string]string{"new": "api"})
+	defer host.Close()
+	r := testRepositoryChecker(fakeRepositoryHost{}, nil, nil)
+	g := GlobalTFVars{
+		ProjectID: "prj-seed",
+		InfraCloudbuildV2RepositoryConfig: CloudbuildV2RepositoryConfig{
+			RepoType:                               repoTypeGitLab,
+			GitlabEnterpriseHostURI:                strPtr(host.URL),
+			GitlabAuthorizerCredentialSecretID:     strPtr("projects/p/secrets/gitlab-api"),
+			GitlabReadAuthorizerCredentialSecretID: strPtr("projects/p/secrets/gitlab-read"),
+			GitlabWebhookSecretID:                  strPtr("projects/p/secrets/gitlab-webhook"),
+		},
+		AppServicesCloudbuildV2RepositoryConfig: CloudbuildV2RepositoryConfig{
+			RepoType:                               repoTypeGitLab,
+			GitlabEnterpriseHostURI:                strPtr(host.URL),
+			GitlabAuthorizerCredentialSecretID:     strPtr("projects/p/secrets/gitlab-api"),
+			GitlabReadAuthorizerCredentialSecretID: strPtr("projects/p/secrets/gitlab-read"),
+			GitlabWebhookSecretID:                  strPtr("projects/p/secrets/gitlab-webhook"),
+		},
+	}
+	sm := newFakeSecretManager("projects/p/secrets/gitlab-api", "projects/p/secrets/gitlab-read", "projects/p/secrets/gitlab-webhook")
+	sm.connections["projects/prj-seed/locations/us-central1"] = []string{"projects/prj-seed/locations/us-central1/connections/eab"}
+
+	results, err := ApplySecrets(t, g, sm, r, SecretsOptions{
+		Values:                map[string]string{GitlabTokenEnv: "new"},
+		Rotate:                true,
+		AppConnectionProjects: []string{"prj-admin"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []SecretResult{
+		{
+			Name:        "projects/p/secrets/gitlab-api",
+			Action:      SecretRotated,
+			Version:     "projects/p/secrets/gitlab-api/versions/2",
+			Connections: []string{"projects/prj-seed/locations/us-central1/connections/eab"},
+		},
+		{Name: "projects/p/secrets/gitlab-read", Action: SecretUnchanged},
+		{Name: "projects/p/secrets/gitlab-webhook", Action: SecretUnchanged},
+	}, results)
+	assert.Equal(t, []string{
+		"projects/prj-seed/locations/us-central1 projects/p/secrets/gitlab-api/versions/2",
+		"projects/prj-admin/locations/us-central1 projects/p/secrets/gitlab-api/versions/2",
+	}, sm.refreshed)
+
+	results, err = ApplySecrets(t, g, sm, r, SecretsOptions{RotateWebhook: true})
+	assert.NoError(t, err)
+	assert.Equal(t, SecretRotated, results[2].Action)
+
+	g.ConnectionLocation = strPtr("europe-west1")
+	_, err = ApplySecrets(t, g, sm, r, SecretsOptions{RotateWebhook: true})
+	assert.ErrorContains(t, err, "secret projects/p/secrets/gitlab-webhook was rotated but no Cloud Build connection in europe-west1 of projects prj-seed uses it")
+
+	sm.connections["projects/prj-seed/locations/europe-west1"] = []string{"projects/prj-seed/locations/europe-west1/connections/eab"}
+	results, err = ApplySecrets(t, g, sm, r, SecretsOptions{RotateWebhook: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"projects/prj-seed/locations/europe-west1/connections/eab"}, results[2].Connections)
+	webhook := sm.secrets["projects/p/secrets/gitlab-webhook"]
+	assert.Len(t, webhook, 4)
+	assert.Len(t, webhook[1], 2*webhookSecretSize)
+}
@@ -0,0 +1,279 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/credentials"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
+)
+
+// StateTarget is the terraform directory of a stage environment where the state commands are executed.
+type StateTarget struct {
+	Stage   string
+	Env     string
+	Service string
+	Dir     string
+	// Repo is the checkout of the stage repository, empty for the bootstrap stage.
+	Repo string
+	// Branch is the branch of the repository with the code of the environment.
+	Branch         string
+	ServiceAccount string
+}
+
+// Name identifies the target in the backup files.
+func (st StateTarget) Name() string {
+	parts := []string{st.Stage}
+	if st.Service != "" {
+		parts = append(parts, st.Service)
+	}
+	if st.Env != "" {
+		parts = append(parts, st.Env)
+	}
+	return strings.Join(parts, "-")
+}
+
+// TerraformDirs are the terraform directories of an environment of the stage, one for each grouping unit.
+func (sc StageConf) TerraformDirs(c CommonConf, env string) []string {
+	dirs := []string{}
+	for _, g := range sc.GroupingUnits {
+		dirs = append(dirs, filepath.Join(c.CheckoutPath, sc.Repo, g, env))
+	}
+	return dirs
+}
+
+// stateStageConf is the configuration of the stages with terraform state, with the same repositories, environments
+// and grouping units used by the deploy and destroy of the stage. The service is only used by the app infra stage.
+func stateStageConf(tfvars GlobalTFVars, stage, service string) (StageConf, error) {
+	infraRepos := tfvars.InfraCloudbuildV2RepositoryConfig.Repositories
+	envs := slices.Sorted(maps.Keys(tfvars.Envs))
+	switch stage {
+	case MultitenantStep:
+		return StageConf{Stage: stage, Step: stage, Repo: infraRepos["multitenant"].RepositoryName, Envs: envs, GroupingUnits: []string{"envs"}}, nil
+	case FleetscopeStep:
+		return StageConf{Stage: stage, Step: stage, Repo: infraRepos["fleetscope"].RepositoryName, Envs: envs, GroupingUnits: []string{"envs"}}, nil
+	case AppFactoryStep:
+		return StageConf{Stage: stage, Step: stage, Repo: infraRepos["applicationfactory"].RepositoryName, Envs: []string{"shared"}, GroupingUnits: []string{"envs"}}, nil
+	case AppInfraStep:
+		for exampleName, services := range tfvars.Applications {
+			if _, ok := services[service]; ok {
+				return StageConf{
+					Stage:         stage,
+					Step:          stage,
+					Repo:          infraRepos[service].RepositoryName,
+					Envs:          append([]string{"shared"}, envs...),
+					GroupingUnits: []string{fmt.Sprintf("apps/%s/%s/envs", exampleName, service)},
+				}, nil
+			}
+		}
+		return StageConf{}, fmt.Errorf("service '%s' not found in the applications, expected one of: %s", service, strings.Join(applicationServices(tfvars), ", "))
+	case AppSourceStep:
+		return StageConf{}, fmt.Errorf("stage %s has no terraform state", stage)
+	default:
+		return StageConf{}, fmt.Errorf("unknown stage '%s'", stage)
+	}
+}
+
+// applicationServices are the names of the services of all the applications.
+func applicationServices(tfvars GlobalTFVars) []string {
+	services := []string{}
+	for _, s := range tfvars.Applications {
+		services = append(services, slices.Collect(maps.Keys(s))...)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// resolveStateTarget finds the terraform directory of a stage environment, without the service account.
+func resolveStateTarget(tfvars GlobalTFVars, c CommonConf, stage, env, service string) (StateTarget, error) {
+	if stage == BootstrapStep {
+		if env != "" || service != "" {
+			return StateTarget{}, fmt.Errorf("stage %s has no environments or services", stage)
+		}
+		return StateTarget{Stage: stage, Dir: c.BootstrapDir()}, nil
+	}
+	if stage == AppInfraStep && service == "" {
+		if services := applicationServices(tfvars); len(services) == 1 {
+			service = services[0]
+		} else {
+			return StateTarget{}, fmt.Errorf("the service is required for stage %s, expected one of: %s", stage, strings.Join(services, ", "))
+		}
+	} else if stage != AppInfraStep && service != "" {
+		return StateTarget{}, fmt.Errorf("stage %s has no services", stage)
+	}
+	sc, err := stateStageConf(tfvars, stage, service)
+	if err != nil {
+		return StateTarget{}, err
+	}
+	if !slices.Contains(sc.Envs, env) {
+		return StateTarget{}, fmt.Errorf("environment '%s' not found in stage %s, expected one of: %s", env, stage, strings.Join(sc.Envs, ", "))
+	}
+	branch := env
+	if branch == "shared" {
+		branch = "production"
+	}
+	return StateTarget{
+		Stage:   stage,
+		Env:     env,
+		Service: service,
+		Dir:     sc.TerraformDirs(c, env)[0],
+		Repo:    filepath.Join(c.CheckoutPath, sc.Repo),
+		Branch:  branch,
+	}, nil
+}
+
+// ResolveStateTarget finds the terraform directory and the service account of a stage environment.
+// The service is only required by the app infra stage when there is more than one application service.
+func ResolveStateTarget(t testing.TB, tfvars GlobalTFVars, c CommonConf, stage, env, service string) (StateTarget, error) {
+	st, err := resolveStateTarget(tfvars, c, stage, env, service)
+	if err != nil {
+		return StateTarget{}, err
+	}
+	switch st.Stage {
+	case BootstrapStep:
+		if _, err := PrepareBootstrapDir(t, c); err != nil {
+			return StateTarget{}, err
+		}
+	case MultitenantStep, FleetscopeStep, AppFactoryStep:
+		keys := map[string]string{MultitenantStep: "multitenant", FleetscopeStep: "fleetscope", AppFactoryStep: "applicationfactory"}
+		st.ServiceAccount = GetBootstrapStepOutputs(t, c.BootstrapDir(), c.TerraformBinary, c.Identity).CBServiceAccountsEmails[keys[st.Stage]]
+	case AppInfraStep:
+		repo := tfvars.InfraCloudbuildV2RepositoryConfig.Repositories["applicationfactory"].RepositoryName
+		outputs := GetAppFactoryStepOutputs(t, filepath.Join(c.CheckoutPath, repo), c.TerraformBinary, c.Identity)
+		for exampleName, services := range tfvars.Applications {
+			if _, ok := services[st.Service]; ok {
+				email := strings.Split(outputs.AppGroup[fmt.Sprintf("%s.%s", exampleName, st.Service)].AppCloudbuildWorkspaceCloudbuildSAEmail, "/")
+				st.ServiceAccount = email[len(email)-1]
+			}
+		}
+	}
+	return st, nil
+}
+
+// StateCommand runs terraform state commands in a stage environment.
+// The mutations of the state are always preceded by a backup of the state in BackupDir.
+type StateCommand struct {
+	Target    StateTarget
+	BackupDir string
+	options   *terraform.Options
+	// identity is the identity of the terraform commands, it impersonates the service account of the target.
+	identity credentials.Identity
+}
+
+// NewStateCommand checks out the branch of the environment and initializes the terraform directory.
+func NewStateCommand(t testing.TB, st StateTarget, backupDir string, c CommonConf) (*StateCommand, error) {
+	exist, err := utils.FileExists(st.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, fmt.Errorf("terraform directory %s not found, the stage must be deployed first", st.Dir)
+	}
+	if st.Repo != "" {
+		if err := utils.GetRepoOnly(t, st.Repo, c.Logger).CheckoutBranch(st.Branch); err != nil {
+			return nil, err
+		}
+	}
+	options := &terraform.Options{
+		TerraformBinary:    c.TerraformBinary,
+		TerraformDir:       st.Dir,
+		Logger:             c.Logger,
+		NoColor:            true,
+		BackendConfig:      c.Backend.InitConfig(),
+		MaxRetries:         MaxErrorRetries,
+		TimeBetweenRetries: TimeBetweenErrorRetries,
+	}
+	s := &StateCommand{Target: st, BackupDir: backupDir, options: options, identity: c.Identity.Impersonate(st.ServiceAccount)}
+	t.Logf("Running terraform in %s as %s", st.Dir, s.identity)
+	if err := s.identity.SetTerraformEnv(options); err != nil {
+		return nil, err
+	}
+	if _, err := terraform.InitE(t, options); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// run runs a terraform command and returns the standard output. The environment of the identity is set on each
+// command, the short-lived access tokens are read again.
+func (s *StateCommand) run(t testing.TB, args ...string) (string, error) {
+	if err := s.identity.SetTerraformEnv(s.options); err != nil {
+		return "", err
+	}
+	return terraform.RunTerraformCommandAndGetStdoutE(t, s.options, args...)
+}
+
+// List lists the resources in the state, optionally filtered by addresses.
+func (s *StateCommand) List(t testing.TB, addresses ...string) (string, error) {
+	return s.run(t, append([]string{"state", "list"}, addresses...)...)
+}
+
+// Show shows a resource of the state.
+func (s *StateCommand) Show(t testing.TB, address string) (string, error) {
+	return s.run(t, "state", "show", "-no-color", address)
+}
+
+// Pull returns the content of the remote state.
+func (s *StateCommand) Pull(t testing.TB) (string, error) {
+	return s.run(t, "state", "pull")
+}
+
+// Backup saves the content of the remote state in a new file of the backup directory and returns its path.
+// The backup files are only readable by the owner, the state can have sensitive values.
+func (s *StateCommand) Backup(t testing.TB) (string, error) {
+	state, err := s.Pull(t)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(s.BackupDir, 0700); err != nil {
+		return "", err
+	}
+	file := filepath.Join(s.BackupDir, fmt.Sprintf("%s-%s.tfstate", s.Target.Name(), time.Now().UTC().Format("20060102T150405.000Z")))
+	if err := os.WriteFile(file, []byte(state), 0600); err != nil {
+		return "", err
+	}
+	t.Logf("State of %s saved in %s", s.Target.Name(), file)
+	return file, nil
+}
+
+// Import backs up the state and imports an existing resource in the address.
+func (s *StateCommand) Import(t testing.TB, address, id string) (string, error) {
+	if _, err := s.Backup(t); err != nil {
+		return "", fmt.Errorf("state backup failed, import not executed: %w", err)
+	}
+	return s.run(t, "import", "-no-color", "-input=false", address, id)
+}
+
+// Remove backs up the state and removes the addresses from the state, the resources are not destroyed.
+func (s *StateCommand) Remove(t testing.TB, addresses ...string) (string, error) {
+	if len(addresses) == 0 {
+		return "", fmt.Errorf("at least one address is required")
+	}
+	if _, err := s.Backup(t); err != nil {
+		return "", fmt.Errorf("state backup failed, remove not executed: %w", err)
+	}
+	return s.run(t, append([]string{"state", "rm"}, addresses...)...)
+}
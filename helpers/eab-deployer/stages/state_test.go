@@ -0,0 +1,137 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"path/filepath"
+	gotest "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func stateTestConfig() GlobalTFVars {
+	return GlobalTFVars{
+		Envs: map[string]Env{
+			"development": {},
+			"production":  {},
+		},
+		InfraCloudbuildV2RepositoryConfig: CloudbuildV2RepositoryConfig{
+			Repositories: map[string]Repository{
+				"multitenant":        {RepositoryName: "eab-multitenant"},
+				"fleetscope":         {RepositoryName: "eab-fleetscope"},
+				"applicationfactory": {RepositoryName: "eab-applicationfactory"},
+				"hello-world":        {RepositoryName: "eab-default-example-hello-world"},
+			},
+		},
+		Applications: map[string]map[string]ApplicationService{
+			"default-example": {"hello-world": {}},
+		},
+	}
+}
+
+func TestResolveStateTarget(t *gotest.T) {
+	c := CommonConf{EABPath: "/eab", CheckoutPath: "/checkout"}
+	tests := []struct {
+		name    string
+		stage   string
+		env     string
+		service string
+		want    StateTarget
+	}{
+		{
+			name:  "bootstrap",
+			stage: BootstrapStep,
+			want:  StateTarget{Stage: BootstrapStep, Dir: filepath.Join("/checkout", StagesDir, "1-bootstrap")},
+		},
+		{
+			name:  "fleetscope",
+			stage: FleetscopeStep,
+			env:   "development",
+			want: StateTarget{
+				Stage:  FleetscopeStep,
+				Env:    "development",
+				Dir:    filepath.Join("/checkout", "eab-fleetscope", "envs", "development"),
+				Repo:   filepath.Join("/checkout", "eab-fleetscope"),
+				Branch: "development",
+			},
+		},
+		{
+			name:  "app factory",
+			stage: AppFactoryStep,
+			env:   "shared",
+			want: StateTarget{
+				Stage:  AppFactoryStep,
+				Env:    "shared",
+				Dir:    filepath.Join("/checkout", "eab-applicationfactory", "envs", "shared"),
+				Repo:   filepath.Join("/checkout", "eab-applicationfactory"),
+				Branch: "production",
+			},
+		},
+		{
+			name:  "app infra with the only service",
+			stage: AppInfraStep,
+			env:   "production",
+			want: StateTarget{
+				Stage:   AppInfraStep,
+				Env:     "production",
+				Service: "hello-world",
+				Dir:     filepath.Join("/checkout", "eab-default-example-hello-world", "apps", "default-example", "hello-world", "envs", "production"),
+				Repo:    filepath.Join("/checkout", "eab-default-example-hello-world"),
+				Branch:  "production",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *gotest.T) {
+			got, err := resolveStateTarget(stateTestConfig(), c, tt.stage, tt.env, tt.service)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestResolveStateTargetErrors(t *gotest.T) {
+	c := CommonConf{EABPath: "/eab", CheckoutPath: "/checkout"}
+	g := stateTestConfig()
+	g.Applications["default-example"]["other-service"] = ApplicationService{}
+	tests := []struct {
+		name    string
+		stage   string
+		env     string
+		service string
+		errMsg  string
+	}{
+		{name: "unknown stage", stage: "7-unknown", env: "production", errMsg: "unknown stage '7-unknown'"},
+		{name: "app source", stage: AppSourceStep, env: "production", errMsg: "stage 6-appsource has no terraform state"},
+		{name: "bootstrap env", stage: BootstrapStep, env: "production", errMsg: "stage 1-bootstrap has no environments or services"},
+		{name: "unknown env", stage: MultitenantStep, env: "staging", errMsg: "environment 'staging' not found in stage 2-multitenant, expected one of: development, production"},
+		{name: "shared env", stage: MultitenantStep, env: "shared", errMsg: "environment 'shared' not found"},
+		{name: "service of infra stage", stage: FleetscopeStep, env: "production", service: "hello-world", errMsg: "stage 3-fleetscope has no services"},
+		{name: "missing service", stage: AppInfraStep, env: "shared", errMsg: "the service is required for stage 5-appinfra, expected one of: hello-world, other-service"},
+		{name: "unknown service", stage: AppInfraStep, env: "shared", service: "cart", errMsg: "service 'cart' not found in the applications"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *gotest.T) {
+			_, err := resolveStateTarget(g, c, tt.stage, tt.env, tt.service)
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestStateTargetName(t *gotest.T) {
+	assert.Equal(t, "1-bootstrap", StateTarget{Stage: BootstrapStep}.Name())
+	assert.Equal(t, "3-fleetscope-production", StateTarget{Stage: FleetscopeStep, Env: "production"}.Name())
+	assert.Equal(t, "5-appinfra-hello-world-shared", StateTarget{Stage: AppInfraStep, Service: "hello-world", Env: "shared"}.Name())
+}
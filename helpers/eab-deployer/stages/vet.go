@@ -15,60 +15,59 @@
 package stages
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"path/filepath"
-	"strings"
+	"time"
 
 	"github.com/gruntwork-io/terratest/modules/logger"
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/mitchellh/go-testing-interface"
-	"github.com/tidwall/gjson"
 
-	"github.com/GoogleCloudPlatform/cloud-foundation-toolkit/infra/blueprint-test/pkg/gcloud"
-	"github.com/GoogleCloudPlatform/cloud-foundation-toolkit/infra/blueprint-test/pkg/utils"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/policy"
 )
 
-// TerraformVet runs gcloud terraform vet on the plan of the provided terraform directory
-func TerraformVet(t testing.TB, terraformDir, policyPath, project string) error {
+// TerraformVet evaluates the saved plan of the provided terraform options against the policy library.
+// The policies that apply to the stage and the waivers are loaded from the policy path.
+// The plan file is the one applied afterwards, so the result covers the changes that are applied.
+func TerraformVet(t testing.TB, options *terraform.Options, policyPath, stage string) error {
 
 	fmt.Println("")
-	fmt.Println("# Running gcloud terraform vet")
+	fmt.Println("# Running terraform vet")
 	fmt.Println("")
 
-	options := &terraform.Options{
-		TerraformDir:       terraformDir,
-		Logger:             logger.Discard,
-		NoColor:            true,
-		PlanFilePath:       filepath.Join(os.TempDir(), "plan.tfplan"),
-		MaxRetries:         MaxErrorRetries,
-		TimeBetweenRetries: TimeBetweenErrorRetries,
+	if options.PlanFilePath == "" {
+		return fmt.Errorf("no plan file to vet in %s", options.TerraformDir)
 	}
-	_, err := terraform.PlanE(t, options)
+	showOptions := *options
+	showOptions.Logger = logger.Discard
+	jsonPlan, err := terraform.ShowE(t, &showOptions)
 	if err != nil {
 		return err
 	}
-	jsonPlan, err := terraform.ShowE(t, options)
+
+	set, err := policy.LoadSet(policyPath, stage)
 	if err != nil {
 		return err
 	}
-	jsonFile, err := utils.WriteTmpFileWithExtension(jsonPlan, "json")
-	defer os.Remove(jsonFile)
-	defer os.Remove(options.PlanFilePath)
-	if err != nil {
-		return err
+	for _, c := range set.Skipped {
+		fmt.Printf("# Constraint %s skipped, its template does not validate terraform resource changes\n", c)
 	}
-	command := fmt.Sprintf("beta terraform vet %s --policy-library=%s --project=%s --quiet", jsonFile, policyPath, project)
-	result, err := gcloud.RunCmdE(t, command)
-	if err != nil && !(strings.Contains(err.Error(), "Validating resources") && strings.Contains(err.Error(), "done")) {
+	violations, err := set.Evaluate(context.Background(), []byte(jsonPlan), time.Now())
+	if err != nil {
 		return err
 	}
-	if !gjson.Valid(result) {
-		return fmt.Errorf("Error parsing output, invalid json: %s", result)
-	}
 
-	if len(gjson.Parse(result).Array()) > 0 {
-		return fmt.Errorf("Policy violations found: %s", result)
+	count := 0
+	for _, v := range violations {
+		if v.Waived {
+			fmt.Printf("# WAIVED %s %s: %s (%s)\n", v.Constraint, v.Address, v.Message, v.Reason)
+			continue
+		}
+		count++
+		fmt.Printf("# VIOLATION %s %s: %s\n", v.Constraint, v.Address, v.Message)
+	}
+	if count > 0 {
+		return fmt.Errorf("%d policy violations found in %s", count, options.TerraformDir)
 	}
 	fmt.Println("")
 	fmt.Println("# The configuration passed tf vet.")
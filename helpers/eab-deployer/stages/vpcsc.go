@@ -0,0 +1,234 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mitchellh/go-testing-interface"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+)
+
+const (
+	perimeterModeDryRun  = "DRY_RUN"
+	perimeterModeEnforce = "ENFORCE"
+
+	// violationsFreshness is how far back the dry-run violation logs are read.
+	violationsFreshness = "7d"
+	violationsLimit     = 1000
+)
+
+// VPCSCInfo is the information from Google Cloud needed to analyze the service perimeter.
+type VPCSCInfo interface {
+	GetServicePerimeter(t testing.TB, name string) gcp.ServicePerimeter
+	GetAccessLevelMembers(t testing.TB, name string) []string
+	GetProjectNumber(t testing.TB, project string) string
+	ListVPCSCViolations(t testing.TB, project, freshness string, limit int) []gcp.VPCSCViolation
+}
+
+// ViolationSummary groups the dry-run violations of a method.
+type ViolationSummary struct {
+	Service    string
+	Method     string
+	Reason     string
+	Principals []string
+	Count      int
+}
+
+// VPCSCReport is the result of the analysis of the service perimeter.
+type VPCSCReport struct {
+	Mode string
+	// RestrictedAPIs are the APIs used by the deployment that are restricted by the perimeter.
+	RestrictedAPIs []string
+	Findings       []string
+	Violations     []ViolationSummary
+}
+
+// AnalyzeVPCSC checks if the service perimeter allows the deployment.
+// The perimeter configuration checked is the dry-run spec in DRY_RUN mode and the enforced status in ENFORCE mode.
+// The serviceAccounts are the stage service accounts by repository key, as in ValidatePermissions.
+func AnalyzeVPCSC(t testing.TB, g GlobalTFVars, serviceAccounts map[string]string, v VPCSCInfo) VPCSCReport {
+	r := VPCSCReport{Mode: perimeterModeDryRun, RestrictedAPIs: []string{}, Findings: []string{}, Violations: []ViolationSummary{}}
+	if g.ServicePerimeterMode != nil && *g.ServicePerimeterMode != "" {
+		r.Mode = *g.ServicePerimeterMode
+	}
+	if r.Mode != perimeterModeDryRun && r.Mode != perimeterModeEnforce {
+		r.Findings = append(r.Findings, fmt.Sprintf("Invalid service_perimeter_mode %s, valid modes are %s and %s.", r.Mode, perimeterModeEnforce, perimeterModeDryRun))
+		return r
+	}
+	if g.AccessLevelName == nil || *g.AccessLevelName == "" {
+		r.Findings = append(r.Findings, "You must provide the associated Access Level name to be used with Service Perimeter.")
+		return r
+	}
+
+	p := v.GetServicePerimeter(t, *g.ServicePerimeterName)
+	if p.Name == "" {
+		r.Findings = append(r.Findings, fmt.Sprintf("Service perimeter %s not found.", *g.ServicePerimeterName))
+		return r
+	}
+	config := p.Status
+	if r.Mode == perimeterModeDryRun {
+		config = p.Spec
+	}
+
+	if !slices.Contains(config.AccessLevels, *g.AccessLevelName) {
+		r.Findings = append(r.Findings, fmt.Sprintf("The access level %s is not associated with the %s configuration of the service perimeter.", *g.AccessLevelName, r.Mode))
+	}
+
+	// APIs
+	used := slices.Clone(requiredAPIs)
+	if g.InfraProjectAPIs != nil {
+		used = append(used, *g.InfraProjectAPIs...)
+	}
+	slices.Sort(used)
+	used = slices.Compact(used)
+	notRestricted := []string{}
+	for _, api := range used {
+		if slices.Contains(config.RestrictedServices, api) {
+			r.RestrictedAPIs = append(r.RestrictedAPIs, api)
+		} else {
+			notRestricted = append(notRestricted, api)
+		}
+	}
+	if len(notRestricted) > 0 {
+		r.Findings = append(r.Findings, fmt.Sprintf("APIs used by the deployment that are not restricted by the perimeter, their data is not protected: %s", strings.Join(notRestricted, ", ")))
+	}
+
+	// worker pool
+	if wp, err := extractInfoWithRegex(g.WorkerPoolID, `projects/(?P<project>[^/]+)/locations/(?P<location>[^/]+)/workerPools/(?P<workerPool>[^/]+)`); err == nil && len(r.RestrictedAPIs) > 0 {
+		resource := fmt.Sprintf("projects/%s", v.GetProjectNumber(t, wp["project"]))
+		if !slices.Contains(config.Resources, resource) && !slices.ContainsFunc(config.IngressPolicies, func(i gcp.PerimeterPolicy) bool {
+			return slices.Contains(i.Sources, resource) || slices.Contains(i.Sources, "*")
+		}) {
+			r.Findings = append(r.Findings, fmt.Sprintf("The worker pool project %s (%s) is outside the perimeter and no ingress policy allows requests from it to the restricted services.", wp["project"], resource))
+		}
+	}
+
+	// stage service accounts
+	members := v.GetAccessLevelMembers(t, *g.AccessLevelName)
+	for _, key := range slices.Sorted(maps.Keys(serviceAccounts)) {
+		member := fmt.Sprintf("serviceAccount:%s", serviceAccounts[key])
+		if slices.Contains(members, member) {
+			continue
+		}
+		if !slices.ContainsFunc(config.IngressPolicies, func(i gcp.PerimeterPolicy) bool { return allowsIdentity(i, member) }) {
+			r.Findings = append(r.Findings, fmt.Sprintf("The %s service account %s is not a member of the access level %s and no ingress policy allows it.", key, serviceAccounts[key], *g.AccessLevelName))
+		}
+		if !slices.ContainsFunc(config.EgressPolicies, func(e gcp.PerimeterPolicy) bool { return allowsIdentity(e, member) }) {
+			r.Findings = append(r.Findings, fmt.Sprintf("The %s service account %s is not a member of the access level %s and no egress policy allows it.", key, serviceAccounts[key], *g.AccessLevelName))
+		}
+	}
+
+	if r.Mode == perimeterModeDryRun {
+		r.Violations = summarizeViolations(t, g, v)
+	}
+	return r
+}
+
+// allowsIdentity checks if a perimeter policy allows the identity.
+func allowsIdentity(p gcp.PerimeterPolicy, member string) bool {
+	switch p.IdentityType {
+	case "ANY_IDENTITY":
+		return true
+	case "ANY_SERVICE_ACCOUNT":
+		return strings.HasPrefix(member, "serviceAccount:")
+	}
+	return slices.Contains(p.Identities, member)
+}
+
+// summarizeViolations groups the recent dry-run violations of the seed and network projects by method.
+func summarizeViolations(t testing.TB, g GlobalTFVars, v VPCSCInfo) []ViolationSummary {
+	projects := []string{g.ProjectID}
+	for _, env := range slices.Sorted(maps.Keys(g.Envs)) {
+		if p := g.Envs[env].NetworkProjectID; p != "" && !slices.Contains(projects, p) {
+			projects = append(projects, p)
+		}
+	}
+
+	summary := map[string]*ViolationSummary{}
+	for _, project := range projects {
+		for _, violation := range v.ListVPCSCViolations(t, project, violationsFreshness, violationsLimit) {
+			if !violation.DryRun {
+				continue
+			}
+			key := strings.Join([]string{violation.Service, violation.Method, violation.Reason}, "|")
+			s, ok := summary[key]
+			if !ok {
+				s = &ViolationSummary{Service: violation.Service, Method: violation.Method, Reason: violation.Reason, Principals: []string{}}
+				summary[key] = s
+			}
+			s.Count++
+			if violation.Principal != "" && !slices.Contains(s.Principals, violation.Principal) {
+				s.Principals = append(s.Principals, violation.Principal)
+			}
+		}
+	}
+
+	result := []ViolationSummary{}
+	for _, s := range summary {
+		slices.Sort(s.Principals)
+		result = append(result, *s)
+	}
+	slices.SortFunc(result, func(a, b ViolationSummary) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Service, b.Service), cmp.Compare(a.Method, b.Method), cmp.Compare(a.Reason, b.Reason))
+	})
+	return result
+}
+
+// ViolationsTable writes the dry-run violations summary as a table.
+func (r VPCSCReport) ViolationsTable(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "# COUNT\tSERVICE\tMETHOD\tREASON\tPRINCIPALS")
+	for _, s := range r.Violations {
+		fmt.Fprintf(tw, "# %d\t%s\t%s\t%s\t%s\n", s.Count, s.Service, s.Method, s.Reason, strings.Join(s.Principals, ","))
+	}
+	tw.Flush()
+}
+
+// ValidateVPCSCRequirements checks if the service perimeter allows the deployment and, in DRY_RUN mode,
+// summarizes the requests that would be denied when the perimeter is enforced.
+func ValidateVPCSCRequirements(t testing.TB, g GlobalTFVars, gcpConf gcp.GCP, serviceAccounts map[string]string) {
+	validateVPCSCRequirements(t, g, serviceAccounts, gcpConf, os.Stdout)
+}
+
+func validateVPCSCRequirements(t testing.TB, g GlobalTFVars, serviceAccounts map[string]string, v VPCSCInfo, out io.Writer) {
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "# Checking VPC-SC requirements.")
+	if g.ServicePerimeterName == nil || *g.ServicePerimeterName == "" {
+		fmt.Fprintln(out, "# No Service Perimeter provided.")
+		return
+	}
+	r := AnalyzeVPCSC(t, g, serviceAccounts, v)
+	for _, f := range r.Findings {
+		fmt.Fprintf(out, "# %s\n", f)
+	}
+	if r.Mode != perimeterModeDryRun {
+		return
+	}
+	if len(r.Violations) == 0 {
+		fmt.Fprintf(out, "# No dry-run violations in the last %s.\n", violationsFreshness)
+		return
+	}
+	fmt.Fprintf(out, "# Requests in the last %s that would be denied when the perimeter is enforced:\n", violationsFreshness)
+	r.ViolationsTable(out)
+}
@@ -0,0 +1,155 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stages
+
+import (
+	"bytes"
+	gotest "testing"
+
+	"github.com/mitchellh/go-testing-interface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/gcp"
+)
+
+// fakeVPCSC is a VPCSCInfo with fixed data.
+type fakeVPCSC struct {
+	perimeter  gcp.ServicePerimeter
+	members    []string
+	violations map[string][]gcp.VPCSCViolation
+}
+
+func (f fakeVPCSC) GetServicePerimeter(t testing.TB, name string) gcp.ServicePerimeter {
+	return f.perimeter
+}
+
+func (f fakeVPCSC) GetAccessLevelMembers(t testing.TB, name string) []string {
+	return f.members
+}
+
+func (f fakeVPCSC) GetProjectNumber(t testing.TB, project string) string {
+	return map[string]string{"prj-seed": "111111111111", "prj-pool": "222222222222"}[project]
+}
+
+func (f fakeVPCSC) ListVPCSCViolations(t testing.TB, project, freshness string, limit int) []gcp.VPCSCViolation {
+	return f.violations[project]
+}
+
+func vpcscTestConfig(mode string) GlobalTFVars {
+	perimeter := "accessPolicies/123/servicePerimeters/eab"
+	level := "accessPolicies/123/accessLevels/eab"
+	return GlobalTFVars{
+		ProjectID:            "prj-seed",
+		WorkerPoolID:         "projects/prj-pool/locations/us-central1/workerPools/cb-pool",
+		ServicePerimeterName: &perimeter,
+		ServicePerimeterMode: &mode,
+		AccessLevelName:      &level,
+		InfraProjectAPIs:     &[]string{"sqladmin.googleapis.com", "redis.googleapis.com"},
+		Envs: map[string]Env{
+			"development": {NetworkProjectID: "prj-d-svpc"},
+			"production":  {NetworkProjectID: "prj-p-svpc"},
+		},
+	}
+}
+
+func TestAllowsIdentity(t *gotest.T) {
+	sa := "serviceAccount:sa@prj.iam.gserviceaccount.com"
+	assert.True(t, allowsIdentity(gcp.PerimeterPolicy{IdentityType: "ANY_IDENTITY"}, "user:admin@example.com"))
+	assert.True(t, allowsIdentity(gcp.PerimeterPolicy{IdentityType: "ANY_SERVICE_ACCOUNT"}, sa))
+	assert.False(t, allowsIdentity(gcp.PerimeterPolicy{IdentityType: "ANY_USER_ACCOUNT"}, sa))
+	assert.True(t, allowsIdentity(gcp.PerimeterPolicy{Identities: []string{sa}}, sa))
+}
+
+func TestAnalyzeVPCSCEnforced(t *gotest.T) {
+	g := vpcscTestConfig("ENFORCE")
+	v := fakeVPCSC{
+		perimeter: gcp.ServicePerimeter{
+			Name: *g.ServicePerimeterName,
+			Status: gcp.PerimeterConfig{
+				Resources:          []string{"projects/111111111111"},
+				AccessLevels:       []string{*g.AccessLevelName},
+				RestrictedServices: append(append([]string{}, requiredAPIs...), "sqladmin.googleapis.com"),
+				IngressPolicies: []gcp.PerimeterPolicy{
+					{Sources: []string{"projects/222222222222"}, Identities: []string{"serviceAccount:fs-sa@prj-seed.iam.gserviceaccount.com"}},
+				},
+				EgressPolicies: []gcp.PerimeterPolicy{{IdentityType: "ANY_SERVICE_ACCOUNT"}},
+			},
+		},
+		members: []string{"serviceAccount:mt-sa@prj-seed.iam.gserviceaccount.com"},
+	}
+
+	r := AnalyzeVPCSC(t, g, map[string]string{
+		"multitenant":        "mt-sa@prj-seed.iam.gserviceaccount.com",
+		"fleetscope":         "fs-sa@prj-seed.iam.gserviceaccount.com",
+		"applicationfactory": "af-sa@prj-seed.iam.gserviceaccount.com",
+	}, v)
+	assert.Equal(t, []string{
+		"APIs used by the deployment that are not restricted by the perimeter, their data is not protected: redis.googleapis.com",
+		"The applicationfactory service account af-sa@prj-seed.iam.gserviceaccount.com is not a member of the access level accessPolicies/123/accessLevels/eab and no ingress policy allows it.",
+	}, r.Findings)
+	assert.Contains(t, r.RestrictedAPIs, "sqladmin.googleapis.com")
+	assert.Empty(t, r.Violations, "violations are only read in DRY_RUN mode")
+}
+
+func TestAnalyzeVPCSCDryRun(t *gotest.T) {
+	g := vpcscTestConfig("DRY_RUN")
+	v := fakeVPCSC{
+		perimeter: gcp.ServicePerimeter{
+			Name:   *g.ServicePerimeterName,
+			Status: gcp.PerimeterConfig{AccessLevels: []string{*g.AccessLevelName}},
+			Spec: gcp.PerimeterConfig{
+				Resources:          []string{"projects/111111111111"},
+				RestrictedServices: []string{"storage.googleapis.com"},
+			},
+		},
+		violations: map[string][]gcp.VPCSCViolation{
+			"prj-seed": {
+				{Service: "storage.googleapis.com", Method: "google.storage.objects.get", Reason: "NO_MATCHING_ACCESS_LEVEL", Principal: "b@example.com", DryRun: true},
+				{Service: "storage.googleapis.com", Method: "google.storage.objects.get", Reason: "NO_MATCHING_ACCESS_LEVEL", Principal: "a@example.com", DryRun: true},
+				{Service: "storage.googleapis.com", Method: "google.storage.objects.get", Reason: "NO_MATCHING_ACCESS_LEVEL", Principal: "a@example.com", DryRun: false},
+			},
+			"prj-p-svpc": {
+				{Service: "compute.googleapis.com", Method: "v1.compute.subnetworks.get", Reason: "RESOURCES_NOT_IN_SAME_SERVICE_PERIMETER", Principal: "a@example.com", DryRun: true},
+			},
+		},
+	}
+
+	r := AnalyzeVPCSC(t, g, map[string]string{}, v)
+	assert.Equal(t, "The access level accessPolicies/123/accessLevels/eab is not associated with the DRY_RUN configuration of the service perimeter.", r.Findings[0])
+	assert.Equal(t, []string{"storage.googleapis.com"}, r.RestrictedAPIs)
+	assert.Equal(t, "The worker pool project prj-pool (projects/222222222222) is outside the perimeter and no ingress policy allows requests from it to the restricted services.", r.Findings[2])
+	assert.Equal(t, []ViolationSummary{
+		{Service: "storage.googleapis.com", Method: "google.storage.objects.get", Reason: "NO_MATCHING_ACCESS_LEVEL", Principals: []string{"a@example.com", "b@example.com"}, Count: 2},
+		{Service: "compute.googleapis.com", Method: "v1.compute.subnetworks.get", Reason: "RESOURCES_NOT_IN_SAME_SERVICE_PERIMETER", Principals: []string{"a@example.com"}, Count: 1},
+	}, r.Violations)
+
+	var out bytes.Buffer
+	validateVPCSCRequirements(t, g, map[string]string{}, v, &out)
+	assert.Contains(t, out.String(), "# Requests in the last 7d that would be denied when the perimeter is enforced:")
+	assert.Contains(t, out.String(), "# 2      storage.googleapis.com  google.storage.objects.get  NO_MATCHING_ACCESS_LEVEL")
+}
+
+func TestValidateVPCSCRequirements(t *gotest.T) {
+	var out bytes.Buffer
+	validateVPCSCRequirements(t, GlobalTFVars{}, map[string]string{}, fakeVPCSC{}, &out)
+	assert.Equal(t, "\n# Checking VPC-SC requirements.\n# No Service Perimeter provided.\n", out.String())
+
+	g := vpcscTestConfig("DRY_RUN")
+	g.AccessLevelName = nil
+	assert.Equal(t, []string{"You must provide the associated Access Level name to be used with Service Perimeter."}, AnalyzeVPCSC(t, g, map[string]string{}, fakeVPCSC{}).Findings)
+
+	g = vpcscTestConfig("DRY_RUN")
+	assert.Equal(t, []string{"Service perimeter accessPolicies/123/servicePerimeters/eab not found."}, AnalyzeVPCSC(t, g, map[string]string{}, fakeVPCSC{}).Findings)
+}
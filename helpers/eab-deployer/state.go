@@ -0,0 +1,81 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/deployer"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/msg"
+)
+
+const stateUsage = `usage:
+  eab-deployer state list   -stage STAGE [-env ENV] [-service SERVICE] [ADDRESS...]
+  eab-deployer state show   -stage STAGE [-env ENV] [-service SERVICE] ADDRESS
+  eab-deployer state import -stage STAGE [-env ENV] [-service SERVICE] ADDRESS ID
+  eab-deployer state rm     -stage STAGE [-env ENV] [-service SERVICE] ADDRESS...
+  eab-deployer state pull   -stage STAGE [-env ENV] [-service SERVICE] [-out FILE]
+  eab-deployer state backup -stage STAGE [-env ENV] [-service SERVICE]`
+
+// runStateCommand runs the state subcommands. The mutations must be confirmed unless the prompt is disabled.
+func runStateCommand(ctx context.Context, d *deployer.Deployer, args []string, disablePrompt bool) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing state command\n%s", stateUsage)
+	}
+	fs := flag.NewFlagSet("state "+args[0], flag.ContinueOnError)
+	stage := fs.String("stage", "", "Name of the `stage`, for example 3-fleetscope.")
+	env := fs.String("env", "", "Name of the `environment` of the stage, not used by 1-bootstrap.")
+	service := fs.String("service", "", "Name of the application `service` of 5-appinfra.")
+	out := fs.String("out", "", "Write the output to a `file` instead of the standard output.")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *stage == "" {
+		return fmt.Errorf("-stage is required\n%s", stateUsage)
+	}
+	r := deployer.StateRequest{Operation: args[0], Stage: *stage, Env: *env, Service: *service, Args: fs.Args()}
+
+	if (r.Operation == deployer.StateImport || r.Operation == deployer.StateRemove) && !disablePrompt {
+		if !msg.Confirm(fmt.Sprintf("# Run 'terraform %s %s' in the state of %s %s?", r.Operation, strings.Join(r.Args, " "), r.Stage, r.Env)) {
+			fmt.Println("# State not changed.")
+			return nil
+		}
+	}
+
+	result, err := d.State(ctx, r)
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		if err := os.WriteFile(*out, []byte(result), 0600); err != nil {
+			return err
+		}
+		fmt.Printf("# Output saved in %s\n", *out)
+		return nil
+	}
+	if r.Operation == deployer.StateBackup {
+		fmt.Printf("# State saved in %s\n", result)
+		return nil
+	}
+	fmt.Print(result)
+	if !strings.HasSuffix(result, "\n") {
+		fmt.Println()
+	}
+	return nil
+}
@@ -18,8 +18,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"regexp"
 	"sort"
 	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/telemetry"
 )
 
 const (
@@ -27,21 +32,74 @@ const (
 	destroyedStatus = "DESTROYED"
 	failedStatus    = "FAILED"
 	pendingStatus   = "PENDING"
+	pushedStatus    = "PUSHED"
+	runningStatus   = "RUNNING"
+	staleStatus     = "STALE"
+	waitingStatus   = "WAITING_APPROVAL"
+)
+
+// Operations and moments of the step hooks.
+const (
+	Apply   = "apply"
+	Destroy = "destroy"
+	Before  = "before"
+	After   = "after"
 )
 
 type Step struct {
 	Name   string `json:"name"`
 	Status string `json:"status"`
 	Error  string `json:"error"`
+	// Reason is why a stale step must be executed again.
+	Reason string `json:"reason,omitempty"`
+	// Inputs are the checksums of the inputs of a completed step.
+	Inputs map[string]string `json:"inputs,omitempty"`
+	// Push is the commit of a pushed step, the step is applied by the build of the commit.
+	Push *Push `json:"push,omitempty"`
+}
+
+// Push is a commit pushed to the repository of a stage, its build applies the step.
+type Push struct {
+	Project string `json:"project"`
+	Region  string `json:"region"`
+	Repo    string `json:"repo"`
+	Branch  string `json:"branch"`
+	Commit  string `json:"commit"`
+	// Service, if set, is the Cloud Deploy delivery pipeline of the release created by the build.
+	Service string `json:"service,omitempty"`
+}
+
+var commitRe = regexp.MustCompile(`^[0-9a-f]{7,64}$`)
+
+// ShortCommit is the short SHA of the commit, used in the names of the Cloud Deploy releases.
+// It fails when the commit read from the steps file is not a commit SHA.
+func (p Push) ShortCommit() (string, error) {
+	if !commitRe.MatchString(p.Commit) {
+		return "", fmt.Errorf("invalid commit '%s' of %s branch %s", p.Commit, p.Repo, p.Branch)
+	}
+	return p.Commit[0:7], nil
 }
 
 type Steps struct {
 	File  string          `json:"file"`
 	Steps map[string]Step `json:"steps"`
+	// OnChange, if set, is called for each step status change, including the start of a step execution or destruction.
+	OnChange func(Step) `json:"-"`
+	// Hook, if set, is called with the operation, apply or destroy, before and after a step is executed or
+	// destroyed. An error of the hook fails the step, the step is executed again by the next run.
+	Hook func(step, operation, when string) error `json:"-"`
+	// Trace, if set, is the Trace of the execution of the steps.
+	Trace *telemetry.Trace `json:"-"`
 }
 
 // String creates a string representation of the step
 func (s Step) String() string {
+	if s.Reason != "" {
+		return fmt.Sprintf("%s %s reason:%s", s.Name, s.Status, s.Reason)
+	}
+	if s.Push != nil {
+		return fmt.Sprintf("%s %s commit:%s", s.Name, s.Status, s.Push.Commit)
+	}
 	if s.Error == "" {
 		return fmt.Sprintf("%s %s", s.Name, s.Status)
 	}
@@ -89,6 +147,37 @@ func LoadSteps(file string) (Steps, error) {
 	return s, nil
 }
 
+// notify calls OnChange, if set, with the step.
+func (s Steps) notify(step Step) {
+	if s.OnChange != nil {
+		s.OnChange(step)
+	}
+}
+
+// hooked runs f between the calls of the hook of the step.
+func (s Steps) hooked(step, operation string, f func() error) error {
+	if s.Hook == nil {
+		return f()
+	}
+	if err := s.Hook(step, operation, Before); err != nil {
+		return err
+	}
+	if err := f(); err != nil {
+		return err
+	}
+	return s.Hook(step, operation, After)
+}
+
+// traced runs a hooked step in a span of the Trace of the steps and records its duration.
+func (s Steps) traced(step, operation string, f func() error) error {
+	attrs := []attribute.KeyValue{telemetry.StepKey.String(step), telemetry.OperationKey.String(operation)}
+	return s.Trace.Run("step "+step, attrs, func(sp *telemetry.Span) error {
+		err := s.hooked(step, operation, f)
+		telemetry.RecordStep(step, operation, sp.Duration(), err)
+		return err
+	})
+}
+
 // SaveSteps saves the current execution state of the steps in the file that was loaded.
 func (s Steps) SaveSteps() error {
 	f, err := json.MarshalIndent(s, "", "    ")
@@ -109,6 +198,7 @@ func (s Steps) CompleteStep(name string) error {
 		return err
 	}
 	fmt.Printf("# completing step '%s' execution\n", name)
+	s.notify(s.Steps[name])
 	return nil
 }
 
@@ -121,6 +211,73 @@ func (s Steps) IsStepComplete(name string) bool {
 	return false
 }
 
+// SetInputs saves the checksums of the inputs of a completed step.
+func (s Steps) SetInputs(name string, inputs map[string]string) error {
+	v, ok := s.Steps[name]
+	if !ok || v.Status != completedStatus {
+		return fmt.Errorf("step '%s' is not completed", name)
+	}
+	v.Inputs = inputs
+	s.Steps[name] = v
+	return s.SaveSteps()
+}
+
+// GetInputs gets the checksums of the inputs of a step, nil if they were not saved.
+func (s Steps) GetInputs(name string) map[string]string {
+	return s.Steps[name].Inputs
+}
+
+// MarkStale marks a completed step and its completed nested steps as stale, with the reason.
+// The nested steps are the steps named after the step or one of the prefixes, followed by a dot.
+// Stale steps are executed again by RunStep.
+func (s Steps) MarkStale(name, reason string, prefixes ...string) error {
+	prefixes = append([]string{name}, prefixes...)
+	stale := []Step{}
+	for _, v := range s.Steps {
+		if v.Status != completedStatus {
+			continue
+		}
+		for _, p := range prefixes {
+			if v.Name == p || strings.HasPrefix(v.Name, p+".") {
+				stale = append(stale, Step{Name: v.Name, Status: staleStatus, Reason: reason})
+				break
+			}
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].Name < stale[j].Name })
+	for _, v := range stale {
+		s.Steps[v.Name] = v
+	}
+	if err := s.SaveSteps(); err != nil {
+		return err
+	}
+	for _, v := range stale {
+		fmt.Printf("# marking step '%s' as stale: %s\n", v.Name, reason)
+		s.notify(v)
+	}
+	return nil
+}
+
+// WaitApproval notifies that a running step waits for the approval of the user, with the reason, and calls wait.
+// The step is running again when wait returns, the waiting status is not saved.
+func (s Steps) WaitApproval(name, reason string, wait func()) {
+	s.notify(Step{Name: name, Status: waitingStatus, Reason: reason})
+	wait()
+	s.notify(Step{Name: name, Status: runningStatus})
+}
+
+// IsStepStale checks if the given step is stale.
+func (s Steps) IsStepStale(name string) bool {
+	v, ok := s.Steps[name]
+	if ok {
+		return v.Status == staleStatus
+	}
+	return false
+}
+
 // StepExists checks if the given step exists
 func (s Steps) StepExists(name string) bool {
 	_, ok := s.Steps[name]
@@ -138,6 +295,7 @@ func (s Steps) FailStep(name string, err string) error {
 	if e != nil {
 		return e
 	}
+	s.notify(s.Steps[name])
 	return nil
 }
 
@@ -160,6 +318,7 @@ func (s Steps) ResetStep(name string) error {
 		return err
 	}
 	fmt.Printf("# resetting step '%s' execution\n", name)
+	s.notify(s.Steps[name])
 	if isNested(name) {
 		return s.ResetStep(parent(name))
 	}
@@ -188,12 +347,48 @@ func (s Steps) ListSteps() []string {
 // RunStep executes a step and marks it as completed or failed.
 // Completed steps are not executed again.
 func (s Steps) RunStep(step string, f func() error) error {
+	return s.run(step, f, func() error {
+		return s.CompleteStep(step)
+	})
+}
+
+// RunStageStep executes a step with nested steps applied by pipelines. The step is pushed instead of completed
+// when one of its nested steps is pushed, the nested steps are named after the step or one of the prefixes.
+func (s Steps) RunStageStep(step string, prefixes []string, f func() error) error {
+	return s.run(step, f, func() error {
+		if s.HasPushedSteps(append([]string{step}, prefixes...)...) {
+			return s.PushStep(step, nil)
+		}
+		return s.CompleteStep(step)
+	})
+}
+
+// RunPushStep executes a step that pushes the commit applied by a pipeline and marks it as pushed or failed.
+// Completed and pushed steps are not executed again.
+func (s Steps) RunPushStep(step string, f func() (Push, error)) error {
+	if s.IsStepPushed(step) {
+		fmt.Printf("# skipping step '%s' execution, its commit %s was pushed\n", step, s.Steps[step].Push.Commit)
+		return nil
+	}
+	var p Push
+	return s.run(step, func() error {
+		var err error
+		p, err = f()
+		return err
+	}, func() error {
+		return s.PushStep(step, &p)
+	})
+}
+
+// run executes a step that is not completed, done marks the step when it succeeds.
+func (s Steps) run(step string, f func() error, done func() error) error {
 	if s.IsStepComplete(step) {
 		fmt.Printf("# skipping step '%s' execution\n", step)
 		return nil
 	}
 	fmt.Printf("# starting step '%s' execution\n", step)
-	err := f()
+	s.notify(Step{Name: step, Status: runningStatus})
+	err := s.traced(step, Apply, f)
 	if err != nil {
 		e := s.FailStep(step, err.Error())
 		if e != nil {
@@ -201,7 +396,61 @@ func (s Steps) RunStep(step string, f func() error) error {
 		}
 		return err
 	}
-	return s.CompleteStep(step)
+	return done()
+}
+
+// PushStep marks a step as pushed, with the commit applied by its pipeline. The steps with pushed nested
+// steps are pushed without a commit.
+func (s Steps) PushStep(name string, p *Push) error {
+	s.Steps[name] = Step{
+		Name:   name,
+		Status: pushedStatus,
+		Push:   p,
+	}
+	err := s.SaveSteps()
+	if err != nil {
+		return err
+	}
+	if p != nil {
+		fmt.Printf("# step '%s' pushed commit %s to branch %s of %s\n", name, p.Commit, p.Branch, p.Repo)
+	} else {
+		fmt.Printf("# step '%s' is applied by its pipelines\n", name)
+	}
+	s.notify(s.Steps[name])
+	return nil
+}
+
+// IsStepPushed checks if the given step is pushed.
+func (s Steps) IsStepPushed(name string) bool {
+	v, ok := s.Steps[name]
+	if ok {
+		return v.Status == pushedStatus
+	}
+	return false
+}
+
+// HasPushedSteps checks if one of the steps named after the prefixes, or nested in them, is pushed with a commit.
+func (s Steps) HasPushedSteps(prefixes ...string) bool {
+	for _, v := range s.PushedSteps() {
+		for _, p := range prefixes {
+			if v.Name == p || strings.HasPrefix(v.Name, p+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PushedSteps are the pushed steps with a commit, sorted by name.
+func (s Steps) PushedSteps() []Step {
+	l := []Step{}
+	for _, v := range s.Steps {
+		if v.Status == pushedStatus && v.Push != nil {
+			l = append(l, v)
+		}
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+	return l
 }
 
 // IsStepDestroyed checks is the step was destroyed
@@ -224,6 +473,7 @@ func (s Steps) DestroyStep(name string) error {
 		return err
 	}
 	fmt.Printf("# destroying step '%s'\n", name)
+	s.notify(s.Steps[name])
 	return nil
 }
 
@@ -234,7 +484,8 @@ func (s Steps) RunDestroyStep(step string, f func() error) error {
 		return nil
 	}
 	fmt.Printf("# starting step '%s' destruction\n", step)
-	err := f()
+	s.notify(Step{Name: step, Status: runningStatus})
+	err := s.traced(step, Destroy, f)
 	if err != nil {
 		e := s.FailStep(step, err.Error())
 		if e != nil {
@@ -132,3 +132,143 @@ func TestProcessSteps(t *testing.T) {
 	}
 	assert.ElementsMatch(t, expectedSteps, s.ListSteps())
 }
+
+func TestOnChange(t *testing.T) {
+	s, err := LoadSteps(filepath.Join(t.TempDir(), "new.json"))
+	assert.NoError(t, err)
+	changes := []string{}
+	s.OnChange = func(step Step) {
+		changes = append(changes, step.String())
+	}
+
+	assert.NoError(t, s.RunStep("ok", func() error { return nil }))
+	assert.Error(t, s.RunStep("fail", func() error { return fmt.Errorf("build failed") }))
+	assert.NoError(t, s.RunStep("ok", func() error { return nil }), "completed steps are skipped")
+	assert.NoError(t, s.RunDestroyStep("ok", func() error { return nil }))
+	assert.NoError(t, s.ResetStep("fail"))
+	s.WaitApproval("quota", "billing quota increase", func() {})
+
+	assert.Equal(t, []string{
+		"ok RUNNING",
+		"ok COMPLETED",
+		"fail RUNNING",
+		"fail FAILED error:build failed",
+		"ok RUNNING",
+		"ok DESTROYED",
+		"fail PENDING",
+		"quota WAITING_APPROVAL reason:billing quota increase",
+		"quota RUNNING",
+	}, changes)
+}
+
+func TestMarkStale(t *testing.T) {
+	s, err := LoadSteps(filepath.Join(t.TempDir(), "new.json"))
+	assert.NoError(t, err)
+	for _, name := range []string{"gcp-multitenant", "eab-multitenant.copy-code", "eab-multitenant.development", "eab-multitenant-other.plan", "gcp-fleetscope"} {
+		assert.NoError(t, s.CompleteStep(name))
+	}
+	assert.NoError(t, s.FailStep("eab-multitenant.production", "build failed"))
+
+	assert.EqualError(t, s.SetInputs("missing", map[string]string{"code": "1"}), "step 'missing' is not completed")
+	assert.NoError(t, s.SetInputs("gcp-multitenant", map[string]string{"code": "1"}))
+	assert.Equal(t, map[string]string{"code": "1"}, s.GetInputs("gcp-multitenant"))
+
+	assert.NoError(t, s.MarkStale("gcp-multitenant", "inputs changed: code", "eab-multitenant"))
+	assert.True(t, s.IsStepStale("gcp-multitenant"))
+	assert.True(t, s.IsStepStale("eab-multitenant.copy-code"))
+	assert.True(t, s.IsStepStale("eab-multitenant.development"))
+	assert.False(t, s.IsStepStale("eab-multitenant-other.plan"), "only the steps of the prefix are nested")
+	assert.False(t, s.IsStepStale("eab-multitenant.production"), "failed steps run again anyway")
+	assert.True(t, s.IsStepComplete("gcp-fleetscope"))
+	assert.Nil(t, s.GetInputs("gcp-multitenant"), "stale steps have no inputs")
+	assert.Contains(t, s.ListSteps(), "gcp-multitenant STALE reason:inputs changed: code")
+
+	loaded, err := LoadSteps(s.File)
+	assert.NoError(t, err)
+	assert.True(t, loaded.IsStepStale("eab-multitenant.copy-code"), "stale steps should be saved")
+
+	ran := false
+	assert.NoError(t, s.RunStep("eab-multitenant.copy-code", func() error { ran = true; return nil }))
+	assert.True(t, ran, "stale steps should run again")
+	assert.True(t, s.IsStepComplete("eab-multitenant.copy-code"))
+}
+
+func TestHook(t *testing.T) {
+	s, err := LoadSteps(filepath.Join(t.TempDir(), "new.json"))
+	assert.NoError(t, err)
+	calls := []string{}
+	s.Hook = func(step, operation, when string) error {
+		calls = append(calls, fmt.Sprintf("%s %s %s", step, operation, when))
+		if step == "scan" && when == Before {
+			return fmt.Errorf("scan found vulnerabilities")
+		}
+		return nil
+	}
+
+	assert.NoError(t, s.RunStep("ok", func() error { calls = append(calls, "ok"); return nil }))
+	assert.EqualError(t, s.RunStep("scan", func() error { calls = append(calls, "scan"); return nil }), "scan found vulnerabilities")
+	assert.Equal(t, "scan found vulnerabilities", s.GetStepError("scan"), "the hook error should fail the step")
+	assert.Error(t, s.RunStep("fail", func() error { return fmt.Errorf("build failed") }))
+	assert.NoError(t, s.RunStep("ok", func() error { return nil }), "completed steps are skipped")
+	assert.NoError(t, s.RunDestroyStep("ok", func() error { return nil }))
+
+	assert.Equal(t, []string{
+		"ok apply before",
+		"ok",
+		"ok apply after",
+		"scan apply before",
+		"fail apply before",
+		"ok destroy before",
+		"ok destroy after",
+	}, calls)
+}
+
+func TestPushSteps(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "steps.json")
+	s, err := LoadSteps(file)
+	assert.NoError(t, err)
+	push := Push{Project: "prj-b-cicd", Region: "us-central1", Repo: "eab-multitenant", Branch: "plan", Commit: "a1b2c3d4e5"}
+
+	err = s.RunStageStep("gcp-multitenant", []string{"eab-multitenant"}, func() error {
+		assert.NoError(t, s.RunStep("gcp-multitenant.local", func() error { return nil }))
+		return s.RunPushStep("eab-multitenant.plan", func() (Push, error) { return push, nil })
+	})
+	assert.NoError(t, err)
+	assert.True(t, s.IsStepPushed("gcp-multitenant"), "a stage with pushed steps should be pushed")
+	assert.True(t, s.IsStepPushed("eab-multitenant.plan"))
+	assert.True(t, s.HasPushedSteps("eab-multitenant"))
+	assert.False(t, s.HasPushedSteps("eab-fleetscope"))
+
+	pushed := s.PushedSteps()
+	assert.Len(t, pushed, 1, "only the steps with a commit should be listed")
+	assert.Equal(t, "eab-multitenant.plan", pushed[0].Name)
+	assert.Equal(t, "eab-multitenant.plan PUSHED commit:a1b2c3d4e5", pushed[0].String())
+
+	// pushed steps are not pushed again
+	err = s.RunPushStep("eab-multitenant.plan", func() (Push, error) { return Push{}, fmt.Errorf("pushed again") })
+	assert.NoError(t, err)
+
+	// the commits are saved
+	l, err := LoadSteps(file)
+	assert.NoError(t, err)
+	assert.Equal(t, push, *l.Steps["eab-multitenant.plan"].Push)
+
+	err = s.RunPushStep("eab-multitenant.development", func() (Push, error) { return Push{}, fmt.Errorf("push rejected") })
+	assert.EqualError(t, err, "push rejected")
+	assert.False(t, s.IsStepPushed("eab-multitenant.development"))
+
+	err = s.RunStageStep("gcp-fleetscope", []string{"eab-fleetscope"}, func() error { return nil })
+	assert.NoError(t, err)
+	assert.True(t, s.IsStepComplete("gcp-fleetscope"), "a stage without pushed steps should be completed")
+}
+
+func TestShortCommit(t *testing.T) {
+	commit, err := Push{Commit: "a1b2c3d4e5"}.ShortCommit()
+	assert.NoError(t, err)
+	assert.Equal(t, "a1b2c3d", commit)
+
+	_, err = Push{Repo: "eab-hello-world", Branch: "main", Commit: "a1b2"}.ShortCommit()
+	assert.EqualError(t, err, "invalid commit 'a1b2' of eab-hello-world branch main")
+	_, err = Push{Commit: "not a sha"}.ShortCommit()
+	assert.Error(t, err)
+}
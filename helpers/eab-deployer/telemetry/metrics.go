@@ -0,0 +1,107 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package telemetry
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Names of the metrics.
+const (
+	StepDurationMetric      = "eab.step.duration"
+	TerraformDurationMetric = "eab.terraform.duration"
+	WaitDurationMetric      = "eab.wait.duration"
+	RetriesMetric           = "eab.retries"
+)
+
+// Kinds of the waits and the retries.
+const (
+	Build      = "build"
+	BuildQueue = "build_queue"
+	Rollout    = "rollout"
+)
+
+// Statuses of the metrics.
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// descriptions are the descriptions of the metrics.
+var descriptions = map[string]string{
+	StepDurationMetric:      "Duration of the steps.",
+	TerraformDurationMetric: "Duration of the terraform commands.",
+	WaitDurationMetric:      "Duration of the waits for builds, build queues and rollouts.",
+	RetriesMetric:           "Number of retries of the failed builds.",
+}
+
+// histogram records a duration. The instruments are created with the current meter provider when they are
+// recorded, the providers cache them.
+func histogram(name string, d time.Duration, attrs ...attribute.KeyValue) {
+	h, err := otel.Meter(scope).Float64Histogram(name, metric.WithUnit("s"), metric.WithDescription(descriptions[name]))
+	if err != nil {
+		otel.Handle(err)
+		return
+	}
+	h.Record(context.Background(), d.Seconds(), metric.WithAttributes(attrs...))
+}
+
+// status is the status attribute of an error.
+func status(err error) string {
+	if err != nil {
+		return StatusError
+	}
+	return StatusOK
+}
+
+// RecordStep records the duration of a step.
+func RecordStep(step, operation string, d time.Duration, err error) {
+	histogram(StepDurationMetric, d, StepKey.String(step), OperationKey.String(operation), StatusKey.String(status(err)))
+}
+
+// RecordWait records the duration of a wait, kind is Build, BuildQueue or Rollout.
+func RecordWait(kind string, d time.Duration, err error) {
+	histogram(WaitDurationMetric, d, attribute.String("eab.wait", kind), StatusKey.String(status(err)))
+}
+
+// RecordRetry counts a retry of a kind of operation, for example Build.
+func RecordRetry(kind string, attrs ...attribute.KeyValue) {
+	c, err := otel.Meter(scope).Int64Counter(RetriesMetric, metric.WithDescription(descriptions[RetriesMetric]))
+	if err != nil {
+		otel.Handle(err)
+		return
+	}
+	c.Add(context.Background(), 1, metric.WithAttributes(append(attrs, attribute.String("eab.retry", kind))...))
+}
+
+// Terraform runs a terraform command of a stage in a span and records its duration. The environment of the command
+// is the name of dir when it is in an envs directory.
+func Terraform(tr *Trace, command, stage, dir string, f func() error) error {
+	attrs := []attribute.KeyValue{CommandKey.String(command), StageKey.String(stage), DirKey.String(dir)}
+	if filepath.Base(filepath.Dir(dir)) == "envs" {
+		attrs = append(attrs, EnvKey.String(filepath.Base(dir)))
+	}
+	return tr.Run("terraform "+command, attrs, func(s *Span) error {
+		err := f()
+		histogram(TerraformDurationMetric, s.Duration(), CommandKey.String(command), StageKey.String(stage), StatusKey.String(status(err)))
+		return err
+	})
+}
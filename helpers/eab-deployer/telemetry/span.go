@@ -0,0 +1,180 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// scope is the instrumentation scope of the tracer and the meter.
+const scope = "github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer"
+
+// Attributes of the spans and the metrics.
+const (
+	StageKey     = attribute.Key("eab.stage")
+	StepKey      = attribute.Key("eab.step")
+	OperationKey = attribute.Key("eab.operation")
+	StatusKey    = attribute.Key("eab.status")
+	EnvKey       = attribute.Key("eab.env")
+	CommandKey   = attribute.Key("eab.terraform.command")
+	DirKey       = attribute.Key("eab.terraform.dir")
+	RepoKey      = attribute.Key("eab.repo")
+	BranchKey    = attribute.Key("eab.git.branch")
+	RemoteKey    = attribute.Key("eab.git.remote")
+	ProjectKey   = attribute.Key("eab.project")
+	RegionKey    = attribute.Key("eab.region")
+	CommitKey    = attribute.Key("eab.commit")
+	BuildKey     = attribute.Key("eab.build.id")
+	ReleaseKey   = attribute.Key("eab.release")
+	TargetKey    = attribute.Key("eab.target")
+	RetryKey     = attribute.Key("eab.retry_count")
+)
+
+// Trace is the stack of the started spans of an execution. The functions of the stages do not receive a context,
+// the parent of a new span is the last started span of the execution that is not ended. Each execution has its own
+// Trace, so the spans of concurrent executions are never parents of each other. The spans of a nil Trace are
+// started outside of an execution.
+type Trace struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// NewTrace creates the Trace of an execution.
+func NewTrace() *Trace {
+	return &Trace{}
+}
+
+// Tracer is implemented by the values that carry the Trace of an execution, like the testing.TB of the stages.
+type Tracer interface {
+	Trace() *Trace
+}
+
+// detached is the Trace of the spans started outside of an execution.
+var detached = NewTrace()
+
+// TraceOf is the Trace carried by v, the spans started without the Trace of an execution have their own Trace.
+func TraceOf(v any) *Trace {
+	if t, ok := v.(Tracer); ok && t.Trace() != nil {
+		return t.Trace()
+	}
+	return detached
+}
+
+// Span is a span of the stack of active spans of a Trace.
+type Span struct {
+	span  trace.Span
+	ctx   context.Context
+	start time.Time
+	trace *Trace
+	// wait, if set, is the kind of wait of the span, its duration is recorded when it ends.
+	wait string
+}
+
+// Start starts a span, child of the last active span of the Trace.
+func (tr *Trace) Start(name string, attrs ...attribute.KeyValue) *Span {
+	if tr == nil {
+		tr = detached
+	}
+	tr.mu.Lock()
+	ctx := context.Background()
+	if n := len(tr.spans); n > 0 {
+		ctx = tr.spans[n-1].ctx
+	}
+	tr.mu.Unlock()
+	return tr.StartContext(ctx, name, attrs...)
+}
+
+// StartContext starts a span of the Trace, child of the span of the context.
+func (tr *Trace) StartContext(ctx context.Context, name string, attrs ...attribute.KeyValue) *Span {
+	if tr == nil {
+		tr = detached
+	}
+	ctx, span := otel.Tracer(scope).Start(ctx, name, trace.WithAttributes(attrs...))
+	s := &Span{span: span, ctx: ctx, start: time.Now(), trace: tr}
+	tr.mu.Lock()
+	tr.spans = append(tr.spans, s)
+	tr.mu.Unlock()
+	return s
+}
+
+// StartWait starts the span of a wait, kind is Build, BuildQueue or Rollout.
+func (tr *Trace) StartWait(kind, name string, attrs ...attribute.KeyValue) *Span {
+	s := tr.Start(name, attrs...)
+	s.wait = kind
+	return s
+}
+
+// Run runs f in a span, child of the last active span of the Trace.
+func (tr *Trace) Run(name string, attrs []attribute.KeyValue, f func(*Span) error) (err error) {
+	s := tr.Start(name, attrs...)
+	defer s.Finish(&err)
+	return f(s)
+}
+
+// Context is the context of the span.
+func (s *Span) Context() context.Context {
+	return s.ctx
+}
+
+// Duration is the time since the start of the span.
+func (s *Span) Duration() time.Duration {
+	return time.Since(s.start)
+}
+
+// SetAttributes sets attributes of the span.
+func (s *Span) SetAttributes(attrs ...attribute.KeyValue) {
+	s.span.SetAttributes(attrs...)
+}
+
+// AddEvent adds an event to the span.
+func (s *Span) AddEvent(name string, attrs ...attribute.KeyValue) {
+	s.span.AddEvent(name, trace.WithAttributes(attrs...))
+}
+
+// End ends the span with the status of err.
+func (s *Span) End(err error) {
+	if s.wait != "" {
+		RecordWait(s.wait, s.Duration(), err)
+	}
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+	s.trace.mu.Lock()
+	if i := slices.Index(s.trace.spans, s); i >= 0 {
+		s.trace.spans = slices.Delete(s.trace.spans, i, i+1)
+	}
+	s.trace.mu.Unlock()
+}
+
+// Finish ends the span with the error of a named result, it must be deferred. The panics, like the t.Fatal of the
+// stages, end the span with an error and continue.
+func (s *Span) Finish(err *error) {
+	if r := recover(); r != nil {
+		s.End(fmt.Errorf("panic: %v", r))
+		panic(r)
+	}
+	s.End(*err)
+}
@@ -0,0 +1,135 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package telemetry records OpenTelemetry traces and metrics of the executions of the helper: a span for each
+// stage, step, terraform command, git push and wait for a build or a rollout, and metrics of the durations and the
+// retries. The spans and metrics are exported with OTLP and/or to local files for offline analysis.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const (
+	// ServiceName is the service.name of the resource of the spans and metrics.
+	ServiceName = "eab-deployer"
+	// TracesFile and MetricsFile are the files of the file exporter, with a JSON document on each line.
+	TracesFile  = "traces.jsonl"
+	MetricsFile = "metrics.jsonl"
+	// otlpEndpointEnv is the standard variable of the OTLP endpoint, it enables the OTLP exporter.
+	otlpEndpointEnv = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	metricsInterval = 30 * time.Second
+)
+
+// Options configure the exporters. No exporter is configured when both are empty and the OTLP variables are not set.
+type Options struct {
+	// OTLPEndpoint is the URL of an OTLP/HTTP collector, for example http://localhost:4318. When empty the
+	// collector of the OTEL_EXPORTER_OTLP_ENDPOINT variable is used if it is set.
+	OTLPEndpoint string
+	// Dir, if set, is the directory of the files of the file exporter.
+	Dir string
+}
+
+// Setup configures the global tracer and meter providers with the exporters of the options. The returned function
+// flushes the pending spans and metrics and closes the exporters, it must be called before the helper exits.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	otlp := opts.OTLPEndpoint != "" || os.Getenv(otlpEndpointEnv) != ""
+	if !otlp && opts.Dir == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	traceOpts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	metricOpts := []sdkmetric.Option{sdkmetric.WithResource(res)}
+	closers := []func() error{}
+	fail := func(err error) (func(context.Context) error, error) {
+		for _, c := range closers {
+			_ = c()
+		}
+		return nil, err
+	}
+
+	if otlp {
+		to, mo := []otlptracehttp.Option{}, []otlpmetrichttp.Option{}
+		if opts.OTLPEndpoint != "" {
+			to = append(to, otlptracehttp.WithEndpointURL(opts.OTLPEndpoint))
+			mo = append(mo, otlpmetrichttp.WithEndpointURL(opts.OTLPEndpoint))
+		}
+		te, err := otlptracehttp.New(ctx, to...)
+		if err != nil {
+			return fail(fmt.Errorf("failed to create the OTLP trace exporter: %w", err))
+		}
+		me, err := otlpmetrichttp.New(ctx, mo...)
+		if err != nil {
+			return fail(fmt.Errorf("failed to create the OTLP metric exporter: %w", err))
+		}
+		traceOpts = append(traceOpts, sdktrace.WithBatcher(te))
+		metricOpts = append(metricOpts, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(me, sdkmetric.WithInterval(metricsInterval))))
+	}
+
+	if opts.Dir != "" {
+		if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+			return fail(err)
+		}
+		tf, err := os.OpenFile(filepath.Join(opts.Dir, TracesFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return fail(err)
+		}
+		closers = append(closers, tf.Close)
+		mf, err := os.OpenFile(filepath.Join(opts.Dir, MetricsFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return fail(err)
+		}
+		closers = append(closers, mf.Close)
+		te, err := stdouttrace.New(stdouttrace.WithWriter(tf))
+		if err != nil {
+			return fail(err)
+		}
+		me, err := stdoutmetric.New(stdoutmetric.WithWriter(mf))
+		if err != nil {
+			return fail(err)
+		}
+		traceOpts = append(traceOpts, sdktrace.WithBatcher(te))
+		metricOpts = append(metricOpts, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(me, sdkmetric.WithInterval(metricsInterval))))
+	}
+
+	tp := sdktrace.NewTracerProvider(traceOpts...)
+	mp := sdkmetric.NewMeterProvider(metricOpts...)
+	otel.SetTracerProvider(tp)
+	otel.SetMeterProvider(mp)
+	return func(ctx context.Context) error {
+		errs := []error{tp.Shutdown(ctx), mp.Shutdown(ctx)}
+		for _, c := range closers {
+			errs = append(errs, c())
+		}
+		return errors.Join(errs...)
+	}, nil
+}
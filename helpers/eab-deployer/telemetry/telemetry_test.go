@@ -0,0 +1,160 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// setupTest sets in memory providers of the spans and the metrics.
+func setupTest(t *testing.T) (*tracetest.InMemoryExporter, *sdkmetric.ManualReader) {
+	exporter := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	return exporter, reader
+}
+
+// metricNames are the names of the collected metrics.
+func metricNames(t *testing.T, reader *sdkmetric.ManualReader) []string {
+	var rm metricdata.ResourceMetrics
+	assert.NoError(t, reader.Collect(context.Background(), &rm))
+	names := []string{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			names = append(names, m.Name)
+		}
+	}
+	return names
+}
+
+func TestSpans(t *testing.T) {
+	exporter, reader := setupTest(t)
+
+	tr := NewTrace()
+	err := tr.Run("stage 1-bootstrap", nil, func(*Span) error {
+		assert.NoError(t, Terraform(tr, "init", "1-bootstrap", "/eab/3-fleetscope/envs/development", func() error { return nil }))
+		return tr.Run("git push", nil, func(*Span) error { return fmt.Errorf("push rejected") })
+	})
+	assert.EqualError(t, err, "push rejected")
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 3)
+	parent := spans[2]
+	assert.Equal(t, "stage 1-bootstrap", parent.Name)
+	assert.Equal(t, "terraform init", spans[0].Name)
+	assert.Equal(t, parent.SpanContext.SpanID(), spans[0].Parent.SpanID())
+	assert.Contains(t, spans[0].Attributes, EnvKey.String("development"))
+	assert.Equal(t, "git push", spans[1].Name)
+	assert.Equal(t, parent.SpanContext.SpanID(), spans[1].Parent.SpanID())
+	assert.Equal(t, codes.Error, spans[1].Status.Code)
+	assert.Equal(t, codes.Error, parent.Status.Code)
+	assert.Empty(t, tr.spans)
+	assert.Contains(t, metricNames(t, reader), TerraformDurationMetric)
+}
+
+// tracedT carries the Trace of an execution.
+type tracedT struct {
+	trace *Trace
+}
+
+func (t tracedT) Trace() *Trace {
+	return t.trace
+}
+
+func TestConcurrentTraces(t *testing.T) {
+	exporter, _ := setupTest(t)
+
+	first, second := tracedT{NewTrace()}, tracedT{NewTrace()}
+	a := first.trace.StartContext(context.Background(), "apply")
+	b := second.trace.StartContext(context.Background(), "apply")
+	TraceOf(first).Start("stage 2-multitenant").End(nil)
+	TraceOf(second).Start("stage 3-fleetscope").End(nil)
+	b.End(nil)
+	a.End(nil)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 4)
+	assert.Equal(t, "stage 2-multitenant", spans[0].Name)
+	assert.Equal(t, spans[3].SpanContext.SpanID(), spans[0].Parent.SpanID(), "the span should be a child of its execution")
+	assert.Equal(t, "stage 3-fleetscope", spans[1].Name)
+	assert.Equal(t, spans[2].SpanContext.SpanID(), spans[1].Parent.SpanID(), "the span should be a child of its execution")
+	assert.Equal(t, detached, TraceOf(&struct{}{}))
+}
+
+func TestFinishPanic(t *testing.T) {
+	exporter, reader := setupTest(t)
+
+	assert.Panics(t, func() {
+		_ = func() (err error) {
+			s := detached.StartWait(Build, "wait build", BuildKey.String("1234"))
+			defer s.Finish(&err)
+			panic("build not found")
+		}()
+	})
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, "panic: build not found", spans[0].Status.Description)
+	assert.Empty(t, detached.spans)
+	assert.Contains(t, metricNames(t, reader), WaitDurationMetric)
+}
+
+func TestSetupFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "telemetry")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+
+	shutdown, err := Setup(context.Background(), Options{Dir: dir})
+	assert.NoError(t, err)
+	err = NewTrace().Run("step 1-bootstrap", []attribute.KeyValue{StepKey.String("1-bootstrap")}, func(s *Span) error {
+		RecordStep("1-bootstrap", "apply", s.Duration(), nil)
+		RecordRetry(Build, RepoKey.String("eab-applicationfactory"))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	traces, err := os.ReadFile(filepath.Join(dir, TracesFile))
+	assert.NoError(t, err)
+	assert.Contains(t, string(traces), `"Name":"step 1-bootstrap"`)
+	assert.Contains(t, string(traces), ServiceName)
+	metrics, err := os.ReadFile(filepath.Join(dir, MetricsFile))
+	assert.NoError(t, err)
+	for _, name := range []string{StepDurationMetric, RetriesMetric} {
+		assert.True(t, strings.Contains(string(metrics), `"Name":"`+name+`"`), name)
+	}
+}
+
+func TestSetupDisabled(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	shutdown, err := Setup(context.Background(), Options{})
+	assert.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+}
@@ -0,0 +1,265 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tfversion
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+const (
+	// ReleasesURL is the base URL of the HashiCorp releases.
+	ReleasesURL = "https://releases.hashicorp.com"
+	// KeyURL is the URL of the public key used to sign the HashiCorp releases.
+	KeyURL = "https://www.hashicorp.com/.well-known/pgp-key.txt"
+	// Fingerprint is the fingerprint of the HashiCorp release signing key.
+	Fingerprint = "C874011F0AB405110D02105534365D9472D7468F"
+	// checksumFile has the checksum of the installed binary, to detect changes of the cached binary.
+	checksumFile = "terraform.sha256"
+)
+
+// Installer downloads terraform binaries into a cache directory.
+// The checksums file of the release is verified with the HashiCorp signing key and the
+// archive is verified with the checksums file before the binary is extracted.
+type Installer struct {
+	CacheDir string
+	// BaseURL defaults to ReleasesURL.
+	BaseURL string
+	// KeyFile, if set, is a local copy of the signing key, used instead of KeyURL.
+	KeyFile string
+	// KeyURL defaults to the KeyURL constant.
+	KeyURL string
+	// Fingerprint of the signing key, defaults to the Fingerprint constant.
+	Fingerprint string
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+	// OS and Arch of the binary, default to the platform of the helper.
+	OS   string
+	Arch string
+}
+
+func (i Installer) withDefaults() Installer {
+	if i.BaseURL == "" {
+		i.BaseURL = ReleasesURL
+	}
+	if i.KeyURL == "" {
+		i.KeyURL = KeyURL
+	}
+	if i.Fingerprint == "" {
+		i.Fingerprint = Fingerprint
+	}
+	if i.Client == nil {
+		i.Client = http.DefaultClient
+	}
+	if i.OS == "" {
+		i.OS = runtime.GOOS
+	}
+	if i.Arch == "" {
+		i.Arch = runtime.GOARCH
+	}
+	return i
+}
+
+// binaryName is the name of the terraform binary in the release archive.
+func (i Installer) binaryName() string {
+	if i.OS == "windows" {
+		return "terraform.exe"
+	}
+	return "terraform"
+}
+
+// Path is the path of the binary of a version in the cache.
+func (i Installer) Path(v string) string {
+	return filepath.Join(i.CacheDir, v, i.withDefaults().binaryName())
+}
+
+// Install returns the path of the binary of a version, downloading it when it is not in the cache
+// or when the cached binary was changed.
+func (i Installer) Install(ctx context.Context, v string) (string, error) {
+	i = i.withDefaults()
+	dir := filepath.Join(i.CacheDir, v)
+	binary := filepath.Join(dir, i.binaryName())
+	if ok, err := cached(dir, binary); err != nil || ok {
+		return binary, err
+	}
+
+	base := fmt.Sprintf("%s/terraform/%s", strings.TrimSuffix(i.BaseURL, "/"), v)
+	sums, err := i.get(ctx, fmt.Sprintf("%s/terraform_%s_SHA256SUMS", base, v))
+	if err != nil {
+		return "", err
+	}
+	sig, err := i.get(ctx, fmt.Sprintf("%s/terraform_%s_SHA256SUMS.sig", base, v))
+	if err != nil {
+		return "", err
+	}
+	if err := i.verifySignature(ctx, sums, sig); err != nil {
+		return "", err
+	}
+
+	archive := fmt.Sprintf("terraform_%s_%s_%s.zip", v, i.OS, i.Arch)
+	want, err := findChecksum(sums, archive)
+	if err != nil {
+		return "", err
+	}
+	content, err := i.get(ctx, fmt.Sprintf("%s/%s", base, archive))
+	if err != nil {
+		return "", err
+	}
+	if got := checksum(content); got != want {
+		return "", fmt.Errorf("checksum of %s is %s, expected %s", archive, got, want)
+	}
+	if err := extract(content, i.binaryName(), dir); err != nil {
+		return "", fmt.Errorf("failed to extract %s: %w", archive, err)
+	}
+	return binary, nil
+}
+
+// cached checks if the binary is in the cache and was not changed after the installation.
+func cached(dir, binary string) (bool, error) {
+	want, err := os.ReadFile(filepath.Join(dir, checksumFile))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	content, err := os.ReadFile(binary)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return checksum(content) == strings.TrimSpace(string(want)), nil
+}
+
+func (i Installer) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := i.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// verifySignature checks that the checksums file is signed by the key with the expected fingerprint.
+func (i Installer) verifySignature(ctx context.Context, sums, sig []byte) error {
+	var key []byte
+	var err error
+	if i.KeyFile != "" {
+		key, err = os.ReadFile(i.KeyFile)
+	} else {
+		key, err = i.get(ctx, i.KeyURL)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the signing key: %w", err)
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key))
+	if err != nil {
+		return fmt.Errorf("failed to read the signing key: %w", err)
+	}
+	signer, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(sums), bytes.NewReader(sig), nil)
+	if err != nil {
+		return fmt.Errorf("invalid signature of the checksums file: %w", err)
+	}
+	got := strings.ToUpper(hex.EncodeToString(signer.PrimaryKey.Fingerprint[:]))
+	if got != strings.ToUpper(i.Fingerprint) {
+		return fmt.Errorf("the checksums file is signed by key %s, expected %s", got, i.Fingerprint)
+	}
+	return nil
+}
+
+// findChecksum finds the checksum of a file in a checksums file.
+func findChecksum(sums []byte, file string) (string, error) {
+	s := bufio.NewScanner(bytes.NewReader(sums))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && fields[1] == file {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("%s not found in the checksums file", file)
+}
+
+// extract writes the binary of the archive in the directory. The binary is written to a temporary file
+// and renamed, an interrupted installation never leaves an incomplete binary.
+func extract(archive []byte, name, dir string) error {
+	r, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return err
+	}
+	for _, f := range r.File {
+		if f.Name != name {
+			continue
+		}
+		src, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		content, err := io.ReadAll(src)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		tmp, err := os.CreateTemp(dir, name+".*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(content); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		if err := os.Chmod(tmp.Name(), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, checksumFile), []byte(checksum(content)+"\n"), 0644)
+	}
+	return fmt.Errorf("%s not found in the archive", name)
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
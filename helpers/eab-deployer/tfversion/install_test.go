@@ -0,0 +1,181 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tfversion
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/stretchr/testify/assert"
+)
+
+const binaryContent = "#!/bin/sh\necho '{\"terraform_version\":\"1.6.6\"}'\n"
+
+// release serves a signed terraform release and counts the downloads of the archive.
+type release struct {
+	files     map[string][]byte
+	downloads int
+}
+
+func (r *release) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	content, ok := r.files[req.URL.Path]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	if strings.HasSuffix(req.URL.Path, ".zip") {
+		r.downloads++
+	}
+	_, _ = w.Write(content)
+}
+
+func newRelease(t *testing.T, signer *openpgp.Entity, archive []byte) *release {
+	sums := []byte(fmt.Sprintf("%s  terraform_1.6.6_linux_amd64.zip\n%s  terraform_1.6.6_darwin_arm64.zip\n", checksum(archive), checksum([]byte("other"))))
+	var sig bytes.Buffer
+	assert.NoError(t, openpgp.DetachSign(&sig, signer, bytes.NewReader(sums), nil))
+	return &release{files: map[string][]byte{
+		"/terraform/1.6.6/terraform_1.6.6_SHA256SUMS":      sums,
+		"/terraform/1.6.6/terraform_1.6.6_SHA256SUMS.sig":  sig.Bytes(),
+		"/terraform/1.6.6/terraform_1.6.6_linux_amd64.zip": archive,
+	}}
+}
+
+func newKey(t *testing.T) (*openpgp.Entity, string) {
+	e, err := openpgp.NewEntity("releases", "", "releases@example.com", nil)
+	assert.NoError(t, err)
+	var key bytes.Buffer
+	w, err := armor.Encode(&key, openpgp.PublicKeyType, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, e.Serialize(w))
+	assert.NoError(t, w.Close())
+	file := filepath.Join(t.TempDir(), "key.asc")
+	assert.NoError(t, os.WriteFile(file, key.Bytes(), 0644))
+	return e, file
+}
+
+func zipBinary(t *testing.T, content string) []byte {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	f, err := w.Create("terraform")
+	assert.NoError(t, err)
+	_, err = f.Write([]byte(content))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return b.Bytes()
+}
+
+func TestInstall(t *testing.T) {
+	signer, keyFile := newKey(t)
+	r := newRelease(t, signer, zipBinary(t, binaryContent))
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	i := Installer{
+		CacheDir:    t.TempDir(),
+		BaseURL:     srv.URL,
+		KeyFile:     keyFile,
+		Fingerprint: hex.EncodeToString(signer.PrimaryKey.Fingerprint[:]),
+		OS:          "linux",
+		Arch:        "amd64",
+	}
+	binary, err := i.Install(context.Background(), "1.6.6")
+	assert.NoError(t, err)
+	assert.Equal(t, i.Path("1.6.6"), binary)
+	content, err := os.ReadFile(binary)
+	assert.NoError(t, err)
+	assert.Equal(t, binaryContent, string(content))
+	info, err := os.Stat(binary)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	_, err = i.Install(context.Background(), "1.6.6")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, r.downloads, "the cached binary should be used")
+
+	assert.NoError(t, os.WriteFile(binary, []byte("changed"), 0755))
+	_, err = i.Install(context.Background(), "1.6.6")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, r.downloads, "a changed binary should be installed again")
+}
+
+func TestInstallErrors(t *testing.T) {
+	signer, keyFile := newKey(t)
+	other, _ := newKey(t)
+	fingerprint := hex.EncodeToString(signer.PrimaryKey.Fingerprint[:])
+
+	tests := []struct {
+		name    string
+		release *release
+		arch    string
+		errMsg  string
+	}{
+		{
+			name:    "signed by another key",
+			release: newRelease(t, other, zipBinary(t, binaryContent)),
+			errMsg:  "invalid signature of the checksums file",
+		},
+		{
+			name: "changed archive",
+			release: func() *release {
+				r := newRelease(t, signer, zipBinary(t, binaryContent))
+				r.files["/terraform/1.6.6/terraform_1.6.6_linux_amd64.zip"] = zipBinary(t, "changed")
+				return r
+			}(),
+			errMsg: "checksum of terraform_1.6.6_linux_amd64.zip is",
+		},
+		{
+			name:    "platform not released",
+			release: newRelease(t, signer, zipBinary(t, binaryContent)),
+			arch:    "s390x",
+			errMsg:  "terraform_1.6.6_linux_s390x.zip not found in the checksums file",
+		},
+		{
+			name:    "version not released",
+			release: &release{},
+			errMsg:  "404 Not Found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.release)
+			defer srv.Close()
+			arch := tt.arch
+			if arch == "" {
+				arch = "amd64"
+			}
+			i := Installer{CacheDir: t.TempDir(), BaseURL: srv.URL, KeyFile: keyFile, Fingerprint: fingerprint, OS: "linux", Arch: arch}
+			_, err := i.Install(context.Background(), "1.6.6")
+			assert.ErrorContains(t, err, tt.errMsg)
+			assert.NoFileExists(t, i.Path("1.6.6"))
+		})
+	}
+
+	srv := httptest.NewServer(newRelease(t, signer, zipBinary(t, binaryContent)))
+	defer srv.Close()
+	i := Installer{CacheDir: t.TempDir(), BaseURL: srv.URL, KeyFile: keyFile, OS: "linux", Arch: "amd64"}
+	_, err := i.Install(context.Background(), "1.6.6")
+	assert.ErrorContains(t, err, "expected "+Fingerprint, "only the HashiCorp key should be trusted by default")
+}
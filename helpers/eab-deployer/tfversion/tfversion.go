@@ -0,0 +1,135 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tfversion finds the terraform version pinned by the blueprint and installs a verified terraform
+// binary of that version, so the helper runs the same version as the Cloud Build pipelines.
+package tfversion
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+const (
+	// Dockerfile builds the terraform image used by the Cloud Build pipelines of the stages.
+	Dockerfile = "1-bootstrap/Dockerfile"
+	// ImageFile has the tag of the terraform image built by the bootstrap stage.
+	ImageFile = "1-bootstrap/tf_image.tf"
+)
+
+var (
+	dockerfileRe = regexp.MustCompile(`(?m)^ARG TERRAFORM_VERSION=(\S+)\s*$`)
+	imageTagRe   = regexp.MustCompile(`docker_tag_version_terraform\s*=\s*"([^"]+)"`)
+	// publicImageRe finds the public terraform images used instead of the image of the bootstrap stage.
+	publicImageRe = regexp.MustCompile(`hashicorp/terraform:(\d+\.\d+\.\d+)`)
+)
+
+// Pin is the terraform version pinned by the blueprint.
+type Pin struct {
+	Version string
+	// Source is the file with the version.
+	Source string
+	// ImageTag is the tag of the terraform image of the pipelines, it changes when the image must be built again.
+	ImageTag string
+}
+
+// Pinned reads the terraform version of the image used by the pipelines from the blueprint Dockerfile.
+// The Cloud Build files in build must use the image of the bootstrap stage, or a public image of the same version.
+func Pinned(eabPath string) (Pin, error) {
+	source := filepath.Join(eabPath, Dockerfile)
+	content, err := os.ReadFile(source)
+	if err != nil {
+		return Pin{}, err
+	}
+	m := dockerfileRe.FindSubmatch(content)
+	if m == nil {
+		return Pin{}, fmt.Errorf("%s has no TERRAFORM_VERSION argument", source)
+	}
+	v := string(m[1])
+	if _, err := version.NewSemver(v); err != nil {
+		return Pin{}, fmt.Errorf("%s: invalid TERRAFORM_VERSION '%s': %w", source, v, err)
+	}
+	pin := Pin{Version: v, Source: source}
+
+	content, err = os.ReadFile(filepath.Join(eabPath, ImageFile))
+	if err != nil {
+		return Pin{}, err
+	}
+	if m := imageTagRe.FindSubmatch(content); m != nil {
+		pin.ImageTag = string(m[1])
+	}
+
+	builds, err := filepath.Glob(filepath.Join(eabPath, "build", "cloudbuild-tf-*.yaml"))
+	if err != nil {
+		return Pin{}, err
+	}
+	conflicts := []string{}
+	for _, b := range builds {
+		content, err := os.ReadFile(b)
+		if err != nil {
+			return Pin{}, err
+		}
+		for _, m := range publicImageRe.FindAllSubmatch(content, -1) {
+			if string(m[1]) != v {
+				conflicts = append(conflicts, fmt.Sprintf("%s: %s", filepath.Base(b), m[1]))
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return Pin{}, fmt.Errorf("terraform versions of the Cloud Build files do not match version %s of %s:\n%s", v, source, strings.Join(conflicts, "\n"))
+	}
+	return pin, nil
+}
+
+// BinaryVersion is the version reported by a terraform binary.
+func BinaryVersion(ctx context.Context, binary string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, "version", "-json")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to run %s version: %w %s", binary, err, strings.TrimSpace(stderr.String()))
+	}
+	var out struct {
+		TerraformVersion string `json:"terraform_version"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return "", fmt.Errorf("failed to read the version of %s: %w", binary, err)
+	}
+	return out.TerraformVersion, nil
+}
+
+// Check verifies that a terraform binary has the pinned version.
+// A different version can upgrade the state files and break the pipelines that use the pinned version.
+func Check(ctx context.Context, binary string, pin Pin) error {
+	v, err := BinaryVersion(ctx, binary)
+	if err != nil {
+		return err
+	}
+	if v != pin.Version {
+		return fmt.Errorf("terraform %s is version %s, the pipelines use version %s from %s", binary, v, pin.Version, pin.Source)
+	}
+	return nil
+}
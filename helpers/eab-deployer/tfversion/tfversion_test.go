@@ -0,0 +1,73 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tfversion
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, file, content string, perm os.FileMode) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+	assert.NoError(t, os.WriteFile(file, []byte(content), perm))
+}
+
+// fakeBinary writes a script that reports a terraform version.
+func fakeBinary(t *testing.T, v string) string {
+	file := filepath.Join(t.TempDir(), "terraform")
+	writeFile(t, file, "#!/bin/sh\necho '{\"terraform_version\":\""+v+"\",\"platform\":\"linux_amd64\"}'\n", 0755)
+	return file
+}
+
+func blueprint(t *testing.T, dockerfile, build string) string {
+	eab := t.TempDir()
+	writeFile(t, filepath.Join(eab, Dockerfile), dockerfile, 0644)
+	writeFile(t, filepath.Join(eab, ImageFile), "locals {\n  docker_tag_version_terraform = \"v1\"\n}\n", 0644)
+	writeFile(t, filepath.Join(eab, "build", "cloudbuild-tf-apply.yaml"), build, 0644)
+	return eab
+}
+
+func TestPinned(t *testing.T) {
+	dockerfile := "FROM gcr.io/cloud-builders/gcloud-slim\n\nARG TERRAFORM_VERSION=1.6.6\n"
+	eab := blueprint(t, dockerfile, "- name: $_GAR_REGION-docker.pkg.dev/$_GAR_PROJECT_ID/$_GAR_REPOSITORY/terraform:$_DOCKER_TAG_VERSION_TERRAFORM\n")
+	pin, err := Pinned(eab)
+	assert.NoError(t, err)
+	assert.Equal(t, Pin{Version: "1.6.6", Source: filepath.Join(eab, Dockerfile), ImageTag: "v1"}, pin)
+
+	eab = blueprint(t, dockerfile, "- name: hashicorp/terraform:1.6.6\n- name: hashicorp/terraform:1.5.7\n")
+	_, err = Pinned(eab)
+	assert.ErrorContains(t, err, "do not match version 1.6.6")
+	assert.ErrorContains(t, err, "cloudbuild-tf-apply.yaml: 1.5.7")
+
+	eab = blueprint(t, "FROM scratch\n", "")
+	_, err = Pinned(eab)
+	assert.ErrorContains(t, err, "has no TERRAFORM_VERSION argument")
+
+	eab = blueprint(t, "ARG TERRAFORM_VERSION=latest\n", "")
+	_, err = Pinned(eab)
+	assert.ErrorContains(t, err, "invalid TERRAFORM_VERSION 'latest'")
+}
+
+func TestCheck(t *testing.T) {
+	pin := Pin{Version: "1.6.6", Source: Dockerfile}
+	assert.NoError(t, Check(context.Background(), fakeBinary(t, "1.6.6"), pin))
+	old := fakeBinary(t, "1.5.7")
+	assert.EqualError(t, Check(context.Background(), old, pin), "terraform "+old+" is version 1.5.7, the pipelines use version 1.6.6 from "+Dockerfile)
+	assert.ErrorContains(t, Check(context.Background(), filepath.Join(t.TempDir(), "missing"), pin), "failed to run")
+}
@@ -41,6 +41,12 @@ func CopyFile(src string, dest string) error {
 
 // CopyDirectory copies a directory and the files and directories under it.
 func CopyDirectory(src string, dest string) error {
+	return CopyDirectoryExcept(src, dest)
+}
+
+// CopyDirectoryExcept copies a directory and the files and directories under it, except the terraform
+// directories and the files with a name that matches one of the patterns.
+func CopyDirectoryExcept(src string, dest string, patterns ...string) error {
 	err := os.MkdirAll(dest, 0755)
 	if err != nil {
 		return err
@@ -50,11 +56,11 @@ func CopyDirectory(src string, dest string) error {
 		return err
 	}
 	for _, f := range files {
-		if f.Name() == TerraformTempDir || f.Name() == TerraformLockFile {
+		if f.Name() == TerraformTempDir || f.Name() == TerraformLockFile || matchesAny(f.Name(), patterns) {
 			continue
 		}
 		if f.IsDir() {
-			err = CopyDirectory(filepath.Join(src, f.Name()), filepath.Join(dest, f.Name()))
+			err = CopyDirectoryExcept(filepath.Join(src, f.Name()), filepath.Join(dest, f.Name()), patterns...)
 			if err != nil {
 				return err
 			}
@@ -68,6 +74,15 @@ func CopyDirectory(src string, dest string) error {
 	return nil
 }
 
+func matchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // ReplaceStringInFile replaces a string in a file with a new value.
 func ReplaceStringInFile(filename, old, new string) error {
 	s, err := os.Stat(filename)
@@ -68,6 +68,24 @@ func TestCopyDirectory(t *testing.T) {
 	assert.Equal(t, r, []byte(fileContent), "file content should be the same")
 }
 
+func TestCopyDirectoryExcept(t *testing.T) {
+	src := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "modules", TerraformTempDir), 0755))
+	for _, f := range []string{"main.tf", "terraform.tfvars", "terraform.tfstate", "modules/main.tf", "modules/terraform.tfstate.backup"} {
+		_, err := writeTempFile(src, f, f)
+		assert.NoError(t, err)
+	}
+
+	dest := t.TempDir()
+	assert.NoError(t, CopyDirectoryExcept(src, dest, "terraform.tfvars", "*.tfstate", "*.tfstate.backup"))
+	assert.FileExists(t, filepath.Join(dest, "main.tf"))
+	assert.FileExists(t, filepath.Join(dest, "modules", "main.tf"))
+	for _, f := range []string{"terraform.tfvars", "terraform.tfstate", "modules/terraform.tfstate.backup", "modules/.terraform"} {
+		assert.NoFileExists(t, filepath.Join(dest, f))
+		assert.NoDirExists(t, filepath.Join(dest, f))
+	}
+}
+
 func TestReplaceStringInFile(t *testing.T) {
 	f, err := writeTempFile(t.TempDir(), "to_replace.txt", "OLD")
 	assert.NoError(t, err)
@@ -21,40 +21,70 @@ import (
 	"os/exec"
 	"strings"
 
-	"github.com/GoogleCloudPlatform/cloud-foundation-toolkit/infra/blueprint-test/pkg/gcloud"
 	"github.com/GoogleCloudPlatform/cloud-foundation-toolkit/infra/blueprint-test/pkg/git"
 	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/shell"
 	"github.com/mitchellh/go-testing-interface"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/telemetry"
 )
 
 type GitRepo struct {
 	conf *git.CmdCfg
+	// trace is the Trace of the execution that cloned the repository.
+	trace *telemetry.Trace
+	// env are the environment variables of the commands that reach the remote, like the gcloud credentials of
+	// an identity used by the credential helper of the Cloud Source Repositories.
+	env    map[string]string
+	t      testing.TB
+	dir    string
+	logger *logger.Logger
+}
+
+// newGitRepo creates a GitRepo for the repository in path.
+func newGitRepo(t testing.TB, path string, env map[string]string, logger *logger.Logger) GitRepo {
+	return GitRepo{
+		conf:   git.NewCmdConfig(t, git.WithDir(path), git.WithLogger(logger)),
+		trace:  telemetry.TraceOf(t),
+		env:    env,
+		t:      t,
+		dir:    path,
+		logger: logger,
+	}
 }
 
-// GitClone clones git repositories, supporting CSR, Github and Gitlab type of source control
-func GitClone(t testing.TB, repositoryType, repositoryName, repositoryURL, path, project string, logger *logger.Logger) GitRepo {
+// GitClone clones git repositories, supporting CSR, Github and Gitlab type of source control. The clone and the
+// pushes run with the environment variables of env, like the gcloud credentials of an identity.
+func GitClone(t testing.TB, repositoryType, repositoryName, repositoryURL, path, project string, env map[string]string, logger *logger.Logger) GitRepo {
 	conf := GitRepo{}
 	if repositoryType != "CSR" {
-		conf = cloneGit(t, repositoryURL, path, logger)
+		conf = cloneGit(t, repositoryURL, path, env, logger)
 	} else {
-		conf = cloneCSR(t, repositoryName, path, project, logger)
+		conf = cloneCSR(t, repositoryName, path, project, env, logger)
 	}
 	return conf
 }
 
 // cloneCSR clones a Google Cloud Source repository and returns a CmdConfig pointing to the repository.
-func cloneCSR(t testing.TB, name, path, project string, logger *logger.Logger) GitRepo {
+func cloneCSR(t testing.TB, name, path, project string, env map[string]string, logger *logger.Logger) GitRepo {
 	_, err := os.Stat(path)
 	if os.IsNotExist(err) {
-		gcloud.Runf(t, "source repos clone %s %s --project %s", name, path, project)
-	}
-	return GitRepo{
-		conf: git.NewCmdConfig(t, git.WithDir(path), git.WithLogger(logger)),
+		_, err = shell.RunCommandAndGetStdOutE(t, shell.Command{
+			Command: "gcloud",
+			Args:    []string{"source", "repos", "clone", name, path, "--project", project},
+			Env:     env,
+			Logger:  logger,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
 	}
+	return newGitRepo(t, path, env, logger)
 }
 
 // cloneGit clones a Github or Gitlab repository and returns a CmdConfig pointing to the repository.
-func cloneGit(t testing.TB, repositoryUrl, path string, logger *logger.Logger) GitRepo {
+func cloneGit(t testing.TB, repositoryUrl, path string, env map[string]string, logger *logger.Logger) GitRepo {
 	_, err := os.Stat(path)
 
 	if os.IsNotExist(err) {
@@ -70,9 +100,18 @@ func cloneGit(t testing.TB, repositoryUrl, path string, logger *logger.Logger) G
 		fmt.Printf("Current Git branch: %s\n", branchName)
 	}
 
-	return GitRepo{
-		conf: git.NewCmdConfig(t, git.WithDir(path), git.WithLogger(logger)),
-	}
+	return newGitRepo(t, path, env, logger)
+}
+
+// runRemoteCmd runs a git command that reaches the remote with the environment variables of the repository.
+func (g GitRepo) runRemoteCmd(args ...string) (string, error) {
+	return shell.RunCommandAndGetStdOutE(g.t, shell.Command{
+		Command:    "git",
+		Args:       args,
+		Env:        g.env,
+		Logger:     g.logger,
+		WorkingDir: g.dir,
+	})
 }
 
 // GetCurrentBranch gets the current branch in the repository.
@@ -94,8 +133,11 @@ func (g GitRepo) HasUpstream(branch, remote string) (bool, error) {
 
 // PushBranch pushes a branch to 'remote' repository .
 func (g GitRepo) PushBranch(branch, remote string) error {
-	_, err := g.conf.RunCmdE("push", "--set-upstream", remote, branch, "--force")
-	return err
+	attrs := []attribute.KeyValue{telemetry.BranchKey.String(branch), telemetry.RemoteKey.String(remote)}
+	return g.trace.Run("git push", attrs, func(*telemetry.Span) error {
+		_, err := g.runRemoteCmd("push", "--set-upstream", remote, branch, "--force")
+		return err
+	})
 }
 
 // CheckoutBranch checkouts a branch.
@@ -143,10 +185,20 @@ func (g GitRepo) GetCommitSha() (string, error) {
 	return g.conf.RunCmdE("rev-parse", "HEAD")
 }
 
+// GetBranchSha gets the commit SHA of a local branch, or of the branch in the origin remote when there is no
+// local branch. It is empty when the branch does not exist.
+func (g GitRepo) GetBranchSha(branch string) (string, error) {
+	for _, ref := range []string{"refs/heads/" + branch, "refs/remotes/origin/" + branch} {
+		sha, err := g.conf.RunCmdE("rev-parse", "--verify", "--quiet", ref)
+		if err == nil && sha != "" {
+			return sha, nil
+		}
+	}
+	return "", nil
+}
+
 // GetRepoOnly returns a GitRepo object pointed at an existing local directory.
 // It does not clone, it only sets the working directory for future git commands.
 func GetRepoOnly(t testing.TB, path string, logger *logger.Logger) GitRepo {
-	return GitRepo{
-		conf: git.NewCmdConfig(t, git.WithDir(path), git.WithLogger(logger)),
-	}
+	return newGitRepo(t, path, nil, logger)
 }
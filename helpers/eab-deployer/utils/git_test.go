@@ -48,7 +48,7 @@ func TestGitCSR(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Test GitClone with CSR
-	localCSR := GitClone(t, "CSR", "my-csr-git-repo", repo, repo, "", logger.Discard)
+	localCSR := GitClone(t, "CSR", "my-csr-git-repo", repo, repo, "", nil, logger.Discard)
 	assert.NotNil(t, localCSR)
 	err = localCSR.AddRemote(remote, originPath)
 	assert.NoError(t, err)
@@ -73,7 +73,7 @@ func TestGitCSR(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, hasUpstream, "branch 'unit-test' should have a remote")
 
-	origin := cloneCSR(t, "my-csr-git-repo", originPath, "", logger.Discard)
+	origin := cloneCSR(t, "my-csr-git-repo", originPath, "", nil, logger.Discard)
 	files, err := FindFiles(originPath, "go.mod")
 	assert.NoError(t, err)
 	assert.Len(t, files, 0, "'go.mod' file should not exist on main branch")
@@ -105,7 +105,7 @@ func TestGitClone_NonCSR(t *testing.T) {
 	err := CopyDirectory(repo, originPath)
 	assert.NoError(t, err)
 
-	local := GitClone(t, "GITHUB", "blueprint-test", repoURL, originPath, "", logger.Discard)
+	local := GitClone(t, "GITHUB", "blueprint-test", repoURL, originPath, "", nil, logger.Discard)
 	assert.NotNil(t, local)
 
 	// Check if the directory is created
@@ -137,3 +137,38 @@ func TestGitClone_NonCSR(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, hasUpstream, "branch 'unit-test' should have a remote")
 }
+
+func TestGetBranchSha(t *testing.T) {
+	repo := createLocalRepo(t, "my-branches-repo")
+	local := GetRepoOnly(t, repo, logger.Discard)
+	err := local.CheckoutBranch("plan")
+	assert.NoError(t, err)
+	sha, err := local.GetCommitSha()
+	assert.NoError(t, err)
+
+	branchSha, err := local.GetBranchSha("plan")
+	assert.NoError(t, err)
+	assert.Equal(t, sha, branchSha)
+
+	branchSha, err = local.GetBranchSha("production")
+	assert.NoError(t, err)
+	assert.Empty(t, branchSha, "missing branches should have no commit")
+}
+
+func TestPushBranchEnv(t *testing.T) {
+	repo := createLocalRepo(t, "my-env-repo")
+	origin := filepath.Join(t.TempDir(), "my-env-repo-origin")
+	assert.NoError(t, CopyDirectory(repo, origin))
+	marker := filepath.Join(t.TempDir(), "token-file")
+	hook := filepath.Join(repo, ".git", "hooks", "pre-push")
+	assert.NoError(t, os.WriteFile(hook, []byte("#!/bin/sh\necho \"$CLOUDSDK_AUTH_ACCESS_TOKEN_FILE\" > "+marker+"\n"), 0755))
+
+	local := GitClone(t, "CSR", "my-env-repo", repo, repo, "", map[string]string{"CLOUDSDK_AUTH_ACCESS_TOKEN_FILE": "/tmp/token"}, logger.Discard)
+	assert.NoError(t, local.AddRemote("origin", origin))
+	assert.NoError(t, local.CheckoutBranch("plan"))
+	assert.NoError(t, local.PushBranch("plan", "origin"))
+
+	content, err := os.ReadFile(marker)
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/token\n", string(content), "the push should run with the environment of the identity")
+}
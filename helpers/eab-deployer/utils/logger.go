@@ -16,6 +16,7 @@ package utils
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/gruntwork-io/terratest/modules/logger"
@@ -24,15 +25,17 @@ import (
 
 type CustomLogger struct {
 	baseFmt string
+	out     io.Writer
 }
 
 func NewCustomLogger() CustomLogger {
 	return CustomLogger{
 		baseFmt: "  # %s",
+		out:     os.Stdout,
 	}
 }
 func (c CustomLogger) Logf(t grunttest.TestingT, format string, args ...interface{}) {
-	fmt.Fprintln(os.Stdout, fmt.Sprintf(c.baseFmt, fmt.Sprintf(format, args...)))
+	fmt.Fprintln(c.out, fmt.Sprintf(c.baseFmt, fmt.Sprintf(format, args...)))
 }
 
 func GetLogger(quiet bool) *logger.Logger {
@@ -41,3 +44,13 @@ func GetLogger(quiet bool) *logger.Logger {
 	}
 	return logger.New(NewCustomLogger())
 }
+
+// GetFileLogger creates a logger that always writes to the log file and also writes to the standard output when not quiet.
+func GetFileLogger(quiet bool, file io.Writer) *logger.Logger {
+	c := NewCustomLogger()
+	c.out = file
+	if !quiet {
+		c.out = io.MultiWriter(os.Stdout, file)
+	}
+	return logger.New(c)
+}
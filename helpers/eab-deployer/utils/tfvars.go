@@ -15,7 +15,10 @@
 package utils
 
 import (
+	"bytes"
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/hashicorp/hcl/v2/hclparse"
@@ -37,7 +40,35 @@ func ReadTfvars(filename string, val interface{}) error {
 
 // WriteTfvars writes a valid terraform tfvars file from the provided struct.
 func WriteTfvars(filename string, val interface{}) error {
+	return os.WriteFile(filename, EncodeTfvars(val), 0644)
+}
+
+// EncodeTfvars encodes the provided struct as the content of a terraform tfvars file.
+func EncodeTfvars(val interface{}) []byte {
+	f := hclwrite.NewEmptyFile()
+	gohcl.EncodeIntoBody(val, f.Body())
+	return f.Bytes()
+}
+
+// WriteTfvarsWithComments writes a valid terraform tfvars file from the provided struct.
+// The comment of a top level attribute, if present in the comments map, is written in the line before it.
+func WriteTfvarsWithComments(filename string, val interface{}, comments map[string]string) error {
 	f := hclwrite.NewEmptyFile()
 	gohcl.EncodeIntoBody(val, f.Body())
-	return os.WriteFile(filename, f.Bytes(), 0644)
+
+	var buf bytes.Buffer
+	for _, line := range strings.SplitAfter(string(f.Bytes()), "\n") {
+		name, _, found := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if found && !strings.HasPrefix(line, " ") && comments[name] != "" {
+			if buf.Len() > 0 {
+				buf.WriteString("\n")
+			}
+			for _, c := range strings.Split(comments[name], "\n") {
+				buf.WriteString(fmt.Sprintf("// %s\n", c))
+			}
+		}
+		buf.WriteString(line)
+	}
+	return os.WriteFile(filename, hclwrite.Format(buf.Bytes()), 0644)
 }
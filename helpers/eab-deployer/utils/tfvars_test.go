@@ -15,6 +15,7 @@
 package utils
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -87,3 +88,31 @@ func TestWriteReadTfvars(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteTfvarsWithComments(t *testing.T) {
+
+	type tfvars struct {
+		Project string   `hcl:"project_id"`
+		Regions []string `hcl:"regions"`
+	}
+
+	file := filepath.Join(t.TempDir(), "comments.tfvars")
+	in := tfvars{
+		Project: "prj-seed",
+		Regions: []string{"us-central1", "us-east4"},
+	}
+	err := WriteTfvarsWithComments(file, in, map[string]string{
+		"project_id": "Seed project\nMANDATORY",
+	})
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "// Seed project\n// MANDATORY\nproject_id = \"prj-seed\"\n")
+	assert.NotContains(t, string(content), "// regions")
+
+	var read tfvars
+	err = ReadTfvars(file, &read)
+	assert.NoError(t, err)
+	assert.Equal(t, in, read, "tfvars with comments should be readable")
+}
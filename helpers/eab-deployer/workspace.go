@@ -0,0 +1,117 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/deployer"
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/workspace"
+)
+
+const workspaceUsage = `usage:
+  eab-deployer workspace list
+  eab-deployer workspace create NAME [TFVARS_FILE]
+  eab-deployer workspace select NAME
+  eab-deployer workspace status`
+
+// runWorkspaceCommand runs the workspace subcommands. The status shows the custom stages of the hooks file.
+func runWorkspaceCommand(m workspace.Manager, hooksFile string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing workspace command\n%s", workspaceUsage)
+	}
+	switch args[0] {
+	case "list":
+		workspaces, err := m.List()
+		if err != nil {
+			return err
+		}
+		current, err := m.Current()
+		if err != nil {
+			return err
+		}
+		if len(workspaces) == 0 {
+			fmt.Println("# No workspaces found")
+		}
+		for _, w := range workspaces {
+			marker := " "
+			if w.Name == current {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, w.Name)
+		}
+		return nil
+	case "create":
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf("invalid arguments\n%s", workspaceUsage)
+		}
+		tfvars := ""
+		if len(args) == 3 {
+			tfvars = args[2]
+		}
+		w, err := m.Create(args[1], tfvars)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("# Workspace '%s' created in %s\n", w.Name, w.Dir)
+		if tfvars == "" {
+			fmt.Printf("# Create the tfvars file with: eab-deployer -workspace %s -init\n", w.Name)
+		}
+		return nil
+	case "select":
+		if len(args) != 2 {
+			return fmt.Errorf("invalid arguments\n%s", workspaceUsage)
+		}
+		if err := m.Select(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("# Workspace '%s' selected\n", args[1])
+		return nil
+	case "status":
+		deploySteps, err := deployer.StageSteps(hooksFile)
+		if err != nil {
+			return err
+		}
+		return m.Dashboard(os.Stdout, deploySteps)
+	default:
+		return fmt.Errorf("unknown workspace command '%s'\n%s", args[0], workspaceUsage)
+	}
+}
+
+// resolveWorkspace loads the workspace in the -workspace flag or, when no tfvars file is provided, the current workspace.
+// The tfvars and steps files of the configuration are replaced by the workspace files.
+func resolveWorkspace(c *cfg) (*workspace.Workspace, error) {
+	m := workspace.NewManager(c.workspacesDir)
+	name := c.workspace
+	if name == "" && c.tfvarsFile == "" {
+		current, err := m.Current()
+		if err != nil {
+			return nil, err
+		}
+		name = current
+	}
+	if name == "" {
+		return nil, nil
+	}
+	w, err := m.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	c.tfvarsFile = w.TFVarsFile()
+	c.stepsFile = w.StepsFile()
+	fmt.Printf("# Using workspace '%s'\n", w.Name)
+	return &w, nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const lockFile = "deploy.lock"
+
+// Lock is the lock of a workspace, held during a run so that the CLI and the server never deploy the same
+// workspace at the same time. The lock is released by the operating system when the process exits.
+type Lock struct {
+	f *os.File
+}
+
+// Lock acquires the lock of the workspace for the owner, it fails when the workspace is already locked.
+func (w Workspace) Lock(owner string) (*Lock, error) {
+	f, err := os.OpenFile(filepath.Join(w.Dir, lockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer f.Close()
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("failed to lock workspace '%s': %w", w.Name, err)
+		}
+		holder, _ := os.ReadFile(f.Name())
+		if h := strings.TrimSpace(string(holder)); h != "" {
+			return nil, fmt.Errorf("workspace '%s' is locked by %s", w.Name, h)
+		}
+		return nil, fmt.Errorf("workspace '%s' is locked", w.Name)
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteString(owner + "\n"); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Lock{f: f}, nil
+}
+
+// Unlock releases the lock.
+func (l *Lock) Unlock() error {
+	if err := l.f.Truncate(0); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
@@ -0,0 +1,136 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/steps"
+)
+
+const (
+	DriftNotApplied = "NOT_APPLIED"
+	DriftInSync     = "IN_SYNC"
+	DriftChanged    = "TFVARS_CHANGED"
+	DriftUnknown    = "UNKNOWN"
+)
+
+// Status is the deployment status of a workspace.
+type Status struct {
+	Name     string
+	LastStep string
+	// LastStepStatus is the status of the last step, PENDING when no step was executed.
+	LastStepStatus string
+	// NextStep is the first step that is not complete, empty when all the steps are complete.
+	NextStep string
+	Failures []string
+	// Drift compares the hash of the tfvars file with the one of the last successful deployment. Only the
+	// changes of the tfvars file are detected, not the changes of the infrastructure.
+	Drift string
+}
+
+// Status gets the deployment status of the workspace. The deploy steps are the top level steps of the
+// deployment in execution order, with the custom stages.
+func (w Workspace) Status(deploySteps []string) (Status, error) {
+	s := Status{Name: w.Name, LastStepStatus: "PENDING", Failures: []string{}, Drift: DriftNotApplied}
+	if len(deploySteps) > 0 {
+		s.NextStep = deploySteps[0]
+	}
+
+	if _, err := os.Stat(w.StepsFile()); err == nil {
+		st, err := steps.LoadSteps(w.StepsFile())
+		if err != nil {
+			return s, err
+		}
+		s.NextStep = ""
+		for _, name := range deploySteps {
+			if step, ok := st.Steps[name]; ok {
+				s.LastStep = step.Name
+				s.LastStepStatus = step.Status
+			}
+			if s.NextStep == "" && !st.IsStepComplete(name) {
+				s.NextStep = name
+			}
+		}
+		for name, step := range st.Steps {
+			if step.Error != "" {
+				s.Failures = append(s.Failures, name)
+			}
+		}
+		sort.Strings(s.Failures)
+	} else if !os.IsNotExist(err) {
+		return s, err
+	}
+
+	if w.LastApplyTFVarsHash != "" {
+		hash, err := w.TFVarsHash()
+		switch {
+		case err != nil:
+			s.Drift = DriftUnknown
+		case hash == w.LastApplyTFVarsHash:
+			s.Drift = DriftInSync
+		default:
+			s.Drift = DriftChanged
+		}
+	}
+	return s, nil
+}
+
+// Dashboard writes the status of all the workspaces as a table.
+func (m Manager) Dashboard(out io.Writer, deploySteps []string) error {
+	workspaces, err := m.List()
+	if err != nil {
+		return err
+	}
+	if len(workspaces) == 0 {
+		fmt.Fprintln(out, "# No workspaces found")
+		return nil
+	}
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  WORKSPACE\tLAST STEP\tSTATUS\tNEXT STEP\tFAILURES\tTFVARS DRIFT")
+	for _, w := range workspaces {
+		s, err := w.Status(deploySteps)
+		if err != nil {
+			return fmt.Errorf("failed to get status of workspace '%s': %w", w.Name, err)
+		}
+		marker := " "
+		if w.Name == current {
+			marker = "*"
+		}
+		lastStep := s.LastStep
+		if lastStep == "" {
+			lastStep = "-"
+		}
+		nextStep := s.NextStep
+		if nextStep == "" {
+			nextStep = "-"
+		}
+		failures := strings.Join(s.Failures, ",")
+		if failures == "" {
+			failures = "-"
+		}
+		fmt.Fprintf(tw, "%s %s\t%s\t%s\t%s\t%s\t%s\n", marker, w.Name, lastStep, s.LastStepStatus, nextStep, failures, s.Drift)
+	}
+	return tw.Flush()
+}
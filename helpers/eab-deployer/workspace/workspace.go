@@ -0,0 +1,240 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package workspace manages named deployments, each one with its own tfvars file, steps file, checkout directory and logs.
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/utils"
+)
+
+const (
+	currentFile  = "current"
+	metadataFile = "workspace.json"
+	tfvarsFile   = "global.tfvars"
+	stepsFile    = ".steps.json"
+	checkoutDir  = "checkout"
+	logsDir      = "logs"
+	backupsDir   = "state-backups"
+)
+
+var nameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Workspace is a named deployment.
+type Workspace struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// LastApplyTFVarsHash is the hash of the tfvars file of the last successful deployment.
+	LastApplyTFVarsHash string    `json:"last_apply_tfvars_hash"`
+	LastApplyAt         time.Time `json:"last_apply_at"`
+	Dir                 string    `json:"-"`
+}
+
+// Manager manages the workspaces in a root directory.
+type Manager struct {
+	Root string
+}
+
+// DefaultRoot is the default root directory of the workspaces.
+func DefaultRoot() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".eab-deployer", "workspaces")
+	}
+	return filepath.Join(home, ".eab-deployer", "workspaces")
+}
+
+// NewManager creates a workspace manager for the root directory.
+func NewManager(root string) Manager {
+	return Manager{Root: root}
+}
+
+// TFVarsFile is the tfvars file of the workspace.
+func (w Workspace) TFVarsFile() string {
+	return filepath.Join(w.Dir, tfvarsFile)
+}
+
+// StepsFile is the steps file of the workspace.
+func (w Workspace) StepsFile() string {
+	return filepath.Join(w.Dir, stepsFile)
+}
+
+// CheckoutPath is the directory where the repositories of the workspace are checked out.
+func (w Workspace) CheckoutPath() string {
+	return filepath.Join(w.Dir, checkoutDir)
+}
+
+// LogsPath is the directory of the log files of the workspace.
+func (w Workspace) LogsPath() string {
+	return filepath.Join(w.Dir, logsDir)
+}
+
+// StateBackupsPath is the directory of the terraform state backups of the workspace.
+func (w Workspace) StateBackupsPath() string {
+	return filepath.Join(w.Dir, backupsDir)
+}
+
+// NewLogFile creates a new log file for an execution in the workspace.
+func (w Workspace) NewLogFile() (*os.File, error) {
+	return os.Create(filepath.Join(w.LogsPath(), fmt.Sprintf("%s.log", time.Now().UTC().Format("20060102T150405Z"))))
+}
+
+// TFVarsHash is the hash of the current content of the tfvars file of the workspace.
+func (w Workspace) TFVarsHash() (string, error) {
+	content, err := os.ReadFile(w.TFVarsFile())
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// RecordApply saves the hash of the tfvars file of a successful deployment.
+func (w Workspace) RecordApply() error {
+	hash, err := w.TFVarsHash()
+	if err != nil {
+		return err
+	}
+	w.LastApplyTFVarsHash = hash
+	w.LastApplyAt = time.Now().UTC()
+	return w.save()
+}
+
+// RecordDestroy clears the last successful deployment.
+func (w Workspace) RecordDestroy() error {
+	w.LastApplyTFVarsHash = ""
+	w.LastApplyAt = time.Time{}
+	return w.save()
+}
+
+func (w Workspace) save() error {
+	f, err := json.MarshalIndent(w, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(w.Dir, metadataFile), f, 0644)
+}
+
+// ValidateName checks that the name of a workspace can be used as the name of its directory.
+func ValidateName(name string) error {
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("invalid workspace name '%s', use lowercase letters, numbers, '-' and '_'", name)
+	}
+	return nil
+}
+
+// Create creates a new workspace. The tfvars file, if provided, is copied into the workspace.
+func (m Manager) Create(name, tfvars string) (Workspace, error) {
+	if err := ValidateName(name); err != nil {
+		return Workspace{}, err
+	}
+	w := Workspace{Name: name, CreatedAt: time.Now().UTC(), Dir: filepath.Join(m.Root, name)}
+	_, err := os.Stat(w.Dir)
+	if err == nil {
+		return Workspace{}, fmt.Errorf("workspace '%s' already exists", name)
+	}
+	if !os.IsNotExist(err) {
+		return Workspace{}, err
+	}
+	for _, dir := range []string{w.CheckoutPath(), w.LogsPath()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return Workspace{}, err
+		}
+	}
+	if tfvars != "" {
+		if err := utils.CopyFile(tfvars, w.TFVarsFile()); err != nil {
+			return Workspace{}, fmt.Errorf("failed to copy tfvars file '%s': %w", tfvars, err)
+		}
+	}
+	return w, w.save()
+}
+
+// Get loads a workspace.
+func (m Manager) Get(name string) (Workspace, error) {
+	var w Workspace
+	if err := ValidateName(name); err != nil {
+		return w, err
+	}
+	dir := filepath.Join(m.Root, name)
+	f, err := os.ReadFile(filepath.Join(dir, metadataFile))
+	if os.IsNotExist(err) {
+		return w, fmt.Errorf("workspace '%s' does not exist", name)
+	}
+	if err != nil {
+		return w, err
+	}
+	if err := json.Unmarshal(f, &w); err != nil {
+		return w, fmt.Errorf("failed to load workspace '%s': %w", name, err)
+	}
+	w.Dir = dir
+	return w, nil
+}
+
+// List lists the workspaces sorted by name.
+func (m Manager) List() ([]Workspace, error) {
+	entries, err := os.ReadDir(m.Root)
+	if os.IsNotExist(err) {
+		return []Workspace{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	workspaces := []Workspace{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(m.Root, e.Name(), metadataFile)); err != nil {
+			continue
+		}
+		w, err := m.Get(e.Name())
+		if err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, w)
+	}
+	sort.Slice(workspaces, func(i, j int) bool { return workspaces[i].Name < workspaces[j].Name })
+	return workspaces, nil
+}
+
+// Select makes the workspace the current workspace.
+func (m Manager) Select(name string) error {
+	if _, err := m.Get(name); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(m.Root, currentFile), []byte(name+"\n"), 0644)
+}
+
+// Current is the name of the current workspace, it is empty when no workspace was selected.
+func (m Manager) Current() (string, error) {
+	f, err := os.ReadFile(filepath.Join(m.Root, currentFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(f)), nil
+}
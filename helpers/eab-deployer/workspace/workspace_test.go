@@ -0,0 +1,153 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/GoogleCloudPlatform/terraform-google-enterprise-application/helpers/eab-deployer/steps"
+)
+
+func TestCreateSelectList(t *testing.T) {
+	m := NewManager(filepath.Join(t.TempDir(), "workspaces"))
+
+	list, err := m.List()
+	assert.NoError(t, err)
+	assert.Empty(t, list, "root does not exist yet")
+
+	tfvars := filepath.Join(t.TempDir(), "global.tfvars")
+	assert.NoError(t, os.WriteFile(tfvars, []byte("org_id = \"123\"\n"), 0644))
+
+	w, err := m.Create("bu-retail", tfvars)
+	assert.NoError(t, err)
+	assert.DirExists(t, w.CheckoutPath())
+	assert.DirExists(t, w.LogsPath())
+	content, err := os.ReadFile(w.TFVarsFile())
+	assert.NoError(t, err)
+	assert.Equal(t, "org_id = \"123\"\n", string(content))
+
+	_, err = m.Create("bu-retail", "")
+	assert.ErrorContains(t, err, "already exists")
+	_, err = m.Create("BU Retail", "")
+	assert.ErrorContains(t, err, "invalid workspace name")
+
+	_, err = m.Create("bu-finance", "")
+	assert.NoError(t, err)
+
+	list, err = m.List()
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, "bu-finance", list[0].Name)
+	assert.Equal(t, filepath.Join(m.Root, "bu-finance"), list[0].Dir)
+
+	current, err := m.Current()
+	assert.NoError(t, err)
+	assert.Empty(t, current)
+	assert.ErrorContains(t, m.Select("bu-missing"), "does not exist")
+	assert.ErrorContains(t, m.Select("../bu-retail"), "invalid workspace name")
+	assert.NoError(t, m.Select("bu-retail"))
+	current, err = m.Current()
+	assert.NoError(t, err)
+	assert.Equal(t, "bu-retail", current)
+
+	log, err := w.NewLogFile()
+	assert.NoError(t, err)
+	assert.NoError(t, log.Close())
+	assert.Equal(t, w.LogsPath(), filepath.Dir(log.Name()))
+}
+
+func TestStatus(t *testing.T) {
+	deploySteps := []string{"gcp-bootstrap", "gcp-multitenant", "gcp-fleetscope", "observability", "gcp-appfactory"}
+	m := NewManager(t.TempDir())
+	tfvars := filepath.Join(t.TempDir(), "global.tfvars")
+	assert.NoError(t, os.WriteFile(tfvars, []byte("org_id = \"123\"\n"), 0644))
+
+	w, err := m.Create("bu-retail", tfvars)
+	assert.NoError(t, err)
+	s, err := w.Status(deploySteps)
+	assert.NoError(t, err)
+	assert.Equal(t, Status{Name: "bu-retail", LastStepStatus: "PENDING", NextStep: "gcp-bootstrap", Failures: []string{}, Drift: DriftNotApplied}, s)
+
+	st, err := steps.LoadSteps(w.StepsFile())
+	assert.NoError(t, err)
+	assert.NoError(t, st.CompleteStep("gcp-bootstrap"))
+	assert.NoError(t, st.CompleteStep("gcp-multitenant"))
+	assert.NoError(t, st.FailStep("gcp-fleetscope.production", "build failed"))
+	assert.NoError(t, st.FailStep("gcp-fleetscope", "build failed"))
+
+	assert.NoError(t, w.RecordApply())
+	w, err = m.Get("bu-retail")
+	assert.NoError(t, err)
+	s, err = w.Status(deploySteps)
+	assert.NoError(t, err)
+	assert.Equal(t, "gcp-fleetscope", s.LastStep)
+	assert.Equal(t, "FAILED", s.LastStepStatus)
+	assert.Equal(t, "gcp-fleetscope", s.NextStep)
+	assert.Equal(t, []string{"gcp-fleetscope", "gcp-fleetscope.production"}, s.Failures)
+	assert.Equal(t, DriftInSync, s.Drift)
+
+	assert.NoError(t, os.WriteFile(w.TFVarsFile(), []byte("org_id = \"456\"\n"), 0644))
+	s, err = w.Status(deploySteps)
+	assert.NoError(t, err)
+	assert.Equal(t, DriftChanged, s.Drift)
+
+	_, err = m.Create("bu-finance", "")
+	assert.NoError(t, err)
+	assert.NoError(t, m.Select("bu-finance"))
+	var out bytes.Buffer
+	assert.NoError(t, m.Dashboard(&out, deploySteps))
+	assert.Equal(t, `  WORKSPACE   LAST STEP       STATUS   NEXT STEP       FAILURES                                  TFVARS DRIFT
+* bu-finance  -               PENDING  gcp-bootstrap   -                                         NOT_APPLIED
+  bu-retail   gcp-fleetscope  FAILED   gcp-fleetscope  gcp-fleetscope,gcp-fleetscope.production  TFVARS_CHANGED
+`, out.String())
+
+	// the custom stages are part of the status
+	st, err = steps.LoadSteps(w.StepsFile())
+	assert.NoError(t, err)
+	assert.NoError(t, st.CompleteStep("gcp-fleetscope"))
+	assert.NoError(t, st.CompleteStep("observability"))
+	s, err = w.Status(deploySteps)
+	assert.NoError(t, err)
+	assert.Equal(t, "observability", s.LastStep)
+	assert.Equal(t, "gcp-appfactory", s.NextStep)
+
+	assert.NoError(t, w.RecordDestroy())
+	w, err = m.Get("bu-retail")
+	assert.NoError(t, err)
+	s, err = w.Status(deploySteps)
+	assert.NoError(t, err)
+	assert.Equal(t, DriftNotApplied, s.Drift)
+}
+
+func TestLock(t *testing.T) {
+	m := NewManager(filepath.Join(t.TempDir(), "workspaces"))
+	w, err := m.Create("bu-retail", "")
+	assert.NoError(t, err)
+
+	lock, err := w.Lock("run 1")
+	assert.NoError(t, err)
+	_, err = w.Lock("process 2")
+	assert.EqualError(t, err, "workspace 'bu-retail' is locked by run 1")
+
+	assert.NoError(t, lock.Unlock())
+	lock, err = w.Lock("process 2")
+	assert.NoError(t, err, "the lock should be released")
+	assert.NoError(t, lock.Unlock())
+}